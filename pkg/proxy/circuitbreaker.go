@@ -0,0 +1,278 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netns"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+var ErrCircuitOpen = errors.New("all endpoints are failing")
+
+// EndpointReporter is implemented by load balancers that want to know whether connections
+// to the endpoints they picked succeeded.
+type EndpointReporter interface {
+	ReportSuccess(service, endpoint string)
+	ReportFailure(service, endpoint string)
+}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breaker is the state of a single endpoint of a service.
+type breaker struct {
+	failures  int
+	open      bool
+	openedAt  time.Time
+	lastProbe time.Time
+}
+
+// CircuitBreaker wraps a LoadBalancer and takes endpoints out of rotation after repeated
+// failures. A tripped endpoint is skipped for Cooldown, then probed with a single connection
+// at most once every ProbeInterval until a probe succeeds.
+type CircuitBreaker struct {
+	loadBalancer LoadBalancer
+	// FailureThreshold is the number of consecutive failures that trips an endpoint.
+	FailureThreshold int
+	// Cooldown is how long a tripped endpoint receives no traffic at all.
+	Cooldown time.Duration
+	// ProbeInterval is the minimum time between probes of a tripped endpoint after Cooldown.
+	ProbeInterval time.Duration
+	// Updates, if set, receives a REMOVE when an endpoint trips and an ADD when it recovers,
+	// each holding a single api.Endpoints with the service ID and the affected endpoint.
+	// It must be drained.
+	Updates chan<- config.EndpointsUpdate
+	// Clock is the source of time. Defaults to the real clock.
+	Clock config.Clock
+
+	lock     sync.Mutex
+	breakers map[string]map[string]*breaker // service -> endpoint -> state
+}
+
+// NewCircuitBreaker creates a CircuitBreaker around loadBalancer.
+func NewCircuitBreaker(loadBalancer LoadBalancer, failureThreshold int, cooldown, probeInterval time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		loadBalancer:     loadBalancer,
+		FailureThreshold: failureThreshold,
+		Cooldown:         cooldown,
+		ProbeInterval:    probeInterval,
+		breakers:         make(map[string]map[string]*breaker),
+	}
+}
+
+func (cb *CircuitBreaker) now() time.Time {
+	if cb.Clock == nil {
+		return time.Now()
+	}
+	return cb.Clock.Now()
+}
+
+// NextEndpoint returns the next endpoint of the wrapped load balancer that is not tripped.
+func (cb *CircuitBreaker) NextEndpoint(service, port string, srcAddr net.Addr) (netns.NsHandle, string, error) {
+	cb.lock.Lock()
+	attempts := 1
+	for _, b := range cb.breakers[service] {
+		if b.open {
+			attempts++
+		}
+	}
+	cb.lock.Unlock()
+
+	// The wrapped load balancer cycles through the endpoints, so skipping every tripped
+	// endpoint once is enough to reach a healthy one if there is any.
+	for i := 0; i < attempts; i++ {
+		ns, endpoint, err := cb.loadBalancer.NextEndpoint(service, port, srcAddr)
+		if err != nil {
+			return ns, endpoint, err
+		}
+		if cb.allow(service, endpoint) {
+			return ns, endpoint, nil
+		}
+	}
+	return netns.None(), "", ErrCircuitOpen
+}
+
+// allow reports whether endpoint may receive a connection, claiming the probe of a tripped
+// endpoint if one is due.
+func (cb *CircuitBreaker) allow(service, endpoint string) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	b, found := cb.breakers[service][endpoint]
+	if !found || !b.open {
+		return true
+	}
+	now := cb.now()
+	if now.Before(b.openedAt.Add(cb.Cooldown)) || now.Before(b.lastProbe.Add(cb.ProbeInterval)) {
+		return false
+	}
+	glog.V(2).Infof("Probing tripped endpoint %s of %s", endpoint, service)
+	b.lastProbe = now
+	return true
+}
+
+// SetFailureThreshold changes FailureThreshold while the CircuitBreaker is in use. Endpoints
+// that have already tripped stay tripped.
+func (cb *CircuitBreaker) SetFailureThreshold(failureThreshold int) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.FailureThreshold = failureThreshold
+}
+
+// ReportSuccess closes the breaker of endpoint.
+func (cb *CircuitBreaker) ReportSuccess(service, endpoint string) {
+	cb.lock.Lock()
+	b, found := cb.breakers[service][endpoint]
+	if !found {
+		cb.lock.Unlock()
+		return
+	}
+	recovered := b.open
+	delete(cb.breakers[service], endpoint)
+	if len(cb.breakers[service]) == 0 {
+		delete(cb.breakers, service)
+	}
+	cb.lock.Unlock()
+
+	if recovered {
+		glog.Infof("Endpoint %s of %s recovered", endpoint, service)
+		cb.notify(config.ADD, service, endpoint)
+	}
+}
+
+// ReportFailure counts a failure of endpoint, tripping its breaker once FailureThreshold
+// consecutive failures have been seen.
+func (cb *CircuitBreaker) ReportFailure(service, endpoint string) {
+	cb.lock.Lock()
+	if cb.breakers[service] == nil {
+		cb.breakers[service] = make(map[string]*breaker)
+	}
+	b, found := cb.breakers[service][endpoint]
+	if !found {
+		b = &breaker{}
+		cb.breakers[service][endpoint] = b
+	}
+	b.failures++
+	failures := b.failures
+	tripped := !b.open && failures >= cb.FailureThreshold
+	if tripped {
+		b.open = true
+		b.openedAt = cb.now()
+	}
+	cb.lock.Unlock()
+
+	if tripped {
+		glog.Infof("Endpoint %s of %s failed %d times, removing it from rotation", endpoint, service, failures)
+		cb.notify(config.REMOVE, service, endpoint)
+	}
+}
+
+// OnUpdate forgets the state of endpoints that no longer exist.
+func (cb *CircuitBreaker) OnUpdate(endpoints []api.Endpoints) {
+	active := make(map[string]map[string]bool)
+	for _, e := range endpoints {
+		active[e.ID] = make(map[string]bool)
+		for _, endpoint := range e.Endpoints {
+			active[e.ID][endpoint] = true
+		}
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	for service, breakers := range cb.breakers {
+		for endpoint := range breakers {
+			if !active[service][endpoint] {
+				delete(breakers, endpoint)
+			}
+		}
+		if len(breakers) == 0 {
+			delete(cb.breakers, service)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) notify(op config.Operation, service, endpoint string) {
+	if cb.Updates == nil {
+		return
+	}
+	cb.Updates <- config.EndpointsUpdate{Op: op, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: service}, Endpoints: []string{endpoint}},
+	}}
+}
+
+// Tripped returns the endpoints of each service that are out of rotation, sorted.
+func (cb *CircuitBreaker) Tripped() map[string][]string {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	tripped := make(map[string][]string)
+	for service, breakers := range cb.breakers {
+		for endpoint, b := range breakers {
+			if b.open {
+				tripped[service] = append(tripped[service], endpoint)
+			}
+		}
+		sort.Strings(tripped[service])
+	}
+	return tripped
+}
+
+// breakerState is the state of an endpoint as reported by DebugState.
+type breakerState struct {
+	Endpoint string    `json:"endpoint"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+}
+
+// DebugState returns the state of every endpoint that has failed since it last succeeded,
+// keyed by service.
+func (cb *CircuitBreaker) DebugState() interface{} {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	now := cb.now()
+	state := make(map[string][]breakerState)
+	for service, breakers := range cb.breakers {
+		for endpoint, b := range breakers {
+			s := breakerState{Endpoint: endpoint, State: breakerClosed, Failures: b.failures}
+			if b.open {
+				s.State = breakerOpen
+				if !now.Before(b.openedAt.Add(cb.Cooldown)) {
+					s.State = breakerHalfOpen
+				}
+				s.OpenedAt = b.openedAt
+			}
+			state[service] = append(state[service], s)
+		}
+		sort.Sort(breakerStatesByEndpoint(state[service]))
+	}
+	return state
+}
+
+type breakerStatesByEndpoint []breakerState
+
+func (s breakerStatesByEndpoint) Len() int           { return len(s) }
+func (s breakerStatesByEndpoint) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s breakerStatesByEndpoint) Less(i, j int) bool { return s[i].Endpoint < s[j].Endpoint }
@@ -0,0 +1,184 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time                         { return c.now }
+func (c *fakeClock) After(d time.Duration) <-chan time.Time { return nil }
+func (c *fakeClock) NewTimer(d time.Duration) config.Timer  { return nil }
+
+func nextEndpoints(t *testing.T, cb *CircuitBreaker, count int) []string {
+	endpoints := []string{}
+	for i := 0; i < count; i++ {
+		_, endpoint, err := cb.NextEndpoint("foo", "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "foo"},
+			Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+	})
+	updates := make(chan config.EndpointsUpdate, 10)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cb := NewCircuitBreaker(lb, 2, time.Minute, 10*time.Second)
+	cb.Updates = updates
+	cb.Clock = clock
+
+	// a single failure does not trip the breaker
+	cb.ReportFailure("foo", "10.0.0.1:80")
+	expected := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	if actual := nextEndpoints(t, cb, 2); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cb.ReportFailure("foo", "10.0.0.1:80")
+	expectedUpdate := config.EndpointsUpdate{Op: config.REMOVE, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}},
+	}}
+	if actual := <-updates; !reflect.DeepEqual(expectedUpdate, actual) {
+		t.Errorf("expected %#v, got %#v", expectedUpdate, actual)
+	}
+	expected = []string{"10.0.0.2:80", "10.0.0.2:80", "10.0.0.2:80"}
+	if actual := nextEndpoints(t, cb, 3); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// after the cooldown a single probe is let through per probe interval
+	clock.now = clock.now.Add(time.Minute)
+	expected = []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.2:80"}
+	if actual := nextEndpoints(t, cb, 3); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	cb.ReportFailure("foo", "10.0.0.1:80")
+	clock.now = clock.now.Add(5 * time.Second)
+	expected = []string{"10.0.0.2:80", "10.0.0.2:80"}
+	if actual := nextEndpoints(t, cb, 2); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	clock.now = clock.now.Add(5 * time.Second)
+	expected = []string{"10.0.0.1:80"}
+	if actual := nextEndpoints(t, cb, 1); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	cb.ReportSuccess("foo", "10.0.0.1:80")
+	expectedUpdate.Op = config.ADD
+	if actual := <-updates; !reflect.DeepEqual(expectedUpdate, actual) {
+		t.Errorf("expected %#v, got %#v", expectedUpdate, actual)
+	}
+	expected = []string{"10.0.0.2:80", "10.0.0.1:80"}
+	if actual := nextEndpoints(t, cb, 2); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if len(updates) != 0 {
+		t.Errorf("unexpected updates %#v", <-updates)
+	}
+}
+
+func TestCircuitBreakerAllTripped(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "foo"},
+			Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+	})
+	cb := NewCircuitBreaker(lb, 1, time.Minute, time.Second)
+	cb.ReportFailure("foo", "10.0.0.1:80")
+	cb.ReportFailure("foo", "10.0.0.2:80")
+	if _, _, err := cb.NextEndpoint("foo", "", nil); err != ErrCircuitOpen {
+		t.Errorf("expected %v, got %v", ErrCircuitOpen, err)
+	}
+
+	// endpoints that go away are forgotten
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "foo"},
+			Endpoints: []string{"10.0.0.3:80"},
+		},
+	})
+	cb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "foo"},
+			Endpoints: []string{"10.0.0.3:80"},
+		},
+	})
+	if state := cb.DebugState().(map[string][]breakerState); len(state) != 0 {
+		t.Errorf("expected no state, got %#v", state)
+	}
+}
+
+func TestCircuitBreakerDebugState(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	clock := &fakeClock{now: time.Unix(0, 0).UTC()}
+	cb := NewCircuitBreaker(lb, 2, time.Minute, time.Second)
+	cb.Clock = clock
+	cb.ReportFailure("foo", "10.0.0.2:80")
+	cb.ReportFailure("foo", "10.0.0.1:80")
+	cb.ReportFailure("foo", "10.0.0.1:80")
+
+	debug := config.DebugHandler(nil, nil)
+	debug.Register("circuitBreaker", cb)
+	server := httptest.NewServer(debug)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	var state struct {
+		Components struct {
+			CircuitBreaker map[string][]breakerState `json:"circuitBreaker"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]breakerState{
+		"foo": {
+			{Endpoint: "10.0.0.1:80", State: breakerOpen, Failures: 2, OpenedAt: clock.now},
+			{Endpoint: "10.0.0.2:80", State: breakerClosed, Failures: 1},
+		},
+	}
+	if !reflect.DeepEqual(expected, state.Components.CircuitBreaker) {
+		t.Errorf("expected %#v, got %#v", expected, state.Components.CircuitBreaker)
+	}
+}
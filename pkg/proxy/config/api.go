@@ -17,10 +17,16 @@ limitations under the License.
 package config
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util/wait"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
@@ -35,20 +41,445 @@ type Watcher interface {
 	WatchEndpoints(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error)
 }
 
+// WatchStreamer is implemented by Watchers that can return the raw watch response. SourceAPI
+// decodes such responses itself, choosing a WatchDecoder based on the response Content-Type.
+type WatchStreamer interface {
+	StreamServices(label, field labels.Selector, resourceVersion uint64) (*http.Response, error)
+	StreamEndpoints(label, field labels.Selector, resourceVersion uint64) (*http.Response, error)
+}
+
+// ContextWatcher is implemented by Watchers whose requests can be aborted. WithContext returns
+// a Watcher, implementing the same interfaces as the original, whose requests are aborted once
+// ctx is done. SourceAPI uses it to abort the requests RequestTimeout gives up on.
+type ContextWatcher interface {
+	WithContext(ctx context.Context) Watcher
+}
+
+// ServicePager is implemented by Watchers that can list services a page at a time. The
+// continue token returned with a page gets the next one, and is empty after the last page.
+type ServicePager interface {
+	ListServicesPage(label labels.Selector, limit int, continueToken string) (*api.ServiceList, string, error)
+}
+
+// Clock provides the time to a SourceAPI, so that tests can control it. All of the waits of a
+// SourceAPI, such as backoffs, resyncs and watch timeouts, go through its Clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event created by Clock.NewTimer, like a time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, and reports whether it stopped it.
+	Stop() bool
+}
+
+// realClock is a Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// SourceAPIOptions holds optional behavior for a SourceAPI. The zero value gives the default behavior.
+type SourceAPIOptions struct {
+	// SnapshotFencing brackets the SET sent after each full list with SNAPSHOT_START and SNAPSHOT_END.
+	SnapshotFencing bool
+	// WatchTimeout closes and re-establishes a watch from the current resource version once it has
+	// been open this long, even if no error occurred. Zero means watches are never timed out.
+	WatchTimeout time.Duration
+	// Clock is the source of time. Defaults to the real clock.
+	Clock Clock
+	// MultiplexHTTP2 carries the services and endpoints watches as two streams of a single
+	// HTTP/2 connection instead of two connections. It only applies to an HTTPWatcher client.
+	MultiplexHTTP2 bool
+	// WatchCodec decodes the events of watch streams, one event per line. If nil, the decoder is
+	// chosen by the Content-Type of the stream. It only applies to a WatchStreamer client.
+	WatchCodec WatchCodec
+	// Health, if set, is told whether each attempt to list or watch succeeded.
+	Health HealthReporter
+	// TestMode enables InjectService and RemoveService.
+	TestMode bool
+	// Store, if set, is updated directly by InjectService and RemoveService.
+	Store *ConfigStore
+	// Name, if set, is stamped as the Source of every update, along with its Timestamp.
+	Name string
+	// InitialListAttempts is the number of times the list before a watch is tried before giving
+	// up until the next period. Zero means one attempt.
+	InitialListAttempts int
+	// ListRetryBackoff is the wait before the second list attempt. It doubles for each further attempt.
+	ListRetryBackoff time.Duration
+	// RequireOCSP refuses apiservers whose certificate is not reported good by its OCSP
+	// responder. It only applies to an HTTPWatcher client.
+	RequireOCSP bool
+	// EnableWatchLatencyLog logs, at verbosity 4, the time from opening each watch to its first
+	// event and between its events, and keeps the latter for RecentEventLatencies.
+	EnableWatchLatencyLog bool
+	// UseEndpointSlices watches discovery.k8s.io EndpointSlices instead of Endpoints, merging
+	// the slices of each service into the same EndpointsUpdates. It only applies to an
+	// EndpointSliceWatcher client.
+	UseEndpointSlices bool
+	// Observe logs every update instead of sending it, so that a source can be tried out without
+	// changing what is proxied.
+	Observe bool
+	// PreReconnectHealthCheck waits for the apiserver to pass a health check before each
+	// reconnect, backing off between checks. It only applies to a HealthChecker client.
+	PreReconnectHealthCheck bool
+	// IgnoreLabel, if set, drops the services whose label of this key is "true", such as
+	// the IgnoreLabel constant, from updates. Annotations are not part of the API, hence a
+	// label. A service that is labeled after it was sent is removed.
+	IgnoreLabel string
+	// SkipExternalNameServices drops the services whose ServiceType is ExternalNameType from
+	// updates, as they have neither a cluster IP nor endpoints to proxy to. A service whose
+	// type changes to ExternalName after it was sent is removed.
+	SkipExternalNameServices bool
+	// ServiceTransformers are applied, in order, to every service sent. A service that any of
+	// them fails on is dropped.
+	ServiceTransformers []ServiceTransformer
+	// DeadLetter, if set, receives each service or endpoints object that fails Validate, each
+	// service dropped by ServiceTransformers, and the DecodeError of each watch that could not
+	// be decoded, so that invalid objects can be inspected rather than silently lost. The
+	// objects that fail Validate are then dropped by the source itself. It must be drained.
+	DeadLetter chan<- InvalidUpdate
+	// UpdateBufferSize, if positive, queues up to this many updates for each channel instead of
+	// waiting for each to be received. An ADD or REMOVE that arrives while the queue is full is
+	// dropped and counted by DroppedUpdates, and the resource is then relisted, so that the SET
+	// corrects what was lost. SETs are never dropped, they wait for room in the queue.
+	UpdateBufferSize int
+	// AutoScale delivers updates on between MinWorkers and MaxWorkers goroutines, one for every
+	// ScaleThreshold updates a second, so that a slow consumer of one object does not hold up the
+	// others. Updates of the same object are still delivered in order.
+	AutoScale      bool
+	MinWorkers     int
+	MaxWorkers     int
+	ScaleThreshold int
+	// WorkerPoolSize, if more than one, delivers updates on this many goroutines, so that the
+	// watches are not held up by a consumer that is slow to take the updates of one object.
+	// Updates of the same object are still delivered in order. It is ignored with AutoScale.
+	WorkerPoolSize int
+	// DebounceInterval, if positive, holds the ADDs and REMOVEs of the watches for this long and
+	// sends only the last change of each service or endpoints, dropping changes that cancel out.
+	DebounceInterval time.Duration
+	// EventStore, if set, stores every full list and watch event of services and endpoints. A
+	// source whose store holds a list starts from the state it and the events after it lead
+	// to, and watches from there instead of listing.
+	EventStore *SQLiteEventStore
+	// HeartbeatInterval, if positive, sends a Heartbeat on Heartbeats this often for each watch
+	// while it is established, so that a watchdog can tell a quiet source from a stuck one.
+	HeartbeatInterval time.Duration
+	Heartbeats        chan<- Heartbeat
+	// RecordTo, if set, is the path of a HAR file every request to the apiserver and its
+	// response are written to, for debugging. Once it reaches 100 MB it is moved to RecordTo.1
+	// and a new one is started. It only applies to an HTTPWatcher client.
+	RecordTo string
+	// KafkaProducer, if set, publishes every ServiceUpdate sent as JSON to KafkaTopic, where a
+	// KafkaConsumerSource can read it. KafkaTopic must have a single partition, or SETs may
+	// be read out of order with the updates of single services.
+	KafkaProducer KafkaProducer
+	KafkaTopic    string
+	// KafkaCodec, if set, encodes the updates published to KafkaTopic in place of JSON, e.g. a
+	// SnappyUpdateCodec to compress them. Kafka producers can also compress with snappy
+	// themselves, which consumers undo transparently; a compressing codec is only needed if
+	// the producer does not.
+	KafkaCodec UpdateCodec
+	// ListPageSize, if positive, lists services this many at a time, assembling the pages into
+	// a single SET before the watch starts. It only applies to a ServicePager client.
+	ListPageSize int
+	// MaxServices and MaxEndpoints, if positive, drop the services and endpoints that would
+	// take the number the source has sent over the limit, with a warning, as a safety valve
+	// against a selector that matches far more than intended. Updates of objects already sent
+	// are not limited, and a full list keeps those it still has first. The drops are counted
+	// by ServicesOverLimit and EndpointsOverLimit.
+	MaxServices  int
+	MaxEndpoints int
+	// TagConnections gives each list and watch of the apiserver a random UUID that prefixes
+	// the messages logged about it, so that those of successive connections can be told apart.
+	TagConnections bool
+	// RawEvents, if set, receives every event of the watches of services, endpoints and
+	// endpoint slices as it was decoded, before it is turned into updates, for consumers that
+	// keep state of their own. The type of its Object tells the resource. Each list of a
+	// resource is sent as a RawRelist event holding the whole list. It must be drained.
+	RawEvents chan<- watch.Event
+	// SigningKey, if set, signs each ServiceUpdate sent with HMAC-SHA256, so that a consumer
+	// across an untrusted network can check it with VerifySignature.
+	SigningKey []byte
+	// CompactEndpoints drops the endpoints of ADDs and REMOVEs that change nothing, see
+	// CompactEndpointsUpdate. SETs are always sent whole.
+	CompactEndpoints bool
+	// DrainTimeout is how long Close waits after sending a DRAIN before it closes the channels.
+	DrainTimeout time.Duration
+	// StartBarrier, if set, holds back the first list of services and endpoints until it is
+	// closed, so that the source can be created before the components it depends on are ready.
+	StartBarrier <-chan struct{}
+	// RequestTimeout, if positive, aborts a list, or the opening of a watch, that has not been
+	// answered this long after it was sent, so that an apiserver that accepts connections but
+	// never responds is retried like one that fails. It applies to a client that is a
+	// ContextWatcher, such as HTTPWatcher, as the requests of others cannot be aborted.
+	RequestTimeout time.Duration
+	// CuckooDedup drops the watch events of services and endpoints that were already seen, by
+	// their object's ID and resource version, such as those a reconnected watch repeats. The
+	// events are looked up in a CuckooFilter, and a hit is only taken as a duplicate if the
+	// event is no newer than the last one seen of its object, so no new event is dropped.
+	CuckooDedup bool
+	// ServiceSelector, if set, limits the source to the services whose labels match it. It is
+	// passed to the apiserver with each list and watch of services, and checked again on each
+	// service received, so that a service whose labels change to no longer match is removed,
+	// whether the apiserver sends the change as a deletion or as a modification.
+	ServiceSelector labels.Selector
+	// DNSSRV, if set, also sends a service for the DNS SRV records of its Domain, with the
+	// targets of the records as its endpoints, polled for changes, for registries that are not
+	// in the apiserver.
+	DNSSRV *DNSSRVSource
+	// MaxEventSize is the most bytes a single event of a watch stream may have. Longer events
+	// are dropped with an error, counted by OversizedEvents, rather than decoded, so that an
+	// apiserver cannot exhaust the memory of the source. Zero means DefaultMaxEventSize, and a
+	// negative value means no limit. The limit applies to each line of a JSON stream, and to
+	// each document of a YAML one. The streams of a WatchCodec are not limited, as their
+	// framing is not known.
+	MaxEventSize int64
+	// NamespaceFallback limits the source to the namespace of the pod it runs in, read from its
+	// service account, once a request across namespaces is forbidden, so that a proxy only
+	// permitted to see its own namespace keeps working for that namespace. A warning is logged
+	// when it falls back. It only applies to an HTTPWatcher client.
+	NamespaceFallback bool
+	// ObjectCodec, if set, decodes the services and endpoints of watch streams in place of
+	// runtime.DefaultCodec, so that a source can consume another version of the API by
+	// converting its objects to the api types. It applies to the watch streams of a
+	// WatchStreamer client, unless a WatchCodec is set, and to the lists of an HTTPWatcher.
+	ObjectCodec runtime.Codec
+	// NodeAnnotation labels each service sent with the hostname of the node as its NodeLabel,
+	// so that the routing tables of proxies on different nodes can be told apart.
+	NodeAnnotation bool
+	// HostnameFunc returns the hostname NodeAnnotation labels services with. Defaults to
+	// os.Hostname. It is called once.
+	HostnameFunc func() string
+	// EndpointsFromServices does not watch endpoints, but sends the endpoints embedded in each
+	// service sent as its EndpointsLabel, with the same operation, for setups without
+	// endpoints objects.
+	EndpointsFromServices bool
+	// SendInitialEvents does not list services and endpoints before watching them from
+	// resource version 0, but asks the watch to start with the existing ones as ADDED events
+	// (sendInitialEvents=true), which needs Kubernetes 1.27 or later. The initial events are
+	// sent as one SET, as a list would be, once a bookmark or any other event ends them. It
+	// only applies to an HTTPWatcher client, and not to endpoint slices.
+	SendInitialEvents bool
+	// EnableCheckpoints keeps a copy of the services and endpoints sent, with the resource
+	// version each was sent at, for Checkpoint.
+	EnableCheckpoints bool
+	// ExpandHeadless sends each headless service as the synthetic services of a
+	// HeadlessExpander, with their endpoints, for backends that cannot proxy headless services.
+	// The synthetic services follow the endpoints of the headless service as they change.
+	ExpandHeadless bool
+}
+
+// HealthChecker is implemented by Watchers that can check the health of the apiserver.
+type HealthChecker interface {
+	Healthz() error
+}
+
+// The wait after the first failed health check before a reconnect, and the most it grows to.
+const (
+	healthCheckBackoff    = 1 * time.Second
+	maxHealthCheckBackoff = 30 * time.Second
+)
+
+// watchBookmark is the type of watch events that carry no change, only the resource version
+// the watch has reached.
+const watchBookmark watch.EventType = "BOOKMARK"
+
+// HealthReporter is told whether a source is able to reach its backend.
+type HealthReporter interface {
+	ReportSuccess()
+	ReportFailure(err error)
+}
+
 // SourceAPI implements a configuration source for services and endpoints that
 // uses the client watch API to efficiently detect changes.
 type SourceAPI struct {
+	SourceAPIOptions
+
 	client    Watcher
 	services  chan<- ServiceUpdate
 	endpoints chan<- EndpointsUpdate
 
+	serviceVersion   versionTracker
+	endpointsVersion versionTracker
+	// slices holds the endpoint slices when UseEndpointSlices is set.
+	slices *endpointSliceState
+
 	waitDuration      time.Duration
 	reconnectDuration time.Duration
+
+	latencies latencyRing
+	buffer    updateBuffer
+	pool      workerPool
+
+	serviceDebounce   debouncer
+	endpointsDebounce debouncer
+
+	servicesSynced  syncSignal
+	endpointsSynced syncSignal
+
+	serviceLimit   objectLimit
+	endpointsLimit objectLimit
+
+	endpointsCompactor CompactEndpointsUpdate
+
+	seenEvents eventFilter
+	srv        srvState
+	oversized  eventSizeCounter
+	node       nodeName
+	checkpoint checkpointState
+	headless   HeadlessExpander
+
+	// closing is marked by Close, after which nothing more is sent on the channels.
+	closeLock sync.RWMutex
+	closeOnce sync.Once
+	closing   syncSignal
+
+	// protocols maps the ID of each service seen to its protocol, if it has one, and weights
+	// to the weights of its endpoints, if it has a WeightLabel. sentEndpoints holds the
+	// endpoints last sent of each service, to send them again when those change.
+	protocolLock  sync.Mutex
+	protocols     map[string]string
+	weights       map[string]map[string]int
+	sentEndpoints map[string]compactEntry
+
+	subscriptionLock sync.RWMutex
+	subscriptions    []*subscription
+}
+
+// versionTracker holds the resource version a watch should resume from.
+// It is safe for concurrent use.
+type versionTracker struct {
+	lock    sync.RWMutex
+	version uint64
+}
+
+// Get returns the current resource version.
+func (v *versionTracker) Get() uint64 {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.version
+}
+
+// Set replaces the current resource version.
+func (v *versionTracker) Set(version uint64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.version = version
+}
+
+// Advance moves the resource version forward to version and reports whether it did.
+// It never moves the version backwards.
+func (v *versionTracker) Advance(version uint64) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if version <= v.version {
+		return false
+	}
+	v.version = version
+	return true
 }
 
 // NewSourceAPI creates a config source that watches for changes to the services and endpoints.
 func NewSourceAPI(client Watcher, period time.Duration, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) *SourceAPI {
+	return NewSourceAPIWithOptions(client, period, services, endpoints, SourceAPIOptions{})
+}
+
+// NewSourceAPIWithOptions creates a config source like NewSourceAPI, with the given options.
+func NewSourceAPIWithOptions(client Watcher, period time.Duration, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate, options SourceAPIOptions) *SourceAPI {
+	if options.MultiplexHTTP2 {
+		if w, ok := client.(*HTTPWatcher); ok {
+			w.MultiplexHTTP2()
+		} else {
+			glog.Warningf("MultiplexHTTP2 is only supported by an HTTPWatcher client, ignoring")
+		}
+	}
+	if options.RequireOCSP {
+		if w, ok := client.(*HTTPWatcher); ok {
+			w.RequireOCSP()
+		} else {
+			glog.Warningf("RequireOCSP is only supported by an HTTPWatcher client, ignoring")
+		}
+	}
+	if options.RecordTo != "" {
+		if w, ok := client.(*HTTPWatcher); ok {
+			if err := w.Record(options.RecordTo, options.Clock); err != nil {
+				glog.Warningf("Unable to record to %s, ignoring: %v", options.RecordTo, err)
+			}
+		} else {
+			glog.Warningf("RecordTo is only supported by an HTTPWatcher client, ignoring")
+		}
+	}
+	if options.NamespaceFallback {
+		if w, ok := client.(*HTTPWatcher); ok {
+			if namespace, err := serviceAccountNamespace(); err == nil {
+				w.FallbackToNamespace(namespace)
+			} else {
+				glog.Warningf("Unable to read the namespace of the service account, ignoring NamespaceFallback: %v", err)
+			}
+		} else {
+			glog.Warningf("NamespaceFallback is only supported by an HTTPWatcher client, ignoring")
+		}
+	}
+	if options.ObjectCodec != nil {
+		if w, ok := client.(*HTTPWatcher); ok {
+			w.UseCodec(options.ObjectCodec)
+		} else if _, ok := client.(WatchStreamer); ok {
+			glog.Warningf("ObjectCodec only decodes the watch streams of a client other than an HTTPWatcher, not its lists")
+		} else {
+			glog.Warningf("ObjectCodec is only supported by an HTTPWatcher or WatchStreamer client, ignoring")
+		}
+	}
+	if options.SendInitialEvents {
+		if w, ok := client.(*HTTPWatcher); ok {
+			w.SendInitialEvents()
+		} else {
+			glog.Warningf("SendInitialEvents is only supported by an HTTPWatcher client, ignoring")
+			options.SendInitialEvents = false
+		}
+	}
+	if options.UseEndpointSlices {
+		if _, ok := client.(EndpointSliceWatcher); !ok {
+			glog.Warningf("UseEndpointSlices is only supported by an EndpointSliceWatcher client, ignoring")
+			options.UseEndpointSlices = false
+		}
+	}
+	if options.PreReconnectHealthCheck {
+		if _, ok := client.(HealthChecker); !ok {
+			glog.Warningf("PreReconnectHealthCheck is only supported by a HealthChecker client, ignoring")
+			options.PreReconnectHealthCheck = false
+		}
+	}
+	if options.HeartbeatInterval > 0 && options.Heartbeats == nil {
+		glog.Warningf("HeartbeatInterval requires a Heartbeats channel, ignoring")
+		options.HeartbeatInterval = 0
+	}
+	if options.KafkaProducer != nil && options.KafkaTopic == "" {
+		glog.Warningf("KafkaProducer requires a KafkaTopic, ignoring")
+		options.KafkaProducer = nil
+	}
+	if options.AutoScale && options.ScaleThreshold <= 0 {
+		glog.Warningf("AutoScale requires a positive ScaleThreshold, ignoring")
+		options.AutoScale = false
+	}
+	if options.AutoScale && options.WorkerPoolSize > 1 {
+		glog.Warningf("WorkerPoolSize does not apply with AutoScale, ignoring")
+		options.WorkerPoolSize = 0
+	}
 	config := &SourceAPI{
+		SourceAPIOptions: options,
+
 		client:    client,
 		services:  services,
 		endpoints: endpoints,
@@ -57,112 +488,924 @@ func NewSourceAPI(client Watcher, period time.Duration, services chan<- ServiceU
 		// prevent hot loops if the server starts to misbehave
 		reconnectDuration: time.Second * 1,
 	}
-	serviceVersion := uint64(0)
-	go util.Forever(func() {
-		config.runServices(&serviceVersion)
-		time.Sleep(wait.Jitter(config.reconnectDuration, 0.0))
-	}, period)
-	endpointVersion := uint64(0)
-	go util.Forever(func() {
-		config.runEndpoints(&endpointVersion)
-		time.Sleep(wait.Jitter(config.reconnectDuration, 0.0))
-	}, period)
+	if options.AutoScale {
+		go config.scaleWorkers()
+	} else if options.WorkerPoolSize > 1 {
+		config.pool.resize(options.WorkerPoolSize)
+	}
+	go config.forever(config.runServices)
+	if !options.EndpointsFromServices {
+		go config.forever(config.runEndpoints)
+	}
+	if options.DNSSRV != nil {
+		go func() {
+			defer util.HandleCrash()
+			config.pollSRV()
+		}()
+	}
 	return config
 }
 
+// forever calls run over and over, waiting between calls, like util.Forever but with the Clock.
+func (s *SourceAPI) forever(run func()) {
+	for {
+		func() {
+			defer util.HandleCrash()
+			run()
+		}()
+		if s.isClosed() {
+			return
+		}
+		s.sleep(wait.Jitter(s.reconnectDuration, 0.0))
+		s.sleep(s.waitDuration)
+		if checker, ok := s.client.(HealthChecker); ok && s.PreReconnectHealthCheck {
+			s.waitForHealthy(checker)
+		}
+	}
+}
+
+// waitForHealthy returns once the apiserver passes a health check, doubling the wait between
+// checks up to maxHealthCheckBackoff.
+func (s *SourceAPI) waitForHealthy(checker HealthChecker) {
+	backoff := healthCheckBackoff
+	for {
+		err := checker.Healthz()
+		if err == nil {
+			return
+		}
+		glog.Warningf("Apiserver is not healthy, checking again in %v: %v", backoff, err)
+		s.reportFailure(err)
+		s.sleep(backoff)
+		backoff *= 2
+		if backoff > maxHealthCheckBackoff {
+			backoff = maxHealthCheckBackoff
+		}
+	}
+}
+
+// injectedSource is the source name used for the Store by InjectService and RemoveService.
+const injectedSource = "api"
+
+// ErrNotTestMode is returned by InjectService and RemoveService when the source is not in
+// TestMode.
+var ErrNotTestMode = errors.New("service injection requires TestMode")
+
+// InjectService sends an ADD for service as if it had arrived on the watch. It is only
+// allowed in TestMode.
+func (s *SourceAPI) InjectService(service api.Service) error {
+	return s.inject(ServiceUpdate{Op: ADD, Services: []api.Service{service}})
+}
+
+// RemoveService sends a REMOVE for the service with the given ID as if it had arrived on
+// the watch. It is only allowed in TestMode.
+func (s *SourceAPI) RemoveService(id string) error {
+	return s.inject(ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: id}}}})
+}
+
+func (s *SourceAPI) inject(update ServiceUpdate) error {
+	if !s.TestMode {
+		return ErrNotTestMode
+	}
+	if s.Store != nil {
+		s.Store.UpdateServices(injectedSource, update)
+	}
+	s.sendServices(update)
+	return nil
+}
+
+// observeLogf logs the updates dropped by Observe. Tests replace it to see them.
+var observeLogf = glog.Infof
+
+// sendServices filters and transforms update, stamps it with the source's name, if it has
+// one, and sends it.
+func (s *SourceAPI) sendServices(update ServiceUpdate) {
+	if s.DNSSRV != nil && update.Op == SET {
+		update.Services = s.withSRVServices(update.Services)
+	}
+	if s.DeadLetter != nil {
+		// The services that would be rejected by the config they are sent to are rejected
+		// here instead, where they can be dead-lettered.
+		op := update.Op
+		valid, err := update.dropInvalid(func(service api.Service, err error) {
+			s.deadLetterService(op, service, err)
+		})
+		if err != nil {
+			s.DeadLetter <- InvalidUpdate{Event: Event{Resource: ServicesResource, Services: &update}, Err: err}
+			return
+		}
+		if len(valid.Services) == 0 && len(update.Services) > 0 && op != SET {
+			return
+		}
+		update = valid
+	}
+	if s.IgnoreLabel != "" {
+		// The services may have been sent before they were labeled.
+		if !s.dropServices(&update, func(service api.Service) bool {
+			return service.Labels[s.IgnoreLabel] == "true"
+		}) {
+			return
+		}
+	}
+	if s.ServiceSelector != nil {
+		// The services may have been sent before their labels changed.
+		if !s.dropServices(&update, func(service api.Service) bool {
+			return !s.ServiceSelector.Matches(labels.Set(service.Labels))
+		}) {
+			return
+		}
+	}
+	if s.SkipExternalNameServices {
+		// The services may have been sent before their type changed.
+		if !s.dropServices(&update, func(service api.Service) bool {
+			return ServiceType(service) == ExternalNameType
+		}) {
+			return
+		}
+	}
+	if s.ExpandHeadless {
+		// Before the transformers, as the ClusterIPLabel of a headless service is no address.
+		expanded := s.expandHeadless(update)
+		if len(expanded) == 0 {
+			return
+		}
+		update = expanded[0]
+		for _, stale := range expanded[1:] {
+			defer s.sendServices(stale)
+		}
+		// The endpoints of the synthetic services are sent once the services are.
+		if endpoints, ok := s.headless.Endpoints(update); ok {
+			defer s.sendEndpointsUpdate(endpoints)
+		}
+	}
+	if len(s.ServiceTransformers) > 0 {
+		var reject func(api.Service, error)
+		if s.DeadLetter != nil {
+			op := update.Op
+			reject = func(service api.Service, err error) {
+				s.deadLetterService(op, service, err)
+			}
+		}
+		var dropped []api.Service
+		update, dropped = transformServices(update, s.ServiceTransformers, reject)
+		if update.Op == ADD && len(dropped) > 0 {
+			// As in dropServices, the services may have been sent before they changed.
+			s.sendServices(ServiceUpdate{Op: REMOVE, Services: dropped})
+		}
+		if update.Op == ADD && len(update.Services) == 0 {
+			return
+		}
+	}
+	if s.MaxServices > 0 {
+		update.Services = s.limitServices(update)
+		if update.Op == ADD && len(update.Services) == 0 {
+			return
+		}
+	}
+	if s.EndpointsFromServices {
+		// The endpoints are sent once the services they belong to are.
+		defer s.sendEndpoints(servicesEndpoints(update))
+	}
+	if s.NodeAnnotation {
+		update.Services = s.labelNode(update.Services)
+	}
+	if s.Name != "" {
+		update.Source = s.Name
+		update.Timestamp = s.clock().Now()
+	}
+	// The endpoints of the services may have been sent before their protocols or weights
+	// were known.
+	defer s.resendEndpoints(append(s.recordProtocols(update), s.recordWeights(update)...))
+	if s.Observe {
+		ids := make([]string, len(update.Services))
+		for i, service := range update.Services {
+			ids[i] = service.ID
+		}
+		observeLogf("Observe: not sending %s of services %v", update.Op, ids)
+		return
+	}
+	s.recordServices(update)
+	if s.DebounceInterval > 0 {
+		s.debounceServices(update)
+		return
+	}
+	s.dispatchServices(update)
+}
+
+// recordProtocols remembers the protocols of the services in update, and returns the IDs of
+// the services whose protocol changed.
+func (s *SourceAPI) recordProtocols(update ServiceUpdate) []string {
+	s.protocolLock.Lock()
+	defer s.protocolLock.Unlock()
+	last := s.protocols
+	if update.Op == SET || s.protocols == nil {
+		s.protocols = make(map[string]string)
+	}
+	for _, service := range update.Services {
+		if update.Op == REMOVE || service.Protocol == "" {
+			delete(s.protocols, ServiceKey(service))
+			continue
+		}
+		s.protocols[ServiceKey(service)] = service.Protocol
+	}
+	if update.Op == REMOVE {
+		// The endpoints of the services are removed along with them.
+		return nil
+	}
+	var changed []string
+	for _, service := range update.Services {
+		if key := ServiceKey(service); last[key] != s.protocols[key] {
+			changed = append(changed, key)
+		}
+	}
+	if update.Op == SET {
+		for key := range last {
+			if _, found := s.protocols[key]; !found {
+				changed = append(changed, key)
+			}
+		}
+	}
+	return changed
+}
+
+// endpointsProtocols returns the protocols of the services owning endpoints, or nil if none
+// is known.
+func (s *SourceAPI) endpointsProtocols(endpoints []api.Endpoints) map[string]string {
+	s.protocolLock.Lock()
+	defer s.protocolLock.Unlock()
+	var protocols map[string]string
+	for _, e := range endpoints {
+		// The protocols are recorded by the ServiceKey of each service, which is the
+		// EndpointsKey of its endpoints.
+		if protocol, ok := s.protocols[EndpointsKey(e)]; ok {
+			if protocols == nil {
+				protocols = make(map[string]string)
+			}
+			protocols[EndpointsKey(e)] = protocol
+		}
+	}
+	return protocols
+}
+
+// sendEndpoints sends update without duplicate addresses, or only the changes an ADD or REMOVE
+// makes if CompactEndpoints is set.
+func (s *SourceAPI) sendEndpoints(update EndpointsUpdate) {
+	if s.DNSSRV != nil && update.Op == SET {
+		update.Endpoints = s.withSRVEndpoints(update.Endpoints)
+	}
+	if s.ExpandHeadless && update.Op == SET {
+		update.Endpoints = s.headless.withSynthetic(update.Endpoints)
+	}
+	if s.DeadLetter != nil {
+		// As in sendServices.
+		op := update.Op
+		valid, err := update.dropInvalid(func(endpoints api.Endpoints, err error) {
+			s.deadLetterEndpoints(op, endpoints, err)
+		})
+		if err != nil {
+			s.DeadLetter <- InvalidUpdate{Event: Event{Resource: EndpointsResource, Endpoints: &update}, Err: err}
+			return
+		}
+		if len(valid.Endpoints) == 0 && len(update.Endpoints) > 0 && op != SET {
+			return
+		}
+		update = valid
+	}
+	update.Endpoints = uniqueEndpoints(update.Endpoints)
+	if !s.CompactEndpoints {
+		s.sendEndpointsUpdate(update)
+		return
+	}
+	for _, compacted := range s.endpointsCompactor.Compact(update) {
+		s.sendEndpointsUpdate(compacted)
+	}
+}
+
+// uniqueEndpoints returns endpoints with only the first of the addresses that appear more than
+// once in the endpoints of a service, as the proxier would otherwise balance over each of them.
+// The endpoints are only copied if they have duplicates.
+func uniqueEndpoints(endpoints []api.Endpoints) []api.Endpoints {
+	var unique []api.Endpoints
+	for i, e := range endpoints {
+		seen := make(map[string]bool, len(e.Endpoints))
+		addresses := make([]string, 0, len(e.Endpoints))
+		for _, address := range e.Endpoints {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+		if len(addresses) == len(e.Endpoints) {
+			if unique != nil {
+				unique = append(unique, e)
+			}
+			continue
+		}
+		if unique == nil {
+			unique = append(make([]api.Endpoints, 0, len(endpoints)), endpoints[:i]...)
+		}
+		e.Endpoints = addresses
+		unique = append(unique, e)
+	}
+	if unique == nil {
+		return endpoints
+	}
+	return unique
+}
+
+// sendEndpointsUpdate limits update to MaxEndpoints, stamps it with the source's name, if it
+// has one, and sends it.
+func (s *SourceAPI) sendEndpointsUpdate(update EndpointsUpdate) {
+	if s.MaxEndpoints > 0 {
+		update.Endpoints = s.limitEndpoints(update)
+		if update.Op == ADD && len(update.Endpoints) == 0 {
+			return
+		}
+	}
+	if s.Name != "" {
+		update.Source = s.Name
+		update.Timestamp = s.clock().Now()
+	}
+	update.Protocols = s.endpointsProtocols(update.Endpoints)
+	update.Weights = s.endpointsWeights(update.Endpoints)
+	if s.Observe {
+		ids := make([]string, len(update.Endpoints))
+		for i, endpoints := range update.Endpoints {
+			ids[i] = endpoints.ID
+		}
+		observeLogf("Observe: not sending %s of endpoints %v", update.Op, ids)
+		return
+	}
+	s.recordEndpoints(update)
+	s.recordSentEndpoints(update)
+	if s.ExpandHeadless {
+		// The synthetic services follow the endpoints of their headless service.
+		if headless := s.headless.headlessOf(update.Endpoints); len(headless) > 0 {
+			defer s.sendServices(ServiceUpdate{Op: ADD, Services: headless})
+		}
+	}
+	if s.DebounceInterval > 0 {
+		s.debounceEndpoints(update)
+		return
+	}
+	s.dispatchEndpoints(update)
+}
+
+// reportSuccess tells the HealthReporter, if any, that an attempt succeeded.
+func (s *SourceAPI) reportSuccess() {
+	if s.Health != nil {
+		s.Health.ReportSuccess()
+	}
+}
+
+// reportFailure tells the HealthReporter, if any, that an attempt failed.
+func (s *SourceAPI) reportFailure(err error) {
+	if s.Health != nil {
+		s.Health.ReportFailure(err)
+	}
+}
+
+// clock returns the configured Clock, or the real clock if there is none.
+func (s *SourceAPI) clock() Clock {
+	if s.Clock == nil {
+		return realClock{}
+	}
+	return s.Clock
+}
+
+// sleep waits for d to pass on the Clock.
+func (s *SourceAPI) sleep(d time.Duration) {
+	<-s.clock().After(d)
+}
+
+// waitForBarrier waits for StartBarrier to be closed, if it is set, and returns false if the
+// source is closed first.
+func (s *SourceAPI) waitForBarrier() bool {
+	if s.StartBarrier == nil {
+		return true
+	}
+	select {
+	case <-s.StartBarrier:
+		return true
+	case <-s.closing.done():
+		return false
+	}
+}
+
+// watchTimeout returns a channel that fires once the current watch should be re-established,
+// and a function to call once the watch ends. If no WatchTimeout is configured the channel is
+// nil and never fires.
+func (s *SourceAPI) watchTimeout() (<-chan time.Time, func()) {
+	if s.WatchTimeout <= 0 {
+		return nil, func() {}
+	}
+	timer := s.clock().NewTimer(s.WatchTimeout)
+	return timer.C(), func() { timer.Stop() }
+}
+
 // runServices loops forever looking for changes to services.
-func (s *SourceAPI) runServices(resourceVersion *uint64) {
-	if *resourceVersion == 0 {
-		services, err := s.client.ListServices(labels.Everything())
+func (s *SourceAPI) runServices() {
+	if !s.waitForBarrier() {
+		return
+	}
+	resourceVersion := &s.serviceVersion
+	if s.buffer.takeRelist(ServicesResource) {
+		resourceVersion.Set(0)
+	}
+	if resourceVersion.Get() == 0 && s.EventStore != nil {
+		s.replayServices()
+	}
+	initial := s.SendInitialEvents && resourceVersion.Get() == 0
+	if resourceVersion.Get() == 0 && !initial {
+		log := s.newConnLog()
+		var services *api.ServiceList
+		err := s.retryList(func(client Watcher) (err error) {
+			services, err = s.listServices(client)
+			return err
+		})
 		if err != nil {
-			glog.Errorf("Unable to load services: %v", err)
-			time.Sleep(wait.Jitter(s.waitDuration, 0.0))
+			log.Errorf("Unable to load services: %v", err)
+			s.reportFailure(&ListError{Resource: ServicesResource, ResourceVersion: resourceVersion.Get(), Err: err})
+			s.sleep(wait.Jitter(s.waitDuration, 0.0))
 			return
 		}
-		*resourceVersion = services.ResourceVersion
-		s.services <- ServiceUpdate{Op: SET, Services: services.Items}
+		resourceVersion.Set(services.ResourceVersion)
+		s.sendRawRelist(services)
+		if s.EventStore != nil {
+			if err := s.EventStore.Snapshot(ServicesResource, services, s.clock().Now()); err != nil {
+				glog.Errorf("Unable to store services: %v", err)
+			}
+		}
+		if s.SnapshotFencing {
+			s.sendServices(ServiceUpdate{Op: SNAPSHOT_START})
+		}
+		// An empty list is still sent as an empty SET, which clears any state left over from
+		// an earlier list.
+		items := services.Items
+		if items == nil {
+			items = []api.Service{}
+		}
+		s.sendServices(ServiceUpdate{Op: SET, Services: items})
+		if s.SnapshotFencing {
+			s.sendServices(ServiceUpdate{Op: SNAPSHOT_END})
+		}
 	}
 
-	watcher, err := s.client.WatchServices(labels.Everything(), labels.Everything(), *resourceVersion)
+	log := s.newConnLog()
+	log.V(2).Infof("Watching services from resource version %d", resourceVersion.Get())
+	watcher, err := s.watchWithTimeout(func(client Watcher) (watch.Interface, error) {
+		return s.watchServices(client, resourceVersion.Get())
+	})
 	if err != nil {
-		glog.Errorf("Unable to watch for services changes: %v", err)
-		time.Sleep(wait.Jitter(s.waitDuration, 0.0))
+		log.Errorf("Unable to watch for services changes: %v", err)
+		s.reportFailure(&WatchError{Resource: ServicesResource, ResourceVersion: resourceVersion.Get(), Err: err})
+		s.sleep(wait.Jitter(s.waitDuration, 0.0))
 		return
 	}
 	defer watcher.Stop()
+	s.reportSuccess()
 
 	ch := watcher.ResultChan()
-	handleServicesWatch(resourceVersion, ch, s.services)
+	if s.CuckooDedup {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.dedupEvents(ch, done)
+	}
+	if s.EnableWatchLatencyLog {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.timeEvents("services", ch, done)
+	}
+	if s.EventStore != nil {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.storeEvents(ServicesResource, ch, done)
+	}
+	if s.RawEvents != nil {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.forwardRawEvents(ch, done)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch = s.untilClosed(ch, done, s.buffer.relisting(ServicesResource))
+	timeout, stop := s.watchTimeout()
+	defer stop()
+	stopHeartbeats := s.startHeartbeats(ServicesResource, resourceVersion)
+	defer stopHeartbeats()
+	err = handleServicesWatch(log, resourceVersion, ch, s.sendServices, timeout, initial)
+	stopHeartbeats()
+	if err != nil {
+		log.Errorf("Watch for services changes failed: %v", err)
+		s.reportFailure(err)
+		s.deadLetterWatch(ServicesResource, err)
+		s.sleep(wait.Jitter(s.waitDuration, 0.0))
+	}
 }
 
-// handleServicesWatch loops over an event channel and delivers config changes to an update channel.
-func handleServicesWatch(resourceVersion *uint64, ch <-chan watch.Event, updates chan<- ServiceUpdate) {
+// serviceSelector returns the ServiceSelector, or a selector of every service if there is none.
+func (s *SourceAPI) serviceSelector() labels.Selector {
+	if s.ServiceSelector == nil {
+		return labels.Everything()
+	}
+	return s.ServiceSelector
+}
+
+// listServices lists the services, a page of ListPageSize at a time if the client supports it.
+// The pages are assembled into one list with the resource version of the first, from which
+// the apiserver serves the rest. A failed page fails the whole list.
+func (s *SourceAPI) listServices(client Watcher) (*api.ServiceList, error) {
+	pager, ok := client.(ServicePager)
+	if !ok || s.ListPageSize <= 0 {
+		return client.ListServices(s.serviceSelector())
+	}
+	var services *api.ServiceList
+	continueToken := ""
+	for {
+		page, next, err := pager.ListServicesPage(s.serviceSelector(), s.ListPageSize, continueToken)
+		if err != nil {
+			return nil, err
+		}
+		if services == nil {
+			services = page
+		} else {
+			services.Items = append(services.Items, page.Items...)
+		}
+		if next == "" {
+			return services, nil
+		}
+		glog.V(4).Infof("Listed %d services so far, continuing", len(services.Items))
+		continueToken = next
+	}
+}
+
+// retryList calls list, within the RequestTimeout, until it succeeds or InitialListAttempts
+// attempts have failed, backing off between attempts, and returns the last error.
+func (s *SourceAPI) retryList(list func(client Watcher) error) error {
+	backoff := s.ListRetryBackoff
+	for attempt := 1; ; attempt++ {
+		cancel, err := s.withTimeout(list)
+		cancel()
+		if err == nil || attempt >= s.InitialListAttempts {
+			return err
+		}
+		glog.Warningf("List attempt %d of %d failed, retrying in %v: %v", attempt, s.InitialListAttempts, backoff, err)
+		<-s.clock().After(backoff)
+		backoff *= 2
+	}
+}
+
+// errRequestTimeout is returned for a request that was not answered within RequestTimeout.
+var errRequestTimeout = errors.New("request timed out")
+
+// withTimeout calls request with the client of s and returns its error, or, if RequestTimeout
+// passes first, aborts it and returns errRequestTimeout once it has returned. The requests of
+// a client that is not a ContextWatcher cannot be aborted, so they are given no timeout.
+// cancel aborts the requests of the client passed to request, and must be called once what
+// they opened is no longer used.
+func (s *SourceAPI) withTimeout(request func(client Watcher) error) (cancel context.CancelFunc, err error) {
+	watcher, ok := s.client.(ContextWatcher)
+	if s.RequestTimeout <= 0 || !ok {
+		return func() {}, request(s.client)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		defer util.HandleCrash()
+		done <- request(watcher.WithContext(ctx))
+	}()
+	select {
+	case err := <-done:
+		return cancel, err
+	case <-s.clock().After(s.RequestTimeout):
+		cancel()
+		<-done
+		return cancel, errRequestTimeout
+	}
+}
+
+// watchWithTimeout opens a watch with open, within the RequestTimeout.
+func (s *SourceAPI) watchWithTimeout(open func(client Watcher) (watch.Interface, error)) (watch.Interface, error) {
+	var w watch.Interface
+	cancel, err := s.withTimeout(func(client Watcher) (err error) {
+		w, err = open(client)
+		return err
+	})
+	if err != nil {
+		if w != nil {
+			w.Stop()
+		}
+		cancel()
+		return nil, err
+	}
+	return &cancelingWatch{Interface: w, cancel: cancel}, nil
+}
+
+// cancelingWatch is a watch that aborts the request it was opened by once it is stopped.
+type cancelingWatch struct {
+	watch.Interface
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWatch) Stop() {
+	w.Interface.Stop()
+	w.cancel()
+}
+
+// watchServices opens a watch on services, decoding the stream directly if the client supports it.
+func (s *SourceAPI) watchServices(client Watcher, resourceVersion uint64) (watch.Interface, error) {
+	if streamer, ok := client.(WatchStreamer); ok {
+		resp, err := streamer.StreamServices(s.serviceSelector(), labels.Everything(), resourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		return watch.NewStreamWatcher(s.watchDecoder(resp)), nil
+	}
+	return checkWatch(client.WatchServices(s.serviceSelector(), labels.Everything(), resourceVersion))
+}
+
+// watchDecoder returns the WatchDecoder for a watch response.
+// Errors decoding the stream end the watch with an ERROR event.
+func (s *SourceAPI) watchDecoder(resp *http.Response) WatchDecoder {
+	body := s.limitEvents(resp.Body, resp.Header.Get("Content-Type"))
+	if s.WatchCodec != nil {
+		return &decodeErrors{decoder: newCodecWatchDecoder(body, s.WatchCodec)}
+	}
+	codec := s.ObjectCodec
+	if codec == nil {
+		codec = runtime.DefaultCodec
+	}
+	// Objects that do not say which namespace they are in are in the one the watch is limited to.
+	scope := ""
+	if resp.Request != nil {
+		scope = resp.Request.URL.Query().Get("namespace")
+	}
+	codec = namespaceCodec{codec, scope}
+	return &decodeErrors{decoder: newWatchDecoder(resp.Header.Get("Content-Type"), body, codec)}
+}
+
+// errNilWatch is returned in place of a watch that a client returned as nil without an error.
+var errNilWatch = errors.New("client returned no watch")
+
+// checkWatch turns a nil watch without an error into errNilWatch, so that a misbehaving client
+// is backed off and retried like a failed watch.
+func checkWatch(w watch.Interface, err error) (watch.Interface, error) {
+	if err == nil && w == nil {
+		return nil, errNilWatch
+	}
+	return w, err
+}
+
+// watchError returns the error sent by a watch as an ERROR event.
+func watchError(obj runtime.Object) error {
+	if status, ok := obj.(*api.Status); ok {
+		return fmt.Errorf("%s (%d)", status.Message, status.Code)
+	}
+	return fmt.Errorf("unexpected error event: %#v", obj)
+}
+
+// watchFailed returns the WatchError of a watch of resource that sent obj as an ERROR event, or
+// the error of its stream if it could not be decoded.
+// If the resource version of the watch has expired, it is reset to 0, so that the next run
+// lists again rather than watching from it forever.
+func watchFailed(resource ResourceType, resourceVersion *versionTracker, obj runtime.Object) error {
+	if failure, ok := obj.(*decodeFailure); ok {
+		return streamError(resource, resourceVersion.Get(), failure.err)
+	}
+	err := &WatchError{Resource: resource, ResourceVersion: resourceVersion.Get(), Err: watchError(obj)}
+	if status, ok := obj.(*api.Status); ok && status.Code == http.StatusGone {
+		resourceVersion.Set(0)
+	}
+	return err
+}
+
+// handleServicesWatch loops over an event channel and delivers config changes with send.
+// It returns nil when the event channel is closed or timeout fires, a WatchError if the watch
+// sends an error, and a DecodeError if it sends something other than a service.
+// If initial, the ADDED events the watch starts with are collected and sent as one SET once a
+// bookmark or any other event ends them. The resource version is only moved past them then, so
+// a watch that ends before sends nothing and the next one starts over.
+func handleServicesWatch(log *connLog, resourceVersion *versionTracker, ch <-chan watch.Event, send func(ServiceUpdate), timeout <-chan time.Time, initial bool) error {
+	initialServices := []api.Service{}
+	initialVersion := uint64(0)
 	for {
 		select {
+		case <-timeout:
+			log.V(2).Infof("WatchServices timed out, reconnecting")
+			return nil
+
 		case event, ok := <-ch:
 			if !ok {
-				glog.V(2).Infof("WatchServices channel closed")
-				return
+				log.V(2).Infof("WatchServices channel closed")
+				return nil
+			}
+			if event.Type == watch.Error {
+				return watchFailed(ServicesResource, resourceVersion, event.Object)
 			}
 
-			service := event.Object.(*api.Service)
-			*resourceVersion = service.ResourceVersion + 1
+			service, ok := event.Object.(*api.Service)
+			if !ok {
+				return unexpectedObject(ServicesResource, resourceVersion.Get(), event.Object)
+			}
+			if initial {
+				if event.Type == watch.Added {
+					initialServices = append(initialServices, *service)
+					if service.ResourceVersion >= initialVersion {
+						initialVersion = service.ResourceVersion + 1
+					}
+					continue
+				}
+				send(ServiceUpdate{Op: SET, Services: initialServices})
+				resourceVersion.Advance(initialVersion)
+				initial = false
+			}
+			if event.Type == watchBookmark {
+				// Moving the resource version forward lets the next watch resume from here.
+				resourceVersion.Advance(service.ResourceVersion + 1)
+				continue
+			}
+			// An event older than the last one processed, or than the last list, would undo
+			// newer state, e.g. when it arrives late from a watch that has since been replaced.
+			if !resourceVersion.Advance(service.ResourceVersion + 1) {
+				log.V(2).Infof("Ignoring stale service event for %s at resource version %d", service.ID, service.ResourceVersion)
+				continue
+			}
 
 			switch event.Type {
 			case watch.Added, watch.Modified:
-				updates <- ServiceUpdate{Op: ADD, Services: []api.Service{*service}}
+				send(ServiceUpdate{Op: ADD, Services: []api.Service{*service}})
 
 			case watch.Deleted:
-				updates <- ServiceUpdate{Op: REMOVE, Services: []api.Service{*service}}
+				send(ServiceUpdate{Op: REMOVE, Services: []api.Service{*service}})
 			}
 		}
 	}
 }
 
 // runEndpoints loops forever looking for changes to endpoints.
-func (s *SourceAPI) runEndpoints(resourceVersion *uint64) {
-	if *resourceVersion == 0 {
-		endpoints, err := s.client.ListEndpoints(labels.Everything())
+func (s *SourceAPI) runEndpoints() {
+	if !s.waitForBarrier() {
+		return
+	}
+	if s.buffer.takeRelist(EndpointsResource) {
+		s.endpointsVersion.Set(0)
+	}
+	if slices, ok := s.client.(EndpointSliceWatcher); ok && s.UseEndpointSlices {
+		s.runEndpointSlices(slices)
+		return
+	}
+	resourceVersion := &s.endpointsVersion
+	if resourceVersion.Get() == 0 && s.EventStore != nil {
+		s.replayEndpoints()
+	}
+	initial := s.SendInitialEvents && resourceVersion.Get() == 0
+	if resourceVersion.Get() == 0 && !initial {
+		log := s.newConnLog()
+		var endpoints *api.EndpointsList
+		err := s.retryList(func(client Watcher) (err error) {
+			endpoints, err = client.ListEndpoints(labels.Everything())
+			return err
+		})
 		if err != nil {
-			glog.Errorf("Unable to load endpoints: %v", err)
-			time.Sleep(wait.Jitter(s.waitDuration, 0.0))
+			log.Errorf("Unable to load endpoints: %v", err)
+			s.reportFailure(&ListError{Resource: EndpointsResource, ResourceVersion: resourceVersion.Get(), Err: err})
+			s.sleep(wait.Jitter(s.waitDuration, 0.0))
 			return
 		}
-		*resourceVersion = endpoints.ResourceVersion
-		s.endpoints <- EndpointsUpdate{Op: SET, Endpoints: endpoints.Items}
+		resourceVersion.Set(endpoints.ResourceVersion)
+		s.sendRawRelist(endpoints)
+		if s.EventStore != nil {
+			if err := s.EventStore.Snapshot(EndpointsResource, endpoints, s.clock().Now()); err != nil {
+				glog.Errorf("Unable to store endpoints: %v", err)
+			}
+		}
+		if s.SnapshotFencing {
+			s.sendEndpoints(EndpointsUpdate{Op: SNAPSHOT_START})
+		}
+		s.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: endpoints.Items})
+		if s.SnapshotFencing {
+			s.sendEndpoints(EndpointsUpdate{Op: SNAPSHOT_END})
+		}
 	}
 
-	watcher, err := s.client.WatchEndpoints(labels.Everything(), labels.Everything(), *resourceVersion)
+	log := s.newConnLog()
+	log.V(2).Infof("Watching endpoints from resource version %d", resourceVersion.Get())
+	watcher, err := s.watchWithTimeout(func(client Watcher) (watch.Interface, error) {
+		return s.watchEndpoints(client, resourceVersion.Get())
+	})
 	if err != nil {
-		glog.Errorf("Unable to watch for endpoints changes: %v", err)
-		time.Sleep(wait.Jitter(s.waitDuration, 0.0))
+		log.Errorf("Unable to watch for endpoints changes: %v", err)
+		s.reportFailure(&WatchError{Resource: EndpointsResource, ResourceVersion: resourceVersion.Get(), Err: err})
+		s.sleep(wait.Jitter(s.waitDuration, 0.0))
 		return
 	}
 	defer watcher.Stop()
+	s.reportSuccess()
 
 	ch := watcher.ResultChan()
-	handleEndpointsWatch(resourceVersion, ch, s.endpoints)
+	if s.CuckooDedup {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.dedupEvents(ch, done)
+	}
+	if s.EnableWatchLatencyLog {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.timeEvents("endpoints", ch, done)
+	}
+	if s.EventStore != nil {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.storeEvents(EndpointsResource, ch, done)
+	}
+	if s.RawEvents != nil {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.forwardRawEvents(ch, done)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch = s.untilClosed(ch, done, s.buffer.relisting(EndpointsResource))
+	timeout, stop := s.watchTimeout()
+	defer stop()
+	stopHeartbeats := s.startHeartbeats(EndpointsResource, resourceVersion)
+	defer stopHeartbeats()
+	err = handleEndpointsWatch(log, resourceVersion, ch, s.sendEndpoints, timeout, initial)
+	stopHeartbeats()
+	if err != nil {
+		log.Errorf("Watch for endpoints changes failed: %v", err)
+		s.reportFailure(err)
+		s.deadLetterWatch(EndpointsResource, err)
+		s.sleep(wait.Jitter(s.waitDuration, 0.0))
+	}
 }
 
-// handleEndpointsWatch loops over an event channel and delivers config changes to an update channel.
-func handleEndpointsWatch(resourceVersion *uint64, ch <-chan watch.Event, updates chan<- EndpointsUpdate) {
+// watchEndpoints opens a watch on endpoints, decoding the stream directly if the client supports it.
+func (s *SourceAPI) watchEndpoints(client Watcher, resourceVersion uint64) (watch.Interface, error) {
+	if streamer, ok := client.(WatchStreamer); ok {
+		resp, err := streamer.StreamEndpoints(labels.Everything(), labels.Everything(), resourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		return watch.NewStreamWatcher(s.watchDecoder(resp)), nil
+	}
+	return checkWatch(client.WatchEndpoints(labels.Everything(), labels.Everything(), resourceVersion))
+}
+
+// handleEndpointsWatch loops over an event channel and delivers config changes with send.
+// It returns nil when the event channel is closed or timeout fires, a WatchError if the watch
+// sends an error, and a DecodeError if it sends something other than an endpoints object.
+// If initial, the ADDED events the watch starts with are sent as one SET, as in
+// handleServicesWatch.
+func handleEndpointsWatch(log *connLog, resourceVersion *versionTracker, ch <-chan watch.Event, send func(EndpointsUpdate), timeout <-chan time.Time, initial bool) error {
+	initialEndpoints := []api.Endpoints{}
+	initialVersion := uint64(0)
 	for {
 		select {
+		case <-timeout:
+			log.V(2).Infof("WatchEndpoints timed out, reconnecting")
+			return nil
+
 		case event, ok := <-ch:
 			if !ok {
-				glog.V(2).Infof("WatchEndpoints channel closed")
-				return
+				log.V(2).Infof("WatchEndpoints channel closed")
+				return nil
+			}
+			if event.Type == watch.Error {
+				return watchFailed(EndpointsResource, resourceVersion, event.Object)
 			}
 
-			endpoints := event.Object.(*api.Endpoints)
-			*resourceVersion = endpoints.ResourceVersion + 1
+			endpoints, ok := event.Object.(*api.Endpoints)
+			if !ok {
+				return unexpectedObject(EndpointsResource, resourceVersion.Get(), event.Object)
+			}
+			if initial {
+				if event.Type == watch.Added {
+					initialEndpoints = append(initialEndpoints, *endpoints)
+					if endpoints.ResourceVersion >= initialVersion {
+						initialVersion = endpoints.ResourceVersion + 1
+					}
+					continue
+				}
+				send(EndpointsUpdate{Op: SET, Endpoints: initialEndpoints})
+				resourceVersion.Advance(initialVersion)
+				initial = false
+			}
+			if event.Type == watchBookmark {
+				resourceVersion.Advance(endpoints.ResourceVersion + 1)
+				continue
+			}
+			// Drop stale events, as in handleServicesWatch.
+			if !resourceVersion.Advance(endpoints.ResourceVersion + 1) {
+				log.V(2).Infof("Ignoring stale endpoints event for %s at resource version %d", endpoints.ID, endpoints.ResourceVersion)
+				continue
+			}
 
 			switch event.Type {
 			case watch.Added, watch.Modified:
-				updates <- EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{*endpoints}}
+				send(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{*endpoints}})
 
 			case watch.Deleted:
-				updates <- EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{*endpoints}}
+				send(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{*endpoints}})
 			}
 		}
 	}
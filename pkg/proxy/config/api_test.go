@@ -17,66 +17,1003 @@ limitations under the License.
 package config
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
 )
 
+// fakeClock is a Clock whose time only moves when Step is called.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeClockWaiter
+}
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeClockWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{clock: c, ch: c.After(d)}
+}
+
+// BlockUntil waits until n goroutines are waiting on the clock.
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	for i := 0; i < 1000; i++ {
+		c.lock.Lock()
+		waiting := len(c.waiters)
+		c.lock.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters", n)
+}
+
+// Step advances the clock by d, firing any waiters whose deadline has passed.
+func (c *fakeClock) Step(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	var pending []fakeClockWaiter
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// fakeTimer is a Timer of a fakeClock.
+type fakeTimer struct {
+	clock *fakeClock
+	ch    <-chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	for i, w := range t.clock.waiters {
+		if w.ch == t.ch {
+			t.clock.waiters = append(t.clock.waiters[:i], t.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 func TestServices(t *testing.T) {
 	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
 
 	fakeWatch := watch.NewFake()
 	fakeClient := &client.Fake{Watch: fakeWatch}
-	services := make(chan ServiceUpdate)
-	source := SourceAPI{client: fakeClient, services: services}
-	resourceVersion := uint64(1)
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.serviceVersion.Set(1)
+	go func() {
+		// called twice
+		source.runServices()
+		source.runServices()
+	}()
+
+	// test adding a service to the watch
+	fakeWatch.Add(&service)
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}}) {
+		t.Errorf("expected call to watch-services, got %#v", fakeClient)
+	}
+
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// verify that a delete results in a config change
+	service.ResourceVersion = 3
+	fakeWatch.Delete(&service)
+	actual = <-services
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// verify that closing the channel results in a new call to WatchServices with a higher resource version
+	newFakeWatch := watch.NewFake()
+	fakeClient.Watch = newFakeWatch
+	fakeWatch.Stop()
+
+	newFakeWatch.Add(&service)
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}, {"watch-services", uint64(4)}}) {
+		t.Errorf("expected call to watch-endpoints, got %#v", fakeClient)
+	}
+}
+
+func TestServicesModified(t *testing.T) {
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(5)}, Port: 80}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.serviceVersion.Set(1)
+	go func() {
+		// called twice
+		source.runServices()
+		source.runServices()
+	}()
+
+	// a modification is sent as an ADD, which replaces the service
+	fakeWatch.Modify(&service)
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	// the watch resumes after the modification
+	if version := source.serviceVersion.Get(); version != 6 {
+		t.Errorf("expected resource version 6, got %d", version)
+	}
+
+	newFakeWatch := watch.NewFake()
+	fakeClient.Watch = newFakeWatch
+	fakeWatch.Stop()
+
+	newFakeWatch.Add(&service)
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}, {"watch-services", uint64(6)}}) {
+		t.Errorf("expected a watch from resource version 6, got %#v", fakeClient.Actions)
+	}
+}
+
+func TestServicesOrdered(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: &client.Fake{Watch: fakeWatch}, services: services}
+	source.serviceVersion.Set(1)
+	go source.runServices()
+	go func() {
+		for i := 0; i < 50; i++ {
+			service := api.Service{JSONBase: api.JSONBase{ID: fmt.Sprintf("service-%d", i%5), ResourceVersion: uint64(i + 1)}}
+			fakeWatch.Add(&service)
+		}
+		fakeWatch.Stop()
+	}()
+
+	// the updates arrive in the order of the events
+	last := uint64(0)
+	for i := 0; i < 50; i++ {
+		update := <-services
+		if update.Op != ADD {
+			t.Fatalf("expected an ADD, got %#v", update)
+		}
+		version := update.Services[0].ResourceVersion
+		if version < last {
+			t.Errorf("expected resource version %d after %d", version, last)
+		}
+		last = version
+	}
+	if last != 50 {
+		t.Errorf("expected the last update at resource version 50, got %d", last)
+	}
+}
+
+// selectorClient records the label selectors of the lists and watches of services.
+type selectorClient struct {
+	*client.Fake
+	selectors []string
+}
+
+func (c *selectorClient) ListServices(selector labels.Selector) (*api.ServiceList, error) {
+	c.selectors = append(c.selectors, selector.String())
+	return c.Fake.ListServices(selector)
+}
+
+func (c *selectorClient) WatchServices(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	c.selectors = append(c.selectors, label.String())
+	return c.Fake.WatchServices(label, field, resourceVersion)
+}
+
+func TestServicesSelector(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}, Port: 80, Labels: map[string]string{"app": "web"}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}, Port: 81, Labels: map[string]string{"app": "db"}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &selectorClient{Fake: &client.Fake{Watch: fakeWatch}}
+	fakeClient.ServiceList = api.ServiceList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items:    []api.Service{foo, bar},
+	}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.ServiceSelector = labels.Set{"app": "web"}.AsSelector()
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+
+	// services the apiserver sends that do not match are left out
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{foo}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// a service modified to no longer match is removed, and added once it matches again
+	moved := foo
+	moved.ResourceVersion = 3
+	moved.Labels = map[string]string{"app": "db"}
+	fakeWatch.Modify(&moved)
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{moved}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	back := foo
+	back.ResourceVersion = 4
+	fakeWatch.Modify(&back)
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{back}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	fakeWatch.Stop()
+	<-done
+	if expected := []string{"app=web", "app=web"}; !reflect.DeepEqual(expected, fakeClient.selectors) {
+		t.Errorf("expected the selector to be passed to the apiserver, got %#v", fakeClient.selectors)
+	}
+}
+
+func TestServicesFromZero(t *testing.T) {
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
+
+	fakeWatch := watch.NewFake()
+	fakeWatch.Stop()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items: []api.Service{
+			service,
+		},
+	}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// should get services SET
+	actual := <-services
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// should have listed, then watched
+	<-ch
+	if source.serviceVersion.Get() != 2 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}, {"watch-services", uint64(2)}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient)
+	}
+}
+
+func TestSendInitialEvents(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: uint64(2)}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(4)}}
+	endpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(3)}, Endpoints: []string{"10.0.0.1:80"}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	servicesCh := make(chan ServiceUpdate)
+	endpointsCh := make(chan EndpointsUpdate)
+	source := SourceAPI{client: fakeClient, services: servicesCh, endpoints: endpointsCh}
+	source.SendInitialEvents = true
+	run := func(f func()) chan struct{} {
+		ch := make(chan struct{})
+		go func() {
+			f()
+			close(ch)
+		}()
+		return ch
+	}
+
+	// a watch that ends before its initial events do sends nothing, and the next starts over
+	ch := run(source.runServices)
+	fakeWatch.Add(&foo)
+	fakeWatch.Stop()
+	<-ch
+	if source.serviceVersion.Get() != 0 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+
+	// the existing services come from the watch rather than a list, as one SET
+	fakeWatch = watch.NewFake()
+	fakeClient.Watch = fakeWatch
+	ch = run(source.runServices)
+	fakeWatch.Add(&foo)
+	fakeWatch.Add(&bar)
+	fakeWatch.Action(watchBookmark, &api.Service{JSONBase: api.JSONBase{ResourceVersion: uint64(5)}})
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{foo, bar}}
+	if actual := <-servicesCh; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	// then later events are sent as they come
+	foo.ResourceVersion = 6
+	fakeWatch.Modify(&foo)
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{foo}}
+	if actual := <-servicesCh; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Stop()
+	<-ch
+	if source.serviceVersion.Get() != 7 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+
+	// an event other than ADDED ends the initial events too
+	fakeWatch = watch.NewFake()
+	fakeClient.Watch = fakeWatch
+	ch = run(source.runEndpoints)
+	fakeWatch.Add(&endpoints)
+	modified := endpoints
+	modified.ResourceVersion = 4
+	fakeWatch.Modify(&modified)
+	if actual := <-endpointsCh; !reflect.DeepEqual(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{endpoints}}, actual) {
+		t.Errorf("unexpected update %#v", actual)
+	}
+	if actual := <-endpointsCh; !reflect.DeepEqual(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{modified}}, actual) {
+		t.Errorf("unexpected update %#v", actual)
+	}
+	fakeWatch.Stop()
+	<-ch
+
+	// nothing was listed
+	expectedActions := []client.FakeAction{{"watch-services", uint64(0)}, {"watch-services", uint64(0)}, {"watch-endpoints", uint64(0)}}
+	if !reflect.DeepEqual(fakeClient.Actions, expectedActions) {
+		t.Errorf("unexpected actions, got %#v", fakeClient)
+	}
+}
+
+func TestServicesFromZeroEmpty(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeWatch.Stop()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 2}}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// should get an empty SET before watching
+	actual := <-services
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	<-ch
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}, {"watch-services", uint64(2)}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient)
+	}
+}
+
+func TestStartBarrier(t *testing.T) {
+	newClient := func() *client.Fake {
+		fakeWatch := watch.NewFake()
+		fakeWatch.Stop()
+		fakeClient := &client.Fake{Watch: fakeWatch}
+		fakeClient.ServiceList = api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 2}}
+		fakeClient.EndpointsList = api.EndpointsList{JSONBase: api.JSONBase{ResourceVersion: 2}}
+		return fakeClient
+	}
+	barrier := make(chan struct{})
+	// the services and endpoints of separate sources, as the fake client is not safe for
+	// concurrent use
+	servicesClient, endpointsClient := newClient(), newClient()
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	servicesSource := SourceAPI{client: servicesClient, services: services}
+	servicesSource.StartBarrier = barrier
+	endpointsSource := SourceAPI{client: endpointsClient, endpoints: endpoints}
+	endpointsSource.StartBarrier = barrier
+	servicesDone, endpointsDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		servicesSource.runServices()
+		close(servicesDone)
+	}()
+	go func() {
+		endpointsSource.runEndpoints()
+		close(endpointsDone)
+	}()
+
+	// nothing is listed until the barrier is closed
+	select {
+	case update := <-services:
+		t.Fatalf("unexpected update before the barrier was closed: %#v", update)
+	case update := <-endpoints:
+		t.Fatalf("unexpected update before the barrier was closed: %#v", update)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(barrier)
+	if update := <-services; update.Op != SET {
+		t.Errorf("expected a SET, got %#v", update)
+	}
+	if update := <-endpoints; update.Op != SET {
+		t.Errorf("expected a SET, got %#v", update)
+	}
+	<-servicesDone
+	<-endpointsDone
+	if expected := []client.FakeAction{{"list-services", nil}, {"watch-services", uint64(2)}}; !reflect.DeepEqual(expected, servicesClient.Actions) {
+		t.Errorf("expected %#v, got %#v", expected, servicesClient.Actions)
+	}
+	if expected := []client.FakeAction{{"list-endpoints", nil}, {"watch-endpoints", uint64(2)}}; !reflect.DeepEqual(expected, endpointsClient.Actions) {
+		t.Errorf("expected %#v, got %#v", expected, endpointsClient.Actions)
+	}
+}
+
+func TestStartBarrierClose(t *testing.T) {
+	fakeClient := &client.Fake{}
+	source := SourceAPI{client: fakeClient, services: make(chan ServiceUpdate, 1)}
+	source.StartBarrier = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+
+	// closing the source gives up on the barrier, and nothing is listed
+	source.Close()
+	<-done
+	if len(fakeClient.Actions) != 0 {
+		t.Errorf("expected no requests, got %#v", fakeClient.Actions)
+	}
+}
+
+func TestEndpointsFromServices(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{EndpointsLabel: "10.0.0.1:80, 10.0.0.2:80"}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 3}, Port: 81, Labels: map[string]string{NamespaceLabel: "a"}}
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 2}, Items: []api.Service{foo}}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := NewSourceAPIWithOptions(fakeClient, time.Minute, services, endpoints, SourceAPIOptions{EndpointsFromServices: true})
+
+	if update := <-services; !reflect.DeepEqual(ServiceUpdate{Op: SET, Services: []api.Service{foo}}, update) {
+		t.Errorf("unexpected update %#v", update)
+	}
+	expected := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+	}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// a service without endpoints has none
+	fakeWatch.Add(&bar)
+	<-services
+	expected = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "a/bar"}, Endpoints: []string{}},
+	}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	if expected := []client.FakeAction{{"list-services", nil}, {"watch-services", uint64(2)}}; !reflect.DeepEqual(expected, fakeClient.Actions) {
+		t.Errorf("expected %#v, got %#v", expected, fakeClient.Actions)
+	}
+
+	go source.Close()
+	<-services
+	<-endpoints
+}
+
+func TestServicesObserve(t *testing.T) {
+	var logged []string
+	defer func(logf func(string, ...interface{})) { observeLogf = logf }(observeLogf)
+	observeLogf = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	fakeWatch := watch.NewFake()
+	fakeWatch.Stop()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items:    []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}, {JSONBase: api.JSONBase{ID: "bar"}}},
+	}
+	services := make(chan ServiceUpdate, 3)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.Observe = true
+	source.SnapshotFencing = true
+	source.runServices()
+
+	if len(services) != 0 {
+		t.Errorf("expected no updates to be sent, got %#v", <-services)
+	}
+	expected := []string{
+		"Observe: not sending SNAPSHOT_START of services []",
+		"Observe: not sending SET of services [foo bar]",
+		"Observe: not sending SNAPSHOT_END of services []",
+	}
+	if !reflect.DeepEqual(expected, logged) {
+		t.Errorf("expected %#v, got %#v", expected, logged)
+	}
+}
+
+func TestServicesSnapshotFencing(t *testing.T) {
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items: []api.Service{
+			service,
+		},
+	}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.SnapshotFencing = true
+	go source.runServices()
+
+	// the SET from the list should be fenced
+	for _, expected := range []ServiceUpdate{
+		{Op: SNAPSHOT_START},
+		{Op: SET, Services: []api.Service{service}},
+		{Op: SNAPSHOT_END},
+	} {
+		actual := <-services
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v", expected, actual)
+		}
+	}
+
+	// changes from the watch should not be fenced
+	fakeWatch.Add(&service)
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Stop()
+}
+
+func TestServicesWatchTimeout(t *testing.T) {
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, services: services}
+	source.WatchTimeout = time.Minute
+	source.Clock = clock
+	source.serviceVersion.Set(1)
+	go func() {
+		// called twice
+		source.runServices()
+		source.runServices()
+	}()
+
+	fakeWatch.Add(&service)
+	<-services
+
+	// the watch should stay open until the timeout has elapsed
+	newFakeWatch := watch.NewFake()
+	fakeClient.Watch = newFakeWatch
+	clock.Step(time.Minute - time.Second)
+	if fakeWatch.Stopped {
+		t.Errorf("expected watch to stay open before the timeout")
+	}
+
+	// once it elapses the watch is re-established from the current resource version
+	clock.Step(time.Second)
+	service.ResourceVersion = 3
+	newFakeWatch.Add(&service)
+	<-services
+	if !fakeWatch.Stopped {
+		t.Errorf("expected timed out watch to be stopped")
+	}
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}, {"watch-services", uint64(3)}}) {
+		t.Errorf("expected reconnect to watch-services, got %#v", fakeClient)
+	}
+	newFakeWatch.Stop()
+}
+
+func TestServicesStaleEvent(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.serviceVersion.Set(1)
+	go source.runServices()
+
+	newer := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(5)}, Port: 2}
+	fakeWatch.Add(&newer)
+	<-services
+
+	// an event older than the last one processed is dropped
+	older := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(3)}, Port: 1}
+	fakeWatch.Modify(&older)
+	latest := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(6)}, Port: 3}
+	fakeWatch.Modify(&latest)
+
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{latest}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if source.serviceVersion.Get() != 7 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+	fakeWatch.Stop()
+}
+
+func TestServicesResync(t *testing.T) {
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &flakyClient{Fake: &client.Fake{Watch: fakeWatch}, failures: 1}
+	fakeClient.ServiceList = api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 1}}
+	services := make(chan ServiceUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, services: services, waitDuration: time.Minute, reconnectDuration: time.Second}
+	source.Clock = clock
+	go source.forever(source.runServices)
+
+	// the failed list waits for the period, then the loop for the reconnect delay and the period
+	for _, d := range []time.Duration{2 * time.Minute, 2 * time.Second} {
+		clock.BlockUntil(t, 1)
+		clock.Step(d)
+	}
+	clock.BlockUntil(t, 1)
+	select {
+	case update := <-services:
+		t.Fatalf("unexpected update before the resync %#v", update)
+	default:
+	}
+	clock.Step(time.Minute)
+
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Add(&service)
+	<-services
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}, {"list-services", nil}, {"watch-services", uint64(1)}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient.Actions)
+	}
+}
+
+func TestServicesBookmark(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.serviceVersion.Set(1)
+	go func() {
+		// called twice
+		source.runServices()
+		source.runServices()
+	}()
+
+	// a bookmark only moves the resource version forward
+	fakeWatch.Action(watchBookmark, &api.Service{JSONBase: api.JSONBase{ResourceVersion: uint64(5)}})
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(6)}}
+	fakeWatch.Add(&service)
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// the next watch resumes after the last bookmark without listing
+	newFakeWatch := watch.NewFake()
+	fakeClient.Watch = newFakeWatch
+	fakeWatch.Action(watchBookmark, &api.Service{JSONBase: api.JSONBase{ResourceVersion: uint64(9)}})
+	fakeWatch.Stop()
+	newFakeWatch.Add(&api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(10)}})
+	<-services
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}, {"watch-services", uint64(10)}}) {
+		t.Errorf("expected call to watch-services, got %#v", fakeClient)
+	}
+	newFakeWatch.Stop()
+}
+
+func TestEndpointsBookmark(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: fakeClient, endpoints: endpoints}
+	source.endpointsVersion.Set(1)
+	done := make(chan struct{})
+	go func() {
+		source.runEndpoints()
+		close(done)
+	}()
+
+	fakeWatch.Action(watchBookmark, &api.Endpoints{JSONBase: api.JSONBase{ResourceVersion: uint64(5)}})
+	fakeWatch.Stop()
+	<-done
+	if source.endpointsVersion.Get() != 6 {
+		t.Errorf("unexpected resource version, got %#v", source.endpointsVersion.Get())
+	}
+}
+
+func TestServicesError(t *testing.T) {
+	fakeClient := &client.Fake{Err: errors.New("test")}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// should have listed only
+	<-ch
+	if source.serviceVersion.Get() != 1 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient)
+	}
+}
+
+func TestServicesWatchClosed(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+	source.Clock = clock
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// a watch that is closed by the server returns without backing off
+	fakeWatch.Stop()
+	<-ch
+	if len(clock.waiters) != 0 {
+		t.Errorf("expected no wait after a closed watch, got %d waiters", len(clock.waiters))
+	}
+}
+
+func TestServicesWatchError(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+	source.Clock = clock
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
 	go func() {
-		// called twice
-		source.runServices(&resourceVersion)
-		source.runServices(&resourceVersion)
+		source.runServices()
+		close(ch)
 	}()
 
-	// test adding a service to the watch
-	fakeWatch.Add(&service)
-	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}}) {
-		t.Errorf("expected call to watch-services, got %#v", fakeClient)
+	// an error event backs off before returning
+	fakeWatch.Error(&api.Status{Message: "too old resource version", Code: 410})
+	clock.BlockUntil(t, 1)
+	select {
+	case <-ch:
+		t.Fatalf("expected runServices to back off after a watch error")
+	default:
+	}
+	clock.Step(2 * time.Minute)
+	<-ch
+	// the expired resource version is dropped, so the next run lists again
+	if source.serviceVersion.Get() != 0 {
+		t.Errorf("expected the resource version to be reset, got %#v", source.serviceVersion.Get())
 	}
 
-	actual := <-services
-	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
-	if !reflect.DeepEqual(expected, actual) {
-		t.Errorf("expected %#v, got %#v", expected, actual)
+	// other errors resume from the same resource version
+	fakeWatch = watch.NewFake()
+	fakeClient.Watch = fakeWatch
+	source.serviceVersion.Set(1)
+	ch = make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+	fakeWatch.Error(&api.Status{Message: "internal error", Code: 500})
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+	if source.serviceVersion.Get() != 1 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
 	}
+}
 
-	// verify that a delete results in a config change
-	fakeWatch.Delete(&service)
-	actual = <-services
-	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{service}}
-	if !reflect.DeepEqual(expected, actual) {
-		t.Errorf("expected %#v, got %#v", expected, actual)
+func TestServicesNilWatch(t *testing.T) {
+	// a client that returns neither a watch nor an error
+	fakeClient := &client.Fake{}
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+	source.Clock = clock
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		source.runServices()
+		close(ch)
+	}()
+
+	// each attempt backs off before the next
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(t, 1)
+		clock.Step(2 * time.Minute)
+	}
+	<-ch
+	expected := []client.FakeAction{{"watch-services", uint64(1)}, {"watch-services", uint64(1)}}
+	if !reflect.DeepEqual(fakeClient.Actions, expected) {
+		t.Errorf("expected %#v, got %#v", expected, fakeClient.Actions)
 	}
+}
 
-	// verify that closing the channel results in a new call to WatchServices with a higher resource version
-	newFakeWatch := watch.NewFake()
-	fakeClient.Watch = newFakeWatch
-	fakeWatch.Stop()
+func TestEndpointsNilWatch(t *testing.T) {
+	fakeClient := &client.Fake{}
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+	source.Clock = clock
+	source.endpointsVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runEndpoints()
+		close(ch)
+	}()
 
-	newFakeWatch.Add(&service)
-	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}, {"watch-services", uint64(3)}}) {
-		t.Errorf("expected call to watch-endpoints, got %#v", fakeClient)
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-endpoints", uint64(1)}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient.Actions)
 	}
 }
 
-func TestServicesFromZero(t *testing.T) {
+// hangingClient is a client whose lists and watches are not answered until they are aborted,
+// which it reports on cancelled.
+type hangingClient struct {
+	*client.Fake
+	ctx       context.Context
+	cancelled chan error
+}
+
+func (c *hangingClient) WithContext(ctx context.Context) Watcher {
+	return &hangingClient{Fake: c.Fake, ctx: ctx, cancelled: c.cancelled}
+}
+
+func (c *hangingClient) hang() error {
+	<-c.ctx.Done()
+	c.cancelled <- c.ctx.Err()
+	return c.ctx.Err()
+}
+
+func (c *hangingClient) ListServices(selector labels.Selector) (*api.ServiceList, error) {
+	return nil, c.hang()
+}
+
+func (c *hangingClient) WatchServices(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return nil, c.hang()
+}
+
+func TestServicesRequestTimeout(t *testing.T) {
+	for _, resourceVersion := range []uint64{0, 1} {
+		fakeClient := &hangingClient{Fake: &client.Fake{}, cancelled: make(chan error, 1)}
+		clock := newFakeClock()
+		failures := make(failureReporter, 1)
+		source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+		source.Clock = clock
+		source.Health = failures
+		source.RequestTimeout = 10 * time.Second
+		source.serviceVersion.Set(resourceVersion)
+		ch := make(chan struct{})
+		go func() {
+			source.runServices()
+			close(ch)
+		}()
+
+		// a list, or a watch, that is not answered is aborted after the timeout
+		clock.BlockUntil(t, 1)
+		clock.Step(10 * time.Second)
+		if err := <-fakeClient.cancelled; err != context.Canceled {
+			t.Errorf("expected the request to be cancelled, got %v", err)
+		}
+		if err := <-failures; !errors.Is(err, errRequestTimeout) {
+			t.Errorf("expected %v, got %v", errRequestTimeout, err)
+		}
+		clock.BlockUntil(t, 1)
+		clock.Step(2 * time.Minute)
+		<-ch
+	}
+}
+
+func TestServicesFromZeroError(t *testing.T) {
+	fakeClient := &client.Fake{Err: errors.New("test")}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// should have listed only
+	<-ch
+	if source.serviceVersion.Get() != 0 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient)
+	}
+}
+
+// flakyClient fails the first failures lists of services, then behaves like the wrapped client.
+type flakyClient struct {
+	*client.Fake
+	failures int
+}
+
+func (c *flakyClient) ListServices(selector labels.Selector) (*api.ServiceList, error) {
+	if c.failures > 0 {
+		c.failures--
+		c.Actions = append(c.Actions, client.FakeAction{Action: "list-services"})
+		return nil, errors.New("unavailable")
+	}
+	return c.Fake.ListServices(selector)
+}
+
+func TestServicesFromZeroRetry(t *testing.T) {
 	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
 
 	fakeWatch := watch.NewFake()
 	fakeWatch.Stop()
-	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient := &flakyClient{Fake: &client.Fake{Watch: fakeWatch}, failures: 1}
 	fakeClient.ServiceList = api.ServiceList{
 		JSONBase: api.JSONBase{ResourceVersion: 2},
 		Items: []api.Service{
@@ -85,69 +1022,79 @@ func TestServicesFromZero(t *testing.T) {
 	}
 	services := make(chan ServiceUpdate)
 	source := SourceAPI{client: fakeClient, services: services}
-	resourceVersion := uint64(0)
+	source.InitialListAttempts = 3
+	source.ListRetryBackoff = time.Millisecond
 	ch := make(chan struct{})
 	go func() {
-		source.runServices(&resourceVersion)
+		source.runServices()
 		close(ch)
 	}()
 
-	// should get services SET
+	// the failed list is retried and the services eventually SET
 	actual := <-services
 	expected := ServiceUpdate{Op: SET, Services: []api.Service{service}}
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("expected %#v, got %#v", expected, actual)
 	}
-
-	// should have listed, then watched
 	<-ch
-	if resourceVersion != 2 {
-		t.Errorf("unexpected resource version, got %#v", resourceVersion)
-	}
-	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}, {"watch-services", uint64(2)}}) {
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}, {"list-services", nil}, {"watch-services", uint64(2)}}) {
 		t.Errorf("unexpected actions, got %#v", fakeClient)
 	}
 }
 
-func TestServicesError(t *testing.T) {
-	fakeClient := &client.Fake{Err: errors.New("test")}
-	services := make(chan ServiceUpdate)
-	source := SourceAPI{client: fakeClient, services: services}
-	resourceVersion := uint64(1)
-	ch := make(chan struct{})
-	go func() {
-		source.runServices(&resourceVersion)
-		close(ch)
-	}()
+// pagedClient lists its services in pages of the requested size.
+type pagedClient struct {
+	*client.Fake
+}
 
-	// should have listed only
-	<-ch
-	if resourceVersion != 1 {
-		t.Errorf("unexpected resource version, got %#v", resourceVersion)
+func (c *pagedClient) ListServicesPage(selector labels.Selector, limit int, continueToken string) (*api.ServiceList, string, error) {
+	c.Actions = append(c.Actions, client.FakeAction{Action: "list-services-page", Value: continueToken})
+	start := 0
+	if continueToken != "" {
+		start, _ = strconv.Atoi(continueToken)
 	}
-	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(1)}}) {
-		t.Errorf("unexpected actions, got %#v", fakeClient)
+	end := start + limit
+	next := strconv.Itoa(end)
+	if end >= len(c.ServiceList.Items) {
+		end = len(c.ServiceList.Items)
+		next = ""
 	}
+	page := api.ServiceList{Items: c.ServiceList.Items[start:end]}
+	// later pages are served from the snapshot of the first
+	if start == 0 {
+		page.ResourceVersion = c.ServiceList.ResourceVersion
+	}
+	return &page, next, nil
 }
 
-func TestServicesFromZeroError(t *testing.T) {
-	fakeClient := &client.Fake{Err: errors.New("test")}
-	services := make(chan ServiceUpdate)
+func TestServicesFromZeroPaged(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 1}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz", ResourceVersion: 3}}
+
+	fakeWatch := watch.NewFake()
+	fakeWatch.Stop()
+	fakeClient := &pagedClient{Fake: &client.Fake{Watch: fakeWatch}}
+	fakeClient.ServiceList = api.ServiceList{
+		JSONBase: api.JSONBase{ResourceVersion: 3},
+		Items:    []api.Service{foo, bar, baz},
+	}
+	services := make(chan ServiceUpdate, 2)
 	source := SourceAPI{client: fakeClient, services: services}
-	resourceVersion := uint64(0)
-	ch := make(chan struct{})
-	go func() {
-		source.runServices(&resourceVersion)
-		close(ch)
-	}()
+	source.ListPageSize = 2
+	source.runServices()
 
-	// should have listed only
-	<-ch
-	if resourceVersion != 0 {
-		t.Errorf("unexpected resource version, got %#v", resourceVersion)
+	// both pages make up a single SET
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{foo, bar, baz}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
 	}
-	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-services", nil}}) {
-		t.Errorf("unexpected actions, got %#v", fakeClient)
+	if len(services) != 0 {
+		t.Errorf("unexpected update %#v", <-services)
+	}
+	expectedActions := []client.FakeAction{{"list-services-page", ""}, {"list-services-page", "2"}, {"watch-services", uint64(3)}}
+	if !reflect.DeepEqual(expectedActions, fakeClient.Actions) {
+		t.Errorf("expected %#v, got %#v", expectedActions, fakeClient.Actions)
 	}
 }
 
@@ -158,11 +1105,11 @@ func TestEndpoints(t *testing.T) {
 	fakeClient := &client.Fake{Watch: fakeWatch}
 	endpoints := make(chan EndpointsUpdate)
 	source := SourceAPI{client: fakeClient, endpoints: endpoints}
-	resourceVersion := uint64(1)
+	source.endpointsVersion.Set(1)
 	go func() {
 		// called twice
-		source.runEndpoints(&resourceVersion)
-		source.runEndpoints(&resourceVersion)
+		source.runEndpoints()
+		source.runEndpoints()
 	}()
 
 	// test adding an endpoint to the watch
@@ -178,6 +1125,7 @@ func TestEndpoints(t *testing.T) {
 	}
 
 	// verify that a delete results in a config change
+	endpoint.ResourceVersion = 3
 	fakeWatch.Delete(&endpoint)
 	actual = <-endpoints
 	expected = EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{endpoint}}
@@ -191,7 +1139,7 @@ func TestEndpoints(t *testing.T) {
 	fakeWatch.Stop()
 
 	newFakeWatch.Add(&endpoint)
-	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-endpoints", uint64(1)}, {"watch-endpoints", uint64(3)}}) {
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-endpoints", uint64(1)}, {"watch-endpoints", uint64(4)}}) {
 		t.Errorf("expected call to watch-endpoints, got %#v", fakeClient)
 	}
 }
@@ -210,10 +1158,9 @@ func TestEndpointsFromZero(t *testing.T) {
 	}
 	endpoints := make(chan EndpointsUpdate)
 	source := SourceAPI{client: fakeClient, endpoints: endpoints}
-	resourceVersion := uint64(0)
 	ch := make(chan struct{})
 	go func() {
-		source.runEndpoints(&resourceVersion)
+		source.runEndpoints()
 		close(ch)
 	}()
 
@@ -226,29 +1173,142 @@ func TestEndpointsFromZero(t *testing.T) {
 
 	// should have listed, then watched
 	<-ch
-	if resourceVersion != 2 {
-		t.Errorf("unexpected resource version, got %#v", resourceVersion)
+	if source.endpointsVersion.Get() != 2 {
+		t.Errorf("unexpected resource version, got %#v", source.endpointsVersion.Get())
 	}
 	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-endpoints", nil}, {"watch-endpoints", uint64(2)}}) {
 		t.Errorf("unexpected actions, got %#v", fakeClient)
 	}
 }
 
+func TestEndpointsDuplicates(t *testing.T) {
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}, Endpoints: []string{"10.0.0.1:80"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}, Endpoints: []string{"10.0.0.2:80", "10.0.0.1:80", "10.0.0.2:80"}}
+	baz := api.Endpoints{JSONBase: api.JSONBase{ID: "baz", ResourceVersion: 3}, Endpoints: []string{"10.0.0.3:80", "10.0.0.3:80"}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.EndpointsList = api.EndpointsList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items:    []api.Endpoints{foo, bar},
+	}
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: fakeClient, endpoints: endpoints}
+	go source.runEndpoints()
+
+	// only the first of each address is sent, in the order they came in
+	uniqueBar := bar
+	uniqueBar.Endpoints = []string{"10.0.0.2:80", "10.0.0.1:80"}
+	expected := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo, uniqueBar}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if len(fakeClient.EndpointsList.Items[1].Endpoints) != 3 {
+		t.Errorf("expected the listed endpoints to be left as they are, got %#v", fakeClient.EndpointsList.Items[1])
+	}
+
+	fakeWatch.Add(&baz)
+	uniqueBaz := baz
+	uniqueBaz.Endpoints = []string{"10.0.0.3:80"}
+	expected = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{uniqueBaz}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Stop()
+}
+
+func TestEndpointsProtocol(t *testing.T) {
+	dns := api.Endpoints{JSONBase: api.JSONBase{ID: "dns"}, Endpoints: []string{"127.0.0.1:53"}}
+	web := api.Endpoints{JSONBase: api.JSONBase{ID: "web"}, Endpoints: []string{"127.0.0.1:80"}}
+
+	fakeWatch := watch.NewFake()
+	fakeWatch.Stop()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items: []api.Service{
+			{JSONBase: api.JSONBase{ID: "dns"}, Port: 53, Protocol: "UDP"},
+			{JSONBase: api.JSONBase{ID: "web"}, Port: 80},
+		},
+	}
+	fakeClient.EndpointsList = api.EndpointsList{
+		JSONBase: api.JSONBase{ResourceVersion: 2},
+		Items:    []api.Endpoints{dns, web},
+	}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: fakeClient, services: services, endpoints: endpoints}
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+	<-services
+	<-ch
+	go source.runEndpoints()
+
+	// the protocol of the UDP service is carried along its endpoints, the other defaults to TCP
+	actual := <-endpoints
+	expected := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{dns, web}, Protocols: map[string]string{"dns": "UDP"}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if actual.Protocol("dns") != "UDP" || actual.Protocol("web") != "TCP" {
+		t.Errorf("unexpected protocols %q and %q", actual.Protocol("dns"), actual.Protocol("web"))
+	}
+
+	// once the service is removed its protocol is no longer known
+	go source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "dns"}}}})
+	<-services
+	if protocols := source.endpointsProtocols([]api.Endpoints{dns}); protocols != nil {
+		t.Errorf("expected no protocols, got %#v", protocols)
+	}
+}
+
+func TestEndpointsProtocolResent(t *testing.T) {
+	services := make(chan ServiceUpdate, 10)
+	endpoints := make(chan EndpointsUpdate, 10)
+	source := SourceAPI{services: services, endpoints: endpoints}
+
+	// the endpoints arrive before their service, so their protocol is not known yet
+	dns := api.Endpoints{JSONBase: api.JSONBase{ID: "dns"}, Endpoints: []string{"10.0.0.1:53"}}
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{dns}})
+	if update := <-endpoints; update.Protocols != nil {
+		t.Errorf("expected no protocols, got %#v", update.Protocols)
+	}
+	source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{{JSONBase: api.JSONBase{ID: "dns"}, Port: 53, Protocol: "UDP"}}})
+	<-services
+	expected := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{dns}, Protocols: map[string]string{"dns": "UDP"}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	// the protocol going back to the default is sent too
+	source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{{JSONBase: api.JSONBase{ID: "dns"}, Port: 53}}})
+	<-services
+	expected = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{dns}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if len(endpoints) != 0 {
+		t.Errorf("unexpected update %#v", <-endpoints)
+	}
+}
+
 func TestEndpointsError(t *testing.T) {
 	fakeClient := &client.Fake{Err: errors.New("test")}
 	endpoints := make(chan EndpointsUpdate)
 	source := SourceAPI{client: fakeClient, endpoints: endpoints}
-	resourceVersion := uint64(1)
+	source.endpointsVersion.Set(1)
 	ch := make(chan struct{})
 	go func() {
-		source.runEndpoints(&resourceVersion)
+		source.runEndpoints()
 		close(ch)
 	}()
 
 	// should have listed only
 	<-ch
-	if resourceVersion != 1 {
-		t.Errorf("unexpected resource version, got %#v", resourceVersion)
+	if source.endpointsVersion.Get() != 1 {
+		t.Errorf("unexpected resource version, got %#v", source.endpointsVersion.Get())
 	}
 	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-endpoints", uint64(1)}}) {
 		t.Errorf("unexpected actions, got %#v", fakeClient)
@@ -259,19 +1319,193 @@ func TestEndpointsFromZeroError(t *testing.T) {
 	fakeClient := &client.Fake{Err: errors.New("test")}
 	endpoints := make(chan EndpointsUpdate)
 	source := SourceAPI{client: fakeClient, endpoints: endpoints}
-	resourceVersion := uint64(0)
 	ch := make(chan struct{})
 	go func() {
-		source.runEndpoints(&resourceVersion)
+		source.runEndpoints()
 		close(ch)
 	}()
 
 	// should have listed only
 	<-ch
-	if resourceVersion != 0 {
-		t.Errorf("unexpected resource version, got %#v", resourceVersion)
+	if source.endpointsVersion.Get() != 0 {
+		t.Errorf("unexpected resource version, got %#v", source.endpointsVersion.Get())
 	}
 	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"list-endpoints", nil}}) {
 		t.Errorf("unexpected actions, got %#v", fakeClient)
 	}
 }
+
+func TestServicesResourceVersionConcurrentAccess(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// read the resource version while the watch is advancing it, run with -race to catch unguarded access
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					source.serviceVersion.Get()
+				}
+			}
+		}()
+	}
+
+	for i := 2; i <= 50; i++ {
+		fakeWatch.Add(&api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(i)}})
+		<-services
+	}
+	fakeWatch.Stop()
+	<-ch
+	close(stop)
+	readers.Wait()
+
+	if source.serviceVersion.Get() != 51 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+}
+
+func TestServicesInjection(t *testing.T) {
+	services := make(chan ServiceUpdate, 2)
+	source := SourceAPI{services: services}
+	service := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	if err := source.InjectService(service); err != ErrNotTestMode {
+		t.Errorf("expected %v, got %v", ErrNotTestMode, err)
+	}
+
+	source.TestMode = true
+	source.Store = NewConfigStore()
+	if err := source.InjectService(service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if actual, found := source.Store.GetService("foo"); !found || !reflect.DeepEqual(service, actual) {
+		t.Errorf("expected %#v, got %#v", service, actual)
+	}
+
+	if err := source.RemoveService("foo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if _, found := source.Store.GetService("foo"); found {
+		t.Errorf("expected foo to be removed from the store")
+	}
+}
+
+func TestServicesStampedWithSource(t *testing.T) {
+	service := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: uint64(2)}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, services: services}
+	source.Name = "apiserver"
+	source.Clock = clock
+	source.serviceVersion.Set(1)
+	go source.runServices()
+
+	fakeWatch.Add(&service)
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}, Source: "apiserver", Timestamp: clock.Now()}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Stop()
+}
+
+// benchmarkServicesEventProcessing measures the time SourceAPI takes to turn n watch events
+// into updates, each for a different service.
+func benchmarkServicesEventProcessing(b *testing.B, n int) {
+	events := make([]*api.Service, n)
+	for i := range events {
+		events[i] = &api.Service{JSONBase: api.JSONBase{ID: "service-" + strconv.Itoa(i), ResourceVersion: uint64(i + 1)}, Port: 80}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fakeWatch := watch.NewFake()
+		services := make(chan ServiceUpdate)
+		source := SourceAPI{client: &client.Fake{Watch: fakeWatch}, services: services}
+		source.serviceVersion.Set(1)
+		go source.runServices()
+		go func() {
+			for _, event := range events {
+				fakeWatch.Add(event)
+			}
+			fakeWatch.Stop()
+		}()
+		for j := 0; j < n; j++ {
+			<-services
+		}
+	}
+}
+
+func BenchmarkServicesEventProcessing100(b *testing.B) {
+	benchmarkServicesEventProcessing(b, 100)
+}
+
+func BenchmarkServicesEventProcessing10000(b *testing.B) {
+	benchmarkServicesEventProcessing(b, 10000)
+}
+
+func BenchmarkServicesEventProcessing100000(b *testing.B) {
+	benchmarkServicesEventProcessing(b, 100000)
+}
+
+// benchmarkEndpointsEventProcessing measures the time SourceAPI takes to turn n watch events
+// into updates, each for the endpoints of a different service.
+func benchmarkEndpointsEventProcessing(b *testing.B, n int) {
+	events := make([]*api.Endpoints, n)
+	for i := range events {
+		events[i] = &api.Endpoints{JSONBase: api.JSONBase{ID: "service-" + strconv.Itoa(i), ResourceVersion: uint64(i + 1)}, Endpoints: []string{"10.0.0.1:80"}}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fakeWatch := watch.NewFake()
+		endpoints := make(chan EndpointsUpdate)
+		source := SourceAPI{client: &client.Fake{Watch: fakeWatch}, endpoints: endpoints}
+		source.endpointsVersion.Set(1)
+		go source.runEndpoints()
+		go func() {
+			for _, event := range events {
+				fakeWatch.Add(event)
+			}
+			fakeWatch.Stop()
+		}()
+		for j := 0; j < n; j++ {
+			<-endpoints
+		}
+	}
+}
+
+func BenchmarkEndpointsEventProcessing100(b *testing.B) {
+	benchmarkEndpointsEventProcessing(b, 100)
+}
+
+func BenchmarkEndpointsEventProcessing10000(b *testing.B) {
+	benchmarkEndpointsEventProcessing(b, 10000)
+}
+
+func BenchmarkEndpointsEventProcessing100000(b *testing.B) {
+	benchmarkEndpointsEventProcessing(b, 100000)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// updateBuffer queues the updates of a SourceAPI with an UpdateBufferSize in front of its
+// channels, so that a slow consumer does not block the watches. An ADD or REMOVE that does not
+// fit is dropped, and the watch of its resource is ended so that the next one relists; a SET,
+// or the snapshot markers around it, waits for room instead, as it is what heals the state.
+// It is safe for concurrent use.
+type updateBuffer struct {
+	once      sync.Once
+	services  chan ServiceUpdate
+	endpoints chan EndpointsUpdate
+
+	lock    sync.Mutex
+	dropped uint64
+	relist  map[ResourceType]chan struct{}
+}
+
+// start creates the queues of s and the goroutines that forward them to its channels.
+func (b *updateBuffer) start(s *SourceAPI) {
+	b.once.Do(func() {
+		b.services = make(chan ServiceUpdate, s.UpdateBufferSize)
+		b.endpoints = make(chan EndpointsUpdate, s.UpdateBufferSize)
+		go func() {
+			for update := range b.services {
+				s.sendOnServices(update)
+			}
+		}()
+		go func() {
+			for update := range b.endpoints {
+				s.sendOnEndpoints(update)
+			}
+		}()
+	})
+}
+
+// close closes the queues, which ends the goroutines forwarding them once the updates left
+// are dropped, and keeps start from creating them again. s.closeLock must be held.
+func (b *updateBuffer) close() {
+	b.once.Do(func() {})
+	if b.services != nil {
+		close(b.services)
+	}
+	if b.endpoints != nil {
+		close(b.endpoints)
+	}
+}
+
+// droppable returns whether an update with op may be dropped when the buffer is full.
+func droppable(op Operation) bool {
+	return op == ADD || op == REMOVE
+}
+
+// drop counts an update of resource that did not fit in the buffer, and asks for a relist of
+// resource.
+func (b *updateBuffer) drop(resource ResourceType, op Operation) {
+	b.lock.Lock()
+	b.dropped++
+	relist := b.relistLocked(resource)
+	select {
+	case <-relist:
+	default:
+		close(relist)
+	}
+	b.lock.Unlock()
+	glog.Warningf("Update buffer is full, dropping %s of %s and relisting", op, resource)
+}
+
+// relistLocked returns the channel that is closed once resource must be relisted. b.lock must
+// be held.
+func (b *updateBuffer) relistLocked(resource ResourceType) chan struct{} {
+	if b.relist == nil {
+		b.relist = make(map[ResourceType]chan struct{})
+	}
+	relist, ok := b.relist[resource]
+	if !ok {
+		relist = make(chan struct{})
+		b.relist[resource] = relist
+	}
+	return relist
+}
+
+// relisting returns a channel that is closed once an update of resource has been dropped, to
+// end its watch.
+func (b *updateBuffer) relisting(resource ResourceType) <-chan struct{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.relistLocked(resource)
+}
+
+// takeRelist returns whether an update of resource has been dropped since the last call, and
+// so whether it must be relisted.
+func (b *updateBuffer) takeRelist(resource ResourceType) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	select {
+	case <-b.relistLocked(resource):
+		delete(b.relist, resource)
+		return true
+	default:
+		return false
+	}
+}
+
+// DroppedUpdates returns the number of updates dropped because the buffer set by
+// UpdateBufferSize was full.
+func (s *SourceAPI) DroppedUpdates() uint64 {
+	s.buffer.lock.Lock()
+	defer s.buffer.lock.Unlock()
+	return s.buffer.dropped
+}
+
+// deliverServices sends update on the services channel, or queues it if the source is
+// buffered.
+func (s *SourceAPI) deliverServices(update ServiceUpdate) {
+	if s.UpdateBufferSize <= 0 {
+		s.sendOnServices(update)
+		return
+	}
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+	if s.isClosed() {
+		glog.V(4).Infof("Source is closed, dropping %s of services", update.Op)
+		return
+	}
+	s.buffer.start(s)
+	if !droppable(update.Op) {
+		select {
+		case s.buffer.services <- update:
+		case <-s.closing.done():
+		}
+		return
+	}
+	select {
+	case s.buffer.services <- update:
+	default:
+		s.buffer.drop(ServicesResource, update.Op)
+	}
+}
+
+// deliverEndpoints is deliverServices for endpoints.
+func (s *SourceAPI) deliverEndpoints(update EndpointsUpdate) {
+	if s.UpdateBufferSize <= 0 {
+		s.sendOnEndpoints(update)
+		return
+	}
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+	if s.isClosed() {
+		glog.V(4).Infof("Source is closed, dropping %s of endpoints", update.Op)
+		return
+	}
+	s.buffer.start(s)
+	if !droppable(update.Op) {
+		select {
+		case s.buffer.endpoints <- update:
+		case <-s.closing.done():
+		}
+		return
+	}
+	select {
+	case s.buffer.endpoints <- update:
+	default:
+		s.buffer.drop(EndpointsResource, update.Op)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+func TestUpdateBufferOverflow(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.UpdateBufferSize = 2
+
+	// nothing reads from services, so once the buffer is full updates are dropped instead
+	// of blocking
+	for i := 0; i < 10; i++ {
+		source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: uint64(i)}}}})
+	}
+	// one update may have been taken from the buffer and be waiting to be received
+	if dropped := source.DroppedUpdates(); dropped < 7 || dropped > 8 {
+		t.Errorf("expected 7 or 8 dropped updates, got %d", dropped)
+	}
+
+	for i := 0; i < 2; i++ {
+		expected := ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: uint64(i)}}}}
+		if actual := <-services; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v", expected, actual)
+		}
+	}
+	if !source.buffer.takeRelist(ServicesResource) {
+		t.Errorf("expected a relist of the services to be asked for")
+	}
+	if source.buffer.takeRelist(ServicesResource) || source.buffer.takeRelist(EndpointsResource) {
+		t.Errorf("expected only one relist of the services")
+	}
+}
+
+func TestUpdateBufferRelist(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services, waitDuration: time.Minute}
+	source.UpdateBufferSize = 1
+	source.serviceVersion.Set(1)
+
+	// at most one update is queued and one waits to be received, so the third is dropped
+	for i := 0; i < 3; i++ {
+		source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: uint64(i)}}}})
+	}
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+	// the services are relisted, and the SET waits for room rather than being dropped
+	dropped := uint64(3)
+	actual := <-services
+	for ; actual.Op == ADD; actual = <-services {
+		dropped--
+	}
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if dropped == 0 || dropped != source.DroppedUpdates() {
+		t.Errorf("expected %d dropped updates, got %d", dropped, source.DroppedUpdates())
+	}
+
+	// another drop ends the watch
+	for i := 0; i < 3; i++ {
+		source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: uint64(i)}}}})
+	}
+	<-ch
+	expectedActions := []client.FakeAction{{Action: "list-services"}, {Action: "watch-services", Value: uint64(0)}}
+	if !reflect.DeepEqual(expectedActions, fakeClient.Actions) {
+		t.Errorf("expected %#v, got %#v", expectedActions, fakeClient.Actions)
+	}
+	if source.DroppedUpdates() <= dropped {
+		t.Errorf("expected more than %d dropped updates, got %d", dropped, source.DroppedUpdates())
+	}
+}
+
+func TestUpdateBufferUnbounded(t *testing.T) {
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{endpoints: endpoints}
+	go source.sendEndpoints(EndpointsUpdate{Op: SET})
+	expected := EndpointsUpdate{Op: SET}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if dropped := source.DroppedUpdates(); dropped != 0 {
+		t.Errorf("expected no dropped updates, got %d", dropped)
+	}
+}
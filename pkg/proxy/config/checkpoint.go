@@ -0,0 +1,143 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// ErrNoCheckpoints is returned by Checkpoint for a source without EnableCheckpoints.
+var ErrNoCheckpoints = errors.New("checkpoint requires EnableCheckpoints")
+
+// checkpointSource is the source the updates sent by a SourceAPI are kept as for Checkpoint.
+const checkpointSource = "api"
+
+// checkpointState holds the services and endpoints a SourceAPI has sent, and the resource
+// version of each watch as of the last update of it sent.
+type checkpointState struct {
+	lock             sync.Mutex
+	store            *ConfigStore
+	serviceVersion   uint64
+	endpointsVersion uint64
+}
+
+// recordServices applies update, as it is sent, to the state kept for Checkpoint.
+func (s *SourceAPI) recordServices(update ServiceUpdate) {
+	if !s.EnableCheckpoints {
+		return
+	}
+	c := &s.checkpoint
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.store == nil {
+		c.store = NewConfigStore()
+	}
+	c.store.UpdateServices(checkpointSource, update)
+	// The watch moves the version past an event before sending it.
+	c.serviceVersion = s.serviceVersion.Get()
+}
+
+// recordEndpoints applies update, as it is sent, to the state kept for Checkpoint.
+func (s *SourceAPI) recordEndpoints(update EndpointsUpdate) {
+	if !s.EnableCheckpoints {
+		return
+	}
+	c := &s.checkpoint
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.store == nil {
+		c.store = NewConfigStore()
+	}
+	c.store.UpdateEndpoints(checkpointSource, update)
+	c.endpointsVersion = s.endpointsVersion.Get()
+}
+
+// Snapshot is a copy of the state of a ConfigStore, keyed by source and ID, or ServiceKey for
+// services. It shares nothing with the store.
+type Snapshot struct {
+	Services  map[string]map[string]api.Service
+	Endpoints map[string]map[string]api.Endpoints
+}
+
+// Checkpoint returns a copy of the services and endpoints the source has sent, keyed by the
+// source "api", together with the resource version the watches would resume from after the
+// last of them, taken under the same lock. Services and endpoints are watched separately, so
+// the version is the lower of the two: watching both from it misses no change made after the
+// copy. It returns ErrNoCheckpoints unless EnableCheckpoints is set.
+func (s *SourceAPI) Checkpoint() (Snapshot, uint64, error) {
+	if !s.EnableCheckpoints {
+		return Snapshot{}, 0, ErrNoCheckpoints
+	}
+	c := &s.checkpoint
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.store == nil {
+		c.store = NewConfigStore()
+	}
+	version := c.serviceVersion
+	if c.endpointsVersion < version {
+		version = c.endpointsVersion
+	}
+	return c.store.snapshot(), version, nil
+}
+
+// snapshot returns a copy of the store, holding the locks of services and endpoints together.
+func (s *ConfigStore) snapshot() Snapshot {
+	s.services.serviceLock.RLock()
+	defer s.services.serviceLock.RUnlock()
+	s.endpoints.endpointLock.RLock()
+	defer s.endpoints.endpointLock.RUnlock()
+	snapshot := Snapshot{
+		Services:  make(map[string]map[string]api.Service, len(s.services.services)),
+		Endpoints: make(map[string]map[string]api.Endpoints, len(s.endpoints.endpoints)),
+	}
+	for source, services := range s.services.services {
+		snapshot.Services[source] = make(map[string]api.Service, len(services))
+		for id, service := range services {
+			snapshot.Services[source][id] = copyService(service)
+		}
+	}
+	for source, endpoints := range s.endpoints.endpoints {
+		snapshot.Endpoints[source] = make(map[string]api.Endpoints, len(endpoints))
+		for id, e := range endpoints {
+			e.Endpoints = append([]string(nil), e.Endpoints...)
+			snapshot.Endpoints[source][id] = e
+		}
+	}
+	return snapshot
+}
+
+// copyService returns a copy of service that shares no maps with it.
+func copyService(service api.Service) api.Service {
+	service.Labels = copyMap(service.Labels)
+	service.Selector = copyMap(service.Selector)
+	return service
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
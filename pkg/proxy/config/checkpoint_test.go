@@ -0,0 +1,76 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestCheckpoint(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	endpointsCh := make(chan EndpointsUpdate)
+	source := SourceAPI{services: services, endpoints: endpointsCh}
+	if _, _, err := source.Checkpoint(); err != ErrNoCheckpoints {
+		t.Errorf("expected %v, got %v", ErrNoCheckpoints, err)
+	}
+
+	source.EnableCheckpoints = true
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{"tier": "web"}}
+	endpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	source.serviceVersion.Set(7)
+	go source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo}})
+	<-services
+	source.endpointsVersion.Set(5)
+	go source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{endpoints}})
+	<-endpointsCh
+	// a watch that has moved on without sending anything is not part of the checkpoint
+	source.serviceVersion.Set(8)
+
+	snapshot, version, err := source.Checkpoint()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != 5 {
+		t.Errorf("expected resource version 5, got %d", version)
+	}
+	expected := Snapshot{
+		Services:  map[string]map[string]api.Service{"api": {"foo": {JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{"tier": "web"}}}},
+		Endpoints: map[string]map[string]api.Endpoints{"api": {"foo": {JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}}},
+	}
+	if !reflect.DeepEqual(expected, snapshot) {
+		t.Errorf("expected %#v, got %#v", expected, snapshot)
+	}
+
+	// later updates, or changes to the objects sent, leave the checkpoint as it was
+	foo.Labels["tier"] = "db"
+	endpoints.Endpoints[0] = "10.0.0.2:80"
+	go source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}, Port: 81}}})
+	<-services
+	source.endpointsVersion.Set(9)
+	go source.sendEndpoints(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{endpoints}})
+	<-endpointsCh
+	if !reflect.DeepEqual(expected, snapshot) {
+		t.Errorf("expected %#v, got %#v", expected, snapshot)
+	}
+	snapshot, version, _ = source.Checkpoint()
+	if version != 8 || len(snapshot.Services["api"]) != 2 || len(snapshot.Endpoints["api"]) != 0 {
+		t.Errorf("unexpected checkpoint at resource version %d: %#v", version, snapshot)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var ErrIncompleteKeyPair = errors.New("client certificate and key must be given together")
+
+// ClientConfig describes how to reach and authenticate to the apiserver.
+type ClientConfig struct {
+	// Host is the apiserver URL, e.g. "https://10.0.0.1:6443".
+	Host string
+	// CAFile is a PEM bundle of the CAs trusted to sign the apiserver certificate. If empty,
+	// the system roots are used.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented to the apiserver.
+	CertFile string
+	KeyFile  string
+	// BearerToken is sent with every request, if set.
+	BearerToken string
+}
+
+// NewHTTPWatcherFromConfig creates an HTTPWatcher for the apiserver described by config.
+// Certificates are loaded right away, so a missing or invalid one is reported here.
+func NewHTTPWatcherFromConfig(config ClientConfig) (*HTTPWatcher, error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	w := NewHTTPWatcher(config.Host, &http.Client{Transport: transport})
+	w.token = config.BearerToken
+	return w, nil
+}
+
+// NewSourceAPIFromConfig creates a config source like NewSourceAPIWithOptions, watching the
+// apiserver described by config.
+func NewSourceAPIFromConfig(config ClientConfig, period time.Duration, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate, options SourceAPIOptions) (*SourceAPI, error) {
+	client, err := NewHTTPWatcherFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewSourceAPIWithOptions(client, period, services, endpoints, options), nil
+}
+
+func (config ClientConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.CAFile != "" {
+		data, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, ErrIncompleteKeyPair
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/vishvananda/wormhole/pkg/proxy/certtest"
+)
+
+func TestNewSourceAPIFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-certs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := certtest.NewCA(t)
+	certtest.WriteFiles(t, dir, "ca", ca.Certificate())
+	certtest.WriteFiles(t, dir, "apiserver", ca.Issue(t, certtest.Leaf("apiserver", x509.ExtKeyUsageServerAuth)))
+	certtest.WriteFiles(t, dir, "proxy", ca.Issue(t, certtest.Leaf("proxy", x509.ExtKeyUsageClientAuth)))
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "apiserver.crt"), filepath.Join(dir, "apiserver.key"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if auth := req.Header.Get("Authorization"); auth != "Bearer secret" {
+			http.Error(w, "bad token "+auth, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"kind":"ServiceList","items":[{"id":"foo","port":80}]}`)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	config := ClientConfig{
+		Host:        server.URL,
+		CAFile:      filepath.Join(dir, "ca.crt"),
+		CertFile:    filepath.Join(dir, "proxy.crt"),
+		KeyFile:     filepath.Join(dir, "proxy.key"),
+		BearerToken: "secret",
+	}
+	source, err := NewSourceAPIFromConfig(config, time.Minute, nil, nil, SourceAPIOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	services, err := source.client.ListServices(labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services.Items) != 1 || services.Items[0].ID != "foo" {
+		t.Errorf("expected service foo, got %#v", services.Items)
+	}
+
+	// without the client certificate the apiserver refuses the connection
+	config.CertFile, config.KeyFile = "", ""
+	watcher, err := NewHTTPWatcherFromConfig(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := watcher.ListServices(labels.Everything()); err == nil {
+		t.Errorf("expected connection without a client certificate to fail")
+	}
+}
+
+func TestNewSourceAPIFromConfigInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-certs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	for _, config := range []ClientConfig{
+		{CAFile: missing},
+		{CAFile: notPEM},
+		{CertFile: missing, KeyFile: missing},
+		{CertFile: notPEM, KeyFile: notPEM},
+		{CertFile: notPEM},
+	} {
+		if _, err := NewSourceAPIFromConfig(config, time.Minute, nil, nil, SourceAPIOptions{}); err == nil {
+			t.Errorf("expected an error for %#v", config)
+		}
+	}
+}
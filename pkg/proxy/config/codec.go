@@ -0,0 +1,135 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// WatchCodec decodes a single serialized watch event.
+type WatchCodec interface {
+	Decode(data []byte, event *watch.Event) error
+}
+
+// JSONWatchCodec decodes events of the form {"type": "ADDED", "object": {...}}, putting each
+// object in the namespace it says it is in.
+type JSONWatchCodec struct {
+	// Codec decodes the object of each event. Defaults to runtime.DefaultCodec.
+	Codec runtime.Codec
+}
+
+// Decode decodes a JSON watch event.
+func (c JSONWatchCodec) Decode(data []byte, event *watch.Event) error {
+	var raw struct {
+		Type   watch.EventType `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	codec := c.Codec
+	if codec == nil {
+		codec = runtime.DefaultCodec
+	}
+	obj, err := codec.Decode(raw.Object)
+	if err != nil {
+		return err
+	}
+	setNamespace(raw.Object, obj, "")
+	event.Type = raw.Type
+	event.Object = obj
+	return nil
+}
+
+// ErrMsgpackUnsupported is returned by MsgpackWatchCodec for every event, since msgpack is not
+// supported yet.
+var ErrMsgpackUnsupported = errors.New("msgpack watch events are not supported yet")
+
+// MsgpackWatchCodec is a stub for msgpack encoded watch events that always fails: it decodes
+// nothing, and every Decode returns ErrMsgpackUnsupported. A watch using it ends at its first
+// event. It only reserves the name until a msgpack library is added to the build.
+type MsgpackWatchCodec struct{}
+
+// Decode always returns ErrMsgpackUnsupported.
+func (MsgpackWatchCodec) Decode(data []byte, event *watch.Event) error {
+	return ErrMsgpackUnsupported
+}
+
+// codecWatchDecoder is a WatchDecoder which reads one event per line of the stream and
+// decodes it with a WatchCodec.
+type codecWatchDecoder struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	codec  WatchCodec
+}
+
+func newCodecWatchDecoder(body io.ReadCloser, codec WatchCodec) *codecWatchDecoder {
+	return &codecWatchDecoder{
+		body:   body,
+		reader: bufio.NewReader(body),
+		codec:  codec,
+	}
+}
+
+// Decode blocks until the next event in the stream can be decoded.
+func (d *codecWatchDecoder) Decode() (watch.EventType, runtime.Object, error) {
+	for {
+		line, err := d.reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+		var event watch.Event
+		if err := d.codec.Decode(line, &event); err != nil {
+			return "", nil, err
+		}
+		return event.Type, event.Object, nil
+	}
+}
+
+// Close closes the underlying stream.
+func (d *codecWatchDecoder) Close() {
+	d.body.Close()
+}
+
+// UpdateCodec serializes ServiceUpdates for a source to send to another, as through Kafka.
+type UpdateCodec interface {
+	Encode(update ServiceUpdate) ([]byte, error)
+	Decode(data []byte, update *ServiceUpdate) error
+}
+
+// JSONUpdateCodec serializes updates as JSON.
+type JSONUpdateCodec struct{}
+
+// Encode returns update as JSON.
+func (JSONUpdateCodec) Encode(update ServiceUpdate) ([]byte, error) {
+	return json.Marshal(update)
+}
+
+// Decode decodes an update encoded as JSON.
+func (JSONUpdateCodec) Decode(data []byte, update *ServiceUpdate) error {
+	return json.Unmarshal(data, update)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// countingCodec counts the events it decodes.
+type countingCodec struct {
+	calls int
+}
+
+func (c *countingCodec) Decode(data []byte, event *watch.Event) error {
+	c.calls++
+	return JSONWatchCodec{}.Decode(data, event)
+}
+
+func TestJSONWatchCodec(t *testing.T) {
+	var event watch.Event
+	err := JSONWatchCodec{}.Decode([]byte(`{"type":"MODIFIED","object":{"kind":"Service","id":"foo","port":80}}`), &event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := watch.Event{
+		Type:   watch.Modified,
+		Object: &api.Service{JSONBase: api.JSONBase{Kind: "Service", ID: "foo"}, Port: 80},
+	}
+	if !reflect.DeepEqual(expected, event) {
+		t.Errorf("expected %#v, got %#v", expected, event)
+	}
+
+	if err := (MsgpackWatchCodec{}).Decode([]byte{0x80}, &event); err != ErrMsgpackUnsupported {
+		t.Errorf("expected %v, got %v", ErrMsgpackUnsupported, err)
+	}
+}
+
+func TestServicesWatchCodec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"type":"ADDED","object":{"kind":"Service","id":"foo","resourceVersion":2}}`)
+		fmt.Fprintln(w, `{"type":"DELETED","object":{"kind":"Service","id":"foo","resourceVersion":3}}`)
+	}))
+	defer server.Close()
+
+	codec := &countingCodec{}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil), services: services}
+	source.WatchCodec = codec
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	service := api.Service{JSONBase: api.JSONBase{Kind: "Service", ID: "foo", ResourceVersion: 2}}
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	service.ResourceVersion = 3
+	actual = <-services
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	<-ch
+	if codec.calls != 2 {
+		t.Errorf("expected 2 calls to the codec, got %d", codec.calls)
+	}
+}
+
+// v1beta3Codec decodes services in the shape of a later version of the API, with the name
+// under metadata and the port under spec, into api.Services.
+type v1beta3Codec struct{}
+
+type v1beta3Service struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		Port int `json:"port"`
+	} `json:"spec"`
+}
+
+func (s v1beta3Service) convert() (*api.Service, error) {
+	if s.Kind != "Service" {
+		return nil, fmt.Errorf("unexpected kind %q", s.Kind)
+	}
+	version, err := strconv.ParseUint(s.Metadata.ResourceVersion, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &api.Service{JSONBase: api.JSONBase{ID: s.Metadata.Name, ResourceVersion: version}, Port: s.Spec.Port}, nil
+}
+
+func (v1beta3Codec) Encode(obj runtime.Object) ([]byte, error) {
+	return nil, errors.New("encoding is not supported")
+}
+
+func (v1beta3Codec) Decode(data []byte) (runtime.Object, error) {
+	var service v1beta3Service
+	if err := json.Unmarshal(data, &service); err != nil {
+		return nil, err
+	}
+	return service.convert()
+}
+
+func (v1beta3Codec) DecodeInto(data []byte, obj runtime.Object) error {
+	list, ok := obj.(*api.ServiceList)
+	if !ok {
+		return fmt.Errorf("unexpected object %T", obj)
+	}
+	var raw struct {
+		Items []v1beta3Service `json:"items"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, item := range raw.Items {
+		service, err := item.convert()
+		if err != nil {
+			return err
+		}
+		list.Items = append(list.Items, *service)
+	}
+	return nil
+}
+
+func TestServicesObjectCodec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case apiPrefix + "/services":
+			fmt.Fprint(w, `{"kind":"ServiceList","items":[{"kind":"Service","metadata":{"name":"foo","resourceVersion":"1"},"spec":{"port":80}}]}`)
+		case apiPrefix + "/watch/services":
+			fmt.Fprintln(w, `{"type":"ADDED","object":{"kind":"Service","metadata":{"name":"bar","resourceVersion":"2"},"spec":{"port":81}}}`)
+		default:
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+	}))
+	defer server.Close()
+
+	watcher := NewHTTPWatcher(server.URL, nil)
+	watcher.UseCodec(v1beta3Codec{})
+	list, err := watcher.ListServices(labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []api.Service{{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 1}, Port: 80}}
+	if !reflect.DeepEqual(expected, list.Items) {
+		t.Errorf("expected %#v, got %#v", expected, list.Items)
+	}
+
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: watcher, services: services}
+	source.ObjectCodec = v1beta3Codec{}
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+	actual := <-services
+	expectedUpdate := ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}, Port: 81}}}
+	if !reflect.DeepEqual(expectedUpdate, actual) {
+		t.Errorf("expected %#v, got %#v", expectedUpdate, actual)
+	}
+	<-ch
+}
+
+func TestObjectCodecOption(t *testing.T) {
+	watcher := NewHTTPWatcher("http://127.0.0.1:1", nil)
+	// the source is never started
+	options := SourceAPIOptions{ObjectCodec: v1beta3Codec{}, StartBarrier: make(chan struct{})}
+	source := NewSourceAPIWithOptions(watcher, time.Minute, nil, nil, options)
+	defer source.Close()
+	if _, ok := watcher.codec.(v1beta3Codec); !ok {
+		t.Errorf("expected the codec of the watcher to be replaced, got %T", watcher.codec)
+	}
+}
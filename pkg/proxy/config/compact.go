@@ -0,0 +1,99 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// compactEntry is the state of the endpoints of a service, with those of its named ports.
+type compactEntry struct {
+	endpoints api.Endpoints
+	ports     map[string][]string
+}
+
+// CompactEndpointsUpdate drops the endpoints of ADDs and REMOVEs that change nothing, so that
+// a consumer does not rework the endpoints of a service that a watch sends again unchanged.
+// SETs are passed on as they are, so that a relist still replaces whatever state the consumer
+// has, even if an earlier update never reached it; they only reset what later updates are
+// compared with. The endpoints of a service are the unit of change: an ADD replaces all of its
+// addresses. The zero value is ready to use, and it is safe for concurrent use.
+type CompactEndpointsUpdate struct {
+	lock sync.Mutex
+	last map[string]compactEntry
+}
+
+// Compact returns the updates to send in place of update, which may be none if it changes
+// nothing.
+func (c *CompactEndpointsUpdate) Compact(update EndpointsUpdate) []EndpointsUpdate {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.last == nil {
+		c.last = make(map[string]compactEntry)
+	}
+	switch update.Op {
+	case SET:
+		c.last = make(map[string]compactEntry, len(update.Endpoints))
+		for _, e := range update.Endpoints {
+			c.last[e.ID] = compactEntry{e, update.Ports[e.ID]}
+		}
+		return []EndpointsUpdate{update}
+	case ADD:
+		return c.add(update)
+	case REMOVE:
+		var removed []api.Endpoints
+		for _, e := range update.Endpoints {
+			if _, found := c.last[e.ID]; found {
+				delete(c.last, e.ID)
+				removed = append(removed, e)
+			}
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		update.Endpoints = removed
+		return []EndpointsUpdate{update}
+	}
+	return []EndpointsUpdate{update}
+}
+
+// add records the endpoints of update and returns an ADD of those that changed, if any.
+func (c *CompactEndpointsUpdate) add(update EndpointsUpdate) []EndpointsUpdate {
+	var added []api.Endpoints
+	var ports map[string]map[string][]string
+	for _, e := range update.Endpoints {
+		entry := compactEntry{e, update.Ports[e.ID]}
+		if last, found := c.last[e.ID]; found && reflect.DeepEqual(last, entry) {
+			continue
+		}
+		c.last[e.ID] = entry
+		added = append(added, e)
+		if entry.ports != nil {
+			if ports == nil {
+				ports = make(map[string]map[string][]string)
+			}
+			ports[e.ID] = entry.ports
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return []EndpointsUpdate{{Op: ADD, Endpoints: added, Source: update.Source, Timestamp: update.Timestamp, Ports: ports}}
+}
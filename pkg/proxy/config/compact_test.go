@@ -0,0 +1,93 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestCompactEndpoints(t *testing.T) {
+	var all []api.Endpoints
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("service%d", i)
+		all = append(all, api.Endpoints{JSONBase: api.JSONBase{ID: id}, Endpoints: []string{fmt.Sprintf("10.0.0.%d:80", i)}})
+	}
+	changed := all[5]
+	changed.Endpoints = []string{"10.0.0.5:80", "10.0.1.5:80"}
+	next := append([]api.Endpoints{}, all[:5]...)
+	next = append(next, changed)
+	next = append(next, all[6:99]...)
+	added := api.Endpoints{JSONBase: api.JSONBase{ID: "new"}, Endpoints: []string{"10.0.2.1:80"}}
+	ports := map[string]map[string][]string{"new": {"http": {"10.0.2.1:80"}}}
+
+	table := []struct {
+		update   EndpointsUpdate
+		expected []EndpointsUpdate
+	}{
+		{EndpointsUpdate{Op: SET, Endpoints: all}, []EndpointsUpdate{{Op: SET, Endpoints: all}}},
+		{EndpointsUpdate{Op: ADD, Endpoints: all[:2]}, nil},
+		// a relist is passed on whole, even if it changes nothing
+		{EndpointsUpdate{Op: SET, Endpoints: next}, []EndpointsUpdate{{Op: SET, Endpoints: next}}},
+		{EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{changed, added}, Ports: ports}, []EndpointsUpdate{
+			{Op: ADD, Endpoints: []api.Endpoints{added}, Ports: ports},
+		}},
+		// a change of the named ports is a change
+		{EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{added}}, []EndpointsUpdate{
+			{Op: ADD, Endpoints: []api.Endpoints{added}},
+		}},
+		{EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{added, all[99]}}, []EndpointsUpdate{
+			{Op: REMOVE, Endpoints: []api.Endpoints{added}},
+		}},
+		{EndpointsUpdate{Op: SET, Endpoints: next}, []EndpointsUpdate{{Op: SET, Endpoints: next}}},
+		{EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{added}}, nil},
+	}
+	var compactor CompactEndpointsUpdate
+	for i, item := range table {
+		if actual := compactor.Compact(item.update); !reflect.DeepEqual(item.expected, actual) {
+			t.Errorf("%d: expected %#v, got %#v", i, item.expected, actual)
+		}
+	}
+}
+
+func TestSourceCompactEndpoints(t *testing.T) {
+	endpoints := make(chan EndpointsUpdate, 10)
+	source := SourceAPI{endpoints: endpoints}
+	source.CompactEndpoints = true
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.0.2:80"}}
+	source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo}})
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{foo, bar}})
+	source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo, bar}})
+
+	expected := []EndpointsUpdate{
+		{Op: SET, Endpoints: []api.Endpoints{foo}},
+		{Op: ADD, Endpoints: []api.Endpoints{bar}},
+		{Op: SET, Endpoints: []api.Endpoints{foo, bar}},
+	}
+	if len(endpoints) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(endpoints))
+	}
+	for _, update := range expected {
+		if actual := <-endpoints; !reflect.DeepEqual(update, actual) {
+			t.Errorf("expected %#v, got %#v", update, actual)
+		}
+	}
+}
@@ -17,7 +17,11 @@ limitations under the License.
 package config
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util/config"
@@ -32,8 +36,25 @@ const (
 	SET Operation = iota
 	ADD
 	REMOVE
+	// SNAPSHOT_START and SNAPSHOT_END bracket the SET sent by a source after a full relist.
+	// They carry no services or endpoints.
+	SNAPSHOT_START
+	SNAPSHOT_END
+	// DRAIN is sent by a source that is about to close, so that consumers can finish the
+	// requests in flight. It carries no services or endpoints.
+	DRAIN
 )
 
+var operationNames = []string{"SET", "ADD", "REMOVE", "SNAPSHOT_START", "SNAPSHOT_END", "DRAIN"}
+
+// String returns the name of op, as in the constants above.
+func (op Operation) String() string {
+	if op < 0 || int(op) >= len(operationNames) {
+		return fmt.Sprintf("Operation(%d)", int(op))
+	}
+	return operationNames[op]
+}
+
 // ServiceUpdate describes an operation of services, sent on the channel.
 // You can add or remove single services by sending an array of size one and Op == ADD|REMOVE.
 // For setting the state of the system to a given state for this source configuration, set Services as desired and Op to SET,
@@ -42,6 +63,43 @@ const (
 type ServiceUpdate struct {
 	Services []api.Service
 	Op       Operation
+	// Source and Timestamp optionally identify the source that sent the update and when.
+	Source    string
+	Timestamp time.Time
+	// Signature is set by a source with a SigningKey, see VerifySignature.
+	Signature string `json:",omitempty"`
+}
+
+// Merge coalesces updates into a single SET of the state they lead to, applying them in order
+// from an empty state. The result only holds the full state of a source if updates include a
+// SET, so a batch is normally started with the last SET received. Source and Timestamp are
+// those of the last update.
+func Merge(updates []ServiceUpdate) ServiceUpdate {
+	services := make(map[string]api.Service)
+	merged := ServiceUpdate{Op: SET}
+	for _, update := range updates {
+		switch update.Op {
+		case SET:
+			services = make(map[string]api.Service)
+			fallthrough
+		case ADD:
+			for _, value := range update.Services {
+				services[ServiceKey(value)] = value
+			}
+		case REMOVE:
+			for _, value := range update.Services {
+				delete(services, ServiceKey(value))
+			}
+		}
+		merged.Source = update.Source
+		merged.Timestamp = update.Timestamp
+	}
+	merged.Services = make([]api.Service, 0, len(services))
+	for _, value := range services {
+		merged.Services = append(merged.Services, value)
+	}
+	sort.Sort(servicesByID(merged.Services))
+	return merged
 }
 
 // EndpointsUpdate describes an operation of endpoints, sent on the channel.
@@ -52,6 +110,52 @@ type ServiceUpdate struct {
 type EndpointsUpdate struct {
 	Endpoints []api.Endpoints
 	Op        Operation
+	// Source and Timestamp optionally identify the source that sent the update and when.
+	Source    string
+	Timestamp time.Time
+	// Protocols optionally maps the ID of endpoints to the protocol of their service, "TCP" or "UDP".
+	Protocols map[string]string
+	// Ports optionally maps the ID of endpoints to the addresses of each named port of their
+	// service, e.g. {"foo": {"http": {"10.0.0.1:80"}, "metrics": {"10.0.0.1:9090"}}}. They are
+	// kept apart from the endpoints and passed on to each PortedEndpointsConfigHandler, so
+	// that a LoadBalancer can pick an address by port name.
+	Ports map[string]map[string][]string
+	// Weights optionally maps the ID of endpoints to the weight of each of their addresses,
+	// from the WeightLabel of their service. See Weight. They are passed on to each
+	// WeightedEndpointsConfigHandler.
+	Weights map[string]map[string]int
+}
+
+// ServicePortName returns the ID of the endpoints of the named port of service, or service
+// itself for the unnamed port.
+func ServicePortName(service, port string) string {
+	if port == "" {
+		return service
+	}
+	return service + ":" + port
+}
+
+// WithNamedPorts returns endpoints followed by the endpoints of each of their named ports in
+// ports, with the ServicePortName as ID, for a load balancer that keys its endpoints by it.
+func WithNamedPorts(endpoints []api.Endpoints, ports map[string]map[string][]string) []api.Endpoints {
+	result := append([]api.Endpoints(nil), endpoints...)
+	for _, e := range endpoints {
+		for name, addresses := range ports[e.ID] {
+			if name == "" {
+				continue
+			}
+			result = append(result, api.Endpoints{JSONBase: api.JSONBase{ID: ServicePortName(e.ID, name)}, Endpoints: addresses})
+		}
+	}
+	return result
+}
+
+// Protocol returns the protocol of the endpoints with the given ID, TCP if it is not known.
+func (u EndpointsUpdate) Protocol(id string) string {
+	if protocol, ok := u.Protocols[id]; ok {
+		return protocol
+	}
+	return "TCP"
 }
 
 // ServiceConfigHandler is an abstract interface of objects which receive update notifications for the set of services.
@@ -69,6 +173,26 @@ type EndpointsConfigHandler interface {
 	OnUpdate(endpoints []api.Endpoints)
 }
 
+// WeightedEndpointsConfigHandler is an EndpointsConfigHandler that is also given the weights
+// of the endpoints, as sent in the EndpointsUpdate.Weights of each source. EndpointsConfig
+// calls OnWeightedUpdate in place of OnUpdate.
+type WeightedEndpointsConfigHandler interface {
+	EndpointsConfigHandler
+	// OnWeightedUpdate is OnUpdate with the weights of the addresses of the endpoints with
+	// each ID. Addresses without a weight have a weight of 1.
+	OnWeightedUpdate(endpoints []api.Endpoints, weights map[string]map[string]int)
+}
+
+// PortedEndpointsConfigHandler is an EndpointsConfigHandler that is also given the addresses
+// of the named ports of the endpoints, as sent in the EndpointsUpdate.Ports of each source.
+// EndpointsConfig calls OnPortsUpdate right before OnUpdate, or OnWeightedUpdate.
+type PortedEndpointsConfigHandler interface {
+	EndpointsConfigHandler
+	// OnPortsUpdate sets the addresses of each named port of the endpoints with each ID, for
+	// the update that follows it.
+	OnPortsUpdate(ports map[string]map[string][]string)
+}
+
 // EndpointsConfig tracks a set of endpoints configurations.
 // It accepts "set", "add" and "remove" operations of endpoints via channels, and invokes registered handlers on change.
 type EndpointsConfig struct {
@@ -81,7 +205,7 @@ type EndpointsConfig struct {
 // It immediately runs the created EndpointsConfig.
 func NewEndpointsConfig() *EndpointsConfig {
 	updates := make(chan struct{})
-	store := &endpointsStore{updates: updates, endpoints: make(map[string]map[string]api.Endpoints)}
+	store := newEndpointsStore(updates)
 	mux := config.NewMux(store)
 	watcher := config.NewWatcher()
 	go watchForUpdates(watcher, store, updates)
@@ -89,7 +213,16 @@ func NewEndpointsConfig() *EndpointsConfig {
 }
 
 func (c *EndpointsConfig) RegisterHandler(handler EndpointsConfigHandler) {
+	weighted, ok := handler.(WeightedEndpointsConfigHandler)
+	ported, hasPorts := handler.(PortedEndpointsConfigHandler)
 	c.watcher.Add(config.ListenerFunc(func(instance interface{}) {
+		if hasPorts {
+			ported.OnPortsUpdate(c.store.mergedPorts())
+		}
+		if ok {
+			weighted.OnWeightedUpdate(instance.([]api.Endpoints), c.store.mergedWeights())
+			return
+		}
 		handler.OnUpdate(instance.([]api.Endpoints))
 	}))
 }
@@ -99,6 +232,19 @@ func (c *EndpointsConfig) Channel(source string) chan EndpointsUpdate {
 	endpointsCh := make(chan EndpointsUpdate)
 	go func() {
 		for update := range endpointsCh {
+			// Only the invalid endpoints are dropped, so that one cannot hold back a SET.
+			dropped := 0
+			update, err := update.dropInvalid(func(endpoints api.Endpoints, err error) {
+				glog.Errorf("Dropping invalid endpoints from %s: %v", source, err)
+				dropped++
+			})
+			if err != nil {
+				glog.Errorf("Rejecting invalid update from %s: %v", source, err)
+				continue
+			}
+			if dropped > 0 && update.Op != SET && len(update.Endpoints) == 0 {
+				continue
+			}
 			ch <- update
 		}
 		close(ch)
@@ -107,46 +253,130 @@ func (c *EndpointsConfig) Channel(source string) chan EndpointsUpdate {
 }
 
 func (c *EndpointsConfig) Config() map[string]map[string]api.Endpoints {
-	return c.store.MergedState().(map[string]map[string]api.Endpoints)
+	return c.store.sourceState()
+}
+
+// EndpointCounts returns the number of endpoints of each service, across sources, as of the
+// last update merged. Services whose endpoints were removed from every source are left out,
+// while those that are known without endpoints count 0.
+func (c *EndpointsConfig) EndpointCounts() map[string]int {
+	return c.store.endpointCounts()
 }
 
 type endpointsStore struct {
 	endpointLock sync.RWMutex
 	endpoints    map[string]map[string]api.Endpoints
+	lastUpdate   map[string]time.Time
 	updates      chan<- struct{}
+	// counts is the number of endpoints of each service, updated by Merge.
+	counts map[string]int
+	// weights are the weights of the endpoints of each source, by ID, and ports the addresses
+	// of their named ports.
+	weights map[string]map[string]map[string]int
+	ports   map[string]map[string]map[string][]string
+}
+
+// newEndpointsStore creates an endpointsStore which signals updates after each merge, if updates is not nil.
+func newEndpointsStore(updates chan<- struct{}) *endpointsStore {
+	return &endpointsStore{
+		updates:    updates,
+		endpoints:  make(map[string]map[string]api.Endpoints),
+		weights:    make(map[string]map[string]map[string]int),
+		ports:      make(map[string]map[string]map[string][]string),
+		lastUpdate: make(map[string]time.Time),
+		counts:     make(map[string]int),
+	}
 }
 
 func (s *endpointsStore) Merge(source string, change interface{}) error {
+	update := change.(EndpointsUpdate)
+	if update.Op == SNAPSHOT_START || update.Op == SNAPSHOT_END || update.Op == DRAIN {
+		// Snapshot fencing and draining are for consumers of the source channel, there is
+		// nothing to merge.
+		return nil
+	}
 	s.endpointLock.Lock()
 	endpoints := s.endpoints[source]
 	if endpoints == nil {
 		endpoints = make(map[string]api.Endpoints)
 	}
-	update := change.(EndpointsUpdate)
+	weights := s.weights[source]
+	if weights == nil || update.Op == SET {
+		weights = make(map[string]map[string]int)
+	}
+	ports := s.ports[source]
+	if ports == nil || update.Op == SET {
+		ports = make(map[string]map[string][]string)
+	}
+	// The services whose count may change: those of the update, and for a SET also those it
+	// replaces.
+	counted := make([]string, 0, len(update.Endpoints))
+	for _, value := range update.Endpoints {
+		counted = append(counted, EndpointsKey(value))
+	}
+	if update.Op == SET {
+		for id := range endpoints {
+			counted = append(counted, id)
+		}
+	}
+	// ADDs of endpoints that are already known and REMOVEs of unknown ones, such as duplicate
+	// watch events, leave the state alone and are not passed on to the handlers.
+	changed := false
 	switch update.Op {
 	case ADD:
 		glog.Infof("Adding new endpoint from source %s : %v", source, update.Endpoints)
 		for _, value := range update.Endpoints {
-			endpoints[value.ID] = value
+			key := EndpointsKey(value)
+			if w := update.Weights[key]; (len(w) > 0 || len(weights[key]) > 0) && !reflect.DeepEqual(weights[key], w) {
+				setWeights(weights, key, update.Weights[key])
+				changed = true
+			}
+			if p := update.Ports[key]; (len(p) > 0 || len(ports[key]) > 0) && !reflect.DeepEqual(ports[key], p) {
+				setPorts(ports, key, p)
+				changed = true
+			}
+			if existing, ok := endpoints[key]; ok && reflect.DeepEqual(existing, value) {
+				continue
+			}
+			endpoints[key] = value
+			changed = true
 		}
 	case REMOVE:
 		glog.Infof("Removing an endpoint %v", update)
 		for _, value := range update.Endpoints {
-			delete(endpoints, value.ID)
+			key := EndpointsKey(value)
+			if _, ok := endpoints[key]; !ok {
+				glog.V(2).Infof("Ignoring removal of unknown endpoints %s from source %s", key, source)
+				continue
+			}
+			delete(endpoints, key)
+			delete(weights, key)
+			delete(ports, key)
+			changed = true
 		}
 	case SET:
 		glog.Infof("Setting endpoints %v", update)
+		changed = true
 		// Clear the old map entries by just creating a new map
 		endpoints = make(map[string]api.Endpoints)
 		for _, value := range update.Endpoints {
-			endpoints[value.ID] = value
+			key := EndpointsKey(value)
+			endpoints[key] = value
+			setWeights(weights, key, update.Weights[key])
+			setPorts(ports, key, update.Ports[key])
 		}
 	default:
 		glog.Infof("Received invalid update type: %v", update)
 	}
 	s.endpoints[source] = endpoints
+	s.weights[source] = weights
+	s.ports[source] = ports
+	s.lastUpdate[source] = time.Now()
+	for _, id := range counted {
+		s.count(id)
+	}
 	s.endpointLock.Unlock()
-	if s.updates != nil {
+	if changed && s.updates != nil {
 		s.updates <- struct{}{}
 	}
 	return nil
@@ -164,6 +394,105 @@ func (s *endpointsStore) MergedState() interface{} {
 	return endpoints
 }
 
+// setWeights sets the weights of the endpoints with the given ID in weights, deleting them if
+// there are none.
+func setWeights(weights map[string]map[string]int, id string, value map[string]int) {
+	if len(value) == 0 {
+		delete(weights, id)
+		return
+	}
+	weights[id] = value
+}
+
+// mergedWeights returns the weights of the endpoints of every source, by ID.
+func (s *endpointsStore) mergedWeights() map[string]map[string]int {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	weights := make(map[string]map[string]int)
+	for _, sourceWeights := range s.weights {
+		for id, value := range sourceWeights {
+			weights[id] = value
+		}
+	}
+	return weights
+}
+
+// setPorts sets the addresses of the named ports of the endpoints with the given ID in ports,
+// deleting them if there are none.
+func setPorts(ports map[string]map[string][]string, id string, value map[string][]string) {
+	if len(value) == 0 {
+		delete(ports, id)
+		return
+	}
+	ports[id] = value
+}
+
+// mergedPorts returns the addresses of the named ports of the endpoints of every source, by ID.
+func (s *endpointsStore) mergedPorts() map[string]map[string][]string {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	ports := make(map[string]map[string][]string)
+	for _, sourcePorts := range s.ports {
+		for id, value := range sourcePorts {
+			ports[id] = value
+		}
+	}
+	return ports
+}
+
+// count updates the number of endpoints of the service of id. It must be called with the lock
+// held.
+func (s *endpointsStore) count(id string) {
+	n, found := 0, false
+	for _, sourceEndpoints := range s.endpoints {
+		if value, ok := sourceEndpoints[id]; ok {
+			n += len(value.Endpoints)
+			found = true
+		}
+	}
+	if found {
+		s.counts[id] = n
+	} else {
+		delete(s.counts, id)
+	}
+}
+
+// endpointCounts returns a copy of the number of endpoints of each service.
+func (s *endpointsStore) endpointCounts() map[string]int {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	counts := make(map[string]int, len(s.counts))
+	for id, n := range s.counts {
+		counts[id] = n
+	}
+	return counts
+}
+
+// sourceState returns a copy of the endpoints known from each source.
+func (s *endpointsStore) sourceState() map[string]map[string]api.Endpoints {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	state := make(map[string]map[string]api.Endpoints, len(s.endpoints))
+	for source, sourceEndpoints := range s.endpoints {
+		state[source] = make(map[string]api.Endpoints, len(sourceEndpoints))
+		for id, value := range sourceEndpoints {
+			state[source][id] = value
+		}
+	}
+	return state
+}
+
+// lastUpdated returns the time of the last update received from each source.
+func (s *endpointsStore) lastUpdated() map[string]time.Time {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	times := make(map[string]time.Time, len(s.lastUpdate))
+	for source, t := range s.lastUpdate {
+		times[source] = t
+	}
+	return times
+}
+
 // ServiceConfig tracks a set of service configurations.
 // It accepts "set", "add" and "remove" operations of services via channels, and invokes registered handlers on change.
 type ServiceConfig struct {
@@ -176,7 +505,7 @@ type ServiceConfig struct {
 // It immediately runs the created ServiceConfig.
 func NewServiceConfig() *ServiceConfig {
 	updates := make(chan struct{})
-	store := &serviceStore{updates: updates, services: make(map[string]map[string]api.Service)}
+	store := newServiceStore(updates)
 	mux := config.NewMux(store)
 	watcher := config.NewWatcher()
 	go watchForUpdates(watcher, store, updates)
@@ -189,11 +518,48 @@ func (c *ServiceConfig) RegisterHandler(handler ServiceConfigHandler) {
 	}))
 }
 
+// RegisterServiceHandler registers fn to be called with the service whose ServiceKey is id each
+// time it changes, and with nil once it is removed, so that a consumer of a single service
+// does not have to go through the full set of services on every update.
+func (c *ServiceConfig) RegisterServiceHandler(id string, fn func(*api.Service)) {
+	var last *api.Service
+	c.watcher.Add(config.ListenerFunc(func(instance interface{}) {
+		var current *api.Service
+		for _, service := range instance.([]api.Service) {
+			if ServiceKey(service) == id {
+				current = &service
+				break
+			}
+		}
+		if current == nil && last == nil {
+			return
+		}
+		if current != nil && last != nil && reflect.DeepEqual(*current, *last) {
+			return
+		}
+		last = current
+		fn(current)
+	}))
+}
+
 func (c *ServiceConfig) Channel(source string) chan ServiceUpdate {
 	ch := c.mux.Channel(source)
 	serviceCh := make(chan ServiceUpdate)
 	go func() {
 		for update := range serviceCh {
+			// Only the invalid services are dropped, so that one cannot hold back a SET.
+			dropped := 0
+			update, err := update.dropInvalid(func(service api.Service, err error) {
+				glog.Errorf("Dropping invalid service from %s: %v", source, err)
+				dropped++
+			})
+			if err != nil {
+				glog.Errorf("Rejecting invalid update from %s: %v", source, err)
+				continue
+			}
+			if dropped > 0 && update.Op != SET && len(update.Services) == 0 {
+				continue
+			}
 			ch <- update
 		}
 		close(ch)
@@ -202,51 +568,123 @@ func (c *ServiceConfig) Channel(source string) chan ServiceUpdate {
 }
 
 func (c *ServiceConfig) Config() map[string]map[string]api.Service {
-	return c.store.MergedState().(map[string]map[string]api.Service)
+	return c.store.sourceState()
 }
 
 type serviceStore struct {
 	serviceLock sync.RWMutex
 	services    map[string]map[string]api.Service
+	lastUpdate  map[string]time.Time
 	updates     chan<- struct{}
+	// index, if set, is kept up to date with the labels of services by Merge.
+	index labelIndex
+}
+
+// newServiceStore creates a serviceStore which signals updates after each merge, if updates is not nil.
+func newServiceStore(updates chan<- struct{}) *serviceStore {
+	return &serviceStore{
+		updates:    updates,
+		services:   make(map[string]map[string]api.Service),
+		lastUpdate: make(map[string]time.Time),
+	}
 }
 
 func (s *serviceStore) Merge(source string, change interface{}) error {
+	update := change.(ServiceUpdate)
+	if update.Op == SNAPSHOT_START || update.Op == SNAPSHOT_END || update.Op == DRAIN {
+		// Snapshot fencing and draining are for consumers of the source channel, there is
+		// nothing to merge.
+		return nil
+	}
 	s.serviceLock.Lock()
 	services := s.services[source]
 	if services == nil {
 		services = make(map[string]api.Service)
 	}
-	update := change.(ServiceUpdate)
+	// ADDs of services that are already known and REMOVEs of unknown ones, such as duplicate
+	// watch events, leave the state alone and are not passed on to the handlers.
+	changed := false
 	switch update.Op {
 	case ADD:
 		glog.Infof("Adding new service from source %s : %v", source, update.Services)
 		for _, value := range update.Services {
-			services[value.ID] = value
+			existing, found, applied := applyService(services, ADD, value, equalServices)
+			if !applied {
+				continue
+			}
+			if found {
+				s.index.remove(source, existing)
+			}
+			s.index.add(source, value)
+			changed = true
 		}
 	case REMOVE:
 		glog.Infof("Removing a service %v", update)
 		for _, value := range update.Services {
-			delete(services, value.ID)
+			existing, _, applied := applyService(services, REMOVE, value, equalServices)
+			if !applied {
+				glog.V(2).Infof("Ignoring removal of unknown service %s from source %s", ServiceKey(value), source)
+				continue
+			}
+			s.index.remove(source, existing)
+			changed = true
 		}
 	case SET:
 		glog.Infof("Setting services %v", update)
+		changed = true
+		for _, value := range services {
+			s.index.remove(source, value)
+		}
 		// Clear the old map entries by just creating a new map
 		services = make(map[string]api.Service)
 		for _, value := range update.Services {
-			services[value.ID] = value
+			if existing, found := services[ServiceKey(value)]; found {
+				s.index.remove(source, existing)
+			}
+			services[ServiceKey(value)] = value
+			s.index.add(source, value)
 		}
 	default:
 		glog.Infof("Received invalid update type: %v", update)
 	}
 	s.services[source] = services
+	s.lastUpdate[source] = time.Now()
 	s.serviceLock.Unlock()
-	if s.updates != nil {
+	if changed && s.updates != nil {
 		s.updates <- struct{}{}
 	}
 	return nil
 }
 
+// applyService applies an ADD or REMOVE of service to services, which are keyed by
+// ServiceKey. An ADD of a service that same finds equal to the known one and a REMOVE of an
+// unknown service leave services alone. It returns the service that was replaced or removed,
+// if there was one, and whether services changed.
+func applyService(services map[string]api.Service, op Operation, service api.Service, same func(a, b api.Service) bool) (existing api.Service, found, changed bool) {
+	key := ServiceKey(service)
+	existing, found = services[key]
+	switch op {
+	case ADD:
+		if found && same(existing, service) {
+			return existing, found, false
+		}
+		services[key] = service
+		return existing, found, true
+	case REMOVE:
+		if !found {
+			return existing, false, false
+		}
+		delete(services, key)
+		return existing, true, true
+	}
+	return existing, found, false
+}
+
+// equalServices returns whether a and b are deeply equal.
+func equalServices(a, b api.Service) bool {
+	return reflect.DeepEqual(a, b)
+}
+
 func (s *serviceStore) MergedState() interface{} {
 	s.serviceLock.RLock()
 	defer s.serviceLock.RUnlock()
@@ -259,6 +697,31 @@ func (s *serviceStore) MergedState() interface{} {
 	return services
 }
 
+// sourceState returns a copy of the services known from each source.
+func (s *serviceStore) sourceState() map[string]map[string]api.Service {
+	s.serviceLock.RLock()
+	defer s.serviceLock.RUnlock()
+	state := make(map[string]map[string]api.Service, len(s.services))
+	for source, sourceServices := range s.services {
+		state[source] = make(map[string]api.Service, len(sourceServices))
+		for id, value := range sourceServices {
+			state[source][id] = value
+		}
+	}
+	return state
+}
+
+// lastUpdated returns the time of the last update received from each source.
+func (s *serviceStore) lastUpdated() map[string]time.Time {
+	s.serviceLock.RLock()
+	defer s.serviceLock.RUnlock()
+	times := make(map[string]time.Time, len(s.lastUpdate))
+	for source, t := range s.lastUpdate {
+		times[source] = t
+	}
+	return times
+}
+
 // watchForUpdates invokes watcher.Notify() with the latest version of an object
 // when changes occur.
 func watchForUpdates(watcher *config.Watcher, accessor config.Accessor, updates <-chan struct{}) {
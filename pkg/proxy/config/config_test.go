@@ -21,6 +21,7 @@ import (
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	. "github.com/GoogleCloudPlatform/kubernetes/pkg/proxy/config"
@@ -45,7 +46,7 @@ func (s sortedServices) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 func (s sortedServices) Less(i, j int) bool {
-	return s[i].JSONBase.ID < s[j].JSONBase.ID
+	return ServiceKey(s[i]) < ServiceKey(s[j])
 }
 
 type ServiceHandlerMock struct {
@@ -171,6 +172,31 @@ func TestServiceAddedRemovedSetAndNotified(t *testing.T) {
 	handler.ValidateServices(t, services)
 }
 
+func TestInvalidServiceUpdateRejected(t *testing.T) {
+	config := NewServiceConfig()
+	channel := config.Channel("one")
+	handler := NewServiceHandlerMock()
+	config.RegisterHandler(handler)
+	// a service without a port is not passed on
+	channel <- CreateServiceUpdate(ADD, api.Service{JSONBase: api.JSONBase{ID: "bar"}})
+	serviceUpdate := CreateServiceUpdate(ADD, api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10})
+	handler.Wait(1)
+	channel <- serviceUpdate
+	handler.ValidateServices(t, serviceUpdate.Services)
+}
+
+func TestInvalidServiceDroppedFromSet(t *testing.T) {
+	config := NewServiceConfig()
+	channel := config.Channel("one")
+	handler := NewServiceHandlerMock()
+	config.RegisterHandler(handler)
+	// the service without a port is dropped, but the rest of the SET is applied
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	handler.Wait(1)
+	channel <- CreateServiceUpdate(SET, foo, api.Service{JSONBase: api.JSONBase{ID: "bar"}})
+	handler.ValidateServices(t, []api.Service{foo})
+}
+
 func TestNewMultipleSourcesServicesAddedAndNotified(t *testing.T) {
 	config := NewServiceConfig()
 	channelOne := config.Channel("one")
@@ -293,3 +319,310 @@ func TestNewMultipleSourcesEndpointsMultipleHandlersAddRemoveSetAndNotified(t *t
 	handler.ValidateEndpoints(t, endpoints)
 	handler2.ValidateEndpoints(t, endpoints)
 }
+
+func TestServicesAcrossNamespaces(t *testing.T) {
+	config := NewServiceConfig()
+	channel := config.Channel("one")
+	handler := NewServiceHandlerMock()
+	config.RegisterHandler(handler)
+	fooA := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10, Labels: map[string]string{NamespaceLabel: "a"}}
+	fooB := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 20, Labels: map[string]string{NamespaceLabel: "b"}}
+	handler.Wait(1)
+	channel <- CreateServiceUpdate(ADD, fooA, fooB)
+	handler.ValidateServices(t, []api.Service{fooA, fooB})
+
+	// removing one leaves the service of the same name in the other namespace
+	handler.Wait(1)
+	channel <- CreateServiceUpdate(REMOVE, fooA)
+	handler.ValidateServices(t, []api.Service{fooB})
+
+	if key := ServiceKey(fooB); key != "b/foo" {
+		t.Errorf("expected b/foo, got %q", key)
+	}
+	if namespace, name := SplitNamespacedName("b/foo"); namespace != "b" || name != "foo" {
+		t.Errorf("expected b foo, got %q %q", namespace, name)
+	}
+}
+
+func TestMergeServiceUpdates(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 82}
+	newFoo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 90}
+	now := time.Unix(10, 0)
+
+	merged := Merge([]ServiceUpdate{
+		{Op: SET, Services: []api.Service{foo, bar}},
+		{Op: ADD, Services: []api.Service{baz}},
+		{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}}}},
+		{Op: ADD, Services: []api.Service{newFoo}},
+		{Op: ADD, Services: []api.Service{bar}, Source: "api", Timestamp: now},
+	})
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{bar, baz, newFoo}, Source: "api", Timestamp: now}
+	if !reflect.DeepEqual(expected, merged) {
+		t.Errorf("expected %#v, got %#v", expected, merged)
+	}
+
+	// a SET drops everything before it, and a REMOVE after an ADD cancels it
+	merged = Merge([]ServiceUpdate{
+		{Op: ADD, Services: []api.Service{foo}},
+		{Op: SET, Services: []api.Service{bar}},
+		{Op: ADD, Services: []api.Service{baz}},
+		{Op: REMOVE, Services: []api.Service{baz}},
+	})
+	expected = ServiceUpdate{Op: SET, Services: []api.Service{bar}}
+	if !reflect.DeepEqual(expected, merged) {
+		t.Errorf("expected %#v, got %#v", expected, merged)
+	}
+
+	expected = ServiceUpdate{Op: SET, Services: []api.Service{}}
+	if merged := Merge(nil); !reflect.DeepEqual(expected, merged) {
+		t.Errorf("expected %#v, got %#v", expected, merged)
+	}
+}
+
+// portedHandlerMock is a PortedEndpointsConfigHandler that sends the endpoints and named
+// ports it is given.
+type portedHandlerMock struct {
+	ports   map[string]map[string][]string
+	updates chan portedUpdate
+}
+
+type portedUpdate struct {
+	endpoints []api.Endpoints
+	ports     map[string]map[string][]string
+}
+
+func (h *portedHandlerMock) OnPortsUpdate(ports map[string]map[string][]string) {
+	h.ports = ports
+}
+
+func (h *portedHandlerMock) OnUpdate(endpoints []api.Endpoints) {
+	h.updates <- portedUpdate{endpoints, h.ports}
+}
+
+func TestEndpointsWithNamedPorts(t *testing.T) {
+	config := NewEndpointsConfig()
+	channel := config.Channel("one")
+	handler := &portedHandlerMock{updates: make(chan portedUpdate)}
+	config.RegisterHandler(handler)
+
+	// the named ports are passed on apart from the endpoints
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.1:9090"}}
+	update := CreateEndpointsUpdate(ADD, foo)
+	update.Ports = map[string]map[string][]string{"foo": {"http": {"10.0.0.1:80"}, "metrics": {"10.0.0.1:9090"}}}
+	channel <- update
+	expected := portedUpdate{[]api.Endpoints{foo}, update.Ports}
+	if actual := <-handler.updates; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// a change of ports alone is passed on, and a port left out of it is removed
+	update = CreateEndpointsUpdate(ADD, foo)
+	update.Ports = map[string]map[string][]string{"foo": {"http": {"10.0.0.1:80"}}}
+	channel <- update
+	expected = portedUpdate{[]api.Endpoints{foo}, update.Ports}
+	if actual := <-handler.updates; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	channel <- CreateEndpointsUpdate(REMOVE, foo)
+	if actual := <-handler.updates; len(actual.endpoints) != 0 || len(actual.ports) != 0 {
+		t.Errorf("expected no endpoints or ports, got %#v", actual)
+	}
+}
+
+func TestWithNamedPorts(t *testing.T) {
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.1:9090"}}
+	ports := map[string]map[string][]string{"foo": {"metrics": {"10.0.0.1:9090"}}, "bar": {"http": {"10.0.0.2:80"}}}
+	expected := []api.Endpoints{
+		foo,
+		{JSONBase: api.JSONBase{ID: ServicePortName("foo", "metrics")}, Endpoints: []string{"10.0.0.1:9090"}},
+	}
+	if actual := WithNamedPorts([]api.Endpoints{foo}, ports); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestEndpointCounts(t *testing.T) {
+	config := NewEndpointsConfig()
+	one := config.Channel("one")
+	two := config.Channel("two")
+	updates := make(endpointsUpdates, 10)
+	config.RegisterHandler(updates)
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.0.3:80"}}
+	baz := api.Endpoints{JSONBase: api.JSONBase{ID: "baz"}, Endpoints: []string{}}
+
+	// the endpoints of named ports are not counted again
+	update := CreateEndpointsUpdate(ADD, foo)
+	update.Ports = map[string]map[string][]string{"foo": {"http": {"10.0.0.1:80", "10.0.0.2:80"}}}
+	steps := []struct {
+		channel  chan EndpointsUpdate
+		update   EndpointsUpdate
+		expected map[string]int
+	}{
+		{one, update, map[string]int{"foo": 2}},
+		{one, CreateEndpointsUpdate(ADD, bar, baz), map[string]int{"foo": 2, "bar": 1, "baz": 0}},
+		// counts are summed across sources
+		{two, CreateEndpointsUpdate(SET, foo), map[string]int{"foo": 4, "bar": 1, "baz": 0}},
+		{one, CreateEndpointsUpdate(REMOVE, foo), map[string]int{"foo": 2, "bar": 1, "baz": 0}},
+		// a SET clears the services it leaves out
+		{one, CreateEndpointsUpdate(SET, bar), map[string]int{"foo": 2, "bar": 1}},
+		{two, CreateEndpointsUpdate(SET), map[string]int{"bar": 1}},
+	}
+	for i, step := range steps {
+		step.channel <- step.update
+		<-updates
+		if actual := config.EndpointCounts(); !reflect.DeepEqual(step.expected, actual) {
+			t.Errorf("step %d: expected %v, got %v", i, step.expected, actual)
+		}
+	}
+}
+
+// serviceUpdates records every list of services its handler is called with.
+type serviceUpdates chan []api.Service
+
+func (u serviceUpdates) OnUpdate(services []api.Service) {
+	sort.Sort(sortedServices(services))
+	u <- services
+}
+
+// endpointsUpdates records every list of endpoints its handler is called with.
+type endpointsUpdates chan []api.Endpoints
+
+func (u endpointsUpdates) OnUpdate(endpoints []api.Endpoints) {
+	sort.Sort(sortedEndpoints(endpoints))
+	u <- endpoints
+}
+
+func TestDuplicateServiceUpdatesIgnored(t *testing.T) {
+	config := NewServiceConfig()
+	channel := config.Channel("one")
+	updates := make(serviceUpdates, 10)
+	config.RegisterHandler(updates)
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 20}
+	updated := bar
+	updated.Port = 30
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 40}
+
+	// Updates that do not change the state are not passed on, so each step notifies the
+	// handler exactly once, with the state after its last update. An extra notification
+	// would be seen by the next step.
+	steps := []struct {
+		updates  []ServiceUpdate
+		expected []api.Service
+	}{
+		{[]ServiceUpdate{CreateServiceUpdate(ADD, foo)}, []api.Service{foo}},
+		{[]ServiceUpdate{
+			CreateServiceUpdate(ADD, foo),
+			CreateServiceUpdate(REMOVE, bar),
+			CreateServiceUpdate(REMOVE, bar),
+			CreateServiceUpdate(ADD, bar),
+		}, []api.Service{bar, foo}},
+		{[]ServiceUpdate{
+			CreateServiceUpdate(REMOVE, foo),
+		}, []api.Service{bar}},
+		{[]ServiceUpdate{
+			CreateServiceUpdate(REMOVE, foo),
+			// an ADD of a known service updates it in place
+			CreateServiceUpdate(ADD, updated),
+		}, []api.Service{updated}},
+		{[]ServiceUpdate{
+			CreateServiceUpdate(ADD, updated),
+			CreateServiceUpdate(ADD, baz),
+		}, []api.Service{updated, baz}},
+	}
+	for i, step := range steps {
+		for _, update := range step.updates {
+			channel <- update
+		}
+		if actual := <-updates; !reflect.DeepEqual(step.expected, actual) {
+			t.Errorf("step %d: expected %#v, got %#v", i, step.expected, actual)
+		}
+	}
+}
+
+func TestServiceHandler(t *testing.T) {
+	config := NewServiceConfig()
+	channel := config.Channel("one")
+	fooUpdates := make(chan *api.Service, 10)
+	config.RegisterServiceHandler("foo", func(service *api.Service) {
+		fooUpdates <- service
+	})
+	// handlers are called in order, so once this one is called foo's has been too
+	updates := make(serviceUpdates, 10)
+	config.RegisterHandler(updates)
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	updatedFoo := foo
+	updatedFoo.Port = 11
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 20}
+	updatedBar := bar
+	updatedBar.Port = 21
+
+	steps := []struct {
+		update   ServiceUpdate
+		expected []*api.Service
+	}{
+		{CreateServiceUpdate(ADD, foo), []*api.Service{&foo}},
+		{CreateServiceUpdate(ADD, bar), nil},
+		{CreateServiceUpdate(ADD, updatedBar), nil},
+		{CreateServiceUpdate(ADD, updatedFoo), []*api.Service{&updatedFoo}},
+		{CreateServiceUpdate(REMOVE, bar), nil},
+		{CreateServiceUpdate(REMOVE, foo), []*api.Service{nil}},
+	}
+	for i, step := range steps {
+		channel <- step.update
+		<-updates
+		var actual []*api.Service
+		for len(fooUpdates) > 0 {
+			actual = append(actual, <-fooUpdates)
+		}
+		if !reflect.DeepEqual(step.expected, actual) {
+			t.Errorf("step %d: expected %#v, got %#v", i, step.expected, actual)
+		}
+	}
+}
+
+func TestDuplicateEndpointsUpdatesIgnored(t *testing.T) {
+	config := NewEndpointsConfig()
+	channel := config.Channel("one")
+	updates := make(endpointsUpdates, 10)
+	config.RegisterHandler(updates)
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"endpoint1"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"endpoint2"}}
+
+	steps := []struct {
+		updates  []EndpointsUpdate
+		expected []api.Endpoints
+	}{
+		{[]EndpointsUpdate{CreateEndpointsUpdate(ADD, foo)}, []api.Endpoints{foo}},
+		{[]EndpointsUpdate{
+			CreateEndpointsUpdate(ADD, foo),
+			CreateEndpointsUpdate(REMOVE, bar),
+			CreateEndpointsUpdate(REMOVE, bar),
+			CreateEndpointsUpdate(ADD, bar),
+		}, []api.Endpoints{bar, foo}},
+		{[]EndpointsUpdate{
+			CreateEndpointsUpdate(REMOVE, foo),
+		}, []api.Endpoints{bar}},
+		// a SET is always passed on
+		{[]EndpointsUpdate{
+			CreateEndpointsUpdate(REMOVE, foo),
+			CreateEndpointsUpdate(SET, bar),
+		}, []api.Endpoints{bar}},
+		{[]EndpointsUpdate{
+			CreateEndpointsUpdate(ADD, bar),
+			CreateEndpointsUpdate(ADD, foo),
+		}, []api.Endpoints{bar, foo}},
+	}
+	for i, step := range steps {
+		for _, update := range step.updates {
+			channel <- update
+		}
+		if actual := <-updates; !reflect.DeepEqual(step.expected, actual) {
+			t.Errorf("step %d: expected %#v, got %#v", i, step.expected, actual)
+		}
+	}
+}
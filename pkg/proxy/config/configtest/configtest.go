@@ -0,0 +1,287 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configtest provides a config.SourceAPI backed by an in-memory store of services and
+// endpoints, so that the handlers of its updates can be tested without an apiserver.
+package configtest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+// WaitTimeout is how long NextServices and NextEndpoints wait for an update.
+var WaitTimeout = 5 * time.Second
+
+// updateBuffer is the number of updates of each channel of a Source that are held until they
+// are read, so that a test can make several changes before it reads the updates.
+const updateBuffer = 100
+
+// Store holds services and endpoints in memory and implements config.Watcher on them. Each
+// change is given the next resource version, which watches resume from as from an apiserver.
+// It is safe for concurrent use.
+type Store struct {
+	lock      sync.Mutex
+	version   uint64
+	services  map[string]api.Service
+	endpoints map[string]api.Endpoints
+	// serviceEvents and endpointsEvents hold every change, in order of resource version.
+	serviceEvents   []storeEvent
+	endpointsEvents []storeEvent
+	// changed is closed and replaced on each change.
+	changed chan struct{}
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{
+		services:  make(map[string]api.Service),
+		endpoints: make(map[string]api.Endpoints),
+		changed:   make(chan struct{}),
+	}
+}
+
+// storeEvent is a change of a Store, at a resource version.
+type storeEvent struct {
+	version uint64
+	event   watch.Event
+}
+
+// nextVersion returns the resource version of a new change. It must be called with the lock
+// held.
+func (s *Store) nextVersion() uint64 {
+	s.version++
+	return s.version
+}
+
+// record appends event, at the current resource version, to events and wakes the watches. It
+// must be called with the lock held.
+func (s *Store) record(events *[]storeEvent, event watch.Event) {
+	*events = append(*events, storeEvent{s.version, event})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// AddService stores service and returns it as stored, with its resource version.
+func (s *Store) AddService(service api.Service) api.Service {
+	return s.putService(watch.Added, service)
+}
+
+// ModifyService replaces the stored service of the same key and returns it as stored.
+func (s *Store) ModifyService(service api.Service) api.Service {
+	return s.putService(watch.Modified, service)
+}
+
+func (s *Store) putService(eventType watch.EventType, service api.Service) api.Service {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	service.ResourceVersion = s.nextVersion()
+	s.record(&s.serviceEvents, watch.Event{Type: eventType, Object: &service})
+	s.services[config.ServiceKey(service)] = service
+	return service
+}
+
+// DeleteService deletes the service whose ServiceKey is key, and returns it as it was deleted,
+// or false if there is none.
+func (s *Store) DeleteService(key string) (api.Service, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	service, ok := s.services[key]
+	if !ok {
+		return api.Service{}, false
+	}
+	delete(s.services, key)
+	service.ResourceVersion = s.nextVersion()
+	s.record(&s.serviceEvents, watch.Event{Type: watch.Deleted, Object: &service})
+	return service, true
+}
+
+// AddEndpoints stores endpoints and returns them as stored, with their resource version.
+func (s *Store) AddEndpoints(endpoints api.Endpoints) api.Endpoints {
+	return s.putEndpoints(watch.Added, endpoints)
+}
+
+// ModifyEndpoints replaces the stored endpoints of the same ID and returns them as stored.
+func (s *Store) ModifyEndpoints(endpoints api.Endpoints) api.Endpoints {
+	return s.putEndpoints(watch.Modified, endpoints)
+}
+
+func (s *Store) putEndpoints(eventType watch.EventType, endpoints api.Endpoints) api.Endpoints {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	endpoints.ResourceVersion = s.nextVersion()
+	s.record(&s.endpointsEvents, watch.Event{Type: eventType, Object: &endpoints})
+	s.endpoints[endpoints.ID] = endpoints
+	return endpoints
+}
+
+// DeleteEndpoints deletes the endpoints of id, and returns them as they were deleted, or false
+// if there are none.
+func (s *Store) DeleteEndpoints(id string) (api.Endpoints, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	endpoints, ok := s.endpoints[id]
+	if !ok {
+		return api.Endpoints{}, false
+	}
+	delete(s.endpoints, id)
+	endpoints.ResourceVersion = s.nextVersion()
+	s.record(&s.endpointsEvents, watch.Event{Type: watch.Deleted, Object: &endpoints})
+	return endpoints, true
+}
+
+// ListServices returns the stored services, sorted by key. The resource version of the list
+// is that of the next change, which a watch from it starts with.
+func (s *Store) ListServices(selector labels.Selector) (*api.ServiceList, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := make([]string, 0, len(s.services))
+	for key := range s.services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := &api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: s.version + 1}, Items: []api.Service{}}
+	for _, key := range keys {
+		list.Items = append(list.Items, s.services[key])
+	}
+	return list, nil
+}
+
+// ListEndpoints returns the stored endpoints, sorted by ID, like ListServices.
+func (s *Store) ListEndpoints(selector labels.Selector) (*api.EndpointsList, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ids := make([]string, 0, len(s.endpoints))
+	for id := range s.endpoints {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	list := &api.EndpointsList{JSONBase: api.JSONBase{ResourceVersion: s.version + 1}, Items: []api.Endpoints{}}
+	for _, id := range ids {
+		list.Items = append(list.Items, s.endpoints[id])
+	}
+	return list, nil
+}
+
+// WatchServices returns a watch of the changes of services from resourceVersion on.
+func (s *Store) WatchServices(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return s.watch(&s.serviceEvents, resourceVersion), nil
+}
+
+// WatchEndpoints returns a watch of the changes of endpoints from resourceVersion on.
+func (s *Store) WatchEndpoints(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return s.watch(&s.endpointsEvents, resourceVersion), nil
+}
+
+// storeWatch is a watch of the events of a Store.
+type storeWatch struct {
+	result chan watch.Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (w *storeWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *storeWatch) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// watch starts sending the events from resourceVersion on, and then each new one, until the
+// watch is stopped.
+func (s *Store) watch(events *[]storeEvent, resourceVersion uint64) watch.Interface {
+	w := &storeWatch{result: make(chan watch.Event), stop: make(chan struct{})}
+	go func() {
+		defer close(w.result)
+		next := 0
+		for {
+			s.lock.Lock()
+			for next < len(*events) && (*events)[next].version < resourceVersion {
+				next++
+			}
+			pending := (*events)[next:]
+			changed := s.changed
+			s.lock.Unlock()
+			for _, e := range pending {
+				select {
+				case w.result <- e.event:
+					next++
+				case <-w.stop:
+					return
+				}
+			}
+			if len(pending) == 0 {
+				select {
+				case <-changed:
+				case <-w.stop:
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// Source is a config.SourceAPI watching a Store, with the channels of its updates.
+type Source struct {
+	*config.SourceAPI
+	Store     *Store
+	Services  <-chan config.ServiceUpdate
+	Endpoints <-chan config.EndpointsUpdate
+}
+
+// NewSource creates a SourceAPI with options that watches store. It starts with a SET of the
+// services and endpoints in store, followed by an update for each change made to it.
+func NewSource(store *Store, options config.SourceAPIOptions) *Source {
+	services := make(chan config.ServiceUpdate, updateBuffer)
+	endpoints := make(chan config.EndpointsUpdate, updateBuffer)
+	return &Source{
+		SourceAPI: config.NewSourceAPIWithOptions(store, time.Second, services, endpoints, options),
+		Store:     store,
+		Services:  services,
+		Endpoints: endpoints,
+	}
+}
+
+// NextServices returns the next update of services, or an error if none is sent within
+// WaitTimeout.
+func (s *Source) NextServices() (config.ServiceUpdate, error) {
+	select {
+	case update := <-s.Services:
+		return update, nil
+	case <-time.After(WaitTimeout):
+		return config.ServiceUpdate{}, fmt.Errorf("no update of services within %v", WaitTimeout)
+	}
+}
+
+// NextEndpoints returns the next update of endpoints, or an error if none is sent within
+// WaitTimeout.
+func (s *Source) NextEndpoints() (config.EndpointsUpdate, error) {
+	select {
+	case update := <-s.Endpoints:
+		return update, nil
+	case <-time.After(WaitTimeout):
+		return config.EndpointsUpdate{}, fmt.Errorf("no update of endpoints within %v", WaitTimeout)
+	}
+}
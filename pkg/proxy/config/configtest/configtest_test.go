@@ -0,0 +1,105 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+func TestSourceServices(t *testing.T) {
+	store := NewStore()
+	foo := store.AddService(api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80})
+	source := NewSource(store, config.SourceAPIOptions{})
+	defer source.Close()
+
+	// the services stored before the source started are listed
+	expected := config.ServiceUpdate{Op: config.SET, Services: []api.Service{foo}}
+	if actual, err := source.NextServices(); err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v, %v", expected, actual, err)
+	}
+
+	bar := store.AddService(api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81})
+	foo.Port = 8080
+	foo = store.ModifyService(foo)
+	deleted, ok := store.DeleteService("bar")
+	if !ok {
+		t.Fatalf("expected bar to be deleted")
+	}
+	if _, ok := store.DeleteService("bar"); ok {
+		t.Errorf("expected no service to delete")
+	}
+	for _, expected := range []config.ServiceUpdate{
+		{Op: config.ADD, Services: []api.Service{bar}},
+		{Op: config.ADD, Services: []api.Service{foo}},
+		{Op: config.REMOVE, Services: []api.Service{deleted}},
+	} {
+		if actual, err := source.NextServices(); err != nil || !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v, %v", expected, actual, err)
+		}
+	}
+	if deleted.ResourceVersion <= bar.ResourceVersion {
+		t.Errorf("expected the deletion after resource version %d, got %d", bar.ResourceVersion, deleted.ResourceVersion)
+	}
+}
+
+func TestSourceEndpoints(t *testing.T) {
+	store := NewStore()
+	source := NewSource(store, config.SourceAPIOptions{})
+	defer source.Close()
+
+	expected := config.EndpointsUpdate{Op: config.SET, Endpoints: []api.Endpoints{}}
+	if actual, err := source.NextEndpoints(); err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v, %v", expected, actual, err)
+	}
+
+	foo := store.AddEndpoints(api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}})
+	foo.Endpoints = []string{"10.0.0.2:80"}
+	foo = store.ModifyEndpoints(foo)
+	deleted, _ := store.DeleteEndpoints("foo")
+	for _, expected := range []config.EndpointsUpdate{
+		{Op: config.ADD, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 1}, Endpoints: []string{"10.0.0.1:80"}}}},
+		{Op: config.ADD, Endpoints: []api.Endpoints{foo}},
+		{Op: config.REMOVE, Endpoints: []api.Endpoints{deleted}},
+	} {
+		if actual, err := source.NextEndpoints(); err != nil || !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v, %v", expected, actual, err)
+		}
+	}
+}
+
+func TestStoreWatchResumes(t *testing.T) {
+	store := NewStore()
+	store.AddService(api.Service{JSONBase: api.JSONBase{ID: "foo"}})
+	store.AddEndpoints(api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}})
+	bar := store.AddService(api.Service{JSONBase: api.JSONBase{ID: "bar"}})
+
+	// a watch from a resource version starts with the change at that version
+	w, _ := store.WatchServices(nil, nil, 2)
+	defer w.Stop()
+	event := <-w.ResultChan()
+	if service := event.Object.(*api.Service); !reflect.DeepEqual(bar, *service) {
+		t.Errorf("expected %#v, got %#v", bar, *service)
+	}
+	w.Stop()
+	if _, ok := <-w.ResultChan(); ok {
+		t.Errorf("expected the watch to be closed once stopped")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// connLog logs the messages about a single list or watch of the apiserver. With
+// TagConnections, each connection gets a random UUID that prefixes its messages, so that
+// those of a watch can be told apart from those of the watches before and after it.
+type connLog struct {
+	id string
+}
+
+// newConnLog returns the log of a new connection.
+func (s *SourceAPI) newConnLog() *connLog {
+	if !s.TagConnections {
+		return &connLog{}
+	}
+	return &connLog{id: newConnectionID()}
+}
+
+func (l *connLog) prefix(format string) string {
+	if l.id == "" {
+		return format
+	}
+	return "[" + l.id + "] " + format
+}
+
+// Infof logs an informational message about the connection.
+func (l *connLog) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, fmt.Sprintf(l.prefix(format), args...))
+}
+
+// Errorf logs an error of the connection.
+func (l *connLog) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintf(l.prefix(format), args...))
+}
+
+// connVerbose logs the messages about a connection at a verbosity level, as glog.Verbose does.
+type connVerbose struct {
+	log     *connLog
+	enabled bool
+}
+
+// V returns the log of the connection at level, as glog.V does.
+func (l *connLog) V(level glog.Level) connVerbose {
+	return connVerbose{log: l, enabled: bool(glog.V(level))}
+}
+
+// Infof logs an informational message about the connection if the level is enabled.
+func (v connVerbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		glog.InfoDepth(1, fmt.Sprintf(v.log.prefix(format), args...))
+	}
+}
+
+// newConnectionID returns a random (version 4) UUID.
+func newConnectionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Tagging is only for reading the logs, so a clash would be harmless.
+		glog.Errorf("Unable to generate connection ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"regexp"
+	"testing"
+)
+
+var taggedMessage = regexp.MustCompile(`^\[([0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12})\] (.*)$`)
+
+func TestTagConnections(t *testing.T) {
+	source := SourceAPI{}
+	source.TagConnections = true
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		message := source.newConnLog().prefix("Unable to load services: %v")
+		match := taggedMessage.FindStringSubmatch(message)
+		if match == nil {
+			t.Fatalf("expected a message tagged with a UUID, got %q", message)
+		}
+		if match[2] != "Unable to load services: %v" {
+			t.Errorf("expected the message after the tag, got %q", match[2])
+		}
+		ids[match[1]] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected each connection to have its own ID, got %v", ids)
+	}
+
+	// untagged connections log as before
+	source.TagConnections = false
+	if message := source.newConnLog().prefix("Unable to load services: %v"); message != "Unable to load services: %v" {
+		t.Errorf("expected an untagged message, got %q", message)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	"github.com/golang/glog"
+)
+
+// The number of fingerprints in a bucket of a CuckooFilter, and the most fingerprints an
+// insert moves to their other bucket before the filter counts as full.
+const (
+	cuckooBucketSize = 4
+	cuckooMaxKicks   = 500
+)
+
+// cuckooDedupCapacity is the number of events the CuckooDedup filter holds before it is
+// cleared.
+const cuckooDedupCapacity = 1 << 16
+
+// CuckooFilter is a set of items in constant memory. Contains never misses an item that was
+// inserted, but reports about one in 8000 items that were not as present: only a 16-bit
+// fingerprint of each item is kept, in one of two buckets of four derived from its hash.
+// It is not safe for concurrent use.
+type CuckooFilter struct {
+	buckets [][cuckooBucketSize]uint16
+	mask    uint64
+	count   int
+	// victim holds the fingerprint left over by an insert that found no room, so that it is
+	// not lost. The filter is full once it is set.
+	victim      uint16
+	victimIndex uint64
+	rand        *rand.Rand
+}
+
+// NewCuckooFilter creates a CuckooFilter with room for at least capacity items.
+func NewCuckooFilter(capacity int) *CuckooFilter {
+	n := uint64(1)
+	for n*cuckooBucketSize < uint64(capacity) {
+		n <<= 1
+	}
+	return &CuckooFilter{
+		buckets: make([][cuckooBucketSize]uint16, n),
+		mask:    n - 1,
+		rand:    rand.New(rand.NewSource(1)),
+	}
+}
+
+// index returns the fingerprint of item and the first of its buckets.
+func (f *CuckooFilter) index(item []byte) (uint16, uint64) {
+	h := fnv.New64a()
+	h.Write(item)
+	sum := h.Sum64()
+	fingerprint := uint16(sum >> 48)
+	if fingerprint == 0 {
+		// Zero marks an empty slot.
+		fingerprint = 1
+	}
+	return fingerprint, sum & f.mask
+}
+
+// alternate returns the other bucket of fingerprint, given one of them. It is its own inverse.
+func (f *CuckooFilter) alternate(fingerprint uint16, i uint64) uint64 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], fingerprint)
+	h := fnv.New64a()
+	h.Write(b[:])
+	return (i ^ h.Sum64()) & f.mask
+}
+
+// Contains returns whether item may have been inserted.
+func (f *CuckooFilter) Contains(item []byte) bool {
+	fingerprint, i := f.index(item)
+	j := f.alternate(fingerprint, i)
+	if f.victim == fingerprint && (f.victimIndex == i || f.victimIndex == j) {
+		return true
+	}
+	return f.has(i, fingerprint) || f.has(j, fingerprint)
+}
+
+func (f *CuckooFilter) has(i uint64, fingerprint uint16) bool {
+	for _, slot := range f.buckets[i] {
+		if slot == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *CuckooFilter) put(i uint64, fingerprint uint16) bool {
+	for k, slot := range f.buckets[i] {
+		if slot == 0 {
+			f.buckets[i][k] = fingerprint
+			return true
+		}
+	}
+	return false
+}
+
+// Insert adds item, and returns false if the filter is too full to.
+func (f *CuckooFilter) Insert(item []byte) bool {
+	if f.victim != 0 {
+		return false
+	}
+	fingerprint, i := f.index(item)
+	j := f.alternate(fingerprint, i)
+	if f.put(i, fingerprint) || f.put(j, fingerprint) {
+		f.count++
+		return true
+	}
+	// Move fingerprints to their other bucket until one has room.
+	if f.rand.Intn(2) == 0 {
+		i = j
+	}
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		k := f.rand.Intn(cuckooBucketSize)
+		fingerprint, f.buckets[i][k] = f.buckets[i][k], fingerprint
+		i = f.alternate(fingerprint, i)
+		if f.put(i, fingerprint) {
+			f.count++
+			return true
+		}
+	}
+	// The item is in, but the fingerprint it displaced last has no room left.
+	f.victim, f.victimIndex = fingerprint, i
+	f.count++
+	return true
+}
+
+// Count returns the number of items inserted since the filter was created or reset.
+func (f *CuckooFilter) Count() int {
+	return f.count
+}
+
+// Reset removes all items.
+func (f *CuckooFilter) Reset() {
+	for i := range f.buckets {
+		f.buckets[i] = [cuckooBucketSize]uint16{}
+	}
+	f.count = 0
+	f.victim, f.victimIndex = 0, 0
+}
+
+// eventFilter remembers the watch events of a CuckooDedup source. The filter answers most
+// lookups, and a hit in it, which may be a false positive, is confirmed against the last
+// resource version seen of the object. It is safe for concurrent use.
+type eventFilter struct {
+	lock   sync.Mutex
+	filter *CuckooFilter
+	// versions maps the type, kind and ID of each event seen to its highest resource version.
+	versions map[string]uint64
+}
+
+// firstSeen records the event of object at version and returns whether no event of object at
+// that version or a later one was seen before. Once the filter is full it starts over.
+func (e *eventFilter) firstSeen(object string, version uint64) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.filter == nil {
+		e.filter = NewCuckooFilter(cuckooDedupCapacity)
+		e.versions = make(map[string]uint64)
+	}
+	key := []byte(fmt.Sprintf("%s/%d", object, version))
+	if e.filter.Contains(key) {
+		if last, ok := e.versions[object]; ok && version <= last {
+			return false
+		}
+	}
+	if !e.filter.Insert(key) {
+		glog.V(2).Infof("Seen %d watch events, forgetting them", e.filter.Count())
+		e.filter.Reset()
+		e.versions = make(map[string]uint64)
+		e.filter.Insert(key)
+	}
+	if version > e.versions[object] {
+		e.versions[object] = version
+	}
+	return true
+}
+
+// eventKey returns what a watch event is deduplicated by: its type and the kind and ID of its
+// object, and the resource version of the object. Events of other objects have no key.
+func eventKey(event watch.Event) (string, uint64, bool) {
+	switch object := event.Object.(type) {
+	case *api.Service:
+		return fmt.Sprintf("%s/services/%s", event.Type, ServiceKey(*object)), object.ResourceVersion, true
+	case *api.Endpoints:
+		return fmt.Sprintf("%s/endpoints/%s", event.Type, object.ID), object.ResourceVersion, true
+	}
+	return "", 0, false
+}
+
+// dedupEvents passes on the events of a watch that were not seen before, by any watch of the
+// source. It stops when in is closed or done is.
+func (s *SourceAPI) dedupEvents(in <-chan watch.Event, done <-chan struct{}) <-chan watch.Event {
+	out := make(chan watch.Event)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					return
+				}
+				if object, version, ok := eventKey(event); ok && !s.seenEvents.firstSeen(object, version) {
+					glog.V(4).Infof("Dropping duplicate watch event %s at %d", object, version)
+					continue
+				}
+				select {
+				case out <- event:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	filter := NewCuckooFilter(1 << 14)
+	// filled to 90%
+	n := 1 << 14 * 9 / 10
+	for i := 0; i < n; i++ {
+		if !filter.Insert([]byte("in-" + strconv.Itoa(i))) {
+			t.Fatalf("unexpected full filter after %d items", i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !filter.Contains([]byte("in-" + strconv.Itoa(i))) {
+			t.Errorf("expected item %d to be in the filter", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 100000; i++ {
+		if filter.Contains([]byte("out-" + strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+	// about 1 in 8000 of the items that are not in the filter
+	if falsePositives > 50 {
+		t.Errorf("expected at most 50 false positives in 100000, got %d", falsePositives)
+	}
+	if count := filter.Count(); count != n {
+		t.Errorf("expected %d items, got %d", n, count)
+	}
+
+	filter.Reset()
+	if filter.Count() != 0 || filter.Contains([]byte("in-0")) {
+		t.Errorf("expected an empty filter after Reset")
+	}
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	filter := NewCuckooFilter(64)
+	inserted := 0
+	for filter.Insert([]byte(strconv.Itoa(inserted))) {
+		inserted++
+		if inserted > 1000 {
+			t.Fatalf("expected the filter to fill up")
+		}
+	}
+	// every item it took is still there once it is full
+	for i := 0; i < inserted; i++ {
+		if !filter.Contains([]byte(strconv.Itoa(i))) {
+			t.Errorf("expected item %d to be in the filter", i)
+		}
+	}
+}
+
+func TestCuckooDedup(t *testing.T) {
+	foo := &api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}}
+	fooChanged := &api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 3}}
+	fooEndpoints := &api.Endpoints{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}}
+	status := &api.Status{Message: "too old resource version", Code: 410}
+
+	source := SourceAPI{}
+	source.CuckooDedup = true
+	events := []watch.Event{
+		{Type: watch.Added, Object: foo},
+		{Type: watch.Added, Object: foo},
+		{Type: watch.Added, Object: fooEndpoints},
+		{Type: watch.Modified, Object: fooChanged},
+		{Type: watch.Error, Object: status},
+		{Type: watch.Error, Object: status},
+	}
+	// the events of one watch are remembered by the next
+	var actual []watch.Event
+	for _, batch := range [][]watch.Event{events[:3], events[3:], events} {
+		in := make(chan watch.Event, len(batch))
+		for _, event := range batch {
+			in <- event
+		}
+		close(in)
+		for event := range source.dedupEvents(in, make(chan struct{})) {
+			actual = append(actual, event)
+		}
+	}
+
+	expected := []watch.Event{
+		{Type: watch.Added, Object: foo},
+		{Type: watch.Added, Object: fooEndpoints},
+		{Type: watch.Modified, Object: fooChanged},
+		// errors have no object to tell them apart
+		{Type: watch.Error, Object: status},
+		{Type: watch.Error, Object: status},
+		{Type: watch.Error, Object: status},
+		{Type: watch.Error, Object: status},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestEventFilterConfirmsHits(t *testing.T) {
+	var filter eventFilter
+	if !filter.firstSeen("ADDED/services/foo", 2) {
+		t.Errorf("expected the first event to be new")
+	}
+	// a false positive of the filter for an object never seen is not taken as a duplicate
+	filter.filter.Insert([]byte("ADDED/services/bar/2"))
+	if !filter.firstSeen("ADDED/services/bar", 2) {
+		t.Errorf("expected an event of another object to be new")
+	}
+	filter.filter.Insert([]byte("ADDED/services/foo/3"))
+	if !filter.firstSeen("ADDED/services/foo", 3) {
+		t.Errorf("expected a later event of the object to be new")
+	}
+	if filter.firstSeen("ADDED/services/foo", 3) {
+		t.Errorf("expected a repeated event to be a duplicate")
+	}
+}
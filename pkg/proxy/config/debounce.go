@@ -0,0 +1,217 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// debouncer holds the ADDs and REMOVEs of a DebounceInterval, keeping only the last change
+// of each object. Changes that cancel out are dropped: an ADD and then a REMOVE of an object
+// that had not been sent before the interval, or a REMOVE and then an ADD, which is sent as
+// the ADD alone.
+// It is safe for concurrent use. lock is also held while the changes taken are sent, so that
+// they are sent in order with the updates that are not debounced.
+type debouncer struct {
+	lock sync.Mutex
+	// known holds the IDs of the objects that have been sent and not removed.
+	known   map[string]bool
+	pending map[string]pendingChange
+	// order holds the IDs of pending in the order they first changed.
+	order []string
+	// interval counts the calls to take, so that the timer of an interval that was flushed
+	// early does not cut the next one short.
+	interval int
+}
+
+// pendingChange is the last change of an object in the current interval.
+type pendingChange struct {
+	op     Operation
+	object interface{}
+}
+
+// add records a change of the object with the given ID, and returns true if it is the first
+// change of the interval, along with the interval. The lock must be held.
+func (d *debouncer) add(id string, op Operation, object interface{}) (bool, int) {
+	if d.pending == nil {
+		d.pending = make(map[string]pendingChange)
+	}
+	first := len(d.order) == 0
+	if _, found := d.pending[id]; !found {
+		d.order = append(d.order, id)
+	}
+	d.pending[id] = pendingChange{op, object}
+	return first, d.interval
+}
+
+// take returns the objects to add and to remove for the changes of the interval, in the order
+// they first changed, and starts a new interval. The lock must be held.
+func (d *debouncer) take() (added, removed []interface{}) {
+	if d.known == nil {
+		d.known = make(map[string]bool)
+	}
+	for _, id := range d.order {
+		change := d.pending[id]
+		if change.op == ADD {
+			added = append(added, change.object)
+			d.known[id] = true
+		} else if d.known[id] {
+			removed = append(removed, change.object)
+			delete(d.known, id)
+		}
+	}
+	d.pending = nil
+	d.order = nil
+	d.interval++
+	return added, removed
+}
+
+// reset replaces the objects known to have been sent with ids, after a SET. The lock must be
+// held.
+func (d *debouncer) reset(ids []string) {
+	d.known = make(map[string]bool)
+	for _, id := range ids {
+		d.known[id] = true
+	}
+}
+
+// debounceServices holds the ADDs and REMOVEs of services for DebounceInterval, and sends
+// any other update right away, after those that are held.
+func (s *SourceAPI) debounceServices(update ServiceUpdate) {
+	d := &s.serviceDebounce
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if update.Op == ADD || update.Op == REMOVE {
+		for _, service := range update.Services {
+			if first, interval := d.add(ServiceKey(service), update.Op, service); first {
+				go func() {
+					<-s.clock().After(s.DebounceInterval)
+					d.lock.Lock()
+					defer d.lock.Unlock()
+					if d.interval == interval {
+						s.flushServices()
+					}
+				}()
+			}
+		}
+		return
+	}
+	s.flushServices()
+	if update.Op == SET {
+		ids := make([]string, len(update.Services))
+		for i, service := range update.Services {
+			ids[i] = ServiceKey(service)
+		}
+		d.reset(ids)
+	}
+	s.dispatchServices(update)
+}
+
+// flushServices sends the changes of services held by debounceServices. The lock of the
+// debouncer must be held.
+func (s *SourceAPI) flushServices() {
+	added, removed := s.serviceDebounce.take()
+	for _, change := range []struct {
+		op      Operation
+		objects []interface{}
+	}{{ADD, added}, {REMOVE, removed}} {
+		if len(change.objects) == 0 {
+			continue
+		}
+		update := ServiceUpdate{Op: change.op}
+		for _, service := range change.objects {
+			update.Services = append(update.Services, service.(api.Service))
+		}
+		if s.Name != "" {
+			update.Source = s.Name
+			update.Timestamp = s.clock().Now()
+		}
+		s.dispatchServices(update)
+	}
+}
+
+// portsChange is a change of endpoints held by debounceEndpoints, with their named ports.
+type portsChange struct {
+	endpoints api.Endpoints
+	ports     map[string][]string
+}
+
+// debounceEndpoints is debounceServices for endpoints.
+func (s *SourceAPI) debounceEndpoints(update EndpointsUpdate) {
+	d := &s.endpointsDebounce
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if update.Op == ADD || update.Op == REMOVE {
+		for _, endpoints := range update.Endpoints {
+			key := EndpointsKey(endpoints)
+			change := portsChange{endpoints, update.Ports[key]}
+			if first, interval := d.add(key, update.Op, change); first {
+				go func() {
+					<-s.clock().After(s.DebounceInterval)
+					d.lock.Lock()
+					defer d.lock.Unlock()
+					if d.interval == interval {
+						s.flushEndpoints()
+					}
+				}()
+			}
+		}
+		return
+	}
+	s.flushEndpoints()
+	if update.Op == SET {
+		ids := make([]string, len(update.Endpoints))
+		for i, endpoints := range update.Endpoints {
+			ids[i] = EndpointsKey(endpoints)
+		}
+		d.reset(ids)
+	}
+	s.dispatchEndpoints(update)
+}
+
+// flushEndpoints is flushServices for endpoints.
+func (s *SourceAPI) flushEndpoints() {
+	added, removed := s.endpointsDebounce.take()
+	for _, change := range []struct {
+		op      Operation
+		objects []interface{}
+	}{{ADD, added}, {REMOVE, removed}} {
+		if len(change.objects) == 0 {
+			continue
+		}
+		update := EndpointsUpdate{Op: change.op}
+		for _, endpoints := range change.objects {
+			change := endpoints.(portsChange)
+			update.Endpoints = append(update.Endpoints, change.endpoints)
+			if change.ports != nil {
+				if update.Ports == nil {
+					update.Ports = make(map[string]map[string][]string)
+				}
+				update.Ports[EndpointsKey(change.endpoints)] = change.ports
+			}
+		}
+		if s.Name != "" {
+			update.Source = s.Name
+			update.Timestamp = s.clock().Now()
+		}
+		update.Protocols = s.endpointsProtocols(update.Endpoints)
+		update.Weights = s.endpointsWeights(update.Endpoints)
+		s.dispatchEndpoints(update)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestDebounceServices(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{services: services}
+	source.Clock = clock
+	source.DebounceInterval = time.Second
+
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 1}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 1}
+	go source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{bar, baz}})
+	<-services
+
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}}
+	newBar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 2}
+	newBaz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 2}
+	// a new service added then removed cancels out
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{foo}})
+	source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{foo}})
+	// a service removed then added is only added
+	source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}})
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{newBar}})
+	// a known service modified then removed is only removed
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{newBaz}})
+	source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{newBaz}})
+
+	clock.BlockUntil(t, 1)
+	select {
+	case update := <-services:
+		t.Fatalf("unexpected update before the interval %#v", update)
+	default:
+	}
+	clock.Step(time.Second)
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{newBar}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{newBaz}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// a SET sends what is held first
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{foo}})
+	go source.sendServices(ServiceUpdate{Op: SET})
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{foo}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expected = ServiceUpdate{Op: SET}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestDebounceEndpoints(t *testing.T) {
+	endpoints := make(chan EndpointsUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{endpoints: endpoints}
+	source.Clock = clock
+	source.DebounceInterval = time.Second
+
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.0.2:80"}}
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{foo}})
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{bar}})
+	source.sendEndpoints(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{foo}})
+
+	clock.BlockUntil(t, 1)
+	clock.Step(time.Second)
+	expected := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{bar}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
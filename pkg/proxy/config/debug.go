@@ -0,0 +1,136 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// debugServices is the state of services from a single source, as rendered by DebugHandler.
+type debugServices struct {
+	LastUpdate time.Time     `json:"lastUpdate"`
+	Services   []api.Service `json:"services"`
+}
+
+// debugEndpoints is the state of endpoints from a single source, as rendered by DebugHandler.
+type debugEndpoints struct {
+	LastUpdate time.Time       `json:"lastUpdate"`
+	Endpoints  []api.Endpoints `json:"endpoints"`
+}
+
+// debugState is the document served by DebugHandler, keyed by source.
+type debugState struct {
+	Services   map[string]debugServices  `json:"services"`
+	Endpoints  map[string]debugEndpoints `json:"endpoints"`
+	Components map[string]interface{}    `json:"components,omitempty"`
+}
+
+// DebugStateProvider is implemented by components that expose their state through a DebugServer.
+type DebugStateProvider interface {
+	// DebugState returns a snapshot of the state that can be encoded as JSON.
+	DebugState() interface{}
+}
+
+// DebugServer is an http.Handler that dumps the services and endpoints currently known
+// to a pair of configs as JSON, grouped by the source they came from, along with the
+// state of any registered components.
+type DebugServer struct {
+	services  *ServiceConfig
+	endpoints *EndpointsConfig
+
+	lock       sync.RWMutex
+	components map[string]DebugStateProvider
+}
+
+// DebugHandler returns a DebugServer for the given configs. Either config may be nil.
+func DebugHandler(services *ServiceConfig, endpoints *EndpointsConfig) *DebugServer {
+	return &DebugServer{
+		services:   services,
+		endpoints:  endpoints,
+		components: make(map[string]DebugStateProvider),
+	}
+}
+
+// Register adds the state of provider to the dump under name.
+func (h *DebugServer) Register(name string, provider DebugStateProvider) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.components[name] = provider
+}
+
+func (h *DebugServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	state := debugState{
+		Services:   make(map[string]debugServices),
+		Endpoints:  make(map[string]debugEndpoints),
+		Components: make(map[string]interface{}),
+	}
+	h.lock.RLock()
+	for name, provider := range h.components {
+		state.Components[name] = provider.DebugState()
+	}
+	h.lock.RUnlock()
+	if h.services != nil {
+		lastUpdate := h.services.store.lastUpdated()
+		for source, services := range h.services.store.sourceState() {
+			items := make([]api.Service, 0, len(services))
+			for _, service := range services {
+				items = append(items, service)
+			}
+			sort.Sort(servicesByID(items))
+			state.Services[source] = debugServices{LastUpdate: lastUpdate[source], Services: items}
+		}
+	}
+	if h.endpoints != nil {
+		lastUpdate := h.endpoints.store.lastUpdated()
+		for source, endpoints := range h.endpoints.store.sourceState() {
+			items := make([]api.Endpoints, 0, len(endpoints))
+			for _, value := range endpoints {
+				items = append(items, value)
+			}
+			sort.Sort(endpointsByID(items))
+			state.Endpoints[source] = debugEndpoints{LastUpdate: lastUpdate[source], Endpoints: items}
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		glog.Errorf("Failed to encode debug state: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+type servicesByID []api.Service
+
+func (s servicesByID) Len() int           { return len(s) }
+func (s servicesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s servicesByID) Less(i, j int) bool { return ServiceKey(s[i]) < ServiceKey(s[j]) }
+
+type endpointsByID []api.Endpoints
+
+func (s endpointsByID) Len() int           { return len(s) }
+func (s endpointsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s endpointsByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
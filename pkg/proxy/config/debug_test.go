@@ -0,0 +1,87 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+type updateSignal chan struct{}
+
+func (s updateSignal) OnUpdate(services []api.Service) {
+	s <- struct{}{}
+}
+
+func TestDebugHandler(t *testing.T) {
+	config := NewServiceConfig()
+	updated := make(updateSignal)
+	config.RegisterHandler(updated)
+	channel := config.Channel("api")
+
+	server := httptest.NewServer(DebugHandler(config, NewEndpointsConfig()))
+	defer server.Close()
+
+	// serve requests while updates are being merged, run with -race to catch unguarded access
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}
+	}()
+	service := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 7}, Port: 10}
+	channel <- ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	<-updated
+	<-done
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	var state debugState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source, found := state.Services["api"]
+	if !found {
+		t.Fatalf("expected source api, got %#v", state)
+	}
+	if !reflect.DeepEqual(source.Services, []api.Service{service}) {
+		t.Errorf("expected %#v, got %#v", []api.Service{service}, source.Services)
+	}
+	if source.LastUpdate.IsZero() {
+		t.Errorf("expected last update time to be set")
+	}
+	if len(state.Endpoints) != 0 {
+		t.Errorf("expected no endpoints, got %#v", state.Endpoints)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	watchjson "github.com/GoogleCloudPlatform/kubernetes/pkg/watch/json"
+	"gopkg.in/v1/yaml"
+)
+
+// WatchDecoder reads watch events off of a watch response stream.
+type WatchDecoder interface {
+	// Decode returns the next event in the stream, or an error once the stream is exhausted.
+	Decode() (action watch.EventType, object runtime.Object, err error)
+	// Close closes the underlying stream.
+	Close()
+}
+
+// newWatchDecoder returns the WatchDecoder appropriate for a response with the given Content-Type.
+// Anything that is not YAML is assumed to be a stream of JSON events.
+func newWatchDecoder(contentType string, body io.ReadCloser, codec runtime.Codec) WatchDecoder {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == "application/yaml" {
+		return NewYAMLWatchDecoder(body, codec)
+	}
+	return watchjson.NewDecoder(body, codec)
+}
+
+// yamlWatchEvent is the YAML form of a single watch event.
+type yamlWatchEvent struct {
+	Type   watch.EventType `yaml:"type"`
+	Object interface{}     `yaml:"object"`
+}
+
+// YAMLWatchDecoder decodes a stream of "---" separated YAML documents, each holding one watch event.
+type YAMLWatchDecoder struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	codec  runtime.Codec
+}
+
+// NewYAMLWatchDecoder creates a YAMLWatchDecoder reading from body. The object of each event is decoded with codec.
+func NewYAMLWatchDecoder(body io.ReadCloser, codec runtime.Codec) *YAMLWatchDecoder {
+	return &YAMLWatchDecoder{
+		body:   body,
+		reader: bufio.NewReader(body),
+		codec:  codec,
+	}
+}
+
+// Decode blocks until the next event in the stream can be decoded.
+func (d *YAMLWatchDecoder) Decode() (watch.EventType, runtime.Object, error) {
+	for {
+		doc, err := d.nextDocument()
+		if len(bytes.TrimSpace(doc)) == 0 {
+			if err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+		var event yamlWatchEvent
+		if err := yaml.Unmarshal(doc, &event); err != nil {
+			return "", nil, err
+		}
+		// The codec only understands JSON, so the object is converted before decoding.
+		data, err := json.Marshal(yamlToJSON(event.Object))
+		if err != nil {
+			return "", nil, err
+		}
+		obj, err := d.codec.Decode(data)
+		if err != nil {
+			return "", nil, err
+		}
+		return event.Type, obj, nil
+	}
+}
+
+// Close closes the underlying stream.
+func (d *YAMLWatchDecoder) Close() {
+	d.body.Close()
+}
+
+// nextDocument reads up to the next document separator or the end of the stream.
+func (d *YAMLWatchDecoder) nextDocument() ([]byte, error) {
+	var doc bytes.Buffer
+	for {
+		line, err := d.reader.ReadString('\n')
+		if strings.TrimRight(line, " \t\r\n") == "---" {
+			return doc.Bytes(), nil
+		}
+		doc.WriteString(line)
+		if err != nil {
+			return doc.Bytes(), err
+		}
+	}
+}
+
+// yamlToJSON converts the generic maps produced by the YAML parser into maps that can be marshalled as JSON.
+func yamlToJSON(in interface{}) interface{} {
+	switch value := in.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			out[fmt.Sprintf("%v", k)] = yamlToJSON(v)
+		}
+		return out
+	case []interface{}:
+		for i := range value {
+			value[i] = yamlToJSON(value[i])
+		}
+		return value
+	}
+	return in
+}
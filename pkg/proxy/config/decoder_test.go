@@ -0,0 +1,131 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+const yamlEvents = `---
+type: ADDED
+object:
+  kind: Service
+  id: foo
+  resourceVersion: 2
+  port: 8080
+---
+type: DELETED
+object:
+  kind: Endpoints
+  id: bar
+  resourceVersion: 3
+  endpoints:
+  - 127.0.0.1:9000
+`
+
+func TestYAMLWatchDecoder(t *testing.T) {
+	decoder := NewYAMLWatchDecoder(ioutil.NopCloser(strings.NewReader(yamlEvents)), runtime.DefaultCodec)
+
+	action, obj, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedService := &api.Service{JSONBase: api.JSONBase{Kind: "Service", ID: "foo", ResourceVersion: 2}, Port: 8080}
+	if action != watch.Added || !reflect.DeepEqual(obj, expectedService) {
+		t.Errorf("expected %v %#v, got %v %#v", watch.Added, expectedService, action, obj)
+	}
+
+	action, obj, err = decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedEndpoints := &api.Endpoints{JSONBase: api.JSONBase{Kind: "Endpoints", ID: "bar", ResourceVersion: 3}, Endpoints: []string{"127.0.0.1:9000"}}
+	if action != watch.Deleted || !reflect.DeepEqual(obj, expectedEndpoints) {
+		t.Errorf("expected %v %#v, got %v %#v", watch.Deleted, expectedEndpoints, action, obj)
+	}
+
+	if _, _, err := decoder.Decode(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestNewWatchDecoder(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(""))
+	for contentType, isYAML := range map[string]bool{
+		"application/yaml":               true,
+		"application/yaml; charset=utf8": true,
+		"application/json":               false,
+		"":                               false,
+	} {
+		_, ok := newWatchDecoder(contentType, body, runtime.DefaultCodec).(*YAMLWatchDecoder)
+		if ok != isYAML {
+			t.Errorf("%q: expected YAML decoder %v, got %v", contentType, isYAML, ok)
+		}
+	}
+}
+
+func TestServicesYAMLStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != apiPrefix+"/watch/services" || req.URL.Query().Get("resourceVersion") != "1" {
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		fmt.Fprint(w, "---\ntype: ADDED\nobject:\n  kind: Service\n  id: foo\n  resourceVersion: 2\n")
+		fmt.Fprint(w, "---\ntype: DELETED\nobject:\n  kind: Service\n  id: foo\n  resourceVersion: 3\n")
+	}))
+	defer server.Close()
+
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil), services: services}
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	service := api.Service{JSONBase: api.JSONBase{Kind: "Service", ID: "foo", ResourceVersion: 2}}
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	service.ResourceVersion = 3
+	actual = <-services
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{service}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// the watch ends with the response body
+	<-ch
+	if source.serviceVersion.Get() != 4 {
+		t.Errorf("unexpected resource version, got %#v", source.serviceVersion.Get())
+	}
+}
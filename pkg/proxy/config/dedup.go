@@ -0,0 +1,111 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+)
+
+// DeduplicatingSource passes on the ServiceUpdates of another source, leaving out the services
+// of ADDs that are identical to the ones last passed on, and the services of REMOVEs that are
+// not known. The apiserver can send such updates again, e.g. after an etcd compaction or a
+// leader election, and consumers would otherwise rebuild their state for nothing. Services
+// that differ only in ResourceVersion are identical. A SET is always passed on.
+type DeduplicatingSource struct {
+	updates  <-chan ServiceUpdate
+	services chan<- ServiceUpdate
+	// last is the last service passed on, by ServiceKey.
+	last map[string]api.Service
+
+	lock       sync.Mutex
+	suppressed uint64
+}
+
+// NewDeduplicatingSource creates a DeduplicatingSource and starts passing on the updates read
+// from updates to services, until updates is closed, when it closes services.
+func NewDeduplicatingSource(updates <-chan ServiceUpdate, services chan<- ServiceUpdate) *DeduplicatingSource {
+	source := &DeduplicatingSource{
+		updates:  updates,
+		services: services,
+		last:     make(map[string]api.Service),
+	}
+	go func() {
+		defer util.HandleCrash()
+		source.run()
+	}()
+	return source
+}
+
+// Suppressed returns the number of services left out of the updates so far.
+func (s *DeduplicatingSource) Suppressed() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.suppressed
+}
+
+func (s *DeduplicatingSource) run() {
+	defer close(s.services)
+	for update := range s.updates {
+		if update, ok := s.filter(update); ok {
+			s.services <- update
+		}
+	}
+}
+
+// filter returns update without the services that would not change the state, and whether
+// anything is left to pass on.
+func (s *DeduplicatingSource) filter(update ServiceUpdate) (ServiceUpdate, bool) {
+	switch update.Op {
+	case SET:
+		s.last = make(map[string]api.Service, len(update.Services))
+		for _, service := range update.Services {
+			s.last[ServiceKey(service)] = service
+		}
+		return update, true
+	case ADD, REMOVE:
+		kept := make([]api.Service, 0, len(update.Services))
+		for _, service := range update.Services {
+			if _, _, changed := applyService(s.last, update.Op, service, sameService); changed {
+				kept = append(kept, service)
+			}
+		}
+		if suppressed := len(update.Services) - len(kept); suppressed > 0 {
+			glog.V(4).Infof("Suppressing %s of %d unchanged services", update.Op, suppressed)
+			s.lock.Lock()
+			s.suppressed += uint64(suppressed)
+			s.lock.Unlock()
+		}
+		if len(kept) == 0 {
+			return update, false
+		}
+		update.Services = kept
+		return update, true
+	}
+	return update, true
+}
+
+// sameService returns whether a and b are equal but for their ResourceVersion.
+func sameService(a, b api.Service) bool {
+	a.ResourceVersion = 0
+	b.ResourceVersion = 0
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestDeduplicatingSource(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 1}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}, Port: 81}
+	// a MODIFIED without changes still has a new version
+	fooAgain := foo
+	fooAgain.ResourceVersion = 3
+	fooChanged := foo
+	fooChanged.ResourceVersion = 4
+	fooChanged.Port = 8080
+
+	updates := make(chan ServiceUpdate)
+	services := make(chan ServiceUpdate, 10)
+	source := NewDeduplicatingSource(updates, services)
+	for _, update := range []ServiceUpdate{
+		{Op: SET, Services: []api.Service{foo}},
+		{Op: ADD, Services: []api.Service{fooAgain}},
+		{Op: ADD, Services: []api.Service{foo, bar}},
+		{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "baz"}}}},
+		{Op: ADD, Services: []api.Service{fooChanged}},
+		{Op: REMOVE, Services: []api.Service{bar}},
+		{Op: REMOVE, Services: []api.Service{bar}},
+		{Op: SET, Services: []api.Service{foo}},
+	} {
+		updates <- update
+	}
+	close(updates)
+
+	expected := []ServiceUpdate{
+		{Op: SET, Services: []api.Service{foo}},
+		{Op: ADD, Services: []api.Service{bar}},
+		{Op: ADD, Services: []api.Service{fooChanged}},
+		{Op: REMOVE, Services: []api.Service{bar}},
+		{Op: SET, Services: []api.Service{foo}},
+	}
+	for i, e := range expected {
+		if actual := <-services; !reflect.DeepEqual(e, actual) {
+			t.Errorf("update %d: expected %#v, got %#v", i, e, actual)
+		}
+	}
+	// services is closed once updates is
+	if update, ok := <-services; ok {
+		t.Errorf("unexpected update %#v", update)
+	}
+	if suppressed := source.Suppressed(); suppressed != 4 {
+		t.Errorf("expected 4 suppressed services, got %d", suppressed)
+	}
+}
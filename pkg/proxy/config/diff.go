@@ -0,0 +1,60 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// diffServices returns the services of next that are new or changed since last, and those of
+// last that are gone from next, each sorted by ServiceKey. Both are keyed by ServiceKey.
+func diffServices(last, next map[string]api.Service) (added, removed []api.Service) {
+	for key, service := range next {
+		if previous, found := last[key]; !found || !reflect.DeepEqual(previous, service) {
+			added = append(added, service)
+		}
+	}
+	for key, service := range last {
+		if _, found := next[key]; !found {
+			removed = append(removed, service)
+		}
+	}
+	sort.Sort(servicesByID(added))
+	sort.Sort(servicesByID(removed))
+	return added, removed
+}
+
+// diffEndpoints returns the endpoints of next that are new or changed since last, and those
+// of last that are gone from next, each sorted by ID. Both are keyed by ID.
+func diffEndpoints(last, next map[string]api.Endpoints) (added, removed []api.Endpoints) {
+	for id, endpoints := range next {
+		if previous, found := last[id]; !found || !reflect.DeepEqual(previous, endpoints) {
+			added = append(added, endpoints)
+		}
+	}
+	for id, endpoints := range last {
+		if _, found := next[id]; !found {
+			removed = append(removed, endpoints)
+		}
+	}
+	sort.Sort(endpointsByID(added))
+	sort.Sort(endpointsByID(removed))
+	return added, removed
+}
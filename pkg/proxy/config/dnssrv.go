@@ -0,0 +1,157 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// SRVDomainLabel is the label holding the domain of a service made from DNS SRV records.
+const SRVDomainLabel = "wormhole.io/srv-domain"
+
+// defaultSRVPollInterval is how often SRV records are looked up if no PollInterval is given.
+const defaultSRVPollInterval = 30 * time.Second
+
+// DNSSRVSource describes the DNS SRV records a SourceAPI adds a service for, alongside those
+// of the apiserver. The records of the Domain are one service, with the Domain as its ID and
+// its SRVDomainLabel, whose endpoints are the "target:port" of each record.
+type DNSSRVSource struct {
+	// Domain is the name the records are looked up under, e.g. "_http._tcp.example.com".
+	Domain string
+	// Port is the port of the service. Defaults to the port of the first record looked up.
+	Port int
+	// PollInterval is how often the records are looked up. Defaults to 30 seconds.
+	PollInterval time.Duration
+	// LookupSRV looks up the records of a name, as net.LookupSRV does, which it defaults to.
+	LookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+// srvState holds the service and endpoints of the last SRV records looked up, if any were.
+type srvState struct {
+	lock      sync.Mutex
+	service   *api.Service
+	endpoints api.Endpoints
+}
+
+// srvService returns the service of the records of domain, on port.
+func srvService(domain string, port int) api.Service {
+	return api.Service{
+		JSONBase: api.JSONBase{ID: domain},
+		Port:     port,
+		Protocol: "TCP",
+		Labels:   map[string]string{SRVDomainLabel: domain},
+	}
+}
+
+// srvEndpoints returns the endpoints of the records of domain. The addresses are sorted, as
+// lookups shuffle records of the same priority.
+func srvEndpoints(domain string, records []*net.SRV) api.Endpoints {
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		addresses = append(addresses, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+	}
+	sort.Strings(addresses)
+	return api.Endpoints{JSONBase: api.JSONBase{ID: domain}, Endpoints: addresses}
+}
+
+// pollSRV looks up the records of DNSSRV every PollInterval, and sends an ADD of their service
+// and of its endpoints when they change. A failed lookup leaves them as they are. It returns
+// once the source is closed.
+func (s *SourceAPI) pollSRV() {
+	interval := s.DNSSRV.PollInterval
+	if interval <= 0 {
+		interval = defaultSRVPollInterval
+	}
+	lookup := s.DNSSRV.LookupSRV
+	if lookup == nil {
+		lookup = net.LookupSRV
+	}
+	for !s.isClosed() {
+		_, records, err := lookup("", "", s.DNSSRV.Domain)
+		if err != nil {
+			glog.Errorf("Unable to look up SRV records of %s: %v", s.DNSSRV.Domain, err)
+		} else {
+			s.updateSRV(records)
+		}
+		<-s.clock().After(interval)
+	}
+}
+
+// updateSRV replaces the service and endpoints of the SRV records with those of records,
+// sending them if they changed.
+func (s *SourceAPI) updateSRV(records []*net.SRV) {
+	domain := s.DNSSRV.Domain
+	endpoints := srvEndpoints(domain, records)
+	s.srv.lock.Lock()
+	port := s.DNSSRV.Port
+	if port == 0 && s.srv.service != nil {
+		port = s.srv.service.Port
+	}
+	if port == 0 && len(records) > 0 {
+		port = int(records[0].Port)
+	}
+	var service *api.Service
+	if port != 0 && s.srv.service == nil {
+		created := srvService(domain, port)
+		service = &created
+		s.srv.service = service
+	}
+	changed := s.srv.service != nil && !reflect.DeepEqual(s.srv.endpoints, endpoints)
+	if changed {
+		s.srv.endpoints = endpoints
+	}
+	s.srv.lock.Unlock()
+
+	// The service is sent first, so that its endpoints are not sent for an unknown service.
+	if service != nil {
+		s.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{*service}})
+	}
+	if changed {
+		s.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{endpoints}})
+	}
+}
+
+// withSRVServices returns services with the service of the last SRV records looked up, which
+// is part of the state of the source that a SET replaces.
+func (s *SourceAPI) withSRVServices(services []api.Service) []api.Service {
+	s.srv.lock.Lock()
+	defer s.srv.lock.Unlock()
+	if s.srv.service == nil {
+		return services
+	}
+	return append(append(make([]api.Service, 0, len(services)+1), services...), *s.srv.service)
+}
+
+// withSRVEndpoints is withSRVServices for the endpoints of the records.
+func (s *SourceAPI) withSRVEndpoints(endpoints []api.Endpoints) []api.Endpoints {
+	s.srv.lock.Lock()
+	defer s.srv.lock.Unlock()
+	if s.srv.service == nil {
+		return endpoints
+	}
+	return append(append(make([]api.Endpoints, 0, len(endpoints)+1), endpoints...), s.srv.endpoints)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// fakeSRVLookup answers the lookups of a domain with each of its results in turn.
+type fakeSRVLookup struct {
+	domain  string
+	results chan []*net.SRV
+}
+
+func (l *fakeSRVLookup) LookupSRV(service, proto, name string) (string, []*net.SRV, error) {
+	if service != "" || proto != "" || name != l.domain {
+		return "", nil, errors.New("unexpected lookup of " + name)
+	}
+	records := <-l.results
+	if records == nil {
+		return "", nil, errors.New("no such host")
+	}
+	return name, records, nil
+}
+
+func TestDNSSRV(t *testing.T) {
+	lookup := &fakeSRVLookup{domain: "_http._tcp.example.com", results: make(chan []*net.SRV)}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{services: services, endpoints: endpoints}
+	source.Clock = clock
+	source.DNSSRV = &DNSSRVSource{Domain: lookup.domain, PollInterval: time.Minute, LookupSRV: lookup.LookupSRV}
+	go source.pollSRV()
+
+	// the records are one service, on the port of the first record, with the records as its
+	// endpoints
+	lookup.results <- []*net.SRV{
+		{Target: "web-1.example.com.", Port: 8080},
+		{Target: "web-0.example.com.", Port: 8080},
+	}
+	service := api.Service{
+		JSONBase: api.JSONBase{ID: lookup.domain},
+		Port:     8080,
+		Protocol: "TCP",
+		Labels:   map[string]string{SRVDomainLabel: lookup.domain},
+	}
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{service}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	web := api.Endpoints{JSONBase: api.JSONBase{ID: lookup.domain}, Endpoints: []string{"web-0.example.com:8080", "web-1.example.com:8080"}}
+	protocols := map[string]string{lookup.domain: "TCP"}
+	expectedEndpoints := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{web}, Protocols: protocols}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	// a failed lookup changes nothing
+	clock.BlockUntil(t, 1)
+	clock.Step(time.Minute)
+	lookup.results <- nil
+
+	// the same records in another order change nothing either
+	clock.BlockUntil(t, 1)
+	clock.Step(time.Minute)
+	lookup.results <- []*net.SRV{
+		{Target: "web-0.example.com.", Port: 8080},
+		{Target: "web-1.example.com.", Port: 8080},
+	}
+
+	// only the endpoints of the service change with its records
+	clock.BlockUntil(t, 1)
+	clock.Step(time.Minute)
+	lookup.results <- []*net.SRV{
+		{Target: "web-0.example.com.", Port: 8080},
+		{Target: "web-2.example.com.", Port: 8081},
+	}
+	web.Endpoints = []string{"web-0.example.com:8080", "web-2.example.com:8081"}
+	expectedEndpoints = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{web}, Protocols: protocols}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	// the service and endpoints of the records are part of each SET of the source
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	go source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo}})
+	expected = ServiceUpdate{Op: SET, Services: []api.Service{foo, service}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fooEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	go source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{fooEndpoints}})
+	expectedEndpoints = EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{fooEndpoints, web}, Protocols: protocols}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	"github.com/golang/glog"
+)
+
+// Close shuts the source down gracefully. It sends a DRAIN on the services and endpoints
+// channels, so that consumers can finish the requests in flight, waits DrainTimeout for them
+// to do so, then closes the channels and cancels the subscriptions. The watches are stopped
+// and no update is sent after the DRAIN. Calling Close again has no effect.
+func (s *SourceAPI) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func (s *SourceAPI) close() {
+	// Marking first unblocks the updates waiting for room in the buffer, so that they give up
+	// the lock.
+	s.closing.mark()
+	s.closeLock.Lock()
+	s.buffer.close()
+	if s.services != nil {
+		s.services <- ServiceUpdate{Op: DRAIN}
+	}
+	if s.endpoints != nil {
+		s.endpoints <- EndpointsUpdate{Op: DRAIN}
+	}
+	s.closeLock.Unlock()
+
+	if s.DrainTimeout > 0 {
+		glog.Infof("Waiting %v for consumers to drain", s.DrainTimeout)
+		s.sleep(s.DrainTimeout)
+	}
+	if s.services != nil {
+		close(s.services)
+	}
+	if s.endpoints != nil {
+		close(s.endpoints)
+	}
+	s.cancelSubscriptions()
+}
+
+// isClosed returns whether Close has been called.
+func (s *SourceAPI) isClosed() bool {
+	select {
+	case <-s.closing.done():
+		return true
+	default:
+		return false
+	}
+}
+
+// sendOnServices sends update on the services channel, unless the source is closed.
+func (s *SourceAPI) sendOnServices(update ServiceUpdate) {
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+	if s.isClosed() {
+		glog.V(4).Infof("Source is closed, dropping %s of services", update.Op)
+		return
+	}
+	s.services <- update
+}
+
+// sendOnEndpoints is sendOnServices for endpoints.
+func (s *SourceAPI) sendOnEndpoints(update EndpointsUpdate) {
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+	if s.isClosed() {
+		glog.V(4).Infof("Source is closed, dropping %s of endpoints", update.Op)
+		return
+	}
+	s.endpoints <- update
+}
+
+// untilClosed passes on the events of a watch until the source is closed, or relist is,
+// then closes the channel it returns, which ends the watch. It stops when in is closed or
+// done is.
+func (s *SourceAPI) untilClosed(in <-chan watch.Event, done, relist <-chan struct{}) <-chan watch.Event {
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-s.closing.done():
+		case <-relist:
+		case <-done:
+		}
+	}()
+	return forwardEvents(in, stop, nil)
+}
+
+// forwardEvents passes on the events of a watch, calling each, if set, on every event before
+// it is passed on. It stops when in is closed, done is, or each returns false.
+func forwardEvents(in <-chan watch.Event, done <-chan struct{}, each func(event watch.Event) bool) <-chan watch.Event {
+	out := make(chan watch.Event)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					return
+				}
+				if each != nil && !each(event) {
+					return
+				}
+				select {
+				case out <- event:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+func TestClose(t *testing.T) {
+	fakeClient := &client.Fake{Watch: watch.NewFake()}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{client: fakeClient, services: services, endpoints: endpoints}
+	source.DrainTimeout = time.Minute
+	source.Clock = clock
+	watching := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(watching)
+	}()
+	<-services
+	sub, err := source.Subscribe(ServicesResource, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		source.Close()
+		close(closed)
+	}()
+	if actual := <-services; !reflect.DeepEqual(ServiceUpdate{Op: DRAIN}, actual) {
+		t.Errorf("expected a DRAIN, got %#v", actual)
+	}
+	if actual := <-endpoints; !reflect.DeepEqual(EndpointsUpdate{Op: DRAIN}, actual) {
+		t.Errorf("expected a DRAIN, got %#v", actual)
+	}
+	// the watch stops, and later updates are dropped
+	<-watching
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}}})
+
+	// the channels stay open for the drain
+	clock.BlockUntil(t, 1)
+	select {
+	case update, ok := <-services:
+		t.Fatalf("expected the channel to stay open, got %#v, %v", update, ok)
+	case <-closed:
+		t.Fatalf("expected Close to wait for the drain")
+	default:
+	}
+	clock.Step(time.Minute)
+	<-closed
+	if update, ok := <-services; ok {
+		t.Errorf("expected the services channel to be closed, got %#v", update)
+	}
+	if update, ok := <-endpoints; ok {
+		t.Errorf("expected the endpoints channel to be closed, got %#v", update)
+	}
+	if event, ok := <-sub.Events(); ok {
+		t.Errorf("expected the subscription to be cancelled, got %#v", event)
+	}
+	// a second Close does nothing
+	source.Close()
+}
+
+func TestCloseBuffered(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{services: services, endpoints: endpoints, UpdateBufferSize: 1}
+	source.deliverServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}}})
+
+	go source.Close()
+	// the queued ADD is sent before the DRAIN, or dropped
+	for update := range services {
+		if update.Op == DRAIN {
+			break
+		}
+	}
+	<-endpoints
+	if update, ok := <-services; ok {
+		t.Errorf("expected the services channel to be closed, got %#v", update)
+	}
+	<-endpoints
+	// the buffer is closed, which ends this loop
+	for range source.buffer.services {
+	}
+	// updates after Close are dropped rather than sent on the closed buffer
+	source.deliverServices(ServiceUpdate{Op: SET})
+}
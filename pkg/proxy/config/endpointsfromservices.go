@@ -0,0 +1,46 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// EndpointsLabel is the service label holding the endpoints of a service embedded in it, as a
+// comma separated list of addresses, for the EndpointsFromServices option.
+const EndpointsLabel = "wormhole.io/endpoints"
+
+// servicesEndpoints returns the update of the endpoints embedded in the services of update,
+// with the same operation. A service without an EndpointsLabel has no endpoints.
+func servicesEndpoints(update ServiceUpdate) EndpointsUpdate {
+	endpoints := make([]api.Endpoints, 0, len(update.Services))
+	for _, service := range update.Services {
+		addresses := []string{}
+		for _, address := range strings.Split(service.Labels[EndpointsLabel], ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+		endpoints = append(endpoints, api.Endpoints{
+			JSONBase:  api.JSONBase{ID: ServiceKey(service)},
+			Endpoints: addresses,
+		})
+	}
+	return EndpointsUpdate{Op: update.Op, Endpoints: endpoints}
+}
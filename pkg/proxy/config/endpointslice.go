@@ -0,0 +1,427 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util/wait"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	watchjson "github.com/GoogleCloudPlatform/kubernetes/pkg/watch/json"
+	"github.com/golang/glog"
+)
+
+// endpointSlicePath is the path under which the apiserver exposes endpoint slices.
+const endpointSlicePath = "/apis/discovery.k8s.io/v1beta1/endpointslices"
+
+// ServiceNameLabel is the label of an EndpointSlice naming the service it belongs to.
+const ServiceNameLabel = "kubernetes.io/service-name"
+
+// EndpointSliceMeta is the object metadata of an EndpointSlice or an EndpointSliceList.
+type EndpointSliceMeta struct {
+	Name            string            `json:"name,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+}
+
+// EndpointSlice is the part of a discovery.k8s.io/v1beta1 EndpointSlice used by the proxy.
+// A service's endpoints may be split over several slices.
+type EndpointSlice struct {
+	Metadata  EndpointSliceMeta       `json:"metadata"`
+	Endpoints []EndpointSliceEndpoint `json:"endpoints"`
+	Ports     []EndpointSlicePort     `json:"ports"`
+}
+
+// EndpointSliceEndpoint is a single backend of an EndpointSlice.
+type EndpointSliceEndpoint struct {
+	Addresses  []string `json:"addresses"`
+	Conditions struct {
+		// Ready is nil if the readiness is unknown, which is treated as ready.
+		Ready *bool `json:"ready,omitempty"`
+	} `json:"conditions"`
+}
+
+// EndpointSlicePort is a port that every endpoint of an EndpointSlice listens on.
+type EndpointSlicePort struct {
+	Name     string `json:"name,omitempty"`
+	Port     *int   `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// EndpointSliceList is a list of EndpointSlices.
+type EndpointSliceList struct {
+	Metadata EndpointSliceMeta `json:"metadata"`
+	Items    []EndpointSlice   `json:"items"`
+}
+
+func (*EndpointSlice) IsAnAPIObject()     {}
+func (*EndpointSliceList) IsAnAPIObject() {}
+
+// EndpointSliceWatcher is implemented by Watchers that can list and watch endpoint slices.
+type EndpointSliceWatcher interface {
+	ListEndpointSlices() (*EndpointSliceList, error)
+	WatchEndpointSlices(resourceVersion uint64) (watch.Interface, error)
+}
+
+// endpointSliceCodec decodes EndpointSlices and EndpointSliceLists, which are not known to
+// runtime.DefaultCodec.
+type endpointSliceCodec struct{}
+
+func (endpointSliceCodec) Encode(obj runtime.Object) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func (endpointSliceCodec) Decode(data []byte) (runtime.Object, error) {
+	var kind struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return nil, err
+	}
+	// ERROR events carry a Status.
+	if kind.Kind == "Status" {
+		status := &api.Status{}
+		if err := json.Unmarshal(data, status); err != nil {
+			return nil, err
+		}
+		return status, nil
+	}
+	slice := &EndpointSlice{}
+	if err := json.Unmarshal(data, slice); err != nil {
+		return nil, err
+	}
+	return slice, nil
+}
+
+func (endpointSliceCodec) DecodeInto(data []byte, obj runtime.Object) error {
+	return json.Unmarshal(data, obj)
+}
+
+// ListEndpointSlices lists all endpoint slices.
+func (w *HTTPWatcher) ListEndpointSlices() (*EndpointSliceList, error) {
+	resp, err := w.get(endpointSlicePath, url.Values{}, scopeRetryCluster)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	slices := &EndpointSliceList{}
+	if err := json.NewDecoder(resp.Body).Decode(slices); err != nil {
+		return nil, err
+	}
+	return slices, nil
+}
+
+// WatchEndpointSlices watches endpoint slices starting at resourceVersion.
+func (w *HTTPWatcher) WatchEndpointSlices(resourceVersion uint64) (watch.Interface, error) {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("resourceVersion", strconv.FormatUint(resourceVersion, 10))
+	resp, err := w.get(endpointSlicePath, query, scopeCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return watch.NewStreamWatcher(&decodeErrors{decoder: watchjson.NewDecoder(resp.Body, endpointSliceCodec{})}), nil
+}
+
+// sliceWatcher returns client, as passed to a request by withTimeout, as an
+// EndpointSliceWatcher, or fallback if it is not one.
+func sliceWatcher(client Watcher, fallback EndpointSliceWatcher) EndpointSliceWatcher {
+	if slices, ok := client.(EndpointSliceWatcher); ok {
+		return slices
+	}
+	return fallback
+}
+
+// runEndpointSlices is runEndpoints for endpoint slices. It lists the slices, unless it is
+// resuming a watch, and sends the endpoints of each service as merged from its slices.
+func (s *SourceAPI) runEndpointSlices(client EndpointSliceWatcher) {
+	resourceVersion := &s.endpointsVersion
+	if resourceVersion.Get() == 0 || s.slices == nil {
+		log := s.newConnLog()
+		var slices *EndpointSliceList
+		err := s.retryList(func(c Watcher) (err error) {
+			slices, err = sliceWatcher(c, client).ListEndpointSlices()
+			return err
+		})
+		if err != nil {
+			log.Errorf("Unable to load endpoint slices: %v", err)
+			s.reportFailure(&ListError{Resource: EndpointsResource, ResourceVersion: resourceVersion.Get(), Err: err})
+			s.sleep(wait.Jitter(s.waitDuration, 0.0))
+			return
+		}
+		resourceVersion.Set(parseResourceVersion(slices.Metadata.ResourceVersion))
+		s.sendRawRelist(slices)
+		s.slices = newEndpointSliceState(slices.Items)
+		if s.SnapshotFencing {
+			s.sendEndpoints(EndpointsUpdate{Op: SNAPSHOT_START})
+		}
+		s.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: s.slices.List(), Ports: s.slices.Ports()})
+		if s.SnapshotFencing {
+			s.sendEndpoints(EndpointsUpdate{Op: SNAPSHOT_END})
+		}
+	}
+
+	log := s.newConnLog()
+	log.V(2).Infof("Watching endpoint slices from resource version %d", resourceVersion.Get())
+	watcher, err := s.watchWithTimeout(func(c Watcher) (watch.Interface, error) {
+		return checkWatch(sliceWatcher(c, client).WatchEndpointSlices(resourceVersion.Get()))
+	})
+	if err != nil {
+		log.Errorf("Unable to watch for endpoint slices changes: %v", err)
+		s.reportFailure(&WatchError{Resource: EndpointsResource, ResourceVersion: resourceVersion.Get(), Err: err})
+		s.sleep(wait.Jitter(s.waitDuration, 0.0))
+		return
+	}
+	defer watcher.Stop()
+	s.reportSuccess()
+
+	ch := watcher.ResultChan()
+	if s.EnableWatchLatencyLog {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.timeEvents("endpoint slices", ch, done)
+	}
+	if s.RawEvents != nil {
+		done := make(chan struct{})
+		defer close(done)
+		ch = s.forwardRawEvents(ch, done)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ch = s.untilClosed(ch, done, s.buffer.relisting(EndpointsResource))
+	timeout, stop := s.watchTimeout()
+	defer stop()
+	stopHeartbeats := s.startHeartbeats(EndpointsResource, resourceVersion)
+	defer stopHeartbeats()
+	err = handleEndpointSlicesWatch(log, resourceVersion, s.slices, ch, s.sendEndpoints, timeout)
+	stopHeartbeats()
+	if err != nil {
+		log.Errorf("Watch for endpoint slices changes failed: %v", err)
+		s.reportFailure(err)
+		s.deadLetterWatch(EndpointsResource, err)
+		s.sleep(wait.Jitter(s.waitDuration, 0.0))
+	}
+}
+
+// handleEndpointSlicesWatch loops over an event channel of endpoint slices and delivers the
+// changed endpoints of their services with send.
+// It returns nil when the event channel is closed or timeout fires, a WatchError if the watch
+// sends an error, and a DecodeError if it sends something other than an endpoint slice.
+func handleEndpointSlicesWatch(log *connLog, resourceVersion *versionTracker, state *endpointSliceState, ch <-chan watch.Event, send func(EndpointsUpdate), timeout <-chan time.Time) error {
+	for {
+		select {
+		case <-timeout:
+			log.V(2).Infof("WatchEndpointSlices timed out, reconnecting")
+			return nil
+
+		case event, ok := <-ch:
+			if !ok {
+				log.V(2).Infof("WatchEndpointSlices channel closed")
+				return nil
+			}
+			if event.Type == watch.Error {
+				return watchFailed(EndpointsResource, resourceVersion, event.Object)
+			}
+
+			slice, ok := event.Object.(*EndpointSlice)
+			if !ok {
+				return unexpectedObject(EndpointsResource, resourceVersion.Get(), event.Object)
+			}
+			version := parseResourceVersion(slice.Metadata.ResourceVersion)
+			if event.Type == watchBookmark {
+				resourceVersion.Advance(version + 1)
+				continue
+			}
+			// Drop stale events, as in handleServicesWatch.
+			if !resourceVersion.Advance(version + 1) {
+				log.V(2).Infof("Ignoring stale endpoint slice event for %s at resource version %d", slice.Metadata.Name, version)
+				continue
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				if endpoints, exists := state.Update(event.Type, *slice); exists {
+					ports := state.servicePorts(endpoints.ID)
+					update := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{endpoints}}
+					if ports != nil {
+						update.Ports = map[string]map[string][]string{endpoints.ID: ports}
+					}
+					send(update)
+				} else {
+					send(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{endpoints}})
+				}
+			}
+		}
+	}
+}
+
+// parseResourceVersion parses the string resource version of an endpoint slice object.
+// Versions that are not numbers are treated as 0.
+func parseResourceVersion(resourceVersion string) uint64 {
+	version, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil && resourceVersion != "" {
+		glog.Warningf("Ignoring resource version %q: %v", resourceVersion, err)
+	}
+	return version
+}
+
+// endpointSliceState holds the current endpoint slices, to merge the slices of each service
+// into a single api.Endpoints.
+type endpointSliceState struct {
+	slices map[string]EndpointSlice // namespaced slice name -> slice
+}
+
+func newEndpointSliceState(slices []EndpointSlice) *endpointSliceState {
+	state := &endpointSliceState{slices: make(map[string]EndpointSlice)}
+	for _, slice := range slices {
+		state.slices[sliceKey(slice)] = slice
+	}
+	return state
+}
+
+// Update applies an event for slice and returns the endpoints of its service, and false if the
+// service has no slices left.
+func (s *endpointSliceState) Update(eventType watch.EventType, slice EndpointSlice) (api.Endpoints, bool) {
+	if eventType == watch.Deleted {
+		delete(s.slices, sliceKey(slice))
+	} else {
+		s.slices[sliceKey(slice)] = slice
+	}
+	service := sliceService(slice)
+	endpoints := api.Endpoints{JSONBase: api.JSONBase{ID: service}}
+	exists := false
+	for _, other := range s.slices {
+		if sliceService(other) == service {
+			exists = true
+			endpoints.Endpoints = append(endpoints.Endpoints, sliceEndpoints(other)...)
+		}
+	}
+	sort.Strings(endpoints.Endpoints)
+	return endpoints, exists
+}
+
+// List returns the endpoints of every service with slices, ordered by service.
+func (s *endpointSliceState) List() []api.Endpoints {
+	byService := make(map[string][]string)
+	for _, slice := range s.slices {
+		service := sliceService(slice)
+		byService[service] = append(byService[service], sliceEndpoints(slice)...)
+	}
+	endpoints := []api.Endpoints{}
+	for service, addresses := range byService {
+		sort.Strings(addresses)
+		endpoints = append(endpoints, api.Endpoints{JSONBase: api.JSONBase{ID: service}, Endpoints: addresses})
+	}
+	sort.Sort(endpointsByID(endpoints))
+	return endpoints
+}
+
+// Ports returns the addresses of the named ports of every service with slices, for
+// EndpointsUpdate.Ports.
+func (s *endpointSliceState) Ports() map[string]map[string][]string {
+	var ports map[string]map[string][]string
+	for _, slice := range s.slices {
+		service := sliceService(slice)
+		if ports[service] != nil {
+			continue
+		}
+		if servicePorts := s.servicePorts(service); servicePorts != nil {
+			if ports == nil {
+				ports = make(map[string]map[string][]string)
+			}
+			ports[service] = servicePorts
+		}
+	}
+	return ports
+}
+
+// servicePorts returns the addresses of each named port of service, or nil if it has none.
+func (s *endpointSliceState) servicePorts(service string) map[string][]string {
+	var ports map[string][]string
+	for _, slice := range s.slices {
+		if sliceService(slice) != service {
+			continue
+		}
+		for name, addresses := range slicePorts(slice) {
+			if ports == nil {
+				ports = make(map[string][]string)
+			}
+			ports[name] = append(ports[name], addresses...)
+		}
+	}
+	for _, addresses := range ports {
+		sort.Strings(addresses)
+	}
+	return ports
+}
+
+// sliceKey returns the key of slice, which is only unique within its namespace.
+func sliceKey(slice EndpointSlice) string {
+	return NamespacedName(slice.Metadata.Namespace, slice.Metadata.Name)
+}
+
+// sliceService returns the ID of the endpoints slice belongs to, the NamespacedName of its
+// service.
+func sliceService(slice EndpointSlice) string {
+	return NamespacedName(slice.Metadata.Namespace, slice.Metadata.Labels[ServiceNameLabel])
+}
+
+// slicePorts returns a "host:port" entry for each ready address of slice, for every named port.
+func slicePorts(slice EndpointSlice) map[string][]string {
+	ports := make(map[string][]string)
+	for _, port := range slice.Ports {
+		if port.Name == "" || port.Port == nil {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				ports[port.Name] = append(ports[port.Name], net.JoinHostPort(address, strconv.Itoa(*port.Port)))
+			}
+		}
+	}
+	return ports
+}
+
+// sliceEndpoints returns a "host:port" entry for every port of every ready address of slice.
+func sliceEndpoints(slice EndpointSlice) []string {
+	var endpoints []string
+	for _, endpoint := range slice.Endpoints {
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, address := range endpoint.Addresses {
+			for _, port := range slice.Ports {
+				if port.Port != nil {
+					endpoints = append(endpoints, net.JoinHostPort(address, strconv.Itoa(*port.Port)))
+				}
+			}
+		}
+	}
+	return endpoints
+}
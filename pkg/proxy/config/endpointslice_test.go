@@ -0,0 +1,213 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// fakeSliceClient is a Watcher that also serves endpoint slices.
+type fakeSliceClient struct {
+	*client.Fake
+	slices EndpointSliceList
+	watch  watch.Interface
+}
+
+func (c *fakeSliceClient) ListEndpointSlices() (*EndpointSliceList, error) {
+	c.Actions = append(c.Actions, client.FakeAction{Action: "list-endpointslices"})
+	return &c.slices, nil
+}
+
+func (c *fakeSliceClient) WatchEndpointSlices(resourceVersion uint64) (watch.Interface, error) {
+	c.Actions = append(c.Actions, client.FakeAction{Action: "watch-endpointslices", Value: resourceVersion})
+	return c.watch, nil
+}
+
+func newEndpointSlice(name, service, resourceVersion string, port int, addresses ...string) *EndpointSlice {
+	slice := &EndpointSlice{
+		Metadata: EndpointSliceMeta{
+			Name:            name,
+			Labels:          map[string]string{ServiceNameLabel: service},
+			ResourceVersion: resourceVersion,
+		},
+		Ports: []EndpointSlicePort{{Port: &port}},
+	}
+	for _, address := range addresses {
+		slice.Endpoints = append(slice.Endpoints, EndpointSliceEndpoint{Addresses: []string{address}})
+	}
+	return slice
+}
+
+func TestEndpointSlices(t *testing.T) {
+	notReady := false
+	unready := newEndpointSlice("foo-c", "foo", "", 80, "10.0.0.9")
+	unready.Endpoints[0].Conditions.Ready = &notReady
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &fakeSliceClient{
+		Fake: &client.Fake{},
+		slices: EndpointSliceList{
+			Metadata: EndpointSliceMeta{ResourceVersion: "2"},
+			Items: []EndpointSlice{
+				*newEndpointSlice("foo-a", "foo", "1", 80, "10.0.0.2"),
+				*newEndpointSlice("foo-b", "foo", "1", 80, "10.0.0.1"),
+				*unready,
+				*newEndpointSlice("bar-a", "bar", "1", 53, "10.0.1.1"),
+			},
+		},
+		watch: fakeWatch,
+	}
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: fakeClient, endpoints: endpoints}
+	source.UseEndpointSlices = true
+	go source.runEndpoints()
+
+	// the slices of each service are merged, leaving out endpoints that are not ready
+	expected := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.1.1:53"}},
+		{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+	}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	fakeWatch.Modify(newEndpointSlice("foo-a", "foo", "3", 80, "10.0.0.2", "10.0.0.3"))
+	expected = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}},
+	}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// the service is removed once its last slice is
+	fakeWatch.Delete(newEndpointSlice("bar-a", "bar", "4", 53))
+	expected = EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "bar"}}}}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	fakeWatch.Stop()
+	if !reflect.DeepEqual(fakeClient.Actions[:2], []client.FakeAction{{"list-endpointslices", nil}, {"watch-endpointslices", uint64(2)}}) {
+		t.Errorf("unexpected actions, got %#v", fakeClient.Actions)
+	}
+}
+
+func TestEndpointSlicesAcrossNamespaces(t *testing.T) {
+	sliceA := newEndpointSlice("foo-a", "foo", "1", 80, "10.0.0.1")
+	sliceA.Metadata.Namespace = "a"
+	sliceB := newEndpointSlice("foo-a", "foo", "1", 80, "10.0.1.1")
+	sliceB.Metadata.Namespace = "b"
+	state := newEndpointSliceState([]EndpointSlice{*sliceA, *sliceB})
+
+	// slices and services of the same name in different namespaces are kept apart
+	expected := []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "a/foo"}, Endpoints: []string{"10.0.0.1:80"}},
+		{JSONBase: api.JSONBase{ID: "b/foo"}, Endpoints: []string{"10.0.1.1:80"}},
+	}
+	if actual := state.List(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	endpoints, exists := state.Update(watch.Deleted, *sliceA)
+	if !reflect.DeepEqual(api.Endpoints{JSONBase: api.JSONBase{ID: "a/foo"}}, endpoints) || exists {
+		t.Errorf("expected a/foo to be removed, got %#v %v", endpoints, exists)
+	}
+	if actual := state.List(); !reflect.DeepEqual(expected[1:], actual) {
+		t.Errorf("expected %#v, got %#v", expected[1:], actual)
+	}
+}
+
+func TestEndpointSlicesNamedPorts(t *testing.T) {
+	http, metrics := 80, 9090
+	slice := newEndpointSlice("foo-a", "foo", "1", 0, "10.0.0.1")
+	slice.Ports = []EndpointSlicePort{{Name: "http", Port: &http}, {Name: "metrics", Port: &metrics}}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &fakeSliceClient{
+		Fake:   &client.Fake{},
+		slices: EndpointSliceList{Metadata: EndpointSliceMeta{ResourceVersion: "1"}, Items: []EndpointSlice{*slice}},
+		watch:  fakeWatch,
+	}
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: fakeClient, endpoints: endpoints}
+	source.UseEndpointSlices = true
+	go source.runEndpoints()
+
+	expected := EndpointsUpdate{
+		Op:        SET,
+		Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.1:9090"}}},
+		Ports:     map[string]map[string][]string{"foo": {"http": {"10.0.0.1:80"}, "metrics": {"10.0.0.1:9090"}}},
+	}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	slice = newEndpointSlice("foo-b", "foo", "2", 0, "10.0.0.2")
+	slice.Ports = []EndpointSlicePort{{Name: "http", Port: &http}}
+	fakeWatch.Add(slice)
+	expected = EndpointsUpdate{
+		Op:        ADD,
+		Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.1:9090", "10.0.0.2:80"}}},
+		Ports:     map[string]map[string][]string{"foo": {"http": {"10.0.0.1:80", "10.0.0.2:80"}, "metrics": {"10.0.0.1:9090"}}},
+	}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Stop()
+}
+
+func TestHTTPWatcherEndpointSlices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != endpointSlicePath {
+			t.Errorf("unexpected request for %s", req.URL.Path)
+		}
+		if req.URL.Query().Get("watch") == "true" {
+			fmt.Fprintln(w, `{"type":"DELETED","object":{"metadata":{"name":"foo-a","resourceVersion":"3"}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"metadata":{"resourceVersion":"2"},"items":[{"metadata":{"name":"foo-a","labels":{"kubernetes.io/service-name":"foo"}},"endpoints":[{"addresses":["10.0.0.1"],"conditions":{"ready":true}}],"ports":[{"port":80,"protocol":"TCP"}]}]}`)
+	}))
+	defer server.Close()
+	watcher := NewHTTPWatcher(server.URL, nil)
+
+	slices, err := watcher.ListEndpointSlices()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}}
+	if actual := newEndpointSliceState(slices.Items).List(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	w, err := watcher.WatchEndpointSlices(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Stop()
+	event := <-w.ResultChan()
+	if slice, ok := event.Object.(*EndpointSlice); event.Type != watch.Deleted || !ok || slice.Metadata.Name != "foo-a" {
+		t.Errorf("unexpected event %#v", event)
+	}
+}
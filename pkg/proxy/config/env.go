@@ -0,0 +1,90 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Reads a single service from the environment, for forwarding one service without a
+// cluster. Example:
+//   WORMHOLE_SERVICE_HOST=nodejs
+//   WORMHOLE_SERVICE_PORT=10000
+//   WORMHOLE_ENDPOINTS=10.240.180.168:8000,10.240.254.199:8000
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+const (
+	envServiceHost = "WORMHOLE_SERVICE_HOST"
+	envServicePort = "WORMHOLE_SERVICE_PORT"
+	envEndpoints   = "WORMHOLE_ENDPOINTS"
+)
+
+// SourceEnv sends the service and endpoints described by environment variables once. The
+// service is identified by WORMHOLE_SERVICE_HOST. The variables are not watched, the process
+// must be restarted to pick up a change.
+type SourceEnv struct {
+	service   api.Service
+	endpoints api.Endpoints
+}
+
+// NewSourceEnv reads the service from the environment and sends it to the specified channels
+// in a goroutine. It returns an error if a variable is missing or malformed.
+func NewSourceEnv(services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) (*SourceEnv, error) {
+	s, err := newSourceEnv(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	go s.Run(services, endpoints)
+	return s, nil
+}
+
+// newSourceEnv parses the service using getenv to look up the variables.
+func newSourceEnv(getenv func(string) string) (*SourceEnv, error) {
+	host := getenv(envServiceHost)
+	if host == "" {
+		return nil, fmt.Errorf("%s is not set", envServiceHost)
+	}
+	port, err := strconv.Atoi(getenv(envServicePort))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", envServicePort, err)
+	}
+	var endpoints []string
+	for _, endpoint := range strings.Split(getenv(envEndpoints), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("%s is not set", envEndpoints)
+	}
+	return &SourceEnv{
+		service:   api.Service{JSONBase: api.JSONBase{ID: host}, Port: port},
+		endpoints: api.Endpoints{JSONBase: api.JSONBase{ID: host}, Endpoints: endpoints},
+	}, nil
+}
+
+// Run sends a SET of the service and one of its endpoints.
+func (s *SourceEnv) Run(services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) {
+	glog.Infof("Forwarding service %s on port %d to %v", s.service.ID, s.service.Port, s.endpoints.Endpoints)
+	services <- ServiceUpdate{Op: SET, Services: []api.Service{s.service}}
+	endpoints <- EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{s.endpoints}}
+}
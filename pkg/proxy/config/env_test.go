@@ -0,0 +1,63 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestSourceEnv(t *testing.T) {
+	env := map[string]string{
+		"WORMHOLE_SERVICE_HOST": "nodejs",
+		"WORMHOLE_SERVICE_PORT": "10000",
+		"WORMHOLE_ENDPOINTS":    "10.0.0.1:8000, 10.0.0.2:8000",
+	}
+	source, err := newSourceEnv(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	go source.Run(services, endpoints)
+
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{{JSONBase: api.JSONBase{ID: "nodejs"}, Port: 10000}}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{{
+		JSONBase:  api.JSONBase{ID: "nodejs"},
+		Endpoints: []string{"10.0.0.1:8000", "10.0.0.2:8000"},
+	}}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+}
+
+func TestSourceEnvInvalid(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"WORMHOLE_SERVICE_PORT": "10000", "WORMHOLE_ENDPOINTS": "10.0.0.1:8000"},
+		{"WORMHOLE_SERVICE_HOST": "nodejs", "WORMHOLE_SERVICE_PORT": "http", "WORMHOLE_ENDPOINTS": "10.0.0.1:8000"},
+		{"WORMHOLE_SERVICE_HOST": "nodejs", "WORMHOLE_SERVICE_PORT": "10000"},
+	} {
+		if _, err := newSourceEnv(func(key string) string { return env[key] }); err == nil {
+			t.Errorf("expected an error for %v", env)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// ListError is reported to the HealthReporter when the services or endpoints of the apiserver
+// cannot be listed. ResourceVersion is the version the list was asked at, 0 for the latest.
+type ListError struct {
+	Resource        ResourceType
+	ResourceVersion uint64
+	Err             error
+}
+
+func (e *ListError) Error() string {
+	return fmt.Sprintf("list of %s at resource version %d: %v", e.Resource, e.ResourceVersion, e.Err)
+}
+
+// Unwrap returns the error of the list.
+func (e *ListError) Unwrap() error {
+	return e.Err
+}
+
+// WatchError is returned by a watch of services or endpoints that cannot be opened or that
+// sends an error. ResourceVersion is the version the watch was opened, or would resume, from.
+// The watch is opened again after a wait, so a WatchError is usually worth retrying.
+type WatchError struct {
+	Resource        ResourceType
+	ResourceVersion uint64
+	Err             error
+}
+
+func (e *WatchError) Error() string {
+	return fmt.Sprintf("watch of %s from resource version %d: %v", e.Resource, e.ResourceVersion, e.Err)
+}
+
+// Unwrap returns the error of the watch.
+func (e *WatchError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned by a watch of services or endpoints whose stream cannot be decoded,
+// or that sends an object of the wrong type, which a new watch of the same apiserver is likely
+// to send again. ResourceVersion is the version the watch had reached.
+type DecodeError struct {
+	Resource        ResourceType
+	ResourceVersion uint64
+	Err             error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s at resource version %d: %v", e.Resource, e.ResourceVersion, e.Err)
+}
+
+// Unwrap returns the error of the decoding.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// unexpectedObject returns the DecodeError of a watch of resource sending obj.
+func unexpectedObject(resource ResourceType, resourceVersion uint64, obj interface{}) error {
+	return &DecodeError{Resource: resource, ResourceVersion: resourceVersion, Err: fmt.Errorf("unexpected object %T", obj)}
+}
+
+// decodeFailure is sent by a decodeErrors decoder as the object of an ERROR event in place of
+// the event it could not decode.
+type decodeFailure struct {
+	err error
+}
+
+func (*decodeFailure) IsAnAPIObject() {}
+
+// decodeErrors wraps a WatchDecoder so that an error decoding the stream ends the watch with an
+// ERROR event, which a watch.StreamWatcher would otherwise end it on without one. The end of
+// the stream, and errors after the decoder is closed, end the watch as before.
+type decodeErrors struct {
+	decoder WatchDecoder
+	lock    sync.Mutex
+	closed  bool
+	failed  bool
+}
+
+func (d *decodeErrors) Decode() (watch.EventType, runtime.Object, error) {
+	action, obj, err := d.decoder.Decode()
+	if err == nil || err == io.EOF {
+		return action, obj, err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed || d.failed {
+		return "", nil, err
+	}
+	d.failed = true
+	return watch.Error, &decodeFailure{err}, nil
+}
+
+func (d *decodeErrors) Close() {
+	d.lock.Lock()
+	d.closed = true
+	d.lock.Unlock()
+	d.decoder.Close()
+}
+
+// streamError returns the error of a watch of resource whose stream failed with err: a
+// WatchError if the stream was cut off, and a DecodeError otherwise.
+func streamError(resource ResourceType, resourceVersion uint64, err error) error {
+	if _, ok := err.(net.Error); ok || err == io.ErrUnexpectedEOF {
+		return &WatchError{Resource: resource, ResourceVersion: resourceVersion, Err: err}
+	}
+	return &DecodeError{Resource: resource, ResourceVersion: resourceVersion, Err: err}
+}
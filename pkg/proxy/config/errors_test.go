@@ -0,0 +1,125 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+func TestServicesErrorTypes(t *testing.T) {
+	refused := errors.New("connection refused")
+	fakeClient := &client.Fake{Err: refused}
+	clock := newFakeClock()
+	failures := make(failureReporter, 1)
+	source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+	source.Clock = clock
+	source.Health = failures
+	run := func() chan struct{} {
+		ch := make(chan struct{})
+		go func() {
+			source.runServices()
+			close(ch)
+		}()
+		return ch
+	}
+
+	// a failed list
+	ch := run()
+	var listErr *ListError
+	if err := <-failures; !errors.As(err, &listErr) || listErr.Resource != ServicesResource || !errors.Is(err, refused) {
+		t.Errorf("expected a ListError of services, got %#v", err)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+
+	// an error sent by the watch
+	fakeWatch := watch.NewFake()
+	fakeClient.Err = nil
+	fakeClient.Watch = fakeWatch
+	source.serviceVersion.Set(3)
+	ch = run()
+	fakeWatch.Error(&api.Status{Message: "internal error", Code: 500})
+	var watchErr *WatchError
+	if err := <-failures; !errors.As(err, &watchErr) || watchErr.Resource != ServicesResource || watchErr.ResourceVersion != 3 {
+		t.Errorf("expected a WatchError of services from resource version 3, got %#v", err)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+
+	// an object that is not a service
+	fakeWatch = watch.NewFake()
+	fakeClient.Watch = fakeWatch
+	ch = run()
+	fakeWatch.Add(&api.Endpoints{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 4}})
+	var decodeErr *DecodeError
+	if err := <-failures; !errors.As(err, &decodeErr) || decodeErr.Resource != ServicesResource || decodeErr.ResourceVersion != 3 {
+		t.Errorf("expected a DecodeError of services at resource version 3, got %#v", err)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+}
+
+func TestServicesStreamDecodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"type":"ADDED","object":{"kind":"Service","id":"foo","resourceVersion":2,"port":80}}`)
+		fmt.Fprintln(w, `{"type":"ADDED","object":{"kind":"Service","id":}}`)
+	}))
+	defer server.Close()
+
+	services := make(chan ServiceUpdate)
+	clock := newFakeClock()
+	failures := make(failureReporter, 1)
+	deadLetter := make(chan InvalidUpdate, 1)
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil), services: services, waitDuration: time.Minute}
+	source.Clock = clock
+	source.Health = failures
+	source.DeadLetter = deadLetter
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	if actual := <-services; actual.Op != ADD || actual.Services[0].ID != "foo" {
+		t.Errorf("unexpected update %#v", actual)
+	}
+	// a stream that cannot be decoded ends the watch with an error, not as if it were closed
+	var decodeErr *DecodeError
+	if err := <-failures; !errors.As(err, &decodeErr) || decodeErr.Resource != ServicesResource || decodeErr.ResourceVersion != 3 {
+		t.Errorf("expected a DecodeError of services at resource version 3, got %#v", err)
+	}
+	// and is dead-lettered
+	if dead := <-deadLetter; dead.Event.Resource != ServicesResource || !errors.As(dead.Err, &decodeErr) {
+		t.Errorf("expected the DecodeError to be dead-lettered, got %#v", dead)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/tools"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/golang/glog"
+)
+
+// The key prefixes the registry stores services and endpoints under, by ID, or by namespace
+// and ID.
+const (
+	etcdServicesPrefix  = registryRoot + "/specs"
+	etcdEndpointsPrefix = registryRoot + "/endpoints"
+)
+
+// etcdErrorCodeIndexCleared is returned by a watch from an index etcd no longer has the
+// history of.
+const etcdErrorCodeIndexCleared = 401
+
+// How long a SourceEtcd waits after a failure, doubling up to the maximum while it keeps
+// failing.
+const (
+	etcdInitialBackoff = time.Second
+	etcdMaxBackoff     = 30 * time.Second
+)
+
+// EtcdClient is the part of *etcd.Client used by a SourceEtcd.
+type EtcdClient interface {
+	Get(key string, sort, recursive bool) (*etcd.Response, error)
+	Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error)
+}
+
+// SourceEtcd is a config source that watches the services and endpoints the registry keeps in
+// etcd directly, bypassing the apiserver. It sends a SET of everything under each prefix, then
+// an ADD or REMOVE for each key that changes. A failed watch is resumed after a backoff from
+// the last index seen, or from a new list if etcd no longer has it.
+type SourceEtcd struct {
+	client    EtcdClient
+	services  chan<- ServiceUpdate
+	endpoints chan<- EndpointsUpdate
+	clock     Clock
+	// closing is marked by Close.
+	closing syncSignal
+}
+
+// NewSourceEtcd creates a SourceEtcd and starts watching services and endpoints with client.
+func NewSourceEtcd(client EtcdClient, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) *SourceEtcd {
+	return newSourceEtcd(client, services, endpoints, realClock{})
+}
+
+func newSourceEtcd(client EtcdClient, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate, clock Clock) *SourceEtcd {
+	config := &SourceEtcd{
+		client:    client,
+		services:  services,
+		endpoints: endpoints,
+		clock:     clock,
+	}
+	go func() {
+		defer util.HandleCrash()
+		config.run(etcdServicesPrefix, config.setServices, config.changeService)
+	}()
+	go func() {
+		defer util.HandleCrash()
+		config.run(etcdEndpointsPrefix, config.setEndpoints, config.changeEndpoints)
+	}()
+	return config
+}
+
+// Close stops the source, ending its watches. A list in progress finishes, but no more updates
+// are sent.
+func (s *SourceEtcd) Close() {
+	s.closing.mark()
+}
+
+// run lists and watches prefix until the source is closed, passing the listed nodes to set and
+// the changes to change.
+func (s *SourceEtcd) run(prefix string, set func([]*etcd.Node), change func(*etcd.Response)) {
+	var index uint64
+	backoff := etcdInitialBackoff
+	for {
+		var err error
+		index, err = s.watch(prefix, index, set, change)
+		select {
+		case <-s.closing.done():
+			return
+		default:
+		}
+		if err == nil {
+			backoff = etcdInitialBackoff
+			continue
+		}
+		if etcdError, ok := err.(*etcd.EtcdError); ok && etcdError.ErrorCode == etcdErrorCodeIndexCleared {
+			glog.Infof("Watch of %s fell behind, listing again: %v", prefix, err)
+			index = 0
+			continue
+		}
+		glog.Errorf("Watch of %s failed, retrying in %v: %v", prefix, backoff, err)
+		select {
+		case <-s.clock.After(backoff):
+		case <-s.closing.done():
+			return
+		}
+		backoff *= 2
+		if backoff > etcdMaxBackoff {
+			backoff = etcdMaxBackoff
+		}
+	}
+}
+
+// watch lists prefix if index is 0, then watches it from after index until the watch ends.
+// It returns the last index seen.
+func (s *SourceEtcd) watch(prefix string, index uint64, set func([]*etcd.Node), change func(*etcd.Response)) (uint64, error) {
+	if index == 0 {
+		response, err := s.client.Get(prefix, false, true)
+		switch {
+		case tools.IsEtcdNotFound(err):
+			// Nothing has been stored yet.
+			set(nil)
+			index = err.(*etcd.EtcdError).Index
+		case err != nil:
+			return 0, err
+		default:
+			set(response.Node.Nodes)
+			index = response.EtcdIndex
+		}
+	}
+
+	glog.V(2).Infof("Watching %s from index %d", prefix, index+1)
+	receiver := make(chan *etcd.Response)
+	stop := make(chan bool)
+	errs := make(chan error, 1)
+	go func() {
+		defer util.HandleCrash()
+		_, err := s.client.Watch(prefix, index+1, true, receiver, stop)
+		errs <- err
+	}()
+	// Closing the source stops the watch.
+	ended := make(chan struct{})
+	defer close(ended)
+	go func() {
+		select {
+		case <-s.closing.done():
+			close(stop)
+		case <-ended:
+		}
+	}()
+	// The client closes receiver once the watch ends.
+	for response := range receiver {
+		if response.Node == nil {
+			continue
+		}
+		change(response)
+		index = response.Node.ModifiedIndex
+	}
+	return index, <-errs
+}
+
+// sendServices sends update, unless the source is closed first.
+func (s *SourceEtcd) sendServices(update ServiceUpdate) {
+	select {
+	case s.services <- update:
+	case <-s.closing.done():
+	}
+}
+
+// sendEndpoints is sendServices for endpoints.
+func (s *SourceEtcd) sendEndpoints(update EndpointsUpdate) {
+	select {
+	case s.endpoints <- update:
+	case <-s.closing.done():
+	}
+}
+
+func (s *SourceEtcd) setServices(nodes []*etcd.Node) {
+	services := []api.Service{}
+	for _, node := range etcdLeaves(nodes) {
+		var service api.Service
+		if err := runtime.DefaultCodec.DecodeInto([]byte(node.Value), &service); err != nil {
+			glog.Errorf("Skipping service %s that cannot be decoded: %v", node.Key, err)
+			continue
+		}
+		namespaceService(&service, etcdNamespace(etcdServicesPrefix, node.Key))
+		services = append(services, service)
+	}
+	s.sendServices(ServiceUpdate{Op: SET, Services: services})
+}
+
+func (s *SourceEtcd) setEndpoints(nodes []*etcd.Node) {
+	endpoints := []api.Endpoints{}
+	for _, node := range etcdLeaves(nodes) {
+		var e api.Endpoints
+		if err := runtime.DefaultCodec.DecodeInto([]byte(node.Value), &e); err != nil {
+			glog.Errorf("Skipping endpoints %s that cannot be decoded: %v", node.Key, err)
+			continue
+		}
+		namespaceEndpoints(&e, etcdNamespace(etcdEndpointsPrefix, node.Key))
+		endpoints = append(endpoints, e)
+	}
+	s.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: endpoints})
+}
+
+// etcdLeaves returns the nodes holding objects among nodes, descending into the directories of
+// a registry that keeps objects by namespace.
+func etcdLeaves(nodes []*etcd.Node) []*etcd.Node {
+	var leaves []*etcd.Node
+	for _, node := range nodes {
+		if node.Dir {
+			leaves = append(leaves, etcdLeaves(node.Nodes)...)
+			continue
+		}
+		leaves = append(leaves, node)
+	}
+	return leaves
+}
+
+// etcdNamespace returns the namespace of the object at key under prefix, the directory between
+// prefix and the ID of the object in a registry that keeps objects by namespace, or "".
+func etcdNamespace(prefix, key string) string {
+	relative := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if i := strings.LastIndex(relative, "/"); i >= 0 {
+		return relative[:i]
+	}
+	return ""
+}
+
+// isEtcdDelete returns whether response is for a key that is gone.
+func isEtcdDelete(response *etcd.Response) bool {
+	switch response.Action {
+	case "delete", "compareAndDelete", "expire":
+		return true
+	}
+	return false
+}
+
+// deletedNode returns the value a deleted key had, if etcd sent it, and its last key element,
+// which is the ID of the object.
+func deletedNode(response *etcd.Response) (string, string) {
+	value := ""
+	if response.PrevNode != nil {
+		value = response.PrevNode.Value
+	}
+	return value, path.Base(response.Node.Key)
+}
+
+func (s *SourceEtcd) changeService(response *etcd.Response) {
+	var service api.Service
+	if response.Node.Dir {
+		return
+	}
+	namespace := etcdNamespace(etcdServicesPrefix, response.Node.Key)
+	if isEtcdDelete(response) {
+		value, id := deletedNode(response)
+		if value == "" || runtime.DefaultCodec.DecodeInto([]byte(value), &service) != nil {
+			service = api.Service{JSONBase: api.JSONBase{ID: id}}
+		}
+		namespaceService(&service, namespace)
+		s.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{service}})
+		return
+	}
+	if err := runtime.DefaultCodec.DecodeInto([]byte(response.Node.Value), &service); err != nil {
+		glog.Errorf("Skipping service %s that cannot be decoded: %v", response.Node.Key, err)
+		return
+	}
+	namespaceService(&service, namespace)
+	s.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{service}})
+}
+
+func (s *SourceEtcd) changeEndpoints(response *etcd.Response) {
+	var e api.Endpoints
+	if response.Node.Dir {
+		return
+	}
+	namespace := etcdNamespace(etcdEndpointsPrefix, response.Node.Key)
+	if isEtcdDelete(response) {
+		_, id := deletedNode(response)
+		s.sendEndpoints(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: NamespacedName(namespace, id)}}}})
+		return
+	}
+	if err := runtime.DefaultCodec.DecodeInto([]byte(response.Node.Value), &e); err != nil {
+		glog.Errorf("Skipping endpoints %s that cannot be decoded: %v", response.Node.Key, err)
+		return
+	}
+	namespaceEndpoints(&e, namespace)
+	s.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{e}})
+}
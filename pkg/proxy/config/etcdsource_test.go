@@ -0,0 +1,223 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/tools"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+type fakeEtcdGet struct {
+	response *etcd.Response
+	err      error
+}
+
+// fakeEtcdWatch is a Watch call of a fakeEtcdClient. The test sends the changes on receiver,
+// then the result of the call on result.
+type fakeEtcdWatch struct {
+	index    uint64
+	receiver chan *etcd.Response
+	result   chan error
+}
+
+// fakeEtcdClient answers the Get of each key with the next of its results, and hands each
+// Watch of a prefix to the test on its channel in watches.
+type fakeEtcdClient struct {
+	lock    sync.Mutex
+	gets    map[string][]fakeEtcdGet
+	watches map[string]chan *fakeEtcdWatch
+}
+
+func newFakeEtcdClient() *fakeEtcdClient {
+	return &fakeEtcdClient{
+		gets: make(map[string][]fakeEtcdGet),
+		watches: map[string]chan *fakeEtcdWatch{
+			etcdServicesPrefix:  make(chan *fakeEtcdWatch),
+			etcdEndpointsPrefix: make(chan *fakeEtcdWatch),
+		},
+	}
+}
+
+func (c *fakeEtcdClient) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	results := c.gets[key]
+	if len(results) == 0 {
+		return nil, errors.New("unexpected get of " + key)
+	}
+	c.gets[key] = results[1:]
+	return results[0].response, results[0].err
+}
+
+func (c *fakeEtcdClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+	w := &fakeEtcdWatch{index: waitIndex, receiver: receiver, result: make(chan error)}
+	select {
+	case c.watches[prefix] <- w:
+	case <-stop:
+		return nil, errWatchStopped
+	}
+	select {
+	case err := <-w.result:
+		return nil, err
+	case <-stop:
+		return nil, errWatchStopped
+	}
+}
+
+// errWatchStopped is returned by a Watch of a fakeEtcdClient that is stopped, as etcd does.
+var errWatchStopped = errors.New("watch stopped by the user via stop channel")
+
+func etcdNode(t *testing.T, key string, obj runtime.Object, index uint64) *etcd.Node {
+	data, err := runtime.DefaultCodec.Encode(obj)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &etcd.Node{Key: key, Value: string(data), ModifiedIndex: index}
+}
+
+func TestSourceEtcd(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{Kind: "Service", ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{Kind: "Service", ID: "bar"}, Port: 81}
+	barChanged := bar
+	barChanged.Port = 8081
+	fooEndpoints := api.Endpoints{JSONBase: api.JSONBase{Kind: "Endpoints", ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+
+	client := newFakeEtcdClient()
+	client.gets[etcdServicesPrefix] = []fakeEtcdGet{
+		{response: &etcd.Response{
+			Node:      &etcd.Node{Key: etcdServicesPrefix, Dir: true, Nodes: etcd.Nodes{etcdNode(t, etcdServicesPrefix+"/foo", &foo, 4)}},
+			EtcdIndex: 5,
+		}},
+		{response: &etcd.Response{
+			Node:      &etcd.Node{Key: etcdServicesPrefix, Dir: true, Nodes: etcd.Nodes{etcdNode(t, etcdServicesPrefix+"/bar", &barChanged, 20)}},
+			EtcdIndex: 21,
+		}},
+	}
+	client.gets[etcdEndpointsPrefix] = []fakeEtcdGet{
+		{err: &etcd.EtcdError{ErrorCode: tools.EtcdErrorCodeNotFound, Index: 3}},
+	}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	clock := newFakeClock()
+	newSourceEtcd(client, services, endpoints, clock)
+
+	// from zero both are listed, even if nothing is stored yet
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{foo}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	endpointsWatch := <-client.watches[etcdEndpointsPrefix]
+	if endpointsWatch.index != 4 {
+		t.Errorf("expected to watch endpoints from 4, got %d", endpointsWatch.index)
+	}
+	endpointsWatch.receiver <- &etcd.Response{Action: "set", Node: etcdNode(t, etcdEndpointsPrefix+"/foo", &fooEndpoints, 7)}
+	expectedEndpoints = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{fooEndpoints}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	servicesWatch := <-client.watches[etcdServicesPrefix]
+	if servicesWatch.index != 6 {
+		t.Errorf("expected to watch services from 6, got %d", servicesWatch.index)
+	}
+	changes := []struct {
+		response *etcd.Response
+		expected ServiceUpdate
+	}{
+		{
+			&etcd.Response{Action: "create", Node: etcdNode(t, etcdServicesPrefix+"/bar", &bar, 8)},
+			ServiceUpdate{Op: ADD, Services: []api.Service{bar}},
+		},
+		{
+			&etcd.Response{
+				Action:   "delete",
+				Node:     &etcd.Node{Key: etcdServicesPrefix + "/foo", ModifiedIndex: 9},
+				PrevNode: etcdNode(t, etcdServicesPrefix+"/foo", &foo, 4),
+			},
+			ServiceUpdate{Op: REMOVE, Services: []api.Service{foo}},
+		},
+	}
+	for _, change := range changes {
+		servicesWatch.receiver <- change.response
+		if actual := <-services; !reflect.DeepEqual(change.expected, actual) {
+			t.Errorf("expected %#v, got %#v", change.expected, actual)
+		}
+	}
+
+	// a failed watch resumes after a backoff, from the last change seen
+	servicesWatch.result <- errors.New("connection reset")
+	clock.BlockUntil(t, 1)
+	clock.Step(etcdInitialBackoff)
+	servicesWatch = <-client.watches[etcdServicesPrefix]
+	if servicesWatch.index != 10 {
+		t.Errorf("expected to watch services from 10, got %d", servicesWatch.index)
+	}
+
+	// once etcd no longer has that index, the services are listed again
+	servicesWatch.result <- &etcd.EtcdError{ErrorCode: etcdErrorCodeIndexCleared}
+	expected = ServiceUpdate{Op: SET, Services: []api.Service{barChanged}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	servicesWatch = <-client.watches[etcdServicesPrefix]
+	if servicesWatch.index != 22 {
+		t.Errorf("expected to watch services from 22, got %d", servicesWatch.index)
+	}
+	select {
+	case update := <-services:
+		t.Errorf("unexpected update %#v", update)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSourceEtcdClose(t *testing.T) {
+	client := newFakeEtcdClient()
+	for _, prefix := range []string{etcdServicesPrefix, etcdEndpointsPrefix} {
+		client.gets[prefix] = []fakeEtcdGet{{err: &etcd.EtcdError{ErrorCode: tools.EtcdErrorCodeNotFound, Index: 3}}}
+	}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := newSourceEtcd(client, services, endpoints, newFakeClock())
+	<-services
+	<-endpoints
+	<-client.watches[etcdServicesPrefix]
+	<-client.watches[etcdEndpointsPrefix]
+
+	// the watches are stopped and not started again
+	source.Close()
+	select {
+	case <-client.watches[etcdServicesPrefix]:
+		t.Errorf("unexpected watch of services after Close")
+	case <-client.watches[etcdEndpointsPrefix]:
+		t.Errorf("unexpected watch of endpoints after Close")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
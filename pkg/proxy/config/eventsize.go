@@ -0,0 +1,166 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// DefaultMaxEventSize is the MaxEventSize of a source that does not set one.
+const DefaultMaxEventSize = 1 << 20
+
+// eventSizeCounter counts the watch events dropped for exceeding MaxEventSize.
+type eventSizeCounter struct {
+	lock    sync.Mutex
+	dropped uint64
+}
+
+func (c *eventSizeCounter) add() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dropped++
+}
+
+// OversizedEvents returns the number of watch events dropped because they exceeded
+// MaxEventSize.
+func (s *SourceAPI) OversizedEvents() uint64 {
+	s.oversized.lock.Lock()
+	defer s.oversized.lock.Unlock()
+	return s.oversized.dropped
+}
+
+// maxEventSize returns the limit of MaxEventSize, or 0 if there is none.
+func (s *SourceAPI) maxEventSize() int64 {
+	switch {
+	case s.MaxEventSize == 0:
+		return DefaultMaxEventSize
+	case s.MaxEventSize < 0:
+		return 0
+	}
+	return s.MaxEventSize
+}
+
+// limitEvents returns the body of a watch response with the events of more than MaxEventSize
+// bytes left out, so that a decoder reading from it never holds more than that of a single
+// event. The events of a YAML stream are its documents, and those of a JSON stream its lines.
+// The stream of a WatchCodec is returned as it is, as its framing is not known.
+func (s *SourceAPI) limitEvents(body io.ReadCloser, contentType string) io.ReadCloser {
+	max := s.maxEventSize()
+	if max == 0 || s.WatchCodec != nil {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return &eventSizeLimiter{
+		body:      body,
+		reader:    bufio.NewReader(body),
+		max:       max,
+		documents: mediaType == "application/yaml",
+		counter:   &s.oversized,
+	}
+}
+
+// eventSizeLimiter reads a stream of newline separated events, or of "---" separated YAML
+// documents if documents is set, dropping those longer than max.
+type eventSizeLimiter struct {
+	body      io.ReadCloser
+	reader    *bufio.Reader
+	max       int64
+	documents bool
+	counter   *eventSizeCounter
+	// pending is the rest of the event being read, and err the error that ended the stream
+	// after it.
+	pending []byte
+	err     error
+}
+
+func (l *eventSizeLimiter) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		if l.documents {
+			l.pending, l.err = l.nextDocument()
+		} else {
+			line, size, err := l.readLine()
+			l.pending, l.err = l.limit(line, size), err
+		}
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+func (l *eventSizeLimiter) Close() error {
+	return l.body.Close()
+}
+
+// nextDocument reads the next document of the stream, with the separator that ends it, or only
+// the separator if the document is longer than max.
+func (l *eventSizeLimiter) nextDocument() ([]byte, error) {
+	var doc []byte
+	var size int64
+	for {
+		line, n, err := l.readLine()
+		if line != nil && strings.TrimRight(string(line), " \t\r\n") == "---" {
+			return append(l.limit(doc, size), line...), err
+		}
+		size += n
+		if size <= l.max {
+			doc = append(doc, line...)
+		} else {
+			doc = nil
+		}
+		if err != nil {
+			return l.limit(doc, size), err
+		}
+	}
+}
+
+// readLine reads the next line of the stream and returns it with its size, or nil if it is
+// longer than max, in which case no more than max bytes of it are held at once.
+func (l *eventSizeLimiter) readLine() ([]byte, int64, error) {
+	var line []byte
+	var size int64
+	for {
+		chunk, err := l.reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		if size <= l.max {
+			line = append(line, chunk...)
+		} else {
+			line = nil
+		}
+		if err != bufio.ErrBufferFull {
+			return line, size, err
+		}
+	}
+}
+
+// limit returns event, of size bytes, or nil if it is longer than max, counting it as dropped.
+func (l *eventSizeLimiter) limit(event []byte, size int64) []byte {
+	if size <= l.max {
+		return event
+	}
+	l.counter.add()
+	glog.Errorf("Dropping watch event of %d bytes, more than the limit of %d", size, l.max)
+	return nil
+}
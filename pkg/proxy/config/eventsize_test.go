@@ -0,0 +1,103 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestServicesMaxEventSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// larger than the buffer of the reader, to be read in several chunks
+		labels := fmt.Sprintf(`{"big":"%s"}`, strings.Repeat("x", 10000))
+		fmt.Fprintf(w, `{"type":"ADDED","object":{"kind":"Service","id":"big","resourceVersion":2,"labels":%s}}`+"\n", labels)
+		fmt.Fprintln(w, `{"type":"ADDED","object":{"kind":"Service","id":"foo","resourceVersion":3}}`)
+	}))
+	defer server.Close()
+
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil), services: services}
+	source.MaxEventSize = 1000
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{Kind: "Service", ID: "foo", ResourceVersion: 3}}}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	<-ch
+	if dropped := source.OversizedEvents(); dropped != 1 {
+		t.Errorf("expected 1 oversized event, got %d", dropped)
+	}
+}
+
+func TestServicesMaxEventSizeYAML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		// every line is short, but the document is not
+		fmt.Fprint(w, "---\ntype: ADDED\nobject:\n  kind: Service\n  id: big\n  resourceVersion: 2\n  labels:\n")
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(w, "    label%d: value\n", i)
+		}
+		fmt.Fprint(w, "---\ntype: ADDED\nobject:\n  kind: Service\n  id: foo\n  resourceVersion: 3\n")
+	}))
+	defer server.Close()
+
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil), services: services}
+	source.MaxEventSize = 1000
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// the whole document is dropped, not only the lines past the limit
+	actual := <-services
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{Kind: "Service", ID: "foo", ResourceVersion: 3}}}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	<-ch
+	if dropped := source.OversizedEvents(); dropped != 1 {
+		t.Errorf("expected 1 oversized event, got %d", dropped)
+	}
+}
+
+func TestMaxEventSizeDefault(t *testing.T) {
+	source := SourceAPI{}
+	if max := source.maxEventSize(); max != DefaultMaxEventSize {
+		t.Errorf("expected %d, got %d", DefaultMaxEventSize, max)
+	}
+	source.MaxEventSize = -1
+	if max := source.maxEventSize(); max != 0 {
+		t.Errorf("expected no limit, got %d", max)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/golang/glog"
+)
+
+// FailoverPolicy splits the endpoints of a service into primaries, which normally serve
+// traffic, and backups, which take over only once every primary is unhealthy.
+type FailoverPolicy struct {
+	Primary []string
+	Backup  []string
+}
+
+// SetFailoverPolicy sets the failover policy for a service, replacing any previous one.
+func (s *ConfigStore) SetFailoverPolicy(serviceID string, policy FailoverPolicy) {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	s.failover[serviceID] = policy
+}
+
+// RemoveFailoverPolicy removes the failover policy for a service.
+func (s *ConfigStore) RemoveFailoverPolicy(serviceID string) {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	delete(s.failover, serviceID)
+}
+
+// SetEndpointHealth records the result of a health check of endpoint.
+func (s *ConfigStore) SetEndpointHealth(endpoint string, healthy bool) {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	if healthy {
+		delete(s.unhealthy, endpoint)
+	} else {
+		s.unhealthy[endpoint] = true
+	}
+}
+
+// GetActiveEndpoints returns the endpoints that should serve traffic for a service.
+// Services with a failover policy get their healthy primaries, or the backups once no
+// primary is healthy. Other services get their endpoints as last received.
+func (s *ConfigStore) GetActiveEndpoints(id string) []string {
+	s.failoverLock.RLock()
+	policy, found := s.failover[id]
+	var healthy []string
+	if found {
+		for _, endpoint := range policy.Primary {
+			if !s.unhealthy[endpoint] {
+				healthy = append(healthy, endpoint)
+			}
+		}
+	}
+	s.failoverLock.RUnlock()
+
+	if !found {
+		endpoints, _ := s.GetEndpoints(id)
+		return endpoints.Endpoints
+	}
+	if len(healthy) == 0 {
+		glog.V(2).Infof("All primary endpoints of %s are unhealthy, using backups %v", id, policy.Backup)
+		return policy.Backup
+	}
+	return healthy
+}
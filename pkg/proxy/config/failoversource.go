@@ -0,0 +1,146 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	failoverPrimary   = "primary"
+	failoverSecondary = "secondary"
+)
+
+// FailoverSource is a config source that passes on the updates of a primary source, the
+// apiserver, until it fails a number of times in a row, then passes on the updates of a
+// secondary source, a file, until the primary recovers. On each switch a SET of the full
+// state of the newly active source is sent, so consumers are reconciled with it.
+type FailoverSource struct {
+	// threshold is the number of consecutive primary failures that switch to the secondary.
+	threshold int
+	services  chan<- ServiceUpdate
+	endpoints chan<- EndpointsUpdate
+
+	primaryServices    chan ServiceUpdate
+	primaryEndpoints   chan EndpointsUpdate
+	secondaryServices  chan ServiceUpdate
+	secondaryEndpoints chan EndpointsUpdate
+	health             chan error
+
+	// state is the last known state of each source, keyed by failoverPrimary or failoverSecondary.
+	state    map[string]*ConfigStore
+	active   string
+	failures int
+}
+
+// NewFailoverSource creates a FailoverSource with a SourceAPI on client as the primary and a
+// ConfigSourceFile reading filename as the secondary, and starts both.
+func NewFailoverSource(client Watcher, period time.Duration, filename string, threshold int, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) *FailoverSource {
+	s := newFailoverSource(threshold, services, endpoints)
+	go s.run()
+	NewSourceAPIWithOptions(client, period, s.primaryServices, s.primaryEndpoints, SourceAPIOptions{Health: s})
+	NewConfigSourceFile(filename, s.secondaryServices, s.secondaryEndpoints)
+	return s
+}
+
+func newFailoverSource(threshold int, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) *FailoverSource {
+	return &FailoverSource{
+		threshold: threshold,
+		services:  services,
+		endpoints: endpoints,
+
+		primaryServices:    make(chan ServiceUpdate),
+		primaryEndpoints:   make(chan EndpointsUpdate),
+		secondaryServices:  make(chan ServiceUpdate),
+		secondaryEndpoints: make(chan EndpointsUpdate),
+		health:             make(chan error),
+
+		state: map[string]*ConfigStore{
+			failoverPrimary:   NewConfigStore(),
+			failoverSecondary: NewConfigStore(),
+		},
+		active: failoverPrimary,
+	}
+}
+
+// ReportSuccess records that the primary reached the apiserver.
+func (s *FailoverSource) ReportSuccess() {
+	s.health <- nil
+}
+
+// ReportFailure records that the primary failed to reach the apiserver.
+func (s *FailoverSource) ReportFailure(err error) {
+	s.health <- err
+}
+
+// run applies updates and health reports one at a time, forever.
+func (s *FailoverSource) run() {
+	for {
+		select {
+		case update := <-s.primaryServices:
+			s.updateServices(failoverPrimary, update)
+		case update := <-s.primaryEndpoints:
+			s.updateEndpoints(failoverPrimary, update)
+		case update := <-s.secondaryServices:
+			s.updateServices(failoverSecondary, update)
+		case update := <-s.secondaryEndpoints:
+			s.updateEndpoints(failoverSecondary, update)
+		case err := <-s.health:
+			s.updateHealth(err)
+		}
+	}
+}
+
+func (s *FailoverSource) updateServices(source string, update ServiceUpdate) {
+	s.state[source].UpdateServices(source, update)
+	if source == s.active {
+		s.services <- update
+	}
+}
+
+func (s *FailoverSource) updateEndpoints(source string, update EndpointsUpdate) {
+	s.state[source].UpdateEndpoints(source, update)
+	if source == s.active {
+		s.endpoints <- update
+	}
+}
+
+func (s *FailoverSource) updateHealth(err error) {
+	if err == nil {
+		s.failures = 0
+		if s.active != failoverPrimary {
+			glog.Infof("Primary config source recovered, switching back to it")
+			s.switchTo(failoverPrimary)
+		}
+		return
+	}
+	s.failures++
+	if s.active == failoverPrimary && s.failures >= s.threshold {
+		glog.Errorf("Primary config source failed %d times in a row, switching to secondary: %v", s.failures, err)
+		s.switchTo(failoverSecondary)
+	}
+}
+
+// switchTo makes source the active source and sends its state.
+func (s *FailoverSource) switchTo(source string) {
+	s.active = source
+	state := s.state[source]
+	s.services <- ServiceUpdate{Op: SET, Services: state.ListServices()}
+	s.endpoints <- EndpointsUpdate{Op: SET, Endpoints: state.ListEndpoints()}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestFailoverSource(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := newFailoverSource(2, services, endpoints)
+	go source.run()
+
+	primary := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	primaryEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	secondary := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 20}
+	secondaryEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.1.1:80"}}
+
+	// updates of the primary are passed on, those of the secondary are only recorded
+	source.primaryServices <- ServiceUpdate{Op: SET, Services: []api.Service{primary}}
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{primary}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	source.primaryEndpoints <- EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{primaryEndpoints}}
+	<-endpoints
+	source.secondaryServices <- ServiceUpdate{Op: SET, Services: []api.Service{secondary}}
+	source.secondaryEndpoints <- EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{secondaryEndpoints}}
+
+	// a failure below the threshold, then a success, does not switch
+	source.ReportFailure(errors.New("unreachable"))
+	source.ReportSuccess()
+	source.ReportFailure(errors.New("unreachable"))
+	select {
+	case update := <-services:
+		t.Fatalf("unexpected update %#v", update)
+	default:
+	}
+
+	// reaching the threshold switches to the secondary
+	go source.ReportFailure(errors.New("unreachable"))
+	expected = ServiceUpdate{Op: SET, Services: []api.Service{secondary}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{secondaryEndpoints}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	// while the secondary is active the primary's updates are only recorded
+	source.primaryServices <- ServiceUpdate{Op: REMOVE, Services: []api.Service{primary}}
+	source.primaryServices <- ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}}}}
+	go func() {
+		source.secondaryEndpoints <- EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{secondaryEndpoints}}
+	}()
+	expectedEndpoints = EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{secondaryEndpoints}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	// a success switches back to the primary with its latest state
+	go source.ReportSuccess()
+	expected = ServiceUpdate{Op: SET, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}}}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints = EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{primaryEndpoints}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+	pb "github.com/vishvananda/wormhole/pkg/proxy/config/flowcontrol"
+	"google.golang.org/grpc"
+)
+
+// RegisterWatchServer serves the updates of s on server, as the FlowControl service of
+// flowcontrol.proto, to each FlowControlledGRPCSource that watches it.
+func (s *SourceAPI) RegisterWatchServer(server *grpc.Server) {
+	pb.RegisterFlowControlServer(server, &watchServer{source: s})
+}
+
+// watchServer implements the FlowControl service for a SourceAPI.
+type watchServer struct {
+	pb.UnimplementedFlowControlServer
+	source *SourceAPI
+}
+
+// Watch streams the updates of the source, one for each token the client has sent, until the
+// stream fails or the source is closed. The updates are taken from the source right away and
+// queued for the client, so a slow client never holds up the source or the other subscribers.
+// While it has no tokens left, a queued ADD or REMOVE of an object is replaced by a later one
+// of the same object, and a SET replaces every update of its resource queued before it, so the
+// queue holds at most about one update per object.
+func (w *watchServer) Watch(stream pb.FlowControl_WatchServer) error {
+	s := w.source
+	services, _ := s.Subscribe(ServicesResource, nil)
+	defer services.Cancel()
+	endpoints, _ := s.Subscribe(EndpointsResource, nil)
+	defer endpoints.Cancel()
+
+	queue := newWatchQueue()
+	go func() {
+		defer util.HandleCrash()
+		for {
+			select {
+			case event, ok := <-services.Events():
+				if !ok {
+					return
+				}
+				queue.push(event)
+			case event, ok := <-endpoints.Events():
+				if !ok {
+					return
+				}
+				queue.push(event)
+			}
+		}
+	}()
+
+	// The context of the stream is done once Watch returns, which stops the token reader.
+	ctx := stream.Context()
+	tokens := make(chan int32)
+	go func() {
+		defer util.HandleCrash()
+		defer close(tokens)
+		for {
+			ready, err := stream.Recv()
+			if err != nil {
+				glog.V(2).Infof("Watch client is gone: %v", err)
+				return
+			}
+			select {
+			case tokens <- ready.Count:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	available := 0
+	for {
+		if available > 0 {
+			if event, ok := queue.pop(); ok {
+				message, err := encodeWatchEvent(event)
+				if err != nil {
+					glog.Errorf("Unable to encode %s update: %v", event.Resource, err)
+					continue
+				}
+				if err := stream.Send(message); err != nil {
+					glog.Errorf("Unable to send %s update: %v", event.Resource, err)
+					return err
+				}
+				available--
+				continue
+			}
+		}
+		var ready <-chan struct{}
+		if available > 0 {
+			ready = queue.ready
+		}
+		select {
+		case count, ok := <-tokens:
+			if !ok {
+				return nil
+			}
+			available += int(count)
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.closing.done():
+			return nil
+		}
+	}
+}
+
+// encodeWatchEvent returns the message of the update of event.
+func encodeWatchEvent(event Event) (*pb.WatchEvent, error) {
+	var update interface{}
+	switch event.Resource {
+	case ServicesResource:
+		update = event.Services
+	case EndpointsResource:
+		update = event.Endpoints
+	default:
+		return nil, ErrUnknownResourceType
+	}
+	data, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.WatchEvent{Resource: string(event.Resource), Update: data}, nil
+}
+
+// decodeWatchEvent returns the update of message.
+func decodeWatchEvent(message *pb.WatchEvent) (Event, error) {
+	event := Event{Resource: ResourceType(message.Resource)}
+	var update interface{}
+	switch event.Resource {
+	case ServicesResource:
+		event.Services = &ServiceUpdate{}
+		update = event.Services
+	case EndpointsResource:
+		event.Endpoints = &EndpointsUpdate{}
+		update = event.Endpoints
+	default:
+		return Event{}, fmt.Errorf("%v: %q", ErrUnknownResourceType, message.Resource)
+	}
+	if err := json.Unmarshal(message.Update, update); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+// watchQueue is the queue of the updates waiting for the tokens of a watch client. It
+// coalesces the updates of each object, so that it does not grow with the number of updates
+// while the client is slow.
+// It is safe for concurrent use.
+type watchQueue struct {
+	lock   sync.Mutex
+	events []*Event
+	// keys maps the key of each object with an ADD or REMOVE queued to that update.
+	keys map[string]*Event
+	// ready is signalled when an update is pushed.
+	ready chan struct{}
+}
+
+func newWatchQueue() *watchQueue {
+	return &watchQueue{
+		keys:  make(map[string]*Event),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// watchKey returns the key of the object of an ADD or REMOVE of a single object, or "" for an
+// update that is not coalesced.
+func watchKey(event Event) string {
+	switch {
+	case event.Services != nil && len(event.Services.Services) == 1 && droppable(event.Services.Op):
+		return "services/" + ServiceKey(event.Services.Services[0])
+	case event.Endpoints != nil && len(event.Endpoints.Endpoints) == 1 && droppable(event.Endpoints.Op):
+		return "endpoints/" + event.Endpoints.Endpoints[0].ID
+	}
+	return ""
+}
+
+// replacedBySet returns whether event is made redundant by a later SET of resource.
+func replacedBySet(event Event, resource ResourceType) bool {
+	op, ok := eventOp(event)
+	return ok && event.Resource == resource && (op == SET || droppable(op))
+}
+
+// push queues event, coalescing it with the updates already queued.
+func (q *watchQueue) push(event Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	defer func() {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}()
+	if op, ok := eventOp(event); ok && op == SET {
+		q.dropBefore(event.Resource)
+	}
+	key := watchKey(event)
+	if queued, ok := q.keys[key]; ok {
+		*queued = event
+		return
+	}
+	q.events = append(q.events, &event)
+	if key != "" {
+		q.keys[key] = &event
+	}
+}
+
+// dropBefore removes the updates queued that a SET of resource replaces.
+func (q *watchQueue) dropBefore(resource ResourceType) {
+	events := q.events[:0]
+	for _, event := range q.events {
+		if replacedBySet(*event, resource) {
+			delete(q.keys, watchKey(*event))
+			continue
+		}
+		events = append(events, event)
+	}
+	q.events = events
+}
+
+// pop returns the first update queued, and false if there is none.
+func (q *watchQueue) pop() (Event, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+	event := q.events[0]
+	q.events = q.events[1:]
+	if key := watchKey(*event); key != "" {
+		delete(q.keys, key)
+	}
+	return *event, true
+}
+
+// FlowControlledGRPCSource is a config source that receives the updates of a SourceAPI over
+// the Watch stream of a server set up by RegisterWatchServer. It grants the server a number of
+// tokens and returns one each time it has sent an update on, so that no more updates than
+// tokens are ever in flight towards a slow consumer.
+type FlowControlledGRPCSource struct {
+	client    pb.FlowControlClient
+	tokens    int
+	services  chan<- ServiceUpdate
+	endpoints chan<- EndpointsUpdate
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewFlowControlledGRPCSource creates a FlowControlledGRPCSource that watches the server at
+// the other end of conn with tokens in flight, at least one, and starts sending the updates to
+// services and endpoints.
+func NewFlowControlledGRPCSource(conn grpc.ClientConnInterface, tokens int, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) *FlowControlledGRPCSource {
+	if tokens < 1 {
+		tokens = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	config := &FlowControlledGRPCSource{
+		client:    pb.NewFlowControlClient(conn),
+		tokens:    tokens,
+		services:  services,
+		endpoints: endpoints,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	go func() {
+		defer util.HandleCrash()
+		config.run()
+	}()
+	return config
+}
+
+// Close ends the stream, which stops the source. It does not close the connection.
+func (s *FlowControlledGRPCSource) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *FlowControlledGRPCSource) run() {
+	stream, err := s.client.Watch(s.ctx)
+	if err != nil {
+		glog.Errorf("Unable to start watch stream: %v", err)
+		return
+	}
+	if err := stream.Send(&pb.ReadyTokens{Count: int32(s.tokens)}); err != nil {
+		glog.Errorf("Unable to start watch stream: %v", err)
+		return
+	}
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				glog.Errorf("Watch stream failed: %v", err)
+			}
+			return
+		}
+		event, err := decodeWatchEvent(message)
+		if err != nil {
+			glog.Errorf("Unable to decode watch event: %v", err)
+		} else if !s.send(event) {
+			return
+		}
+		if err := stream.Send(&pb.ReadyTokens{Count: 1}); err != nil {
+			glog.Errorf("Watch stream failed: %v", err)
+			return
+		}
+	}
+}
+
+// send passes the update of event on, and returns false if the source was closed first.
+func (s *FlowControlledGRPCSource) send(event Event) bool {
+	switch event.Resource {
+	case ServicesResource:
+		select {
+		case s.services <- *event.Services:
+		case <-s.ctx.Done():
+			return false
+		}
+	case EndpointsResource:
+		select {
+		case s.endpoints <- *event.Endpoints:
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// countingStream counts the messages sent on a server stream.
+type countingStream struct {
+	grpc.ServerStream
+	sent *int32
+}
+
+func (s countingStream) SendMsg(m interface{}) error {
+	atomic.AddInt32(s.sent, 1)
+	return s.ServerStream.SendMsg(m)
+}
+
+// subscribed waits until source has count subscriptions.
+func subscribed(source *SourceAPI, count int) {
+	for {
+		source.subscriptionLock.RLock()
+		n := len(source.subscriptions)
+		source.subscriptionLock.RUnlock()
+		if n == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlowControlledGRPCSource(t *testing.T) {
+	source := &SourceAPI{}
+	var sent int32
+	server := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, countingStream{stream, &sent})
+	}))
+	source.RegisterWatchServer(server)
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	remote := NewFlowControlledGRPCSource(conn, 2, services, endpoints)
+	subscribed(source, 2)
+
+	service := func(i, port int) ServiceUpdate {
+		return ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: fmt.Sprintf("foo%d", i)}, Port: port}}}
+	}
+	updates := []ServiceUpdate{service(0, 80), service(1, 81), service(2, 82), service(3, 83)}
+	// The consumer is slow, but the source is not held up: the first two updates take the
+	// tokens, and the others wait on the server, where a later update of foo3 replaces the
+	// one queued.
+	for _, update := range updates {
+		source.sendServices(update)
+	}
+	updates[3] = service(3, 93)
+	source.sendServices(updates[3])
+	// the endpoints are queued after the update of foo3 by the time this returns
+	expected := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo0"}, Endpoints: []string{"1.2.3.4:80"}}}}
+	source.sendEndpoints(expected)
+	time.Sleep(50 * time.Millisecond)
+	if sent := atomic.LoadInt32(&sent); sent != 2 {
+		t.Errorf("expected 2 updates in flight, got %d", sent)
+	}
+
+	for _, expected := range updates {
+		time.Sleep(10 * time.Millisecond)
+		if actual := <-services; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v", expected, actual)
+		}
+	}
+	if actual := <-endpoints; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// closing the source ends the stream, which cancels the subscriptions of the server
+	remote.Close()
+	subscribed(source, 0)
+}
+
+func TestWatchQueueCoalesces(t *testing.T) {
+	service := func(op Operation, id string, port int) Event {
+		return Event{Resource: ServicesResource, Services: &ServiceUpdate{Op: op, Services: []api.Service{{JSONBase: api.JSONBase{ID: id}, Port: port}}}}
+	}
+	endpoints := Event{Resource: EndpointsResource, Endpoints: &EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}}}}}
+	queue := newWatchQueue()
+	queue.push(service(ADD, "foo", 80))
+	queue.push(service(ADD, "bar", 80))
+	queue.push(endpoints)
+	// a later update of foo replaces the queued one
+	queue.push(service(ADD, "foo", 81))
+	queue.push(service(REMOVE, "bar", 80))
+	expected := []Event{service(ADD, "foo", 81), service(REMOVE, "bar", 80), endpoints}
+	for _, e := range expected {
+		if actual, ok := queue.pop(); !ok || !reflect.DeepEqual(e, actual) {
+			t.Errorf("expected %#v, got %#v", e, actual)
+		}
+	}
+	if actual, ok := queue.pop(); ok {
+		t.Errorf("expected the queue to be empty, got %#v", actual)
+	}
+
+	// a SET replaces the updates of its resource queued before it
+	set := Event{Resource: ServicesResource, Services: &ServiceUpdate{Op: SET}}
+	queue.push(service(ADD, "foo", 80))
+	queue.push(endpoints)
+	queue.push(set)
+	queue.push(service(ADD, "foo", 82))
+	expected = []Event{endpoints, set, service(ADD, "foo", 82)}
+	for _, e := range expected {
+		if actual, ok := queue.pop(); !ok || !reflect.DeepEqual(e, actual) {
+			t.Errorf("expected %#v, got %#v", e, actual)
+		}
+	}
+}
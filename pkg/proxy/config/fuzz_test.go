@@ -0,0 +1,190 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+)
+
+// fuzzTime returns a time that survives JSON, which keeps neither the monotonic clock
+// reading nor the location of a time.
+func fuzzTime(sec, nsec uint32) time.Time {
+	return time.Unix(int64(sec), int64(nsec%1e9)).UTC()
+}
+
+// validStrings reports whether values are valid UTF-8, which JSON would otherwise replace.
+func validStrings(values ...string) bool {
+	for _, value := range values {
+		if !utf8.ValidString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// roundTrip encodes in to JSON and decodes it into out.
+func roundTrip(t *testing.T, in, out interface{}) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("unable to encode %#v: %v", in, err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("unable to decode %s: %v", data, err)
+	}
+}
+
+// mergedByStore applies updates to a serviceStore, which keeps its own state, and returns
+// the services it ends up with, sorted as Merge sorts them.
+func mergedByStore(updates []ServiceUpdate) []api.Service {
+	store := newServiceStore(nil)
+	for _, update := range updates {
+		store.Merge("fuzz", update)
+	}
+	services := store.MergedState().([]api.Service)
+	sort.Sort(servicesByID(services))
+	return services
+}
+
+func FuzzServiceUpdateJSON(f *testing.F) {
+	f.Add(int(ADD), "foo", "", "10.0.0.1", 80, "TCP", uint32(1400000000), uint32(5), "api")
+	f.Add(int(REMOVE), "foo", "kube-system", "", 0, "", uint32(0), uint32(0), "")
+	f.Add(int(DRAIN)+1, "", "", "", -1, "UDP", uint32(1<<31), uint32(1<<31), " ")
+	f.Fuzz(func(t *testing.T, op int, id, namespace, clusterIP string, port int, protocol string, sec, nsec uint32, source string) {
+		if !validStrings(id, namespace, clusterIP, protocol, source) {
+			t.Skip()
+		}
+		labels := map[string]string{NamespaceLabel: namespace, ClusterIPLabel: clusterIP}
+		service := api.Service{
+			JSONBase:      api.JSONBase{ID: id, CreationTimestamp: util.Time{Time: fuzzTime(sec, nsec)}, ResourceVersion: uint64(sec)},
+			Port:          port,
+			Protocol:      protocol,
+			Labels:        labels,
+			Selector:      labels,
+			ContainerPort: util.IntOrString{Kind: port & 1, IntVal: port, StrVal: protocol},
+		}
+		update := ServiceUpdate{Op: Operation(op), Services: []api.Service{service}, Source: source, Timestamp: fuzzTime(nsec, sec)}
+
+		var decoded ServiceUpdate
+		roundTrip(t, update, &decoded)
+		if !reflect.DeepEqual(update, decoded) {
+			t.Errorf("expected %#v, got %#v", update, decoded)
+		}
+
+		updates := []ServiceUpdate{update, decoded, {Op: REMOVE, Services: []api.Service{service}}, update}
+		merged := Merge(updates)
+		if expected := mergedByStore(updates); !reflect.DeepEqual(expected, merged.Services) {
+			t.Errorf("expected %#v, got %#v", expected, merged.Services)
+		}
+	})
+}
+
+func FuzzMerge(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{byte(ADD), 0, byte(ADD), 1, byte(REMOVE), 0})
+	f.Add([]byte{byte(SET), 3, byte(SNAPSHOT_START), 2, byte(ADD), 7, byte(SET), 255})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 256 {
+			// The stores log every update, so long inputs only slow the fuzzer down.
+			t.Skip()
+		}
+		// Each pair of bytes is an update of one of a few services, which may share an ID
+		// across namespaces, and the port tells apart the versions of a service.
+		updates := []ServiceUpdate{}
+		for i := 0; i+1 < len(data); i += 2 {
+			b := data[i+1]
+			service := api.Service{
+				JSONBase: api.JSONBase{ID: fmt.Sprintf("s%d", b%4)},
+				Port:     int(b),
+				Labels:   map[string]string{NamespaceLabel: fmt.Sprintf("ns%d", b/4%2)},
+			}
+			updates = append(updates, ServiceUpdate{Op: Operation(data[i] % 6), Services: []api.Service{service}})
+		}
+
+		merged := Merge(updates)
+		if merged.Op != SET {
+			t.Errorf("expected a SET, got %s", merged.Op)
+		}
+		for i := 1; i < len(merged.Services); i++ {
+			if ServiceKey(merged.Services[i-1]) >= ServiceKey(merged.Services[i]) {
+				t.Errorf("expected unique services sorted by key, got %#v", merged.Services)
+			}
+		}
+		if expected := mergedByStore(updates); !reflect.DeepEqual(expected, merged.Services) {
+			t.Errorf("expected %#v, got %#v", expected, merged.Services)
+		}
+
+		var decoded ServiceUpdate
+		roundTrip(t, merged, &decoded)
+		if !reflect.DeepEqual(merged, decoded) {
+			t.Errorf("expected %#v, got %#v", merged, decoded)
+		}
+	})
+}
+
+func FuzzEndpointsUpdateJSON(f *testing.F) {
+	f.Add(int(ADD), "foo", "10.0.0.1:80", "TCP", "http", "10.0.0.1:8080", uint32(1400000000), uint32(5))
+	f.Add(int(SET), "foo:bar", "", "", "", "", uint32(0), uint32(0))
+	f.Add(int(REMOVE), "", "garbage", "SCTP", ":", "::1", uint32(1<<31), uint32(1<<31))
+	f.Fuzz(func(t *testing.T, op int, id, endpoint, protocol, portName, portEndpoint string, sec, nsec uint32) {
+		if !validStrings(id, endpoint, protocol, portName, portEndpoint) {
+			t.Skip()
+		}
+		endpoints := api.Endpoints{
+			JSONBase:  api.JSONBase{ID: id, CreationTimestamp: util.Time{Time: fuzzTime(sec, nsec)}},
+			Endpoints: []string{endpoint},
+		}
+		update := EndpointsUpdate{
+			Op:        Operation(op),
+			Endpoints: []api.Endpoints{endpoints},
+			Timestamp: fuzzTime(nsec, sec),
+			Protocols: map[string]string{id: protocol},
+			Ports:     map[string]map[string][]string{id: {portName: {portEndpoint}}},
+		}
+
+		var decoded EndpointsUpdate
+		roundTrip(t, update, &decoded)
+		if !reflect.DeepEqual(update, decoded) {
+			t.Errorf("expected %#v, got %#v", update, decoded)
+		}
+		update.Protocol(id)
+
+		// Named ports may collide with other IDs, which must not upset the store.
+		store := newEndpointsStore(nil)
+		for _, u := range []EndpointsUpdate{update, decoded, {Op: REMOVE, Endpoints: []api.Endpoints{endpoints}}, update} {
+			store.Merge("fuzz", u)
+		}
+		state := store.MergedState().([]api.Endpoints)
+		if update.Op == SET || update.Op == ADD {
+			found := false
+			for _, e := range state {
+				found = found || reflect.DeepEqual(e, endpoints)
+			}
+			if !found {
+				t.Errorf("expected %#v in %#v", endpoints, state)
+			}
+		}
+	})
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// HeadlessClusterIP is the ClusterIPLabel of a headless service, which has no IP address of
+// its own and is reached on the addresses of its endpoints.
+const HeadlessClusterIP = "None"
+
+// IsHeadless returns whether service is headless.
+func IsHeadless(service api.Service) bool {
+	return service.Labels[ClusterIPLabel] == HeadlessClusterIP
+}
+
+// HeadlessIDPrefix starts the ID of each synthetic service of a HeadlessExpander. It holds a
+// dot, which the name of a service cannot, so that a synthetic service cannot take the ID of
+// a real one.
+const HeadlessIDPrefix = "headless."
+
+// EndpointsGetter looks up the endpoints of a service by ID, as ConfigStore does.
+type EndpointsGetter interface {
+	GetEndpoints(id string) (api.Endpoints, bool)
+}
+
+// HeadlessExpander replaces headless services in ServiceUpdates with a synthetic service for
+// each address of their endpoints, for backends that can only proxy services with a cluster
+// IP. A synthetic service is a copy of the headless one whose ClusterIPLabel is the address,
+// and whose ID is HeadlessIDPrefix, the ID of the headless service and the address, e.g.
+// "headless.foo-10-0-0-1". Its endpoints, given by Endpoints, are those of the headless
+// service on its address. The endpoints are looked up in Store when the headless service is
+// sent, so a headless service should be sent again when its endpoints change, as a SourceAPI
+// with ExpandHeadless does. It is safe for concurrent use.
+type HeadlessExpander struct {
+	Store EndpointsGetter
+
+	lock sync.Mutex
+	// headless holds the headless services expanded, and expanded the synthetic services last
+	// sent for each of them, by ServiceKey.
+	headless map[string]api.Service
+	expanded map[string][]api.Service
+	// endpoints holds the endpoints of each synthetic service, by ServiceKey.
+	endpoints map[string]api.Endpoints
+}
+
+// NewHeadlessExpander creates a HeadlessExpander that looks up endpoints in store.
+func NewHeadlessExpander(store EndpointsGetter) *HeadlessExpander {
+	return &HeadlessExpander{Store: store}
+}
+
+// IsSynthetic returns whether service is a synthetic service of a HeadlessExpander.
+func IsSynthetic(service api.Service) bool {
+	return strings.HasPrefix(service.ID, HeadlessIDPrefix)
+}
+
+// Expand returns the updates to send in place of update. An ADD of a headless service is
+// followed by a REMOVE of the synthetic services of the addresses its endpoints no longer
+// have, and a REMOVE of a headless service removes all of its synthetic services. An ADD left
+// without services is not returned.
+func (e *HeadlessExpander) Expand(update ServiceUpdate) []ServiceUpdate {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.expanded == nil || update.Op == SET {
+		e.headless = make(map[string]api.Service)
+		e.expanded = make(map[string][]api.Service)
+		e.endpoints = make(map[string]api.Endpoints)
+	}
+	switch update.Op {
+	case SET:
+		update.Services = e.expand(update.Services, nil)
+		return []ServiceUpdate{update}
+	case ADD:
+		var stale []api.Service
+		update.Services = e.expand(update.Services, &stale)
+		var result []ServiceUpdate
+		if len(update.Services) > 0 {
+			result = append(result, update)
+		}
+		if len(stale) > 0 {
+			result = append(result, ServiceUpdate{Op: REMOVE, Services: stale, Source: update.Source, Timestamp: update.Timestamp})
+		}
+		return result
+	case REMOVE:
+		services := make([]api.Service, 0, len(update.Services))
+		for _, service := range update.Services {
+			key := ServiceKey(service)
+			synthetic, found := e.expanded[key]
+			if !found {
+				services = append(services, service)
+				continue
+			}
+			delete(e.headless, key)
+			delete(e.expanded, key)
+			for _, s := range synthetic {
+				delete(e.endpoints, ServiceKey(s))
+			}
+			services = append(services, synthetic...)
+		}
+		update.Services = services
+		return []ServiceUpdate{update}
+	}
+	return []ServiceUpdate{update}
+}
+
+// Endpoints returns the endpoints of the synthetic services of an update returned by Expand:
+// an ADD of those of a SET or ADD, and a REMOVE of those of a REMOVE. It returns false if
+// update has no synthetic services.
+func (e *HeadlessExpander) Endpoints(update ServiceUpdate) (EndpointsUpdate, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	result := EndpointsUpdate{Op: ADD, Source: update.Source, Timestamp: update.Timestamp}
+	if update.Op == REMOVE {
+		result.Op = REMOVE
+	}
+	for _, service := range update.Services {
+		if !IsSynthetic(service) {
+			continue
+		}
+		endpoints, ok := e.endpoints[ServiceKey(service)]
+		if update.Op == REMOVE {
+			endpoints, ok = api.Endpoints{JSONBase: api.JSONBase{ID: ServiceKey(service)}}, true
+		}
+		if ok {
+			result.Endpoints = append(result.Endpoints, endpoints)
+		}
+	}
+	return result, len(result.Endpoints) > 0
+}
+
+// headlessOf returns the headless services expanded whose endpoints are among endpoints.
+func (e *HeadlessExpander) headlessOf(endpoints []api.Endpoints) []api.Service {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var services []api.Service
+	for _, value := range endpoints {
+		if service, ok := e.headless[value.ID]; ok {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// withSynthetic returns endpoints with those of the synthetic services, which are part of the
+// state of the source that a SET replaces.
+func (e *HeadlessExpander) withSynthetic(endpoints []api.Endpoints) []api.Endpoints {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.endpoints) == 0 {
+		return endpoints
+	}
+	synthetic := make([]api.Endpoints, 0, len(e.endpoints))
+	for _, value := range e.endpoints {
+		synthetic = append(synthetic, value)
+	}
+	sort.Sort(endpointsByID(synthetic))
+	return append(append(make([]api.Endpoints, 0, len(endpoints)+len(synthetic)), endpoints...), synthetic...)
+}
+
+// expand replaces the headless services among services with their synthetic services and
+// records them. If stale is not nil, the synthetic services recorded before that are not
+// replaced are appended to it.
+func (e *HeadlessExpander) expand(services []api.Service, stale *[]api.Service) []api.Service {
+	result := make([]api.Service, 0, len(services))
+	for _, service := range services {
+		if !IsHeadless(service) {
+			result = append(result, service)
+			continue
+		}
+		key := ServiceKey(service)
+		synthetic, endpoints := e.synthesize(service)
+		ids := make(map[string]bool, len(synthetic))
+		for _, s := range synthetic {
+			ids[s.ID] = true
+		}
+		for _, s := range e.expanded[key] {
+			if !ids[s.ID] {
+				delete(e.endpoints, ServiceKey(s))
+				if stale != nil {
+					*stale = append(*stale, s)
+				}
+			}
+		}
+		for i, s := range synthetic {
+			e.endpoints[ServiceKey(s)] = endpoints[i]
+		}
+		e.headless[key] = service
+		e.expanded[key] = synthetic
+		result = append(result, synthetic...)
+	}
+	return result
+}
+
+// synthesize returns a synthetic service for each distinct address of the endpoints of the
+// headless service, in the order of the endpoints, and the endpoints of each.
+func (e *HeadlessExpander) synthesize(service api.Service) ([]api.Service, []api.Endpoints) {
+	endpoints, ok := e.Store.GetEndpoints(ServiceKey(service))
+	if !ok {
+		return nil, nil
+	}
+	var synthetic []api.Service
+	var syntheticEndpoints []api.Endpoints
+	index := make(map[string]int)
+	for _, endpoint := range endpoints.Endpoints {
+		ip := endpointIP(endpoint)
+		if ip == nil {
+			continue
+		}
+		if i, seen := index[ip.String()]; seen {
+			syntheticEndpoints[i].Endpoints = append(syntheticEndpoints[i].Endpoints, endpoint)
+			continue
+		}
+		index[ip.String()] = len(synthetic)
+		s := service
+		s.ID = HeadlessIDPrefix + service.ID + "-" + strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+		labels := make(map[string]string, len(service.Labels))
+		for k, v := range service.Labels {
+			labels[k] = v
+		}
+		labels[ClusterIPLabel] = ip.String()
+		s.Labels = labels
+		synthetic = append(synthetic, s)
+		syntheticEndpoints = append(syntheticEndpoints, api.Endpoints{JSONBase: api.JSONBase{ID: ServiceKey(s)}, Endpoints: []string{endpoint}})
+	}
+	return synthetic, syntheticEndpoints
+}
+
+// endpointIP returns the address of endpoint, which may or may not have a port, or nil if it
+// is not an IP address.
+func endpointIP(endpoint string) net.IP {
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		endpoint = host
+	}
+	return net.ParseIP(endpoint)
+}
+
+// sentEndpoints is the EndpointsGetter of the endpoints a SourceAPI last sent.
+type sentEndpoints struct {
+	s *SourceAPI
+}
+
+func (g sentEndpoints) GetEndpoints(id string) (api.Endpoints, bool) {
+	g.s.protocolLock.Lock()
+	defer g.s.protocolLock.Unlock()
+	entry, ok := g.s.sentEndpoints[id]
+	return entry.endpoints, ok
+}
+
+// expandHeadless expands the headless services of update with the endpoints the source sent.
+func (s *SourceAPI) expandHeadless(update ServiceUpdate) []ServiceUpdate {
+	s.headless.lock.Lock()
+	if s.headless.Store == nil {
+		s.headless.Store = sentEndpoints{s}
+	}
+	s.headless.lock.Unlock()
+	return s.headless.Expand(update)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func synthetic(id, namespace, ip string) api.Service {
+	labels := map[string]string{ClusterIPLabel: ip}
+	if namespace != "" {
+		labels[NamespaceLabel] = namespace
+	}
+	return api.Service{JSONBase: api.JSONBase{ID: id}, Port: 80, Labels: labels}
+}
+
+func TestHeadlessExpander(t *testing.T) {
+	store := NewConfigStore()
+	store.UpdateEndpoints("one", EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{
+		// the same address on two ports is one service, and an invalid one is none
+		{JSONBase: api.JSONBase{ID: "a/foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.1:81", "bad:80"}},
+		{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"[2001:db8::1]:80"}},
+	}})
+	expander := NewHeadlessExpander(store)
+
+	foo := synthetic("foo", "a", HeadlessClusterIP)
+	bar := synthetic("bar", "", HeadlessClusterIP)
+	baz := synthetic("baz", "", "10.1.0.1")
+	// headless, but without endpoints yet
+	qux := synthetic("qux", "", HeadlessClusterIP)
+	actual := expander.Expand(ServiceUpdate{Op: SET, Services: []api.Service{foo, bar, baz, qux}})
+	expected := []ServiceUpdate{{Op: SET, Services: []api.Service{
+		synthetic("headless.foo-10-0-0-1", "a", "10.0.0.1"),
+		synthetic("headless.foo-10-0-0-2", "a", "10.0.0.2"),
+		synthetic("headless.bar-2001-db8--1", "", "2001:db8::1"),
+		baz,
+	}}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if foo.Labels[ClusterIPLabel] != HeadlessClusterIP {
+		t.Errorf("expected the labels of the headless service to be left as they are, got %#v", foo.Labels)
+	}
+	// each synthetic service has the endpoints of its address
+	endpoints, ok := expander.Endpoints(actual[0])
+	expectedEndpoints := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "a/headless.foo-10-0-0-1"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.1:81"}},
+		{JSONBase: api.JSONBase{ID: "a/headless.foo-10-0-0-2"}, Endpoints: []string{"10.0.0.2:80"}},
+		{JSONBase: api.JSONBase{ID: "headless.bar-2001-db8--1"}, Endpoints: []string{"[2001:db8::1]:80"}},
+	}}
+	if !ok || !reflect.DeepEqual(expectedEndpoints, endpoints) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, endpoints)
+	}
+
+	// an address that is gone is removed
+	store.UpdateEndpoints("one", EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "a/foo"}, Endpoints: []string{"10.0.0.2:80", "10.0.0.3:80"}},
+	}})
+	actual = expander.Expand(ServiceUpdate{Op: ADD, Services: []api.Service{foo}})
+	expected = []ServiceUpdate{
+		{Op: ADD, Services: []api.Service{synthetic("headless.foo-10-0-0-2", "a", "10.0.0.2"), synthetic("headless.foo-10-0-0-3", "a", "10.0.0.3")}},
+		{Op: REMOVE, Services: []api.Service{synthetic("headless.foo-10-0-0-1", "a", "10.0.0.1")}},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	endpoints, _ = expander.Endpoints(actual[1])
+	expectedEndpoints = EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "a/headless.foo-10-0-0-1"}}}}
+	if !reflect.DeepEqual(expectedEndpoints, endpoints) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, endpoints)
+	}
+
+	// an ADD without synthetic services is not sent
+	if actual := expander.Expand(ServiceUpdate{Op: ADD, Services: []api.Service{qux}}); len(actual) != 0 {
+		t.Errorf("expected no updates, got %#v", actual)
+	}
+
+	actual = expander.Expand(ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Labels: map[string]string{NamespaceLabel: "a"}}, baz}})
+	expected = []ServiceUpdate{{Op: REMOVE, Services: []api.Service{
+		synthetic("headless.foo-10-0-0-2", "a", "10.0.0.2"),
+		synthetic("headless.foo-10-0-0-3", "a", "10.0.0.3"),
+		baz,
+	}}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestSourceExpandHeadless(t *testing.T) {
+	services := make(chan ServiceUpdate, 10)
+	endpoints := make(chan EndpointsUpdate, 10)
+	source := SourceAPI{services: services, endpoints: endpoints}
+	source.ExpandHeadless = true
+	source.ServiceTransformers = []ServiceTransformer{ClusterIPNormalizer}
+
+	foo := synthetic("foo", "", HeadlessClusterIP)
+	source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo}})
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// the synthetic services follow the endpoints of the headless service
+	fooEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{fooEndpoints}})
+	<-endpoints
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{synthetic("headless.foo-10-0-0-1", "", "10.0.0.1")}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	synthetic1 := api.Endpoints{JSONBase: api.JSONBase{ID: "headless.foo-10-0-0-1"}, Endpoints: []string{"10.0.0.1:80"}}
+	expectedEndpoints := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{synthetic1}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	// the synthetic endpoints are part of each SET of endpoints
+	source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{fooEndpoints}})
+	expectedEndpoints = EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{fooEndpoints, synthetic1}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+	<-services
+	<-endpoints
+
+	// once the endpoints are gone so are the synthetic services
+	source.sendEndpoints(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{fooEndpoints}})
+	<-endpoints
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{synthetic("headless.foo-10-0-0-1", "", "10.0.0.1")}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints = EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "headless.foo-10-0-0-1"}}}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+}
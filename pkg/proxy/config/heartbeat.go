@@ -0,0 +1,66 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+// Heartbeat is sent on the Heartbeats channel of a SourceAPI to show that a watch is still
+// established, even if nothing has changed.
+type Heartbeat struct {
+	Resource ResourceType
+	// ResourceVersion is the version the watch will resume from.
+	ResourceVersion uint64
+	Timestamp       time.Time
+}
+
+// startHeartbeats sends a Heartbeat for resource every HeartbeatInterval until the returned
+// function is called. Once that returns no more heartbeats are sent; calling it again does
+// nothing.
+func (s *SourceAPI) startHeartbeats(resource ResourceType, resourceVersion *versionTracker) func() {
+	if s.HeartbeatInterval <= 0 || s.Heartbeats == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			timer := s.clock().NewTimer(s.HeartbeatInterval)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case now := <-timer.C():
+				select {
+				case s.Heartbeats <- Heartbeat{Resource: resource, ResourceVersion: resourceVersion.Get(), Timestamp: now}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
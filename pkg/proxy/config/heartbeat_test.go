@@ -0,0 +1,75 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// failureReporter is a HealthReporter that sends the failures it is told about on a channel.
+type failureReporter chan error
+
+func (r failureReporter) ReportSuccess()          {}
+func (r failureReporter) ReportFailure(err error) { r <- err }
+
+func TestHeartbeats(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	clock := newFakeClock()
+	heartbeats := make(chan Heartbeat)
+	failures := make(failureReporter, 1)
+	source := SourceAPI{client: fakeClient, waitDuration: time.Minute}
+	source.Clock = clock
+	source.Health = failures
+	source.HeartbeatInterval = 10 * time.Second
+	source.Heartbeats = heartbeats
+	source.serviceVersion.Set(1)
+	ch := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(ch)
+	}()
+
+	// a heartbeat every interval while the watch is established
+	for i := 1; i <= 2; i++ {
+		clock.BlockUntil(t, 1)
+		clock.Step(10 * time.Second)
+		heartbeat := <-heartbeats
+		expected := Heartbeat{Resource: ServicesResource, ResourceVersion: 1, Timestamp: time.Unix(int64(10*i), 0)}
+		if heartbeat != expected {
+			t.Errorf("expected %#v, got %#v", expected, heartbeat)
+		}
+	}
+
+	// none once the watch fails
+	fakeWatch.Error(&api.Status{Message: "too old resource version", Code: 410})
+	<-failures
+	clock.Step(10 * time.Second)
+	select {
+	case heartbeat := <-heartbeats:
+		t.Errorf("unexpected heartbeat after a watch error %#v", heartbeat)
+	default:
+	}
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Minute)
+	<-ch
+}
@@ -0,0 +1,354 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	"github.com/golang/glog"
+)
+
+// apiPrefix is the path under which the apiserver exposes services and endpoints.
+const apiPrefix = "/api/v1beta1"
+
+// HTTPWatcher implements Watcher and WatchStreamer by talking to the apiserver over HTTP.
+type HTTPWatcher struct {
+	host   string
+	client *http.Client
+	codec  runtime.Codec
+	// token, if set, is sent as a bearer token with every request.
+	token string
+	// fallbackNamespace is the namespace requests are limited to once a request across
+	// namespaces is forbidden, and scope holds the one they are limited to, if any. It is
+	// shared with the copies made by WithContext.
+	fallbackNamespace string
+	scope             *namespaceScope
+	// sendInitialEvents asks watches from resource version 0 to start with the existing
+	// objects.
+	sendInitialEvents bool
+	// ctx, if set, aborts the requests of w once it is done.
+	ctx context.Context
+}
+
+// namespaceScope is the namespace the requests of an HTTPWatcher are limited to.
+type namespaceScope struct {
+	lock      sync.Mutex
+	namespace string
+}
+
+// NewHTTPWatcher creates an HTTPWatcher for the apiserver at host (e.g. "http://127.0.0.1:8080").
+// If client is nil, http.DefaultClient is used.
+func NewHTTPWatcher(host string, client *http.Client) *HTTPWatcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPWatcher{
+		host:   host,
+		client: client,
+		codec:  runtime.DefaultCodec,
+		scope:  &namespaceScope{},
+	}
+}
+
+// WithContext returns a copy of w whose requests are aborted once ctx is done.
+func (w *HTTPWatcher) WithContext(ctx context.Context) Watcher {
+	copy := *w
+	copy.ctx = ctx
+	return &copy
+}
+
+// MultiplexHTTP2 makes w send all of its requests as streams of a single HTTP/2 connection.
+// HTTP/2 is negotiated with TLS for https hosts and assumed with prior knowledge for http hosts,
+// so the apiserver must support it. It must be called before w is used.
+func (w *HTTPWatcher) MultiplexHTTP2() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if t, ok := w.client.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	transport.MaxConnsPerHost = 1
+	client := *w.client
+	client.Transport = transport
+	w.client = &client
+}
+
+// UseCodec makes w decode the lists and watch events it receives with codec in place of
+// runtime.DefaultCodec. It must be called before w is used.
+func (w *HTTPWatcher) UseCodec(codec runtime.Codec) {
+	w.codec = codec
+}
+
+// SendInitialEvents makes w ask the apiserver to start each watch from resource version 0 with
+// the existing objects as ADDED events (sendInitialEvents=true), in place of a list. It must be
+// called before w is used.
+func (w *HTTPWatcher) SendInitialEvents() {
+	w.sendInitialEvents = true
+}
+
+// serviceAccountNamespaceFile holds the namespace of the service account of a pod.
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// serviceAccountNamespace returns the namespace of the pod the proxy runs in.
+func serviceAccountNamespace() (string, error) {
+	data, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", err
+	}
+	namespace := strings.TrimSpace(string(data))
+	if namespace == "" {
+		return "", fmt.Errorf("%s is empty", serviceAccountNamespaceFile)
+	}
+	return namespace, nil
+}
+
+// FallbackToNamespace makes w limit its requests to namespace from the first time a request
+// across all namespaces is forbidden, as it is to a proxy only permitted to see the services
+// of its own namespace. The forbidden request is retried in namespace. Each relist tries
+// across namespaces again, so w stops limiting its requests once that is permitted. It must
+// be called before w is used.
+func (w *HTTPWatcher) FallbackToNamespace(namespace string) {
+	w.fallbackNamespace = namespace
+}
+
+// decoder returns the codec of w, which puts the objects it decodes that do not say which
+// namespace they are in in the namespace the requests of w are limited to.
+func (w *HTTPWatcher) decoder() runtime.Codec {
+	return namespaceCodec{w.codec, w.currentNamespace()}
+}
+
+// currentNamespace returns the namespace requests are limited to, or "" for all of them.
+func (w *HTTPWatcher) currentNamespace() string {
+	w.scope.lock.Lock()
+	defer w.scope.lock.Unlock()
+	return w.scope.namespace
+}
+
+// fallBack limits the requests of w to fallbackNamespace, after a request for path was
+// forbidden.
+func (w *HTTPWatcher) fallBack(path string) {
+	w.scope.lock.Lock()
+	defer w.scope.lock.Unlock()
+	if w.scope.namespace == "" {
+		glog.Warningf("Request for %s across namespaces is forbidden, falling back to namespace %s: services of other namespaces are not proxied", path, w.fallbackNamespace)
+		w.scope.namespace = w.fallbackNamespace
+	}
+}
+
+// widen stops limiting the requests of w to a namespace, after a request for path across
+// namespaces was permitted again.
+func (w *HTTPWatcher) widen(path string) {
+	w.scope.lock.Lock()
+	defer w.scope.lock.Unlock()
+	if w.scope.namespace != "" {
+		glog.Infof("Request for %s across namespaces is permitted again, proxying the services of all namespaces", path)
+		w.scope.namespace = ""
+	}
+}
+
+// ListServices lists the services matching label.
+func (w *HTTPWatcher) ListServices(label labels.Selector) (*api.ServiceList, error) {
+	services := &api.ServiceList{}
+	if err := w.list("services", label, services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// ListEndpoints lists the endpoints matching label.
+func (w *HTTPWatcher) ListEndpoints(label labels.Selector) (*api.EndpointsList, error) {
+	endpoints := &api.EndpointsList{}
+	if err := w.list("endpoints", label, endpoints); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// WatchServices watches services starting at resourceVersion.
+func (w *HTTPWatcher) WatchServices(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return w.watch("services", label, field, resourceVersion)
+}
+
+// WatchEndpoints watches endpoints starting at resourceVersion.
+func (w *HTTPWatcher) WatchEndpoints(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return w.watch("endpoints", label, field, resourceVersion)
+}
+
+// StreamServices opens a watch on services and returns the raw response.
+func (w *HTTPWatcher) StreamServices(label, field labels.Selector, resourceVersion uint64) (*http.Response, error) {
+	return w.stream("services", label, field, resourceVersion)
+}
+
+// StreamEndpoints opens a watch on endpoints and returns the raw response.
+func (w *HTTPWatcher) StreamEndpoints(label, field labels.Selector, resourceVersion uint64) (*http.Response, error) {
+	return w.stream("endpoints", label, field, resourceVersion)
+}
+
+// Healthz checks that the apiserver reports itself healthy.
+func (w *HTTPWatcher) Healthz() error {
+	resp, err := w.get("/healthz", url.Values{}, scopeNone)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListServicesPage lists up to limit services matching label, starting where the page that
+// returned continueToken ended, and returns the token of the next page.
+func (w *HTTPWatcher) ListServicesPage(label labels.Selector, limit int, continueToken string) (*api.ServiceList, string, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if continueToken != "" {
+		query.Set("continue", continueToken)
+	}
+	services := &api.ServiceList{}
+	scope := scopeRetryCluster
+	if continueToken != "" {
+		scope = scopeCurrent
+	}
+	data, err := w.listData("services", label, query, scope)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := w.decoder().DecodeInto(data, services); err != nil {
+		return nil, "", err
+	}
+	// The token is not part of api.ServiceList.
+	var page struct {
+		Continue string `json:"continue"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, "", err
+	}
+	return services, page.Continue, nil
+}
+
+func (w *HTTPWatcher) list(resource string, label labels.Selector, into runtime.Object) error {
+	data, err := w.listData(resource, label, url.Values{}, scopeRetryCluster)
+	if err != nil {
+		return err
+	}
+	return w.decoder().DecodeInto(data, into)
+}
+
+// listData returns the body of a list of resource matching label, with the parameters of query,
+// limited to a namespace as scope says.
+func (w *HTTPWatcher) listData(resource string, label labels.Selector, query url.Values, scope requestScope) ([]byte, error) {
+	query.Set("labels", label.String())
+	resp, err := w.get(apiPrefix+"/"+resource, query, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (w *HTTPWatcher) watch(resource string, label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	resp, err := w.stream(resource, label, field, resourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	return watch.NewStreamWatcher(newWatchDecoder(resp.Header.Get("Content-Type"), resp.Body, w.decoder())), nil
+}
+
+func (w *HTTPWatcher) stream(resource string, label, field labels.Selector, resourceVersion uint64) (*http.Response, error) {
+	query := url.Values{}
+	query.Set("labels", label.String())
+	query.Set("fields", field.String())
+	query.Set("resourceVersion", strconv.FormatUint(resourceVersion, 10))
+	query.Set("allowWatchBookmarks", "true")
+	if w.sendInitialEvents && resourceVersion == 0 {
+		query.Set("sendInitialEvents", "true")
+		query.Set("resourceVersionMatch", "NotOlderThan")
+	}
+	return w.get(apiPrefix+"/watch/"+resource, query, scopeCurrent)
+}
+
+// requestScope is how get limits a request to a namespace.
+type requestScope int
+
+const (
+	// scopeNone never limits the request, as for /healthz.
+	scopeNone requestScope = iota
+	// scopeCurrent limits the request to the current namespace, if any, as for watches and
+	// the next pages of a list.
+	scopeCurrent
+	// scopeRetryCluster tries the request across namespaces again, as for the list starting
+	// a relist, so that a proxy whose permissions were widened proxies all services again.
+	scopeRetryCluster
+)
+
+// get issues a GET for path and returns the response if the server answered with 200 OK.
+// The request is limited to a namespace as scope says. If the fallback namespace is set, a
+// request that is forbidden across namespaces is retried in it.
+func (w *HTTPWatcher) get(path string, query url.Values, scope requestScope) (*http.Response, error) {
+	namespace := ""
+	if scope == scopeCurrent {
+		namespace = w.currentNamespace()
+	}
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	resp, err := w.send(path, query)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusForbidden && scope != scopeNone && namespace == "" && w.fallbackNamespace != "" {
+		resp.Body.Close()
+		w.fallBack(path)
+		query.Set("namespace", w.fallbackNamespace)
+		if resp, err = w.send(path, query); err != nil {
+			return nil, err
+		}
+	} else if resp.StatusCode == http.StatusOK && scope == scopeRetryCluster {
+		w.widen(path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("request for %s failed: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// send issues a GET for path with query, whatever the status of the response.
+func (w *HTTPWatcher) send(path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", w.host+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if w.ctx != nil {
+		req = req.WithContext(w.ctx)
+	}
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	return w.client.Do(req)
+}
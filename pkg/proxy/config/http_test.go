@@ -0,0 +1,390 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+)
+
+func TestMultiplexHTTP2(t *testing.T) {
+	var lock sync.Mutex
+	connections := 0
+	requests := 0
+	// each stream is held open until both have been opened, so they must share the connection
+	bothOpen := make(chan struct{})
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2, got %s", req.Proto)
+		}
+		switch req.URL.Path {
+		case apiPrefix + "/watch/services":
+			fmt.Fprint(w, `{"type":"ADDED","object":{"kind":"Service","id":"foo","resourceVersion":2}}`)
+		case apiPrefix + "/watch/endpoints":
+			fmt.Fprint(w, `{"type":"ADDED","object":{"kind":"Endpoints","id":"bar","resourceVersion":3}}`)
+		default:
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+		w.(http.Flusher).Flush()
+		lock.Lock()
+		requests++
+		if requests == 2 {
+			close(bothOpen)
+		}
+		lock.Unlock()
+		<-bothOpen
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			connections++
+			lock.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	watcher := NewHTTPWatcher(server.URL, nil)
+	watcher.MultiplexHTTP2()
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: watcher, services: services, endpoints: endpoints}
+	source.serviceVersion.Set(1)
+	source.endpointsVersion.Set(1)
+	go source.runServices()
+	go source.runEndpoints()
+
+	expectedServices := ServiceUpdate{Op: ADD, Services: []api.Service{
+		{JSONBase: api.JSONBase{Kind: "Service", ID: "foo", ResourceVersion: 2}},
+	}}
+	if actual := <-services; !reflect.DeepEqual(expectedServices, actual) {
+		t.Errorf("expected %#v, got %#v", expectedServices, actual)
+	}
+	expectedEndpoints := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{Kind: "Endpoints", ID: "bar", ResourceVersion: 3}},
+	}}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	<-bothOpen
+	lock.Lock()
+	defer lock.Unlock()
+	if connections != 1 {
+		t.Errorf("expected 1 connection, got %d", connections)
+	}
+}
+
+func TestPreReconnectHealthCheck(t *testing.T) {
+	var lock sync.Mutex
+	checks := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/healthz" {
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		checks++
+		if checks <= 2 {
+			http.Error(w, "etcd unavailable", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil)}
+	source.Clock = clock
+	source.PreReconnectHealthCheck = true
+	done := make(chan struct{})
+	go func() {
+		source.waitForHealthy(source.client.(HealthChecker))
+		close(done)
+	}()
+
+	// the failed checks are retried after 1s, then after 2s
+	clock.BlockUntil(t, 1)
+	clock.Step(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatalf("expected to wait for the apiserver to be healthy")
+	default:
+	}
+	clock.Step(time.Millisecond)
+	clock.BlockUntil(t, 1)
+	clock.Step(2 * time.Second)
+	<-done
+
+	lock.Lock()
+	defer lock.Unlock()
+	if checks != 3 {
+		t.Errorf("expected 3 health checks, got %d", checks)
+	}
+}
+
+func TestListServicesPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != apiPrefix+"/services" || req.URL.Query().Get("limit") != "1" {
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+		switch req.URL.Query().Get("continue") {
+		case "":
+			fmt.Fprint(w, `{"kind":"ServiceList","resourceVersion":5,"items":[{"id":"foo"}],"continue":"next"}`)
+		case "next":
+			fmt.Fprint(w, `{"kind":"ServiceList","items":[{"id":"bar"}]}`)
+		default:
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+	}))
+	defer server.Close()
+
+	watcher := NewHTTPWatcher(server.URL, nil)
+	source := SourceAPI{client: watcher}
+	source.ListPageSize = 1
+	list, err := source.listServices(watcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &api.ServiceList{
+		JSONBase: api.JSONBase{Kind: "ServiceList", ResourceVersion: 5},
+		Items:    []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}, {JSONBase: api.JSONBase{ID: "bar"}}},
+	}
+	if !reflect.DeepEqual(expected, list) {
+		t.Errorf("expected %#v, got %#v", expected, list)
+	}
+}
+
+func TestHTTPWatcherNamespaces(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case apiPrefix + "/services":
+			fmt.Fprint(w, `{"kind":"ServiceList","resourceVersion":1,"items":[`+
+				`{"id":"foo","namespace":"a","port":80,"protocol":"TCP"},`+
+				`{"id":"foo","metadata":{"namespace":"b"},"port":81,"protocol":"UDP"}]}`)
+		case apiPrefix + "/endpoints":
+			fmt.Fprint(w, `{"kind":"EndpointsList","resourceVersion":1,"items":[`+
+				`{"id":"foo","namespace":"a","endpoints":["10.0.0.1:80"]},`+
+				`{"id":"foo","namespace":"b","endpoints":["10.0.0.2:81"]}]}`)
+		case apiPrefix + "/watch/services":
+			fmt.Fprint(w, `{"type":"MODIFIED","object":{"kind":"Service","id":"foo","namespace":"b","port":82,"protocol":"UDP","resourceVersion":2}}`+"\n")
+			w.(http.Flusher).Flush()
+			<-done
+		default:
+			<-done
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{client: NewHTTPWatcher(server.URL, nil), services: services, endpoints: endpoints}
+	go source.runServices()
+
+	// services of the same name in different namespaces are both kept
+	service := func(namespace string, port int, protocol string) api.Service {
+		return api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: port, Protocol: protocol, Labels: map[string]string{NamespaceLabel: namespace}}
+	}
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{service("a", 80, "TCP"), service("b", 81, "UDP")}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	modified := service("b", 82, "UDP")
+	modified.Kind = "Service"
+	modified.ResourceVersion = 2
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{modified}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// and so are their endpoints, with the protocol of each service
+	go source.runEndpoints()
+	expectedEndpoints := EndpointsUpdate{
+		Op: SET,
+		Endpoints: []api.Endpoints{
+			{JSONBase: api.JSONBase{ID: "a/foo"}, Endpoints: []string{"10.0.0.1:80"}},
+			{JSONBase: api.JSONBase{ID: "b/foo"}, Endpoints: []string{"10.0.0.2:81"}},
+		},
+		Protocols: map[string]string{"a/foo": "TCP", "b/foo": "UDP"},
+	}
+	if actual := <-endpoints; !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+}
+
+func TestNamespaceFallback(t *testing.T) {
+	var lock sync.Mutex
+	forbidden := 0
+	permitted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/healthz" {
+			if req.URL.Query().Get("namespace") != "" {
+				t.Errorf("unexpected namespace for health check: %v", req.URL)
+			}
+			fmt.Fprint(w, "ok")
+			return
+		}
+		switch req.URL.Query().Get("namespace") {
+		case "":
+			lock.Lock()
+			defer lock.Unlock()
+			if permitted {
+				fmt.Fprint(w, `{"kind":"ServiceList","items":[{"id":"foo"},{"id":"bar"}]}`)
+				return
+			}
+			forbidden++
+			http.Error(w, "services is forbidden at the cluster scope", http.StatusForbidden)
+		case "proxy":
+			fmt.Fprint(w, `{"kind":"ServiceList","items":[{"id":"foo"}]}`)
+		default:
+			t.Errorf("unexpected request: %v", req.URL)
+		}
+	}))
+	defer server.Close()
+
+	// without a fallback the request fails
+	if _, err := NewHTTPWatcher(server.URL, nil).ListServices(labels.Everything()); err == nil {
+		t.Errorf("expected a forbidden request to fail")
+	}
+
+	watcher := NewHTTPWatcher(server.URL, nil)
+	watcher.FallbackToNamespace("proxy")
+	for i := 0; i < 2; i++ {
+		services, err := watcher.ListServices(labels.Everything())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(services.Items) != 1 || services.Items[0].ID != "foo" {
+			t.Errorf("expected service foo, got %#v", services.Items)
+		}
+	}
+	if err := watcher.Healthz(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if namespace := watcher.currentNamespace(); namespace != "proxy" {
+		t.Errorf("expected requests limited to proxy, got %q", namespace)
+	}
+	lock.Lock()
+	// once by the watcher without a fallback, then once by each list
+	if forbidden != 3 {
+		t.Errorf("expected 3 forbidden requests, got %d", forbidden)
+	}
+	permitted = true
+	lock.Unlock()
+
+	// the next list across namespaces is permitted and widens the scope again
+	services, err := watcher.ListServices(labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services.Items) != 2 {
+		t.Errorf("expected services across namespaces, got %#v", services.Items)
+	}
+	if namespace := watcher.currentNamespace(); namespace != "" {
+		t.Errorf("expected requests across namespaces, got %q", namespace)
+	}
+}
+
+func TestNamespaceFallbackOption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-serviceaccount")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { serviceAccountNamespaceFile = file }(serviceAccountNamespaceFile)
+	serviceAccountNamespaceFile = filepath.Join(dir, "namespace")
+	if err := ioutil.WriteFile(serviceAccountNamespaceFile, []byte("proxy\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	watcher := NewHTTPWatcher("http://127.0.0.1:1", nil)
+	// the source is never started
+	options := SourceAPIOptions{NamespaceFallback: true, StartBarrier: make(chan struct{})}
+	source := NewSourceAPIWithOptions(watcher, time.Minute, nil, nil, options)
+	defer source.Close()
+	if watcher.fallbackNamespace != "proxy" {
+		t.Errorf("expected fallback to namespace proxy, got %q", watcher.fallbackNamespace)
+	}
+}
+
+func TestHTTPWatcherSendInitialEvents(t *testing.T) {
+	queries := make(chan url.Values, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries <- req.URL.Query()
+	}))
+	defer server.Close()
+
+	watcher := NewHTTPWatcher(server.URL, nil)
+	watcher.SendInitialEvents()
+	// only a watch from the start asks for the existing objects
+	expected := map[uint64]string{0: "true", 5: ""}
+	for resourceVersion, sendInitialEvents := range expected {
+		resp, err := watcher.StreamServices(labels.Everything(), labels.Everything(), resourceVersion)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if actual := (<-queries).Get("sendInitialEvents"); actual != sendInitialEvents {
+			t.Errorf("resource version %d: expected sendInitialEvents %q, got %q", resourceVersion, sendInitialEvents, actual)
+		}
+	}
+}
+
+func TestHTTPWatcherWithContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := NewHTTPWatcher(server.URL, nil).WithContext(ctx)
+	errs := make(chan error)
+	go func() {
+		_, err := watcher.ListServices(labels.Everything())
+		errs <- err
+	}()
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the list to be aborted, got %v", err)
+	}
+}
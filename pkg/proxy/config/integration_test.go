@@ -0,0 +1,340 @@
+//go:build integration
+// +build integration
+
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The tests in this file run SourceAPI against a real apiserver started by envtest. They need
+// the apiserver and etcd binaries in $KUBEBUILDER_ASSETS and are run with
+//   go test -tags integration github.com/vishvananda/wormhole/pkg/proxy/config
+
+package config
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	kwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const integrationNamespace = "default"
+
+// kubeWatcher is a Watcher on the v1 API of a current apiserver. SourceAPI tracks the next
+// resource version it wants to see, while the v1 API watches from the last version seen, so
+// versions are shifted by one in both directions.
+type kubeWatcher struct {
+	client *kubernetes.Clientset
+
+	lock    sync.Mutex
+	lists   int
+	watches []kwatch.Interface
+}
+
+func (w *kubeWatcher) ListServices(label labels.Selector) (*api.ServiceList, error) {
+	w.countList()
+	list, err := w.client.CoreV1().Services(integrationNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	services := &api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: nextVersion(list.ResourceVersion)}}
+	for i := range list.Items {
+		services.Items = append(services.Items, *convertService(&list.Items[i]))
+	}
+	return services, nil
+}
+
+func (w *kubeWatcher) ListEndpoints(label labels.Selector) (*api.EndpointsList, error) {
+	w.countList()
+	list, err := w.client.CoreV1().Endpoints(integrationNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	endpoints := &api.EndpointsList{JSONBase: api.JSONBase{ResourceVersion: nextVersion(list.ResourceVersion)}}
+	for i := range list.Items {
+		endpoints.Items = append(endpoints.Items, *convertEndpoints(&list.Items[i]))
+	}
+	return endpoints, nil
+}
+
+func (w *kubeWatcher) WatchServices(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	source, err := w.client.CoreV1().Services(integrationNamespace).Watch(context.Background(), watchOptions(resourceVersion))
+	if err != nil {
+		return nil, err
+	}
+	return w.convertWatch(source, func(obj kruntime.Object) runtime.Object {
+		return convertService(obj.(*corev1.Service))
+	}), nil
+}
+
+func (w *kubeWatcher) WatchEndpoints(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	source, err := w.client.CoreV1().Endpoints(integrationNamespace).Watch(context.Background(), watchOptions(resourceVersion))
+	if err != nil {
+		return nil, err
+	}
+	return w.convertWatch(source, func(obj kruntime.Object) runtime.Object {
+		return convertEndpoints(obj.(*corev1.Endpoints))
+	}), nil
+}
+
+func (w *kubeWatcher) countList() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.lists++
+}
+
+// StopWatches ends every open watch, as if the apiserver had closed them.
+func (w *kubeWatcher) StopWatches() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, source := range w.watches {
+		source.Stop()
+	}
+	w.watches = nil
+}
+
+// Counts returns the number of lists and of open watches.
+func (w *kubeWatcher) Counts() (int, int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.lists, len(w.watches)
+}
+
+// convertWatch passes on the events of source with their objects converted by convert.
+func (w *kubeWatcher) convertWatch(source kwatch.Interface, convert func(kruntime.Object) runtime.Object) watch.Interface {
+	w.lock.Lock()
+	w.watches = append(w.watches, source)
+	w.lock.Unlock()
+	result := watch.NewFake()
+	go func() {
+		defer result.Stop()
+		for event := range source.ResultChan() {
+			switch event.Type {
+			case kwatch.Added, kwatch.Modified, kwatch.Deleted:
+				result.Action(watch.EventType(event.Type), convert(event.Object))
+			}
+		}
+	}()
+	return result
+}
+
+func watchOptions(resourceVersion uint64) metav1.ListOptions {
+	return metav1.ListOptions{ResourceVersion: strconv.FormatUint(resourceVersion-1, 10)}
+}
+
+func nextVersion(resourceVersion string) uint64 {
+	version, _ := strconv.ParseUint(resourceVersion, 10, 64)
+	return version + 1
+}
+
+func convertService(service *corev1.Service) *api.Service {
+	version, _ := strconv.ParseUint(service.ResourceVersion, 10, 64)
+	result := &api.Service{
+		JSONBase: api.JSONBase{ID: service.Name, ResourceVersion: version},
+		Labels:   service.Labels,
+		Selector: service.Spec.Selector,
+	}
+	if len(service.Spec.Ports) > 0 {
+		result.Port = int(service.Spec.Ports[0].Port)
+		result.Protocol = string(service.Spec.Ports[0].Protocol)
+	}
+	return result
+}
+
+func convertEndpoints(endpoints *corev1.Endpoints) *api.Endpoints {
+	version, _ := strconv.ParseUint(endpoints.ResourceVersion, 10, 64)
+	result := &api.Endpoints{JSONBase: api.JSONBase{ID: endpoints.Name, ResourceVersion: version}}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			for _, port := range subset.Ports {
+				result.Endpoints = append(result.Endpoints, net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	return result
+}
+
+// startAPIServer starts an apiserver and returns a client for it and a function stopping it.
+func startAPIServer(t *testing.T) (*kubernetes.Clientset, func()) {
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("unable to start apiserver: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		env.Stop()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return client, func() { env.Stop() }
+}
+
+func createService(t *testing.T, client *kubernetes.Clientset, name string, port int32, ip string) {
+	ctx := context.Background()
+	_, err := client.CoreV1().Services(integrationNamespace).Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: port, Protocol: corev1.ProtocolTCP}}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create service %s: %v", name, err)
+	}
+	_, err = client.CoreV1().Endpoints(integrationNamespace).Create(ctx, &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: ip}},
+			Ports:     []corev1.EndpointPort{{Port: port}},
+		}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create endpoints %s: %v", name, err)
+	}
+}
+
+func deleteService(t *testing.T, client *kubernetes.Clientset, name string) {
+	ctx := context.Background()
+	if err := client.CoreV1().Services(integrationNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unable to delete service %s: %v", name, err)
+	}
+	if err := client.CoreV1().Endpoints(integrationNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unable to delete endpoints %s: %v", name, err)
+	}
+}
+
+// nextServiceUpdate returns the next update that is not about the kubernetes service, which the
+// apiserver creates itself.
+func nextServiceUpdate(t *testing.T, services <-chan ServiceUpdate) ServiceUpdate {
+	for {
+		select {
+		case update := <-services:
+			var filtered []api.Service
+			for _, service := range update.Services {
+				if service.ID != "kubernetes" {
+					service.JSONBase = api.JSONBase{ID: service.ID}
+					filtered = append(filtered, service)
+				}
+			}
+			if len(filtered) == 0 && update.Op != SET {
+				continue
+			}
+			update.Services = filtered
+			return update
+		case <-time.After(30 * time.Second):
+			t.Fatalf("timed out waiting for a service update")
+		}
+	}
+}
+
+// nextEndpointsUpdate is nextServiceUpdate for endpoints.
+func nextEndpointsUpdate(t *testing.T, endpoints <-chan EndpointsUpdate) EndpointsUpdate {
+	for {
+		select {
+		case update := <-endpoints:
+			var filtered []api.Endpoints
+			for _, e := range update.Endpoints {
+				if e.ID != "kubernetes" {
+					e.JSONBase = api.JSONBase{ID: e.ID}
+					filtered = append(filtered, e)
+				}
+			}
+			if len(filtered) == 0 && update.Op != SET {
+				continue
+			}
+			update.Endpoints = filtered
+			return update
+		case <-time.After(30 * time.Second):
+			t.Fatalf("timed out waiting for an endpoints update")
+		}
+	}
+}
+
+func TestIntegrationListThenWatch(t *testing.T) {
+	client, stop := startAPIServer(t)
+	defer stop()
+	createService(t, client, "foo", 80, "10.0.0.1")
+
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	NewSourceAPI(&kubeWatcher{client: client}, time.Second, services, endpoints)
+
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Protocol: "TCP"}}}
+	if actual := nextServiceUpdate(t, services); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints := EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}}}
+	if actual := nextEndpointsUpdate(t, endpoints); !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+
+	createService(t, client, "bar", 81, "10.0.0.2")
+	deleteService(t, client, "foo")
+	for _, expected := range []ServiceUpdate{
+		{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}, Port: 81, Protocol: "TCP"}}},
+		{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Protocol: "TCP"}}},
+	} {
+		if actual := nextServiceUpdate(t, services); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v", expected, actual)
+		}
+	}
+	for _, expected := range []EndpointsUpdate{
+		{Op: ADD, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.0.2:81"}}}},
+		{Op: REMOVE, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}}},
+	} {
+		if actual := nextEndpointsUpdate(t, endpoints); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v", expected, actual)
+		}
+	}
+}
+
+func TestIntegrationReconnect(t *testing.T) {
+	client, stop := startAPIServer(t)
+	defer stop()
+
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	watcher := &kubeWatcher{client: client}
+	NewSourceAPI(watcher, time.Second, services, endpoints)
+	nextServiceUpdate(t, services)
+	nextEndpointsUpdate(t, endpoints)
+
+	// changes made while the watches are down are received once they are re-established
+	// from the last resource version, without listing again
+	watcher.StopWatches()
+	createService(t, client, "foo", 80, "10.0.0.1")
+	expected := ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Protocol: "TCP"}}}
+	if actual := nextServiceUpdate(t, services); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedEndpoints := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}}}
+	if actual := nextEndpointsUpdate(t, endpoints); !reflect.DeepEqual(expectedEndpoints, actual) {
+		t.Errorf("expected %#v, got %#v", expectedEndpoints, actual)
+	}
+	if lists, watches := watcher.Counts(); lists != 2 || watches != 2 {
+		t.Errorf("expected 2 lists and 2 open watches, got %d and %d", lists, watches)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+)
+
+// KafkaProducer publishes messages to a Kafka topic. A sarama.SyncProducer is adapted to it
+// by sending a sarama.ProducerMessage with the topic, key and value as ByteEncoders.
+type KafkaProducer interface {
+	Send(topic string, key, value []byte) error
+}
+
+// KafkaMessage is a message read from a Kafka topic.
+type KafkaMessage struct {
+	Key   []byte
+	Value []byte
+}
+
+// KafkaConsumer reads the messages of a Kafka topic, e.g. from the sarama.PartitionConsumers
+// of its partitions. The channel is closed once there are no more.
+type KafkaConsumer interface {
+	Messages() <-chan KafkaMessage
+}
+
+// kafkaSetKey is the key of the messages of updates that are not of a single service, such as
+// SETs, so that they stay in order on one partition.
+const kafkaSetKey = "*"
+
+// forwardToKafka publishes update, encoded with KafkaCodec, to KafkaTopic. The message of an
+// update of a single service is keyed by its ServiceKey, so that the updates of a service stay
+// in order on one partition. Other updates, such as SETs, are keyed by kafkaSetKey. Kafka only
+// orders the messages of a partition, so a SET is only seen in order with the updates of
+// single services if KafkaTopic has a single partition.
+func (s *SourceAPI) forwardToKafka(update ServiceUpdate) {
+	codec := s.KafkaCodec
+	if codec == nil {
+		codec = JSONUpdateCodec{}
+	}
+	value, err := codec.Encode(update)
+	if err != nil {
+		glog.Errorf("Unable to encode %s of services for Kafka: %v", update.Op, err)
+		return
+	}
+	key := []byte(kafkaSetKey)
+	if update.Op != SET && len(update.Services) == 1 {
+		key = []byte(ServiceKey(update.Services[0]))
+	}
+	if err := s.KafkaProducer.Send(s.KafkaTopic, key, value); err != nil {
+		glog.Errorf("Unable to send %s of services to Kafka topic %s: %v", update.Op, s.KafkaTopic, err)
+	}
+}
+
+// KafkaConsumerSource is a config source that reads the ServiceUpdates a SourceAPI forwarded
+// to Kafka and sends them on, for consumers that cannot reach the apiserver.
+type KafkaConsumerSource struct {
+	consumer KafkaConsumer
+	codec    UpdateCodec
+	services chan<- ServiceUpdate
+}
+
+// NewKafkaConsumerSource creates a KafkaConsumerSource and starts sending the updates read by
+// consumer to services.
+func NewKafkaConsumerSource(consumer KafkaConsumer, services chan<- ServiceUpdate) *KafkaConsumerSource {
+	return NewKafkaConsumerSourceWithCodec(consumer, JSONUpdateCodec{}, services)
+}
+
+// NewKafkaConsumerSourceWithCodec creates a KafkaConsumerSource reading updates encoded with
+// codec, which must be the KafkaCodec of the SourceAPI that forwards them.
+func NewKafkaConsumerSourceWithCodec(consumer KafkaConsumer, codec UpdateCodec, services chan<- ServiceUpdate) *KafkaConsumerSource {
+	config := &KafkaConsumerSource{
+		consumer: consumer,
+		codec:    codec,
+		services: services,
+	}
+	go func() {
+		defer util.HandleCrash()
+		config.run()
+	}()
+	return config
+}
+
+// run sends the updates read until the consumer is closed. Messages that are not updates are
+// skipped.
+func (s *KafkaConsumerSource) run() {
+	for message := range s.consumer.Messages() {
+		var update ServiceUpdate
+		if err := s.codec.Decode(message.Value, &update); err != nil {
+			glog.Errorf("Skipping Kafka message %q that is not a service update: %v", message.Key, err)
+			continue
+		}
+		s.services <- update
+	}
+}
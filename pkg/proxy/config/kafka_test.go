@@ -0,0 +1,101 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// fakeKafka is a KafkaProducer and KafkaConsumer of a single topic.
+type fakeKafka struct {
+	lock     sync.Mutex
+	topic    string
+	sent     []KafkaMessage
+	err      error
+	messages chan KafkaMessage
+}
+
+func (k *fakeKafka) Send(topic string, key, value []byte) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.err != nil {
+		return k.err
+	}
+	k.topic = topic
+	k.sent = append(k.sent, KafkaMessage{Key: key, Value: value})
+	return nil
+}
+
+func (k *fakeKafka) Messages() <-chan KafkaMessage {
+	return k.messages
+}
+
+func TestKafkaProducer(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{NamespaceLabel: "a"}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81}
+	updates := []ServiceUpdate{
+		{Op: SET, Services: []api.Service{foo, bar}},
+		{Op: REMOVE, Services: []api.Service{foo}},
+	}
+
+	kafka := &fakeKafka{}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.KafkaProducer = kafka
+	source.KafkaTopic = "services"
+	for _, update := range updates {
+		go source.sendServices(update)
+		<-services
+	}
+	// a failed send is only logged
+	kafka.err = errors.New("broker unavailable")
+	go source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	<-services
+
+	kafka.lock.Lock()
+	defer kafka.lock.Unlock()
+	if kafka.topic != "services" {
+		t.Errorf("expected topic services, got %q", kafka.topic)
+	}
+	if len(kafka.sent) != 2 {
+		t.Fatalf("expected 2 messages, got %#v", kafka.sent)
+	}
+	// updates of a single service are keyed by service, SETs share one key
+	if string(kafka.sent[0].Key) != kafkaSetKey || string(kafka.sent[1].Key) != "a/foo" {
+		t.Errorf("unexpected keys %q and %q", kafka.sent[0].Key, kafka.sent[1].Key)
+	}
+
+	// a consumer gets the same updates back
+	kafka.messages = make(chan KafkaMessage, len(kafka.sent)+1)
+	kafka.messages <- KafkaMessage{Key: []byte("garbage"), Value: []byte("not json")}
+	for _, message := range kafka.sent {
+		kafka.messages <- message
+	}
+	close(kafka.messages)
+	remote := make(chan ServiceUpdate)
+	NewKafkaConsumerSource(kafka, remote)
+	for _, expected := range updates {
+		if actual := <-remote; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %#v, got %#v", expected, actual)
+		}
+	}
+}
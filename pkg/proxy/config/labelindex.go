@@ -0,0 +1,116 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sort"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+)
+
+// serviceRef identifies a service of a serviceStore: its ServiceKey in the services of a
+// source.
+type serviceRef struct {
+	source string
+	key    string
+}
+
+// labelIndex holds the services of a serviceStore by each of their labels, mapping a label
+// key and value to the services that have it. A nil labelIndex indexes nothing. It is guarded
+// by the lock of the serviceStore.
+type labelIndex map[string]map[string]map[serviceRef]bool
+
+// add indexes service, of source, by its labels.
+func (l labelIndex) add(source string, service api.Service) {
+	if l == nil {
+		return
+	}
+	ref := serviceRef{source, ServiceKey(service)}
+	for key, value := range service.Labels {
+		values := l[key]
+		if values == nil {
+			values = make(map[string]map[serviceRef]bool)
+			l[key] = values
+		}
+		refs := values[value]
+		if refs == nil {
+			refs = make(map[serviceRef]bool)
+			values[value] = refs
+		}
+		refs[ref] = true
+	}
+}
+
+// remove drops service, of source, from the index.
+func (l labelIndex) remove(source string, service api.Service) {
+	if l == nil {
+		return
+	}
+	ref := serviceRef{source, ServiceKey(service)}
+	for key, value := range service.Labels {
+		refs := l[key][value]
+		delete(refs, ref)
+		if len(refs) == 0 {
+			delete(l[key], value)
+		}
+		if len(l[key]) == 0 {
+			delete(l, key)
+		}
+	}
+}
+
+// query returns the services matching selector, sorted by ServiceKey. If selector requires an
+// exact value of any indexed label, only the services with the least common of those values
+// are matched; otherwise all of them are.
+func (s *serviceStore) query(selector labels.Selector) []api.Service {
+	s.serviceLock.RLock()
+	defer s.serviceLock.RUnlock()
+	var candidates map[serviceRef]bool
+	indexed := false
+	for key, values := range s.index {
+		value, required := selector.RequiresExactMatch(key)
+		if !required {
+			continue
+		}
+		refs := values[value]
+		if !indexed || len(refs) < len(candidates) {
+			candidates = refs
+			indexed = true
+		}
+	}
+
+	services := []api.Service{}
+	matches := func(service api.Service) {
+		if selector.Matches(labels.Set(service.Labels)) {
+			services = append(services, service)
+		}
+	}
+	if indexed {
+		for ref := range candidates {
+			matches(s.services[ref.source][ref.key])
+		}
+	} else {
+		for _, sourceServices := range s.services {
+			for _, service := range sourceServices {
+				matches(service)
+			}
+		}
+	}
+	sort.Sort(servicesByID(services))
+	return services
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	"github.com/golang/glog"
+)
+
+// The number of inter-event latencies kept by a SourceAPI.
+const recentLatencies = 100
+
+// latencyRing holds the most recent latencies, overwriting the oldest once full.
+// It is safe for concurrent use.
+type latencyRing struct {
+	lock   sync.Mutex
+	values [recentLatencies]time.Duration
+	next   int
+	full   bool
+}
+
+// Add records latency, dropping the oldest one if the ring is full.
+func (r *latencyRing) Add(latency time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.values[r.next] = latency
+	r.next = (r.next + 1) % len(r.values)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns the recorded latencies, oldest first.
+func (r *latencyRing) List() []time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]time.Duration{}, r.values[:r.next]...)
+	}
+	return append(append([]time.Duration{}, r.values[r.next:]...), r.values[:r.next]...)
+}
+
+// RecentEventLatencies returns the times between consecutive events of the services and
+// endpoints watches, oldest first. Only the last 100 are kept, and only while
+// EnableWatchLatencyLog is set.
+func (s *SourceAPI) RecentEventLatencies() []time.Duration {
+	return s.latencies.List()
+}
+
+// timeEvents passes on the events of a watch that has just been opened, logging the time to
+// the first event and recording the time between events. It stops when in is closed or done is.
+func (s *SourceAPI) timeEvents(kind string, in <-chan watch.Event, done <-chan struct{}) <-chan watch.Event {
+	opened := s.clock().Now()
+	var last time.Time
+	return forwardEvents(in, done, func(event watch.Event) bool {
+		now := s.clock().Now()
+		if last.IsZero() {
+			glog.V(4).Infof("First %s watch event %v after the watch was opened", kind, now.Sub(opened))
+		} else {
+			latency := now.Sub(last)
+			glog.V(4).Infof("Next %s watch event after %v", kind, latency)
+			s.latencies.Add(latency)
+		}
+		last = now
+		return true
+	})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+func TestWatchLatencies(t *testing.T) {
+	clock := newFakeClock()
+	source := SourceAPI{SourceAPIOptions: SourceAPIOptions{Clock: clock, EnableWatchLatencyLog: true}}
+	fakeWatch := watch.NewFake()
+	done := make(chan struct{})
+	defer close(done)
+	ch := source.timeEvents("services", fakeWatch.ResultChan(), done)
+
+	// the wait for the first event is not an inter-event latency
+	clock.Step(5 * time.Second)
+	for _, d := range []time.Duration{time.Second, 3 * time.Second, 0} {
+		service := &api.Service{JSONBase: api.JSONBase{ID: "foo"}}
+		fakeWatch.Add(service)
+		if event := <-ch; event.Object != service {
+			t.Errorf("expected %#v, got %#v", service, event.Object)
+		}
+		clock.Step(d)
+	}
+	expected := []time.Duration{time.Second, 3 * time.Second}
+	if actual := source.RecentEventLatencies(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	fakeWatch.Stop()
+	if _, ok := <-ch; ok {
+		t.Errorf("expected channel to be closed")
+	}
+}
+
+func TestLatencyRingWraps(t *testing.T) {
+	var ring latencyRing
+	for i := 1; i <= recentLatencies+5; i++ {
+		ring.Add(time.Duration(i))
+	}
+	latencies := ring.List()
+	if len(latencies) != recentLatencies {
+		t.Fatalf("expected %d latencies, got %d", recentLatencies, len(latencies))
+	}
+	if latencies[0] != 6 || latencies[recentLatencies-1] != recentLatencies+5 {
+		t.Errorf("expected latencies 6 to %d, got %v", recentLatencies+5, latencies)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// objectLimit keeps track of the objects of a resource that have been sent, so that ADDs of
+// new ones can be dropped once there are too many.
+type objectLimit struct {
+	lock sync.Mutex
+	keys map[string]bool
+	// dropped counts the objects held back so far.
+	dropped uint64
+}
+
+// admit applies an update of op with objects of keys to the objects known and returns the
+// indexes of the keys that may be sent. The ADDs of new objects beyond max are held back, as
+// are the objects of a SET beyond max, of which those already known are kept first, so that
+// a relist does not trade the objects sent for others.
+func (l *objectLimit) admit(op Operation, keys []string, max int) []int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if op == SET {
+		return l.admitSet(keys, max)
+	}
+	if l.keys == nil {
+		l.keys = make(map[string]bool)
+	}
+	admitted := make([]int, 0, len(keys))
+	for i, key := range keys {
+		switch op {
+		case ADD:
+			if !l.keys[key] && len(l.keys) >= max {
+				l.dropped++
+				continue
+			}
+			l.keys[key] = true
+		case REMOVE:
+			delete(l.keys, key)
+		}
+		admitted = append(admitted, i)
+	}
+	return admitted
+}
+
+// admitSet is admit for a SET.
+func (l *objectLimit) admitSet(keys []string, max int) []int {
+	last := l.keys
+	l.keys = make(map[string]bool)
+	for _, key := range keys {
+		if last[key] && len(l.keys) < max {
+			l.keys[key] = true
+		}
+	}
+	for _, key := range keys {
+		if !l.keys[key] && len(l.keys) < max {
+			l.keys[key] = true
+		}
+	}
+	admitted := make([]int, 0, len(l.keys))
+	for i, key := range keys {
+		if l.keys[key] {
+			admitted = append(admitted, i)
+			continue
+		}
+		l.dropped++
+	}
+	return admitted
+}
+
+// droppedCount returns the number of objects held back so far.
+func (l *objectLimit) droppedCount() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.dropped
+}
+
+// ServicesOverLimit returns the number of services dropped because of MaxServices.
+func (s *SourceAPI) ServicesOverLimit() uint64 {
+	return s.serviceLimit.droppedCount()
+}
+
+// EndpointsOverLimit returns the number of endpoints dropped because of MaxEndpoints.
+func (s *SourceAPI) EndpointsOverLimit() uint64 {
+	return s.endpointsLimit.droppedCount()
+}
+
+// limitServices returns the services of update that are within MaxServices.
+func (s *SourceAPI) limitServices(update ServiceUpdate) []api.Service {
+	keys := make([]string, len(update.Services))
+	for i, service := range update.Services {
+		keys[i] = ServiceKey(service)
+	}
+	admitted := s.serviceLimit.admit(update.Op, keys, s.MaxServices)
+	if len(admitted) == len(keys) {
+		return update.Services
+	}
+	services := make([]api.Service, len(admitted))
+	for i, index := range admitted {
+		services[i] = update.Services[index]
+	}
+	glog.Warningf("Dropping %d services over the limit of %d, check the selectors of the source", len(keys)-len(admitted), s.MaxServices)
+	return services
+}
+
+// limitEndpoints returns the endpoints of update that are within MaxEndpoints.
+func (s *SourceAPI) limitEndpoints(update EndpointsUpdate) []api.Endpoints {
+	keys := make([]string, len(update.Endpoints))
+	for i, endpoints := range update.Endpoints {
+		keys[i] = endpoints.ID
+	}
+	admitted := s.endpointsLimit.admit(update.Op, keys, s.MaxEndpoints)
+	if len(admitted) == len(keys) {
+		return update.Endpoints
+	}
+	endpoints := make([]api.Endpoints, len(admitted))
+	for i, index := range admitted {
+		endpoints[i] = update.Endpoints[index]
+	}
+	glog.Warningf("Dropping %d endpoints over the limit of %d, check the selectors of the source", len(keys)-len(admitted), s.MaxEndpoints)
+	return endpoints
+}
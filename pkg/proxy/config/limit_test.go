@@ -0,0 +1,100 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestMaxServices(t *testing.T) {
+	services := make(chan ServiceUpdate, 10)
+	source := SourceAPI{services: services}
+	source.MaxServices = 2
+
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 82}
+	updated := foo
+	updated.Port = 8080
+	table := []struct {
+		update   ServiceUpdate
+		expected *ServiceUpdate
+	}{
+		{ServiceUpdate{Op: SET, Services: []api.Service{foo}}, &ServiceUpdate{Op: SET, Services: []api.Service{foo}}},
+		// only one more service fits
+		{ServiceUpdate{Op: ADD, Services: []api.Service{bar, baz}}, &ServiceUpdate{Op: ADD, Services: []api.Service{bar}}},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{baz}}, nil},
+		// services already sent may still change
+		{ServiceUpdate{Op: ADD, Services: []api.Service{updated}}, &ServiceUpdate{Op: ADD, Services: []api.Service{updated}}},
+		{ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}}, &ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}}},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{baz}}, &ServiceUpdate{Op: ADD, Services: []api.Service{baz}}},
+		// a SET replaces the services counted against the limit
+		{ServiceUpdate{Op: SET, Services: []api.Service{bar}}, &ServiceUpdate{Op: SET, Services: []api.Service{bar}}},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{foo}}, &ServiceUpdate{Op: ADD, Services: []api.Service{foo}}},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{baz}}, nil},
+		// a SET is limited too, keeping the services already sent
+		{ServiceUpdate{Op: SET, Services: []api.Service{baz, bar, foo}}, &ServiceUpdate{Op: SET, Services: []api.Service{bar, foo}}},
+	}
+	for i, item := range table {
+		source.sendServices(item.update)
+		if item.expected == nil {
+			if len(services) != 0 {
+				t.Errorf("%d: expected the update to be dropped, got %#v", i, <-services)
+			}
+			continue
+		}
+		if actual := <-services; !reflect.DeepEqual(*item.expected, actual) {
+			t.Errorf("%d: expected %#v, got %#v", i, *item.expected, actual)
+		}
+	}
+	if dropped := source.ServicesOverLimit(); dropped != 4 {
+		t.Errorf("expected 4 services over the limit, got %d", dropped)
+	}
+}
+
+func TestMaxEndpoints(t *testing.T) {
+	endpoints := make(chan EndpointsUpdate, 10)
+	source := SourceAPI{endpoints: endpoints}
+	source.MaxEndpoints = 1
+
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.0.2:80"}}
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{foo}})
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{bar}})
+	source.sendEndpoints(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{foo}})
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{bar}})
+
+	expected := []EndpointsUpdate{
+		{Op: ADD, Endpoints: []api.Endpoints{foo}},
+		{Op: REMOVE, Endpoints: []api.Endpoints{foo}},
+		{Op: ADD, Endpoints: []api.Endpoints{bar}},
+	}
+	if len(endpoints) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(endpoints))
+	}
+	for _, update := range expected {
+		if actual := <-endpoints; !reflect.DeepEqual(update, actual) {
+			t.Errorf("expected %#v, got %#v", update, actual)
+		}
+	}
+	if dropped := source.EndpointsOverLimit(); dropped != 1 {
+		t.Errorf("expected 1 endpoints over the limit, got %d", dropped)
+	}
+}
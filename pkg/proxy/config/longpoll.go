@@ -0,0 +1,177 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+)
+
+// LongPollSource is a config source that lists the services and endpoints of the apiserver
+// every interval instead of watching them, for networks whose proxies do not let watches
+// through. It sends a SET of the first list, then an ADD of what is new or changed in each
+// list since the last and a REMOVE of what is gone. A failed list is retried at the next
+// interval, leaving the state as it was.
+type LongPollSource struct {
+	client    Watcher
+	interval  time.Duration
+	services  chan<- ServiceUpdate
+	endpoints chan<- EndpointsUpdate
+	clock     Clock
+	// closing is marked by Close.
+	closing syncSignal
+}
+
+// NewLongPollSource creates a LongPollSource and starts listing services and endpoints with
+// client every interval.
+func NewLongPollSource(client Watcher, interval time.Duration, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate) *LongPollSource {
+	return newLongPollSource(client, interval, services, endpoints, realClock{})
+}
+
+func newLongPollSource(client Watcher, interval time.Duration, services chan<- ServiceUpdate, endpoints chan<- EndpointsUpdate, clock Clock) *LongPollSource {
+	config := &LongPollSource{
+		client:    client,
+		interval:  interval,
+		services:  services,
+		endpoints: endpoints,
+		clock:     clock,
+	}
+	go func() {
+		defer util.HandleCrash()
+		config.pollServices()
+	}()
+	go func() {
+		defer util.HandleCrash()
+		config.pollEndpoints()
+	}()
+	return config
+}
+
+// Close stops the source. Lists in progress finish, but no more updates are sent and no more
+// lists are made.
+func (s *LongPollSource) Close() {
+	s.closing.mark()
+}
+
+// wait waits for interval, and returns false if the source is closed first.
+func (s *LongPollSource) wait() bool {
+	select {
+	case <-s.clock.After(s.interval):
+		return true
+	case <-s.closing.done():
+		return false
+	}
+}
+
+// sendServices sends update, and returns false if the source is closed first.
+func (s *LongPollSource) sendServices(update ServiceUpdate) bool {
+	select {
+	case s.services <- update:
+		return true
+	case <-s.closing.done():
+		return false
+	}
+}
+
+// sendEndpoints is sendServices for endpoints.
+func (s *LongPollSource) sendEndpoints(update EndpointsUpdate) bool {
+	select {
+	case s.endpoints <- update:
+		return true
+	case <-s.closing.done():
+		return false
+	}
+}
+
+// pollServices lists the services every interval and sends the changes, until the source is
+// closed.
+func (s *LongPollSource) pollServices() {
+	// last is nil until the first list.
+	var last map[string]api.Service
+	for {
+		if list, err := s.client.ListServices(labels.Everything()); err != nil {
+			glog.Errorf("Unable to list services: %v", err)
+		} else {
+			next := make(map[string]api.Service, len(list.Items))
+			for _, service := range list.Items {
+				next[ServiceKey(service)] = service
+			}
+			if last == nil {
+				items := list.Items
+				if items == nil {
+					items = []api.Service{}
+				}
+				if !s.sendServices(ServiceUpdate{Op: SET, Services: items}) {
+					return
+				}
+			} else {
+				added, removed := diffServices(last, next)
+				if len(added) > 0 && !s.sendServices(ServiceUpdate{Op: ADD, Services: added}) {
+					return
+				}
+				if len(removed) > 0 && !s.sendServices(ServiceUpdate{Op: REMOVE, Services: removed}) {
+					return
+				}
+			}
+			last = next
+		}
+		if !s.wait() {
+			return
+		}
+	}
+}
+
+// pollEndpoints is pollServices for endpoints.
+func (s *LongPollSource) pollEndpoints() {
+	// last is nil until the first list.
+	var last map[string]api.Endpoints
+	for {
+		if list, err := s.client.ListEndpoints(labels.Everything()); err != nil {
+			glog.Errorf("Unable to list endpoints: %v", err)
+		} else {
+			next := make(map[string]api.Endpoints, len(list.Items))
+			for _, endpoints := range list.Items {
+				next[endpoints.ID] = endpoints
+			}
+			if last == nil {
+				items := list.Items
+				if items == nil {
+					items = []api.Endpoints{}
+				}
+				if !s.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: items}) {
+					return
+				}
+			} else {
+				added, removed := diffEndpoints(last, next)
+				if len(added) > 0 && !s.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: added}) {
+					return
+				}
+				if len(removed) > 0 && !s.sendEndpoints(EndpointsUpdate{Op: REMOVE, Endpoints: removed}) {
+					return
+				}
+			}
+			last = next
+		}
+		if !s.wait() {
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// pollClient answers each list with the next list sent to it, failing on a nil one. It
+// cannot watch.
+type pollClient struct {
+	services  chan *api.ServiceList
+	endpoints chan *api.EndpointsList
+}
+
+func (c *pollClient) ListServices(label labels.Selector) (*api.ServiceList, error) {
+	if list := <-c.services; list != nil {
+		return list, nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func (c *pollClient) ListEndpoints(label labels.Selector) (*api.EndpointsList, error) {
+	if list := <-c.endpoints; list != nil {
+		return list, nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func (c *pollClient) WatchServices(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return nil, errors.New("watches are blocked")
+}
+
+func (c *pollClient) WatchEndpoints(label, field labels.Selector, resourceVersion uint64) (watch.Interface, error) {
+	return nil, errors.New("watches are blocked")
+}
+
+func TestLongPollSource(t *testing.T) {
+	client := &pollClient{services: make(chan *api.ServiceList), endpoints: make(chan *api.EndpointsList)}
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	clock := newFakeClock()
+	source := newLongPollSource(client, time.Minute, services, endpoints, clock)
+
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81}
+	fooEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	client.services <- &api.ServiceList{Items: []api.Service{foo, bar}}
+	if actual := <-services; !reflect.DeepEqual(ServiceUpdate{Op: SET, Services: []api.Service{foo, bar}}, actual) {
+		t.Errorf("unexpected update %#v", actual)
+	}
+	client.endpoints <- &api.EndpointsList{}
+	if actual := <-endpoints; !reflect.DeepEqual(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{}}, actual) {
+		t.Errorf("unexpected update %#v", actual)
+	}
+
+	// a failed list changes nothing
+	clock.BlockUntil(t, 2)
+	clock.Step(time.Minute)
+	client.services <- nil
+	client.endpoints <- nil
+
+	// only the changes since the last list are sent
+	clock.BlockUntil(t, 2)
+	clock.Step(time.Minute)
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 82}
+	foo.Port = 8080
+	client.services <- &api.ServiceList{Items: []api.Service{foo, baz}}
+	expected := []ServiceUpdate{
+		{Op: ADD, Services: []api.Service{baz, foo}},
+		{Op: REMOVE, Services: []api.Service{bar}},
+	}
+	for _, update := range expected {
+		if actual := <-services; !reflect.DeepEqual(update, actual) {
+			t.Errorf("expected %#v, got %#v", update, actual)
+		}
+	}
+	client.endpoints <- &api.EndpointsList{Items: []api.Endpoints{fooEndpoints}}
+	if actual := <-endpoints; !reflect.DeepEqual(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{fooEndpoints}}, actual) {
+		t.Errorf("unexpected update %#v", actual)
+	}
+
+	// nothing is sent if nothing changed
+	clock.BlockUntil(t, 2)
+	clock.Step(time.Minute)
+	client.services <- &api.ServiceList{Items: []api.Service{foo, baz}}
+	client.endpoints <- &api.EndpointsList{}
+	if actual := <-endpoints; !reflect.DeepEqual(EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{fooEndpoints}}, actual) {
+		t.Errorf("unexpected update %#v", actual)
+	}
+	clock.BlockUntil(t, 2)
+	select {
+	case update := <-services:
+		t.Errorf("unexpected update %#v", update)
+	default:
+	}
+
+	// nothing is listed once the source is closed
+	source.Close()
+	clock.Step(time.Minute)
+	select {
+	case client.services <- &api.ServiceList{}:
+		t.Errorf("unexpected list of services after Close")
+	case client.endpoints <- &api.EndpointsList{}:
+		t.Errorf("unexpected list of endpoints after Close")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+)
+
+// NamespaceLabel is the service label holding the namespace of a service watched across
+// namespaces. Endpoints have no labels, so the ID of the endpoints of such a service is its
+// NamespacedName instead. Both are set by setNamespace as the objects are decoded.
+const NamespaceLabel = "wormhole.io/namespace"
+
+// NamespacedName returns the key of the object name in namespace, "namespace/name", or just
+// name if namespace is empty.
+func NamespacedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// SplitNamespacedName returns the namespace and name of a key made by NamespacedName.
+func SplitNamespacedName(key string) (namespace, name string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// ServiceKey returns the key services are indexed by, which is also the ID of their endpoints.
+// Services of the same name in different namespaces have different keys.
+func ServiceKey(service api.Service) string {
+	return NamespacedName(service.Labels[NamespaceLabel], service.ID)
+}
+
+// EndpointsKey returns the key endpoints are indexed by, which is the ServiceKey of their
+// service: their ID, made the NamespacedName of the service by setNamespace.
+func EndpointsKey(endpoints api.Endpoints) string {
+	return endpoints.ID
+}
+
+// objectNamespace returns the namespace of the object serialized in data, from its namespace
+// field or its metadata, or scope if it has none.
+func objectNamespace(data []byte, scope string) string {
+	var object struct {
+		Namespace string `json:"namespace"`
+		Metadata  struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &object); err == nil {
+		if object.Namespace != "" {
+			return object.Namespace
+		}
+		if object.Metadata.Namespace != "" {
+			return object.Metadata.Namespace
+		}
+	}
+	return scope
+}
+
+// setNamespace puts obj, a service, endpoints or a list of either decoded from data, in the
+// namespace of its serialized object, or in scope if that has none, as for the objects of a
+// request limited to the namespace scope. Services get a NamespaceLabel and endpoints have
+// their ID made a NamespacedName, so that the ServiceKey of a service is the EndpointsKey of
+// its endpoints. Objects already in a namespace are left alone.
+func setNamespace(data []byte, obj runtime.Object, scope string) {
+	switch obj := obj.(type) {
+	case *api.Service:
+		namespaceService(obj, objectNamespace(data, scope))
+	case *api.Endpoints:
+		namespaceEndpoints(obj, objectNamespace(data, scope))
+	case *api.ServiceList:
+		if items, ok := listItems(data, len(obj.Items)); ok {
+			for i := range obj.Items {
+				namespaceService(&obj.Items[i], objectNamespace(items[i], scope))
+			}
+		}
+	case *api.EndpointsList:
+		if items, ok := listItems(data, len(obj.Items)); ok {
+			for i := range obj.Items {
+				namespaceEndpoints(&obj.Items[i], objectNamespace(items[i], scope))
+			}
+		}
+	}
+}
+
+// listItems returns the serialized items of the list in data, and false if there are not n.
+func listItems(data []byte, n int) ([]json.RawMessage, bool) {
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil || len(list.Items) != n {
+		return nil, false
+	}
+	return list.Items, true
+}
+
+func namespaceService(service *api.Service, namespace string) {
+	if namespace == "" || service.Labels[NamespaceLabel] != "" {
+		return
+	}
+	if service.Labels == nil {
+		service.Labels = make(map[string]string)
+	}
+	service.Labels[NamespaceLabel] = namespace
+}
+
+func namespaceEndpoints(endpoints *api.Endpoints, namespace string) {
+	if namespace == "" || strings.Contains(endpoints.ID, "/") {
+		return
+	}
+	endpoints.ID = NamespacedName(namespace, endpoints.ID)
+}
+
+// namespaceCodec decodes with Codec, then puts the objects in their namespace with
+// setNamespace.
+type namespaceCodec struct {
+	runtime.Codec
+	// scope is the namespace of the objects that do not have one.
+	scope string
+}
+
+func (c namespaceCodec) Decode(data []byte) (runtime.Object, error) {
+	obj, err := c.Codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	setNamespace(data, obj, c.scope)
+	return obj, nil
+}
+
+func (c namespaceCodec) DecodeInto(data []byte, obj runtime.Object) error {
+	if err := c.Codec.DecodeInto(data, obj); err != nil {
+		return err
+	}
+	setNamespace(data, obj, c.scope)
+	return nil
+}
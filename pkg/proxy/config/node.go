@@ -0,0 +1,72 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// NodeLabel is the service label holding the hostname of the node whose proxy sent the
+// service, set by the NodeAnnotation option.
+const NodeLabel = "wormhole.io/node"
+
+// nodeName holds the hostname the services of a NodeAnnotation source are labeled with,
+// looked up on first use.
+type nodeName struct {
+	once sync.Once
+	name string
+}
+
+// hostname returns the hostname of the node, from HostnameFunc or os.Hostname.
+func (s *SourceAPI) hostname() string {
+	s.node.once.Do(func() {
+		if s.HostnameFunc != nil {
+			s.node.name = s.HostnameFunc()
+			return
+		}
+		name, err := os.Hostname()
+		if err != nil {
+			glog.Errorf("Unable to get the hostname, services are not labeled with it: %v", err)
+		}
+		s.node.name = name
+	})
+	return s.node.name
+}
+
+// labelNode returns services with the hostname of the node as their NodeLabel. The services
+// and their labels are copied rather than changed.
+func (s *SourceAPI) labelNode(services []api.Service) []api.Service {
+	name := s.hostname()
+	if name == "" || len(services) == 0 {
+		return services
+	}
+	labeled := make([]api.Service, len(services))
+	for i, service := range services {
+		serviceLabels := make(map[string]string, len(service.Labels)+1)
+		for key, value := range service.Labels {
+			serviceLabels[key] = value
+		}
+		serviceLabels[NodeLabel] = name
+		service.Labels = serviceLabels
+		labeled[i] = service
+	}
+	return labeled
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestNodeAnnotation(t *testing.T) {
+	calls := 0
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.NodeAnnotation = true
+	source.HostnameFunc = func() string {
+		calls++
+		return "node-1"
+	}
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{NamespaceLabel: "a"}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81}
+
+	go source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo, bar}})
+	actual := <-services
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{
+		{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{NamespaceLabel: "a", NodeLabel: "node-1"}},
+		{JSONBase: api.JSONBase{ID: "bar"}, Port: 81, Labels: map[string]string{NodeLabel: "node-1"}},
+	}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if _, found := foo.Labels[NodeLabel]; found {
+		t.Errorf("expected the labels of the update to be left as they are, got %v", foo.Labels)
+	}
+
+	go source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}})
+	actual = <-services
+	if actual.Services[0].Labels[NodeLabel] != "node-1" {
+		t.Errorf("expected the removed service to be labeled, got %#v", actual)
+	}
+	if calls != 1 {
+		t.Errorf("expected the hostname to be looked up once, got %d", calls)
+	}
+
+	// without the option services are sent as they are
+	plain := SourceAPI{services: services}
+	go plain.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{bar}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"code.google.com/p/go.crypto/ocsp"
+)
+
+// How long we wait for an OCSP responder.
+const ocspTimeout = 10 * time.Second
+
+var (
+	ErrNoOCSPServer = errors.New("certificate lists no OCSP server")
+	ErrNoIssuer     = errors.New("certificate issuer is not known")
+)
+
+// RequireOCSP makes w refuse to talk to an apiserver whose certificate its issuer's OCSP
+// responder does not report as good. It must be called before w is used.
+func (w *HTTPWatcher) RequireOCSP() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if t, ok := w.client.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	ocspClient := &http.Client{Timeout: ocspTimeout}
+	transport.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) < 2 {
+			return ErrNoIssuer
+		}
+		return checkOCSP(ocspClient, verifiedChains[0][0], verifiedChains[0][1])
+	}
+	client := *w.client
+	client.Transport = transport
+	w.client = &client
+}
+
+// checkOCSP asks the OCSP responder of cert whether it has been revoked.
+func checkOCSP(client *http.Client, cert, issuer *x509.Certificate) error {
+	if len(cert.OCSPServer) == 0 {
+		return ErrNoOCSPServer
+	}
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OCSP request to %s failed: %s", cert.OCSPServer[0], resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	response, err := ocsp.ParseResponseForCert(data, cert, issuer)
+	if err != nil {
+		return err
+	}
+	if response.Status != ocsp.Good {
+		return fmt.Errorf("certificate %v has OCSP status %d", cert.Subject, response.Status)
+	}
+	return nil
+}
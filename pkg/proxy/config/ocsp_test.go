@@ -0,0 +1,101 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.crypto/ocsp"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/vishvananda/wormhole/pkg/proxy/certtest"
+)
+
+// newOCSPTestServer starts an apiserver whose certificate is checked by an OCSP responder
+// that answers with status, and returns the server and a client trusting its CA.
+func newOCSPTestServer(t *testing.T, status int) (*httptest.Server, *httptest.Server, *http.Client) {
+	ca := certtest.NewCA(t)
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		request, err := ocsp.ParseRequest(data)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		response, err := ocsp.CreateResponse(ca.Cert, ca.Cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.Key)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		w.Write(response)
+	}))
+
+	template := certtest.Leaf("apiserver", x509.ExtKeyUsageServerAuth)
+	template.OCSPServer = []string{responder.URL}
+	cert := ca.Issue(t, template)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"kind":"ServiceList","items":[]}`)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.Pool()}}}
+	return server, responder, client
+}
+
+func TestRequireOCSP(t *testing.T) {
+	server, responder, client := newOCSPTestServer(t, ocsp.Good)
+	defer server.Close()
+	defer responder.Close()
+
+	watcher := NewHTTPWatcher(server.URL, client)
+	watcher.RequireOCSP()
+	if _, err := watcher.ListServices(labels.Everything()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRequireOCSPRevoked(t *testing.T) {
+	server, responder, client := newOCSPTestServer(t, ocsp.Revoked)
+	defer server.Close()
+	defer responder.Close()
+
+	// without the check the revoked certificate is accepted
+	if _, err := NewHTTPWatcher(server.URL, client).ListServices(labels.Everything()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	watcher := NewHTTPWatcher(server.URL, client)
+	watcher.RequireOCSP()
+	if _, err := watcher.ListServices(labels.Everything()); err == nil {
+		t.Errorf("expected revoked certificate to be refused")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// scaleInterval is how often the workers of an AutoScale source are resized.
+const scaleInterval = time.Second
+
+// workerQueueSize is the number of updates queued for each worker before dispatch waits for it.
+const workerQueueSize = 64
+
+// workerPool delivers updates on a varying number of goroutines. Work with the same key is
+// done by the same worker, in the order it was dispatched, and work without a key is done
+// once all work before it is done, so an update is never overtaken by a later one of the
+// same object.
+// It is safe for concurrent use.
+type workerPool struct {
+	lock    sync.Mutex
+	workers []chan func()
+	pending sync.WaitGroup
+	// events is the number of calls to dispatch since the last call to takeEvents.
+	events int
+}
+
+// dispatch queues work for the worker for key, or, if key is empty, waits for all pending work
+// and does it itself. Work is queued without holding p.lock, so that a worker with a full
+// queue holds up only the dispatches to it; resize waits for the pending work, so the worker
+// is not stopped in between.
+func (p *workerPool) dispatch(key string, work func()) {
+	p.lock.Lock()
+	p.events++
+	if key == "" || len(p.workers) == 0 {
+		defer p.lock.Unlock()
+		p.pending.Wait()
+		work()
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p.pending.Add(1)
+	worker := p.workers[h.Sum32()%uint32(len(p.workers))]
+	p.lock.Unlock()
+	worker <- work
+}
+
+// resize waits for all pending work, since the worker of a key changes with their number,
+// and then starts or stops workers to have n of them.
+func (p *workerPool) resize(n int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pending.Wait()
+	for len(p.workers) < n {
+		ch := make(chan func(), workerQueueSize)
+		go func() {
+			for work := range ch {
+				work()
+				p.pending.Done()
+			}
+		}()
+		p.workers = append(p.workers, ch)
+	}
+	for _, ch := range p.workers[n:] {
+		close(ch)
+	}
+	p.workers = p.workers[:n]
+}
+
+// size returns the number of workers.
+func (p *workerPool) size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.workers)
+}
+
+// takeEvents returns the number of calls to dispatch since it was last called.
+func (p *workerPool) takeEvents() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	events := p.events
+	p.events = 0
+	return events
+}
+
+// workerLimits returns MinWorkers and MaxWorkers, defaulting to one worker and MinWorkers.
+func (s *SourceAPI) workerLimits() (int, int) {
+	min, max := s.MinWorkers, s.MaxWorkers
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// scaleWorkers sizes the workers of an AutoScale source to the rate of updates, one worker for
+// every ScaleThreshold updates a second, within MinWorkers and MaxWorkers. It never returns.
+func (s *SourceAPI) scaleWorkers() {
+	min, max := s.workerLimits()
+	s.pool.resize(min)
+	last := s.clock().Now()
+	for {
+		<-s.clock().After(scaleInterval)
+		now := s.clock().Now()
+		rate := float64(s.pool.takeEvents()) / now.Sub(last).Seconds()
+		last = now
+		n := int(math.Ceil(rate / float64(s.ScaleThreshold)))
+		if n < min {
+			n = min
+		}
+		if n > max {
+			n = max
+		}
+		if size := s.pool.size(); n != size {
+			glog.V(2).Infof("Resizing update workers from %d to %d at %.1f updates/s", size, n, rate)
+			s.pool.resize(n)
+		}
+	}
+}
+
+// pooled returns whether updates are delivered on the workers, which is the case for an
+// AutoScale source and one with a WorkerPoolSize.
+func (s *SourceAPI) pooled() bool {
+	return s.AutoScale || s.WorkerPoolSize > 1
+}
+
+// dispatchServices delivers update on the workers if the source is pooled, and otherwise
+// right away.
+func (s *SourceAPI) dispatchServices(update ServiceUpdate) {
+	if len(s.SigningKey) > 0 {
+		update.Signature = signature(update, s.SigningKey)
+	}
+	deliver := func() {
+		s.publish(Event{Resource: ServicesResource, Services: &update})
+		if s.KafkaProducer != nil {
+			s.forwardToKafka(update)
+		}
+		if s.services != nil {
+			s.deliverServices(update)
+		}
+		if update.Op == SET {
+			s.servicesSynced.mark()
+		}
+	}
+	if !s.pooled() {
+		deliver()
+		return
+	}
+	key := ""
+	if update.Op != SET && len(update.Services) == 1 {
+		key = "services/" + ServiceKey(update.Services[0])
+	}
+	s.pool.dispatch(key, deliver)
+}
+
+// dispatchEndpoints is dispatchServices for endpoints.
+func (s *SourceAPI) dispatchEndpoints(update EndpointsUpdate) {
+	deliver := func() {
+		s.publish(Event{Resource: EndpointsResource, Endpoints: &update})
+		if s.endpoints != nil {
+			s.deliverEndpoints(update)
+		}
+		if update.Op == SET {
+			s.endpointsSynced.mark()
+		}
+	}
+	if !s.pooled() {
+		deliver()
+		return
+	}
+	key := ""
+	if update.Op != SET && len(update.Endpoints) == 1 {
+		key = "endpoints/" + update.Endpoints[0].ID
+	}
+	s.pool.dispatch(key, deliver)
+}
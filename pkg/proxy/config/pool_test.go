@@ -0,0 +1,189 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// generateServices adds n services to fakeWatch, as a busy apiserver would.
+func generateServices(fakeWatch *watch.FakeWatcher, first, n int) {
+	for i := first; i < first+n; i++ {
+		fakeWatch.Add(&api.Service{JSONBase: api.JSONBase{ID: fmt.Sprintf("service-%d", i), ResourceVersion: uint64(i + 2)}})
+	}
+}
+
+func TestAutoScaleWorkers(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	services := make(chan ServiceUpdate)
+	clock := newFakeClock()
+	source := SourceAPI{client: &client.Fake{Watch: fakeWatch}, services: services}
+	source.Clock = clock
+	source.AutoScale = true
+	source.MinWorkers = 1
+	source.MaxWorkers = 4
+	source.ScaleThreshold = 10
+	source.serviceVersion.Set(1)
+	received := make(chan ServiceUpdate)
+	go func() {
+		for update := range services {
+			received <- update
+		}
+	}()
+	go source.scaleWorkers()
+	go source.runServices()
+
+	clock.BlockUntil(t, 1)
+	if size := source.pool.size(); size != 1 {
+		t.Errorf("expected 1 worker, got %d", size)
+	}
+
+	// 25 updates a second need 3 workers
+	go generateServices(fakeWatch, 0, 25)
+	for i := 0; i < 25; i++ {
+		<-received
+	}
+	clock.Step(time.Second)
+	clock.BlockUntil(t, 1)
+	if size := source.pool.size(); size != 3 {
+		t.Errorf("expected 3 workers, got %d", size)
+	}
+
+	// more than MaxWorkers would need is capped
+	go generateServices(fakeWatch, 25, 100)
+	for i := 0; i < 100; i++ {
+		<-received
+	}
+	clock.Step(time.Second)
+	clock.BlockUntil(t, 1)
+	if size := source.pool.size(); size != 4 {
+		t.Errorf("expected 4 workers, got %d", size)
+	}
+
+	// once idle it shrinks back to MinWorkers
+	clock.Step(time.Second)
+	clock.BlockUntil(t, 1)
+	if size := source.pool.size(); size != 1 {
+		t.Errorf("expected 1 worker, got %d", size)
+	}
+	fakeWatch.Stop()
+}
+
+func TestAutoScaleOrder(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.AutoScale = true
+	source.pool.resize(4)
+
+	go func() {
+		for i := 0; i < 20; i++ {
+			service := api.Service{JSONBase: api.JSONBase{ID: fmt.Sprintf("service-%d", i%2)}, Port: i}
+			source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{service}})
+		}
+		source.sendServices(ServiceUpdate{Op: SET})
+	}()
+
+	// updates of the same service arrive in order, and a SET after all of them
+	last := map[string]int{"service-0": -1, "service-1": -1}
+	for i := 0; i < 20; i++ {
+		update := <-services
+		service := update.Services[0]
+		if service.Port <= last[service.ID] {
+			t.Errorf("expected %s port after %d, got %d", service.ID, last[service.ID], service.Port)
+		}
+		last[service.ID] = service.Port
+	}
+	if update := <-services; update.Op != SET {
+		t.Errorf("expected SET, got %#v", update)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: &client.Fake{Watch: fakeWatch}, services: services}
+	source.WorkerPoolSize = 4
+	source.pool.resize(source.WorkerPoolSize)
+	source.serviceVersion.Set(1)
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+	go func() {
+		for i := 0; i < 30; i++ {
+			service := api.Service{JSONBase: api.JSONBase{ID: fmt.Sprintf("service-%d", i%3), ResourceVersion: uint64(i + 1)}, Port: i}
+			fakeWatch.Add(&service)
+		}
+		fakeWatch.Stop()
+	}()
+
+	// every event is delivered, those of the same service in order
+	last := map[string]int{"service-0": -1, "service-1": -1, "service-2": -1}
+	for i := 0; i < 30; i++ {
+		update := <-services
+		service := update.Services[0]
+		if service.Port <= last[service.ID] {
+			t.Errorf("expected %s port after %d, got %d", service.ID, last[service.ID], service.Port)
+		}
+		last[service.ID] = service.Port
+	}
+	<-done
+	for id, port := range last {
+		if port < 27 {
+			t.Errorf("expected the last update of %s, got port %d", id, port)
+		}
+	}
+}
+
+func TestWorkerPoolFullQueue(t *testing.T) {
+	var pool workerPool
+	pool.resize(2)
+	release := make(chan struct{})
+	pool.dispatch("foo", func() { <-release })
+	for i := 0; i < workerQueueSize; i++ {
+		pool.dispatch("foo", func() {})
+	}
+	pool.takeEvents()
+	blocked := make(chan struct{})
+	go func() {
+		pool.dispatch("foo", func() {})
+		close(blocked)
+	}()
+
+	// a dispatch waiting for the full queue of a worker does not hold up the pool
+	for i := 0; i < 1000 && pool.takeEvents() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if size := pool.size(); size != 2 {
+		t.Errorf("expected 2 workers, got %d", size)
+	}
+	select {
+	case <-blocked:
+		t.Errorf("expected the dispatch to wait for room")
+	default:
+	}
+	close(release)
+	<-blocked
+	pool.resize(0)
+}
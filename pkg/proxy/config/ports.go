@@ -0,0 +1,53 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// PortsLabel is the service label holding the named ports the service is served on besides
+// its Port, as comma separated name=port pairs, e.g. "https=443,metrics=9090". The addresses
+// of each named port come from the EndpointsUpdate.Ports of the endpoints of the service.
+const PortsLabel = "wormhole.io/ports"
+
+// ServicePorts returns the port numbers of service by port name, its Port under "" and those
+// of its PortsLabel under their names, skipping malformed pairs.
+func ServicePorts(service api.Service) map[string]int {
+	ports := map[string]int{"": service.Port}
+	for _, pair := range strings.Split(service.Labels[PortsLabel], ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			glog.Errorf("Ignoring malformed port %q of %s", pair, service.ID)
+			continue
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || port <= 0 {
+			glog.Errorf("Ignoring malformed port %q of %s", pair, service.ID)
+			continue
+		}
+		ports[strings.TrimSpace(parts[0])] = port
+	}
+	return ports
+}
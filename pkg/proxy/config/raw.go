@@ -0,0 +1,52 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// RawRelist is the type of the event sent to RawEvents when a resource is listed, whose
+// Object is the whole list: an *api.ServiceList, *api.EndpointsList or *EndpointSliceList. It
+// replaces whatever the events before it built up.
+const RawRelist watch.EventType = "RELIST"
+
+// forwardRawEvents passes on the events of a watch, sending each to RawEvents before it is
+// processed. It stops when in is closed or done is.
+func (s *SourceAPI) forwardRawEvents(in <-chan watch.Event, done <-chan struct{}) <-chan watch.Event {
+	return forwardEvents(in, done, func(event watch.Event) bool {
+		select {
+		case s.RawEvents <- event:
+			return true
+		case <-done:
+			return false
+		}
+	})
+}
+
+// sendRawRelist sends list to RawEvents, if set, as a RawRelist event, unless the source is
+// closed first.
+func (s *SourceAPI) sendRawRelist(list runtime.Object) {
+	if s.RawEvents == nil {
+		return
+	}
+	select {
+	case s.RawEvents <- watch.Event{Type: RawRelist, Object: list}:
+	case <-s.closing.done():
+	}
+}
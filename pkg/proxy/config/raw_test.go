@@ -0,0 +1,108 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+func TestRawEvents(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	services := make(chan ServiceUpdate)
+	raw := make(chan watch.Event)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.RawEvents = raw
+	source.serviceVersion.Set(1)
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+
+	service := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}, Port: 80}
+	modified := service
+	modified.ResourceVersion = 3
+	modified.Port = 81
+	deleted := modified
+	deleted.ResourceVersion = 4
+	stale := service
+	table := []struct {
+		event    watch.Event
+		expected *ServiceUpdate
+	}{
+		{watch.Event{Type: watch.Added, Object: &service}, &ServiceUpdate{Op: ADD, Services: []api.Service{service}}},
+		{watch.Event{Type: watch.Modified, Object: &modified}, &ServiceUpdate{Op: ADD, Services: []api.Service{modified}}},
+		// stale events are passed on as they are, but not processed
+		{watch.Event{Type: watch.Modified, Object: &stale}, nil},
+		{watch.Event{Type: watch.Deleted, Object: &deleted}, &ServiceUpdate{Op: REMOVE, Services: []api.Service{deleted}}},
+	}
+	for _, item := range table {
+		go fakeWatch.Action(item.event.Type, item.event.Object)
+		if actual := <-raw; !reflect.DeepEqual(item.event, actual) {
+			t.Errorf("expected %#v, got %#v", item.event, actual)
+		}
+		if item.expected == nil {
+			continue
+		}
+		if actual := <-services; !reflect.DeepEqual(*item.expected, actual) {
+			t.Errorf("expected %#v, got %#v", *item.expected, actual)
+		}
+	}
+
+	fakeWatch.Stop()
+	<-done
+	if source.serviceVersion.Get() != 5 {
+		t.Errorf("expected resource version 5, got %d", source.serviceVersion.Get())
+	}
+}
+
+func TestRawRelist(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	service := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}, Port: 80}
+	fakeClient := &client.Fake{
+		ServiceList: api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 2}, Items: []api.Service{service}},
+		Watch:       fakeWatch,
+	}
+	services := make(chan ServiceUpdate)
+	raw := make(chan watch.Event)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.RawEvents = raw
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+
+	// the list comes first, whole
+	expected := watch.Event{Type: RawRelist, Object: &fakeClient.ServiceList}
+	if actual := <-raw; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expectedUpdate := ServiceUpdate{Op: SET, Services: []api.Service{service}}
+	if actual := <-services; !reflect.DeepEqual(expectedUpdate, actual) {
+		t.Errorf("expected %#v, got %#v", expectedUpdate, actual)
+	}
+
+	fakeWatch.Stop()
+	<-done
+}
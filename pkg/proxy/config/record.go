@@ -0,0 +1,350 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// maxRecordSize is the size a recording grows to before it is moved aside to path.1 and a new
+// one is started.
+const maxRecordSize = 100 << 20
+
+// maxRecordEntrySize is the size of a response body kept in its entry. The rest is counted but
+// left out, so that a long watch does not grow without bound.
+const maxRecordEntrySize = 1 << 20
+
+const (
+	harHeader  = `{"log":{"version":"1.2","creator":{"name":"wormhole","version":"1"},"entries":[`
+	harTrailer = "]}}\n"
+)
+
+// Record makes w write every request it sends, and the response to it, to a HAR file at path,
+// timed by clock, or the system clock if it is nil. The file is valid HAR after each entry.
+// Response bodies are spooled to a temporary file next to path as they are read, and written
+// once they end, as watch responses do, up to maxRecordEntrySize. It must be called before w
+// is used.
+func (w *HTTPWatcher) Record(path string, clock Clock) error {
+	recorder, err := newHARRecorder(path)
+	if err != nil {
+		return err
+	}
+	if clock != nil {
+		recorder.clock = clock
+	}
+	transport := w.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client := *w.client
+	client.Transport = &recordingTransport{transport: transport, recorder: recorder}
+	w.client = &client
+	return nil
+}
+
+// recordingTransport is an http.RoundTripper that records the requests it sends.
+type recordingTransport struct {
+	transport http.RoundTripper
+	recorder  *harRecorder
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := t.recorder.clock.Now()
+	entry := harEntry{
+		StartedDateTime: started.Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Cache: struct{}{},
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+		}
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	entry.Timings.Wait = milliseconds(t.recorder.clock.Now().Sub(started))
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Headers:     harHeaders(resp.Header),
+		Cookies:     []harNameValue{},
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		HeadersSize: -1,
+	}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, entry: entry, started: started, recorder: t.recorder}
+	return resp, nil
+}
+
+// recordingBody spools what is read of a response body, up to the maxEntrySize of its
+// recorder, and records its entry when it is closed or read to the end.
+type recordingBody struct {
+	io.ReadCloser
+	lock  sync.Mutex // protects the fields below, as a watch may be stopped while it is read
+	spool *os.File
+	size  int
+	// stopped is set once nothing more is spooled, after an error or once the entry is recorded.
+	stopped  bool
+	entry    harEntry
+	started  time.Time
+	recorder *harRecorder
+	once     sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.lock.Lock()
+	b.spoolLocked(p[:n])
+	b.lock.Unlock()
+	if err == io.EOF {
+		b.record()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.record()
+	return b.ReadCloser.Close()
+}
+
+// spoolLocked counts data and writes as much of it to the spool as fits in an entry. b.lock
+// must be held.
+func (b *recordingBody) spoolLocked(data []byte) {
+	kept := b.recorder.maxEntrySize - b.size
+	b.size += len(data)
+	if b.stopped || kept <= 0 || len(data) == 0 {
+		return
+	}
+	if len(data) > kept {
+		data = data[:kept]
+	}
+	if b.spool == nil {
+		spool, err := ioutil.TempFile(filepath.Dir(b.recorder.path), ".har-body")
+		if err != nil {
+			glog.Errorf("Unable to spool the response to %s: %v", b.entry.Request.URL, err)
+			b.stopped = true
+			return
+		}
+		b.spool = spool
+	}
+	if _, err := b.spool.Write(data); err != nil {
+		glog.Errorf("Unable to spool the response to %s: %v", b.entry.Request.URL, err)
+		b.stopped = true
+	}
+}
+
+// spooled returns the body kept in the spool, and removes it.
+func (b *recordingBody) spooled() []byte {
+	if b.spool == nil {
+		return nil
+	}
+	defer os.Remove(b.spool.Name())
+	defer b.spool.Close()
+	if _, err := b.spool.Seek(0, 0); err != nil {
+		glog.Errorf("Unable to read the spooled response to %s: %v", b.entry.Request.URL, err)
+		return nil
+	}
+	data, err := ioutil.ReadAll(b.spool)
+	if err != nil {
+		glog.Errorf("Unable to read the spooled response to %s: %v", b.entry.Request.URL, err)
+	}
+	return data
+}
+
+func (b *recordingBody) record() {
+	b.once.Do(func() {
+		b.lock.Lock()
+		body := b.spooled()
+		b.spool = nil
+		b.entry.Response.Content.Size = b.size
+		b.entry.Response.Content.Text = string(body)
+		if len(body) < b.size {
+			b.entry.Response.Content.Comment = fmt.Sprintf("truncated to the first %d bytes", len(body))
+		}
+		b.entry.Response.BodySize = b.size
+		b.stopped = true
+		b.lock.Unlock()
+		b.entry.Timings.Receive = milliseconds(b.recorder.clock.Now().Sub(b.started)) - b.entry.Timings.Wait
+		b.entry.Time = b.entry.Timings.Wait + b.entry.Timings.Receive
+		if err := b.recorder.add(b.entry); err != nil {
+			glog.Errorf("Unable to record request for %s: %v", b.entry.Request.URL, err)
+		}
+	})
+}
+
+// harRecorder appends entries to a HAR file, rotating it once it reaches maxSize.
+type harRecorder struct {
+	path         string
+	maxSize      int64
+	maxEntrySize int
+	clock        Clock
+
+	lock    sync.Mutex
+	file    *os.File
+	size    int64
+	entries int
+}
+
+func newHARRecorder(path string) (*harRecorder, error) {
+	r := &harRecorder{path: path, maxSize: maxRecordSize, maxEntrySize: maxRecordEntrySize, clock: realClock{}}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open starts a new, empty recording.
+func (r *harRecorder) open() error {
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(harHeader + harTrailer); err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = int64(len(harHeader))
+	r.entries = 0
+	return nil
+}
+
+// add writes entry over the trailer of the file, followed by the trailer. An entry too large
+// for a recording of its own is written without its response body.
+func (r *harRecorder) add(entry harEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if int64(len(harHeader)+len(data)) > r.maxSize {
+		entry.Response.Content.Text = ""
+		entry.Response.Content.Comment = "left out, as the entry is larger than a recording"
+		if data, err = json.Marshal(entry); err != nil {
+			return err
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.entries > 0 && r.size+int64(len(data)) > r.maxSize {
+		r.file.Close()
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	if r.entries > 0 {
+		data = append([]byte(","), data...)
+	}
+	if _, err := r.file.WriteAt(append(data, harTrailer...), r.size); err != nil {
+		return err
+	}
+	r.size += int64(len(data))
+	r.entries++
+	return nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harHeaders converts header to HAR name/value pairs, leaving out credentials.
+func harHeaders(header http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			if name == "Authorization" {
+				value = "REDACTED"
+			}
+			pairs = append(pairs, harNameValue{name, value})
+		}
+	}
+	return pairs
+}
+
+// The HAR 1.2 types, as far as they are recorded.
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type harFile struct {
+	Log struct {
+		Version string     `json:"version"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+func readHAR(t *testing.T, path string) harFile {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("unexpected error: %v in %s", err, data)
+	}
+	return har
+}
+
+func TestRecordTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-record")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apiserver.har")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case apiPrefix + "/services":
+			fmt.Fprint(w, `{"kind":"ServiceList","resourceVersion":1,"items":[{"id":"foo","port":80}]}`)
+		case apiPrefix + "/endpoints":
+			fmt.Fprint(w, `{"kind":"EndpointsList","resourceVersion":1,"items":[]}`)
+		}
+		// watches end right away
+	}))
+	defer server.Close()
+
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	client := NewHTTPWatcher(server.URL, nil)
+	client.token = "secret"
+	NewSourceAPIWithOptions(client, time.Hour, services, endpoints, SourceAPIOptions{RecordTo: path})
+	<-services
+	<-endpoints
+
+	// the lists and the watches after them
+	var har harFile
+	for i := 0; i < 1000; i++ {
+		har = readHAR(t, path)
+		if len(har.Log.Entries) == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 4 {
+		t.Fatalf("expected 4 entries, got %#v", har)
+	}
+	for _, entry := range har.Log.Entries {
+		if entry.Request.Method != "GET" || entry.Response.Status != http.StatusOK {
+			t.Errorf("unexpected entry %#v", entry)
+		}
+		for _, header := range entry.Request.Headers {
+			if header.Name == "Authorization" && header.Value != "REDACTED" {
+				t.Errorf("expected credentials to be left out, got %#v", header)
+			}
+		}
+		if entry.Request.URL == server.URL+apiPrefix+"/services?labels=" {
+			expected := `{"kind":"ServiceList","resourceVersion":1,"items":[{"id":"foo","port":80}]}`
+			if entry.Response.Content.Text != expected {
+				t.Errorf("expected %q, got %q", expected, entry.Response.Content.Text)
+			}
+		}
+	}
+}
+
+func TestRecordRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-record")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apiserver.har")
+
+	recorder, err := newHARRecorder(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := make([]harEntry, 3)
+	for i := range entries {
+		entries[i].Request.URL = fmt.Sprintf("/%d", i)
+		entries[i].Response.Content.Text = strings.Repeat("a", 400)
+	}
+	data, err := json.Marshal(entries[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// room for two entries and the comma between them
+	recorder.maxSize = int64(len(harHeader) + 2*len(data) + 1)
+	for _, entry := range entries {
+		if err := recorder.add(entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the first two entries fit before the recording is rotated
+	if har := readHAR(t, path+".1"); len(har.Log.Entries) != 2 {
+		t.Errorf("expected 2 rotated entries, got %d", len(har.Log.Entries))
+	}
+	har := readHAR(t, path)
+	if len(har.Log.Entries) != 1 || har.Log.Entries[0].Request.URL != "/2" {
+		t.Errorf("expected entry /2, got %#v", har.Log.Entries)
+	}
+}
+
+func TestRecordLimitsEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-record")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apiserver.har")
+
+	clock := newFakeClock()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, strings.Repeat("a", 100))
+	}))
+	defer server.Close()
+	watcher := NewHTTPWatcher(server.URL, nil)
+	if err := watcher.Record(path, clock); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recorder := watcher.client.Transport.(*recordingTransport).recorder
+	recorder.maxEntrySize = 10
+
+	resp, err := watcher.send("/big", url.Values{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.Step(time.Second)
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	// the body is cut short, and the time is taken from the clock
+	har := readHAR(t, path)
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %#v", har.Log.Entries)
+	}
+	entry := har.Log.Entries[0]
+	if content := entry.Response.Content; content.Size != 100 || content.Text != strings.Repeat("a", 10) || content.Comment == "" {
+		t.Errorf("expected the body to be truncated, got %#v", content)
+	}
+	if entry.Time != 1000 {
+		t.Errorf("expected the entry to take 1000ms, got %v", entry.Time)
+	}
+	// the spool is removed
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only the recording to be left, got %d files", len(files))
+	}
+
+	// an entry larger than a recording is written without its body
+	recorder.maxSize = int64(len(harHeader) + 200)
+	large := harEntry{}
+	large.Request.URL = "/large"
+	large.Response.Content.Text = strings.Repeat("a", 400)
+	if err := recorder.add(large); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	har = readHAR(t, path)
+	if content := har.Log.Entries[len(har.Log.Entries)-1].Response.Content; content.Text != "" || content.Comment == "" {
+		t.Errorf("expected the body to be left out, got %#v", content)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/golang/glog"
+)
+
+// signature returns the hex encoded HMAC-SHA256 with key of update as JSON, without its
+// Signature, or "" if it cannot be encoded.
+func signature(update ServiceUpdate, key []byte) string {
+	update.Signature = ""
+	data, err := json.Marshal(update)
+	if err != nil {
+		glog.Errorf("Unable to encode %s of services to sign it: %v", update.Op, err)
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature returns whether u was signed with key by a source with that SigningKey and
+// has not been changed since.
+func VerifySignature(u ServiceUpdate, key []byte) bool {
+	expected := signature(u, key)
+	return expected != "" && hmac.Equal([]byte(expected), []byte(u.Signature))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestSigningKey(t *testing.T) {
+	key := []byte("secret")
+	services := make(chan ServiceUpdate, 1)
+	source := SourceAPI{services: services}
+	source.SigningKey = key
+	source.Name = "api"
+	source.Clock = newFakeClock()
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}}})
+	signed := <-services
+	if signed.Signature == "" {
+		t.Fatalf("expected a signature, got %#v", signed)
+	}
+	if !VerifySignature(signed, key) {
+		t.Errorf("expected %#v to be verified", signed)
+	}
+
+	// the signature survives the trip across the network
+	data, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var received ServiceUpdate
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !VerifySignature(received, key) {
+		t.Errorf("expected %#v to be verified", received)
+	}
+
+	if VerifySignature(signed, []byte("other")) {
+		t.Errorf("expected a different key to fail")
+	}
+	tampered := received
+	tampered.Services = []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 8080}}
+	if VerifySignature(tampered, key) {
+		t.Errorf("expected changed services to fail")
+	}
+	tampered = received
+	tampered.Op = REMOVE
+	if VerifySignature(tampered, key) {
+		t.Errorf("expected a changed operation to fail")
+	}
+	tampered = received
+	tampered.Signature = ""
+	if VerifySignature(tampered, key) {
+		t.Errorf("expected an unsigned update to fail")
+	}
+}
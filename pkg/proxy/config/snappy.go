@@ -0,0 +1,54 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/golang/snappy"
+)
+
+// SnappyUpdateCodec compresses the updates serialized by Codec with snappy, which is faster
+// than gzip at a slightly worse ratio. Each update is a snappy block, not a framed stream.
+type SnappyUpdateCodec struct {
+	// Codec serializes the updates before they are compressed. Defaults to JSONUpdateCodec.
+	Codec UpdateCodec
+}
+
+func (c SnappyUpdateCodec) codec() UpdateCodec {
+	if c.Codec == nil {
+		return JSONUpdateCodec{}
+	}
+	return c.Codec
+}
+
+// Encode returns update serialized by Codec and compressed.
+func (c SnappyUpdateCodec) Encode(update ServiceUpdate) ([]byte, error) {
+	data, err := c.codec().Encode(update)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// Decode decompresses data and decodes the update with Codec. It returns snappy.ErrCorrupt if
+// data is not a valid snappy block.
+func (c SnappyUpdateCodec) Decode(data []byte, update *ServiceUpdate) error {
+	decoded, err := snappy.Decode(nil, data)
+	if err != nil {
+		return err
+	}
+	return c.codec().Decode(decoded, update)
+}
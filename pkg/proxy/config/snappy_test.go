@@ -0,0 +1,113 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/snappy"
+)
+
+func codecUpdate(op Operation, n int) ServiceUpdate {
+	update := ServiceUpdate{Op: op, Source: "api"}
+	for i := 0; i < n; i++ {
+		update.Services = append(update.Services, api.Service{
+			JSONBase: api.JSONBase{ID: fmt.Sprintf("service-%d", i), ResourceVersion: uint64(i)},
+			Port:     8000 + i,
+			Labels:   map[string]string{NamespaceLabel: "default"},
+		})
+	}
+	return update
+}
+
+func TestUpdateCodecs(t *testing.T) {
+	codecs := []UpdateCodec{JSONUpdateCodec{}, SnappyUpdateCodec{}}
+	for _, codec := range codecs {
+		for op := range operationNames {
+			expected := codecUpdate(Operation(op), 3)
+			data, err := codec.Encode(expected)
+			if err != nil {
+				t.Fatalf("%T: unexpected error: %v", codec, err)
+			}
+			var actual ServiceUpdate
+			if err := codec.Decode(data, &actual); err != nil {
+				t.Fatalf("%T: unexpected error: %v", codec, err)
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("%T: expected %#v, got %#v", codec, expected, actual)
+			}
+		}
+	}
+
+	json, _ := JSONUpdateCodec{}.Encode(codecUpdate(SET, 100))
+	compressed, _ := SnappyUpdateCodec{}.Encode(codecUpdate(SET, 100))
+	if len(compressed) >= len(json)/2 {
+		t.Errorf("expected the update to compress to less than half of %d bytes, got %d", len(json), len(compressed))
+	}
+}
+
+func TestSnappyCorrupt(t *testing.T) {
+	// a block claiming 5 bytes that holds 1
+	if err := (SnappyUpdateCodec{}).Decode([]byte{5, 0, 'a'}, &ServiceUpdate{}); err != snappy.ErrCorrupt {
+		t.Errorf("expected %v, got %v", snappy.ErrCorrupt, err)
+	}
+}
+
+func TestKafkaSnappy(t *testing.T) {
+	kafka := &fakeKafka{}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.KafkaProducer = kafka
+	source.KafkaTopic = "services"
+	source.KafkaCodec = SnappyUpdateCodec{}
+	expected := codecUpdate(ADD, 1)
+	go source.sendServices(expected)
+	<-services
+
+	kafka.messages = make(chan KafkaMessage, 1)
+	kafka.messages <- kafka.sent[0]
+	close(kafka.messages)
+	remote := make(chan ServiceUpdate)
+	NewKafkaConsumerSourceWithCodec(kafka, SnappyUpdateCodec{}, remote)
+	if actual := <-remote; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func benchmarkUpdateCodec(b *testing.B, codec UpdateCodec) {
+	update := codecUpdate(SET, 100)
+	for i := 0; i < b.N; i++ {
+		data, err := codec.Encode(update)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := codec.Decode(data, &ServiceUpdate{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJSONUpdateCodec(b *testing.B) {
+	benchmarkUpdateCodec(b, JSONUpdateCodec{})
+}
+
+func BenchmarkSnappyUpdateCodec(b *testing.B) {
+	benchmarkUpdateCodec(b, SnappyUpdateCodec{})
+}
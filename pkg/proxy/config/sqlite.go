@@ -0,0 +1,342 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	"github.com/golang/glog"
+)
+
+// sqliteDriver is the database/sql driver used by SQLiteEventStore. It is registered by
+// importing github.com/mattn/go-sqlite3 in the program.
+const sqliteDriver = "sqlite3"
+
+// defaultCheckpointEvery is the number of events an SQLiteEventStore appends for a resource
+// before it checkpoints the state they build.
+const defaultCheckpointEvery = 1000
+
+// snapshotEvent is the type of the rows of an SQLiteEventStore that hold a full list.
+const snapshotEvent = "SNAPSHOT"
+
+const createEventsTable = `CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	resource TEXT NOT NULL,
+	timestamp INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL
+)`
+
+// SQLiteEventStore keeps the events of the watches of a SourceAPI in an SQLite file, from the
+// last full list on, so that a restarted source can rebuild its state without listing.
+// Each row holds the time an event was received, its type and its object as JSON. Every
+// checkpointEvery events of a resource, the state they build is stored as a snapshot, so that
+// the events before it are deleted and the file does not grow without bound between lists.
+type SQLiteEventStore struct {
+	db              *sql.DB
+	checkpointEvery int
+
+	lock sync.Mutex
+	// appended is the number of events appended for each resource since its last snapshot.
+	appended map[ResourceType]int
+}
+
+// NewSQLiteEventStore opens the store in the SQLite file at path, creating it if needed.
+func NewSQLiteEventStore(path string) (*SQLiteEventStore, error) {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createEventsTable); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteEventStore{
+		db:              db,
+		checkpointEvery: defaultCheckpointEvery,
+		appended:        make(map[ResourceType]int),
+	}, nil
+}
+
+// Close closes the file of the store.
+func (s *SQLiteEventStore) Close() error {
+	return s.db.Close()
+}
+
+// Snapshot stores list, the result of a full list of resource, and deletes the events of
+// resource before it, which it replaces.
+func (s *SQLiteEventStore) Snapshot(resource ResourceType, list runtime.Object, t time.Time) error {
+	payload, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO events (resource, timestamp, type, payload) VALUES (?, ?, ?, ?)", string(resource), t.UnixNano(), snapshotEvent, string(payload))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM events WHERE resource = ? AND id < ?", string(resource), id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.appended[resource] = 0
+	return nil
+}
+
+// Append stores an event of a watch of resource, received at t, and checkpoints resource if
+// enough events were appended since its last snapshot. The events of a resource must be
+// appended from a single goroutine, as the checkpoint replays them.
+func (s *SQLiteEventStore) Append(resource ResourceType, event watch.Event, t time.Time) error {
+	payload, err := json.Marshal(event.Object)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("INSERT INTO events (resource, timestamp, type, payload) VALUES (?, ?, ?, ?)", string(resource), t.UnixNano(), string(event.Type), string(payload)); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.appended[resource]++
+	checkpoint := s.appended[resource] >= s.checkpointEvery
+	s.lock.Unlock()
+	if !checkpoint {
+		return nil
+	}
+	return s.checkpoint(resource, t)
+}
+
+// checkpoint stores the state replayed for resource as a snapshot taken at t, deleting the
+// events it replaces. Without a snapshot to start from, the events do not make up the full
+// state, and nothing is stored.
+func (s *SQLiteEventStore) checkpoint(resource ResourceType, t time.Time) error {
+	var snapshots int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM events WHERE resource = ? AND type = ?", string(resource), snapshotEvent).Scan(&snapshots); err != nil {
+		return err
+	}
+	if snapshots == 0 {
+		return nil
+	}
+	var list runtime.Object
+	switch resource {
+	case ServicesResource:
+		services, version, _, err := s.ReplayServices()
+		if err != nil {
+			return err
+		}
+		list = &api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: version}, Items: services}
+	case EndpointsResource:
+		endpoints, version, _, err := s.ReplayEndpoints()
+		if err != nil {
+			return err
+		}
+		list = &api.EndpointsList{JSONBase: api.JSONBase{ResourceVersion: version}, Items: endpoints}
+	default:
+		return ErrUnknownResourceType
+	}
+	return s.Snapshot(resource, list, t)
+}
+
+// replay calls apply for the last snapshot of resource and each event after it, and returns
+// the number of events, not counting the snapshot.
+func (s *SQLiteEventStore) replay(resource ResourceType, apply func(eventType string, payload []byte) error) (int, error) {
+	rows, err := s.db.Query(`SELECT type, payload FROM events WHERE resource = ? AND id >=
+		COALESCE((SELECT MAX(id) FROM events WHERE resource = ? AND type = ?), 0) ORDER BY id`,
+		string(resource), string(resource), snapshotEvent)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	events := 0
+	for rows.Next() {
+		var eventType, payload string
+		if err := rows.Scan(&eventType, &payload); err != nil {
+			return 0, err
+		}
+		if err := apply(eventType, []byte(payload)); err != nil {
+			return 0, err
+		}
+		if eventType != snapshotEvent {
+			events++
+		}
+	}
+	return events, rows.Err()
+}
+
+// ReplayServices returns the services and the resource version to resume watching from as
+// of the last stored event, and the number of events replayed after the last snapshot. It
+// returns a version of 0 if no snapshot is stored.
+func (s *SQLiteEventStore) ReplayServices() ([]api.Service, uint64, int, error) {
+	services := make(map[string]api.Service)
+	var version uint64
+	events, err := s.replay(ServicesResource, func(eventType string, payload []byte) error {
+		if eventType == snapshotEvent {
+			list := api.ServiceList{}
+			if err := json.Unmarshal(payload, &list); err != nil {
+				return err
+			}
+			for _, service := range list.Items {
+				services[ServiceKey(service)] = service
+			}
+			version = list.ResourceVersion
+			return nil
+		}
+		service := api.Service{}
+		if err := json.Unmarshal(payload, &service); err != nil {
+			return err
+		}
+		// Drop stale events, as in handleServicesWatch.
+		if service.ResourceVersion+1 <= version {
+			return nil
+		}
+		version = service.ResourceVersion + 1
+		switch watch.EventType(eventType) {
+		case watch.Added, watch.Modified:
+			services[ServiceKey(service)] = service
+		case watch.Deleted:
+			delete(services, ServiceKey(service))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	result := make([]api.Service, 0, len(services))
+	for _, service := range services {
+		result = append(result, service)
+	}
+	sort.Sort(servicesByID(result))
+	return result, version, events, nil
+}
+
+// ReplayEndpoints is ReplayServices for endpoints.
+func (s *SQLiteEventStore) ReplayEndpoints() ([]api.Endpoints, uint64, int, error) {
+	endpoints := make(map[string]api.Endpoints)
+	var version uint64
+	events, err := s.replay(EndpointsResource, func(eventType string, payload []byte) error {
+		if eventType == snapshotEvent {
+			list := api.EndpointsList{}
+			if err := json.Unmarshal(payload, &list); err != nil {
+				return err
+			}
+			for _, e := range list.Items {
+				endpoints[e.ID] = e
+			}
+			version = list.ResourceVersion
+			return nil
+		}
+		e := api.Endpoints{}
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return err
+		}
+		if e.ResourceVersion+1 <= version {
+			return nil
+		}
+		version = e.ResourceVersion + 1
+		switch watch.EventType(eventType) {
+		case watch.Added, watch.Modified:
+			endpoints[e.ID] = e
+		case watch.Deleted:
+			delete(endpoints, e.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	result := make([]api.Endpoints, 0, len(endpoints))
+	for _, e := range endpoints {
+		result = append(result, e)
+	}
+	sort.Sort(endpointsByID(result))
+	return result, version, events, nil
+}
+
+// replayServices sends the services replayed from the EventStore as a SET and resumes from
+// their resource version, if the store holds a list of services.
+func (s *SourceAPI) replayServices() {
+	services, version, events, err := s.EventStore.ReplayServices()
+	if err != nil {
+		glog.Errorf("Unable to replay stored services: %v", err)
+		return
+	}
+	if version == 0 {
+		return
+	}
+	glog.Infof("Replayed %d stored services events up to resource version %d", events, version)
+	s.serviceVersion.Set(version)
+	if s.SnapshotFencing {
+		s.sendServices(ServiceUpdate{Op: SNAPSHOT_START})
+	}
+	s.sendServices(ServiceUpdate{Op: SET, Services: services})
+	if s.SnapshotFencing {
+		s.sendServices(ServiceUpdate{Op: SNAPSHOT_END})
+	}
+}
+
+// replayEndpoints is replayServices for endpoints.
+func (s *SourceAPI) replayEndpoints() {
+	endpoints, version, events, err := s.EventStore.ReplayEndpoints()
+	if err != nil {
+		glog.Errorf("Unable to replay stored endpoints: %v", err)
+		return
+	}
+	if version == 0 {
+		return
+	}
+	glog.Infof("Replayed %d stored endpoints events up to resource version %d", events, version)
+	s.endpointsVersion.Set(version)
+	if s.SnapshotFencing {
+		s.sendEndpoints(EndpointsUpdate{Op: SNAPSHOT_START})
+	}
+	s.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: endpoints})
+	if s.SnapshotFencing {
+		s.sendEndpoints(EndpointsUpdate{Op: SNAPSHOT_END})
+	}
+}
+
+// storeEvents passes on the events of a watch of resource, storing each in the EventStore
+// as it goes. It stops when in is closed or done is.
+func (s *SourceAPI) storeEvents(resource ResourceType, in <-chan watch.Event, done <-chan struct{}) <-chan watch.Event {
+	return forwardEvents(in, done, func(event watch.Event) bool {
+		if event.Type != watch.Error {
+			if err := s.EventStore.Append(resource, event, s.clock().Now()); err != nil {
+				glog.Errorf("Unable to store %s event: %v", resource, err)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,179 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The tests in this file need the cgo SQLite driver and are run with
+//   go test -tags sqlite github.com/vishvananda/wormhole/pkg/proxy/config
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLiteEventStoreReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.db")
+
+	store, err := NewSQLiteEventStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 1}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}, Port: 81}
+	newFoo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 3}, Port: 90}
+	deletedBar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 4}, Port: 81}
+
+	fakeWatch := watch.NewFake()
+	fakeClient := &client.Fake{Watch: fakeWatch}
+	fakeClient.ServiceList = api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 1}, Items: []api.Service{foo}}
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{client: fakeClient, services: services}
+	source.EventStore = store
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+	<-services
+	fakeWatch.Add(&bar)
+	<-services
+	fakeWatch.Modify(&newFoo)
+	<-services
+	fakeWatch.Delete(&deletedBar)
+	<-services
+	fakeWatch.Stop()
+	<-done
+	store.Close()
+
+	// a restarted source replays the stored events instead of listing
+	store, err = NewSQLiteEventStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	replayed, version, events, err := store.ReplayServices()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events != 3 {
+		t.Errorf("expected 3 events, got %d", events)
+	}
+	if version != 5 {
+		t.Errorf("expected resource version 5, got %d", version)
+	}
+	if expected := []api.Service{newFoo}; !reflect.DeepEqual(expected, replayed) {
+		t.Errorf("expected %#v, got %#v", expected, replayed)
+	}
+
+	fakeWatch = watch.NewFake()
+	fakeClient = &client.Fake{Watch: fakeWatch}
+	source = SourceAPI{client: fakeClient, services: services}
+	source.EventStore = store
+	done = make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{newFoo}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	fakeWatch.Stop()
+	<-done
+	if !reflect.DeepEqual(fakeClient.Actions, []client.FakeAction{{"watch-services", uint64(5)}}) {
+		t.Errorf("expected only a watch from the replayed version, got %#v", fakeClient.Actions)
+	}
+
+	// without a stored list there is nothing to replay
+	if _, version, events, err := store.ReplayEndpoints(); err != nil || version != 0 || events != 0 {
+		t.Errorf("expected nothing to replay, got %d, %d, %v", version, events, err)
+	}
+}
+
+func TestSQLiteEventStoreCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wormhole-events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewSQLiteEventStore(filepath.Join(dir, "events.db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	store.checkpointEvery = 2
+	now := time.Now()
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 1}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 2}, Port: 81}
+	newFoo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 3}, Port: 90}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz", ResourceVersion: 4}, Port: 82}
+
+	// events before a list are not enough to checkpoint from
+	if err := store.Append(ServicesResource, watch.Event{watch.Added, &bar}, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Append(ServicesResource, watch.Event{watch.Added, &baz}, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	list := &api.ServiceList{JSONBase: api.JSONBase{ResourceVersion: 1}, Items: []api.Service{foo}}
+	if err := store.Snapshot(ServicesResource, list, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, event := range []watch.Event{{watch.Added, &bar}, {watch.Modified, &newFoo}, {watch.Added, &baz}} {
+		if err := store.Append(ServicesResource, event, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var rows int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rows != 2 {
+		t.Errorf("expected a checkpoint and the event after it, got %d rows", rows)
+	}
+	replayed, version, events, err := store.ReplayServices()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events != 1 {
+		t.Errorf("expected 1 event, got %d", events)
+	}
+	if version != 5 {
+		t.Errorf("expected resource version 5, got %d", version)
+	}
+	if expected := []api.Service{bar, baz, newFoo}; !reflect.DeepEqual(expected, replayed) {
+		t.Errorf("expected %#v, got %#v", expected, replayed)
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+)
+
+// The types of change passed to ConfigStore.OnChange.
+const (
+	ChangeAdd    = "ADD"
+	ChangeRemove = "REMOVE"
+	ChangeModify = "MODIFY"
+)
+
+// ConfigStore holds the union of the services and endpoints received from each source.
+// Updates are applied directly with UpdateServices and UpdateEndpoints rather than through
+// a channel, and the current state can be read at any time. It is safe for concurrent use.
+type ConfigStore struct {
+	// OnChange, if set, is called synchronously by UpdateServices for each service it adds,
+	// removes or modifies, with ChangeAdd, ChangeRemove or ChangeModify and the service ID.
+	// It must be set before the store is used.
+	OnChange func(changeType string, serviceID string)
+
+	services  *serviceStore
+	endpoints *endpointsStore
+	// serviceUpdateLock makes the state seen before and after each service update consistent.
+	serviceUpdateLock sync.Mutex
+
+	failoverLock sync.RWMutex
+	failover     map[string]FailoverPolicy
+	unhealthy    map[string]bool
+}
+
+// NewConfigStore creates an empty ConfigStore.
+func NewConfigStore() *ConfigStore {
+	services := newServiceStore(nil)
+	services.index = make(labelIndex)
+	return &ConfigStore{
+		services:  services,
+		endpoints: newEndpointsStore(nil),
+		failover:  make(map[string]FailoverPolicy),
+		unhealthy: make(map[string]bool),
+	}
+}
+
+// UpdateServices applies a service update from source.
+func (s *ConfigStore) UpdateServices(source string, update ServiceUpdate) {
+	if s.OnChange == nil {
+		if err := s.services.Merge(source, update); err != nil {
+			glog.Errorf("Failed to apply service update from %s: %v", source, err)
+		}
+		return
+	}
+
+	s.serviceUpdateLock.Lock()
+	defer s.serviceUpdateLock.Unlock()
+	// The services that may change are those source had and those in the update.
+	var ids []string
+	before := make(map[string]api.Service)
+	for id := range s.services.sourceState()[source] {
+		ids = append(ids, id)
+	}
+	for _, service := range update.Services {
+		ids = append(ids, ServiceKey(service))
+	}
+	for _, id := range ids {
+		if service, found := s.services.get(id); found {
+			before[id] = service
+		}
+	}
+	if err := s.services.Merge(source, update); err != nil {
+		glog.Errorf("Failed to apply service update from %s: %v", source, err)
+		return
+	}
+	notified := make(map[string]bool)
+	for _, id := range ids {
+		if notified[id] {
+			continue
+		}
+		notified[id] = true
+		old, existed := before[id]
+		service, exists := s.services.get(id)
+		switch {
+		case !existed && exists:
+			s.OnChange(ChangeAdd, id)
+		case existed && !exists:
+			s.OnChange(ChangeRemove, id)
+		case existed && exists && !reflect.DeepEqual(old, service):
+			s.OnChange(ChangeModify, id)
+		}
+	}
+}
+
+// UpdateEndpoints applies an endpoints update from source.
+func (s *ConfigStore) UpdateEndpoints(source string, update EndpointsUpdate) {
+	if err := s.endpoints.Merge(source, update); err != nil {
+		glog.Errorf("Failed to apply endpoints update from %s: %v", source, err)
+	}
+}
+
+// ListServices returns a copy of all services.
+func (s *ConfigStore) ListServices() []api.Service {
+	return s.services.MergedState().([]api.Service)
+}
+
+// Query returns the services whose labels match selector, sorted by ServiceKey. The services
+// are looked up by the labels selector requires an exact value of, rather than all matched.
+func (s *ConfigStore) Query(selector labels.Selector) []api.Service {
+	return s.services.query(selector)
+}
+
+// ForEach calls fn with each service, in no particular order, until it returns false. Unlike
+// ListServices it does not copy the services, but it holds the read lock of the store, so fn
+// must not update the store.
+func (s *ConfigStore) ForEach(fn func(api.Service) bool) {
+	s.services.forEach(fn)
+}
+
+// ListEndpoints returns a copy of all endpoints.
+func (s *ConfigStore) ListEndpoints() []api.Endpoints {
+	return s.endpoints.MergedState().([]api.Endpoints)
+}
+
+// GetService returns the service with the given ID, or NamespacedName if it has a namespace.
+func (s *ConfigStore) GetService(id string) (api.Service, bool) {
+	return s.services.get(id)
+}
+
+// GetEndpoints returns the endpoints of the service with the given ID.
+func (s *ConfigStore) GetEndpoints(id string) (api.Endpoints, bool) {
+	return s.endpoints.get(id)
+}
+
+// ServiceIDs returns the IDs of all services, sorted, without copying the services. The ID of a
+// service with a namespace is its NamespacedName.
+func (s *ConfigStore) ServiceIDs() []string {
+	return s.services.ids()
+}
+
+// EndpointServiceIDs returns the IDs of the services that have endpoints, sorted, without
+// copying the endpoints.
+func (s *ConfigStore) EndpointServiceIDs() []string {
+	return s.endpoints.ids()
+}
+
+// ids returns the IDs of the endpoints of all sources, sorted.
+func (s *endpointsStore) ids() []string {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	ids := util.StringSet{}
+	for _, sourceEndpoints := range s.endpoints {
+		for id := range sourceEndpoints {
+			ids.Insert(id)
+		}
+	}
+	return ids.List()
+}
+
+// ids returns the IDs of the services of all sources, sorted.
+func (s *serviceStore) ids() []string {
+	s.serviceLock.RLock()
+	defer s.serviceLock.RUnlock()
+	ids := util.StringSet{}
+	for _, sourceServices := range s.services {
+		for id := range sourceServices {
+			ids.Insert(id)
+		}
+	}
+	return ids.List()
+}
+
+// forEach calls fn with the services of all sources until it returns false.
+func (s *serviceStore) forEach(fn func(api.Service) bool) {
+	s.serviceLock.RLock()
+	defer s.serviceLock.RUnlock()
+	for _, sourceServices := range s.services {
+		for _, value := range sourceServices {
+			if !fn(value) {
+				return
+			}
+		}
+	}
+}
+
+// get returns the endpoints with the given ID from whichever source has them.
+func (s *endpointsStore) get(id string) (api.Endpoints, bool) {
+	s.endpointLock.RLock()
+	defer s.endpointLock.RUnlock()
+	for _, sourceEndpoints := range s.endpoints {
+		if value, found := sourceEndpoints[id]; found {
+			return value, true
+		}
+	}
+	return api.Endpoints{}, false
+}
+
+// get returns the service with the given ID from whichever source has it.
+func (s *serviceStore) get(id string) (api.Service, bool) {
+	s.serviceLock.RLock()
+	defer s.serviceLock.RUnlock()
+	for _, sourceServices := range s.services {
+		if value, found := sourceServices[id]; found {
+			return value, true
+		}
+	}
+	return api.Service{}, false
+}
+
+// updateViewSource is the source the updates consumed by an UpdateView are merged as.
+const updateViewSource = "view"
+
+// UpdateView holds the current services and endpoints as of the updates read from a pair of
+// channels, such as those of a SourceAPI, so that consumers need not keep their own maps.
+// It is safe for concurrent use.
+type UpdateView struct {
+	store *ConfigStore
+	done  sync.WaitGroup
+}
+
+// NewUpdateView creates an UpdateView and starts consuming services and endpoints, either of
+// which may be nil, until they are closed.
+func NewUpdateView(services <-chan ServiceUpdate, endpoints <-chan EndpointsUpdate) *UpdateView {
+	s := &UpdateView{store: NewConfigStore()}
+	if services != nil {
+		s.done.Add(1)
+		go func() {
+			defer util.HandleCrash()
+			defer s.done.Done()
+			for update := range services {
+				s.store.UpdateServices(updateViewSource, update)
+			}
+		}()
+	}
+	if endpoints != nil {
+		s.done.Add(1)
+		go func() {
+			defer util.HandleCrash()
+			defer s.done.Done()
+			for update := range endpoints {
+				s.store.UpdateEndpoints(updateViewSource, update)
+			}
+		}()
+	}
+	return s
+}
+
+// Services returns a copy of the current services, sorted by ServiceKey.
+func (s *UpdateView) Services() []api.Service {
+	services := s.store.ListServices()
+	sort.Sort(servicesByID(services))
+	return services
+}
+
+// Endpoints returns a copy of the current endpoints, sorted by ID. As in an EndpointsConfig,
+// the addresses of each named port are endpoints of their own.
+func (s *UpdateView) Endpoints() []api.Endpoints {
+	endpoints := s.store.ListEndpoints()
+	sort.Sort(endpointsByID(endpoints))
+	return endpoints
+}
+
+// Wait blocks until the channels are closed and all of their updates are applied.
+func (s *UpdateView) Wait() {
+	s.done.Wait()
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+)
+
+func TestConfigStore(t *testing.T) {
+	store := NewConfigStore()
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 20}
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{foo}})
+	store.UpdateServices("two", ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	services := store.ListServices()
+	if len(services) != 2 {
+		t.Errorf("expected 2 services, got %#v", services)
+	}
+	if service, found := store.GetService("bar"); !found || !reflect.DeepEqual(service, bar) {
+		t.Errorf("expected %#v, got %#v", bar, service)
+	}
+
+	store.UpdateServices("two", ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}})
+	if _, found := store.GetService("bar"); found {
+		t.Errorf("expected bar to be removed")
+	}
+
+	endpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	store.UpdateEndpoints("one", EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{endpoints}})
+	if actual, found := store.GetEndpoints("foo"); !found || !reflect.DeepEqual(actual, endpoints) {
+		t.Errorf("expected %#v, got %#v", endpoints, actual)
+	}
+}
+
+func TestConfigStoreOnChange(t *testing.T) {
+	store := NewConfigStore()
+	var changes []string
+	store.OnChange = func(changeType string, serviceID string) {
+		changes = append(changes, changeType+" "+serviceID)
+	}
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 20}
+	newFoo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 11}
+
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{foo, bar}})
+	// re-adding an unchanged service is not a change
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{newFoo}})
+	store.UpdateServices("one", ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}})
+	// a SET removes the services it leaves out
+	store.UpdateServices("one", ServiceUpdate{Op: SET, Services: []api.Service{bar}})
+
+	expected := []string{
+		"ADD foo",
+		"ADD bar",
+		"MODIFY foo",
+		"REMOVE bar",
+		"REMOVE foo",
+		"ADD bar",
+	}
+	if !reflect.DeepEqual(expected, changes) {
+		t.Errorf("expected %#v, got %#v", expected, changes)
+	}
+}
+
+func TestConfigStoreIDs(t *testing.T) {
+	store := NewConfigStore()
+	service := func(id string) api.Service { return api.Service{JSONBase: api.JSONBase{ID: id}} }
+	endpoints := func(id string) api.Endpoints { return api.Endpoints{JSONBase: api.JSONBase{ID: id}} }
+
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{service("foo"), service("bar")}})
+	store.UpdateServices("two", ServiceUpdate{Op: ADD, Services: []api.Service{service("baz"), service("foo")}})
+	store.UpdateServices("one", ServiceUpdate{Op: REMOVE, Services: []api.Service{service("bar")}})
+	store.UpdateEndpoints("one", EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{endpoints("foo"), endpoints("bar")}})
+	store.UpdateEndpoints("one", EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{endpoints("foo")}})
+
+	if expected, actual := []string{"baz", "foo"}, store.ServiceIDs(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if expected, actual := []string{"bar"}, store.EndpointServiceIDs(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	store.UpdateServices("two", ServiceUpdate{Op: SET})
+	if expected, actual := []string{"foo"}, store.ServiceIDs(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestConfigStoreForEach(t *testing.T) {
+	store := NewConfigStore()
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 20}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 30}
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{foo, bar}})
+	store.UpdateServices("two", ServiceUpdate{Op: ADD, Services: []api.Service{baz}})
+
+	seen := []api.Service{}
+	store.ForEach(func(service api.Service) bool {
+		seen = append(seen, service)
+		return true
+	})
+	sort.Sort(servicesByID(seen))
+	if expected := []api.Service{bar, baz, foo}; !reflect.DeepEqual(expected, seen) {
+		t.Errorf("expected %#v, got %#v", expected, seen)
+	}
+
+	calls := 0
+	store.ForEach(func(service api.Service) bool {
+		calls++
+		return calls < 2
+	})
+	if calls != 2 {
+		t.Errorf("expected iteration to stop after 2 services, got %d", calls)
+	}
+}
+
+func TestGetActiveEndpointsWithoutPolicy(t *testing.T) {
+	store := NewConfigStore()
+	store.UpdateEndpoints("one", EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{{
+		JSONBase:  api.JSONBase{ID: "foo"},
+		Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"},
+	}}})
+	expected := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	if actual := store.GetActiveEndpoints("foo"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if actual := store.GetActiveEndpoints("bar"); len(actual) != 0 {
+		t.Errorf("expected no endpoints, got %#v", actual)
+	}
+}
+
+func TestFailoverAndRecovery(t *testing.T) {
+	store := NewConfigStore()
+	store.SetFailoverPolicy("foo", FailoverPolicy{
+		Primary: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		Backup:  []string{"10.0.1.1:80"},
+	})
+
+	expected := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	if actual := store.GetActiveEndpoints("foo"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// a single failed primary is dropped but does not trigger failover
+	store.SetEndpointHealth("10.0.0.1:80", false)
+	expected = []string{"10.0.0.2:80"}
+	if actual := store.GetActiveEndpoints("foo"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	store.SetEndpointHealth("10.0.0.2:80", false)
+	expected = []string{"10.0.1.1:80"}
+	if actual := store.GetActiveEndpoints("foo"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// recovery of any primary moves traffic back off the backups
+	store.SetEndpointHealth("10.0.0.2:80", true)
+	expected = []string{"10.0.0.2:80"}
+	if actual := store.GetActiveEndpoints("foo"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestUpdateView(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	view := NewUpdateView(services, endpoints)
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 10}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 20}
+	fooEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+
+	// reads are safe while the updates are applied
+	stop := make(chan struct{})
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				view.Services()
+				view.Endpoints()
+			}
+		}
+	}()
+	services <- ServiceUpdate{Op: SET, Services: []api.Service{foo, bar}}
+	endpoints <- EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{fooEndpoints}}
+	services <- ServiceUpdate{Op: REMOVE, Services: []api.Service{foo}}
+	close(services)
+	close(endpoints)
+	view.Wait()
+	close(stop)
+	<-read
+
+	if actual := view.Services(); !reflect.DeepEqual([]api.Service{bar}, actual) {
+		t.Errorf("expected %#v, got %#v", []api.Service{bar}, actual)
+	}
+	if actual := view.Endpoints(); !reflect.DeepEqual([]api.Endpoints{fooEndpoints}, actual) {
+		t.Errorf("expected %#v, got %#v", []api.Endpoints{fooEndpoints}, actual)
+	}
+}
+
+func TestConfigStoreQuery(t *testing.T) {
+	store := NewConfigStore()
+	service := func(id string, serviceLabels map[string]string) api.Service {
+		return api.Service{JSONBase: api.JSONBase{ID: id}, Port: 80, Labels: serviceLabels}
+	}
+	foo := service("foo", map[string]string{"app": "web", "tier": "frontend"})
+	bar := service("bar", map[string]string{"app": "web", "tier": "backend"})
+	baz := service("baz", map[string]string{"app": "db", "tier": "backend"})
+	qux := service("qux", nil)
+	store.UpdateServices("one", ServiceUpdate{Op: SET, Services: []api.Service{foo, bar, qux}})
+	store.UpdateServices("two", ServiceUpdate{Op: ADD, Services: []api.Service{baz}})
+
+	query := func(selector labels.Set) []api.Service {
+		return store.Query(selector.AsSelector())
+	}
+	tests := []struct {
+		selector labels.Set
+		expected []api.Service
+	}{
+		{labels.Set{"app": "web"}, []api.Service{bar, foo}},
+		{labels.Set{"app": "web", "tier": "backend"}, []api.Service{bar}},
+		{labels.Set{"app": "db", "tier": "frontend"}, []api.Service{}},
+		{labels.Set{"app": "cache"}, []api.Service{}},
+		{labels.Set{"unknown": "label"}, []api.Service{}},
+		{labels.Set{}, []api.Service{bar, baz, foo, qux}},
+	}
+	for _, test := range tests {
+		if actual := query(test.selector); !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("%v: expected %#v, got %#v", test.selector, test.expected, actual)
+		}
+	}
+
+	// a modified service is indexed by its new labels only
+	bar = service("bar", map[string]string{"app": "api", "tier": "backend"})
+	store.UpdateServices("one", ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	if actual := query(labels.Set{"app": "web"}); !reflect.DeepEqual([]api.Service{foo}, actual) {
+		t.Errorf("expected %#v, got %#v", []api.Service{foo}, actual)
+	}
+	if actual := query(labels.Set{"tier": "backend"}); !reflect.DeepEqual([]api.Service{bar, baz}, actual) {
+		t.Errorf("expected %#v, got %#v", []api.Service{bar, baz}, actual)
+	}
+
+	store.UpdateServices("two", ServiceUpdate{Op: REMOVE, Services: []api.Service{baz}})
+	if actual := query(labels.Set{"tier": "backend"}); !reflect.DeepEqual([]api.Service{bar}, actual) {
+		t.Errorf("expected %#v, got %#v", []api.Service{bar}, actual)
+	}
+	// a SET drops the services it leaves out from the index
+	store.UpdateServices("one", ServiceUpdate{Op: SET, Services: []api.Service{foo}})
+	if actual := query(labels.Set{"tier": "backend"}); len(actual) != 0 {
+		t.Errorf("expected no services, got %#v", actual)
+	}
+	if len(store.services.index) != 2 || len(store.services.index["app"]) != 1 {
+		t.Errorf("expected only the labels of foo to be indexed, got %#v", store.services.index)
+	}
+}
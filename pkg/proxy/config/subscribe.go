@@ -0,0 +1,162 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"sync"
+)
+
+// ResourceType names the kind of objects an Event is about.
+type ResourceType string
+
+const (
+	ServicesResource  ResourceType = "services"
+	EndpointsResource ResourceType = "endpoints"
+)
+
+var ErrUnknownResourceType = errors.New("unknown resource type")
+
+// Event is an update sent by a SourceAPI to its subscriptions.
+type Event struct {
+	Resource ResourceType
+	// Services is set if Resource is ServicesResource.
+	Services *ServiceUpdate
+	// Endpoints is set if Resource is EndpointsResource.
+	Endpoints *EndpointsUpdate
+}
+
+// Subscription receives the events a SourceAPI sends to a subscriber.
+type Subscription interface {
+	// Events returns the channel of events. It is closed by Cancel, or by the Close of the
+	// source, and must be drained until then, as the source waits for every subscription to
+	// take each event.
+	Events() <-chan Event
+	// Cancel stops the delivery of events.
+	Cancel()
+}
+
+type subscription struct {
+	source    *SourceAPI
+	resource  ResourceType
+	predicate func(Event) bool
+	events    chan Event
+	done      chan struct{}
+	once      sync.Once
+}
+
+func (sub *subscription) Events() <-chan Event {
+	return sub.events
+}
+
+func (sub *subscription) Cancel() {
+	sub.once.Do(func() {
+		// Unblock a send in progress before waiting for it to give up the lock.
+		close(sub.done)
+		s := sub.source
+		s.subscriptionLock.Lock()
+		defer s.subscriptionLock.Unlock()
+		for i, other := range s.subscriptions {
+			if other == sub {
+				s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+				break
+			}
+		}
+		close(sub.events)
+	})
+}
+
+// Subscribe returns a Subscription to the updates of resource for which predicate returns
+// true. A nil predicate matches every update. Subscriptions receive updates in addition to
+// the services and endpoints channels of s, which may be nil if only subscriptions are used.
+func (s *SourceAPI) Subscribe(resource ResourceType, predicate func(Event) bool) (Subscription, error) {
+	if resource != ServicesResource && resource != EndpointsResource {
+		return nil, ErrUnknownResourceType
+	}
+	if predicate == nil {
+		predicate = func(Event) bool { return true }
+	}
+	sub := &subscription{
+		source:    s,
+		resource:  resource,
+		predicate: predicate,
+		events:    make(chan Event),
+		done:      make(chan struct{}),
+	}
+	s.subscriptionLock.Lock()
+	defer s.subscriptionLock.Unlock()
+	s.subscriptions = append(s.subscriptions, sub)
+	return sub, nil
+}
+
+// eventOp returns the Op of the update of event, and false if it has none.
+func eventOp(event Event) (Operation, bool) {
+	switch {
+	case event.Services != nil:
+		return event.Services.Op, true
+	case event.Endpoints != nil:
+		return event.Endpoints.Op, true
+	}
+	return 0, false
+}
+
+// cancelSubscriptions cancels every subscription of s.
+func (s *SourceAPI) cancelSubscriptions() {
+	s.subscriptionLock.RLock()
+	subscriptions := append([]*subscription(nil), s.subscriptions...)
+	s.subscriptionLock.RUnlock()
+	for _, sub := range subscriptions {
+		sub.Cancel()
+	}
+}
+
+// OpFilter returns a Subscribe predicate matching the updates whose Op is one of ops, e.g.
+// OpFilter(REMOVE) for a subscriber that only drains the connections of removed services.
+// SETs are matched by their own Op only: the objects a SET leaves out are removed without a
+// REMOVE being sent, so a subscriber that must see every removal should also match SET and
+// compare it with the objects it knows of.
+func OpFilter(ops ...Operation) func(Event) bool {
+	return func(event Event) bool {
+		op, ok := eventOp(event)
+		if !ok {
+			return false
+		}
+		for _, o := range ops {
+			if o == op {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// publish sends event to every matching subscription, unless the source is closed.
+func (s *SourceAPI) publish(event Event) {
+	s.subscriptionLock.RLock()
+	defer s.subscriptionLock.RUnlock()
+	for _, sub := range s.subscriptions {
+		if sub.resource != event.Resource || !sub.predicate(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		case <-s.closing.done():
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// collect reads events from sub until it is closed.
+func collect(sub Subscription) <-chan []Event {
+	result := make(chan []Event)
+	go func() {
+		var events []Event
+		for event := range sub.Events() {
+			events = append(events, event)
+		}
+		result <- events
+	}()
+	return result
+}
+
+func TestSubscribe(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 3}}
+
+	fakeWatch := watch.NewFake()
+	source := SourceAPI{client: &client.Fake{Watch: fakeWatch}}
+	source.serviceVersion.Set(1)
+
+	adds, err := source.Subscribe(ServicesResource, func(event Event) bool {
+		return event.Services.Op == ADD
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	onlyFoo, err := source.Subscribe(ServicesResource, func(event Event) bool {
+		return event.Services.Services[0].ID == "foo"
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	endpoints, err := source.Subscribe(EndpointsResource, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := source.Subscribe("pods", nil); err != ErrUnknownResourceType {
+		t.Errorf("expected %v, got %v", ErrUnknownResourceType, err)
+	}
+	addsEvents, onlyFooEvents, endpointsEvents := collect(adds), collect(onlyFoo), collect(endpoints)
+
+	done := make(chan struct{})
+	go func() {
+		source.runServices()
+		close(done)
+	}()
+	fakeWatch.Add(&foo)
+	fakeWatch.Add(&bar)
+	deleted := foo
+	deleted.ResourceVersion = 4
+	fakeWatch.Delete(&deleted)
+	fakeWatch.Stop()
+	<-done
+
+	adds.Cancel()
+	onlyFoo.Cancel()
+	endpoints.Cancel()
+	expected := []Event{
+		{Resource: ServicesResource, Services: &ServiceUpdate{Op: ADD, Services: []api.Service{foo}}},
+		{Resource: ServicesResource, Services: &ServiceUpdate{Op: ADD, Services: []api.Service{bar}}},
+	}
+	if actual := <-addsEvents; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expected = []Event{
+		{Resource: ServicesResource, Services: &ServiceUpdate{Op: ADD, Services: []api.Service{foo}}},
+		{Resource: ServicesResource, Services: &ServiceUpdate{Op: REMOVE, Services: []api.Service{deleted}}},
+	}
+	if actual := <-onlyFooEvents; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if actual := <-endpointsEvents; len(actual) != 0 {
+		t.Errorf("expected no endpoints events, got %#v", actual)
+	}
+}
+
+func TestSubscriptionCancel(t *testing.T) {
+	source := SourceAPI{}
+	sub, err := source.Subscribe(ServicesResource, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a subscription that is not read from does not block the source once it is cancelled
+	go sub.Cancel()
+	source.sendServices(ServiceUpdate{Op: SET})
+	sub.Cancel()
+	if len(source.subscriptions) != 0 {
+		t.Errorf("expected no subscriptions, got %d", len(source.subscriptions))
+	}
+}
+
+func TestSubscribeOpFilter(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo", ResourceVersion: 2}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar", ResourceVersion: 3}}
+
+	source := SourceAPI{}
+	removals, err := source.Subscribe(ServicesResource, OpFilter(REMOVE))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	removalsEvents := collect(removals)
+
+	source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo}})
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{foo}})
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{foo}})
+	removals.Cancel()
+
+	expected := []Event{
+		{Resource: ServicesResource, Services: &ServiceUpdate{Op: REMOVE, Services: []api.Service{foo}}},
+	}
+	if actual := <-removalsEvents; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	endpoints := Event{Resource: EndpointsResource, Endpoints: &EndpointsUpdate{Op: REMOVE}}
+	if !OpFilter(ADD, REMOVE)(endpoints) || OpFilter(SET)(endpoints) {
+		t.Errorf("expected the filter to match the Op of endpoints updates")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+)
+
+// ServiceTypeLabel is the label holding the type of a service, which the API has no field for.
+// A service without it is of type ClusterIP.
+const ServiceTypeLabel = "wormhole.io/service-type"
+
+// These are the types of services.
+const (
+	ClusterIPType    = "ClusterIP"
+	NodePortType     = "NodePort"
+	LoadBalancerType = "LoadBalancer"
+	ExternalNameType = "ExternalName"
+)
+
+// ServiceType returns the type of service, from its ServiceTypeLabel.
+func ServiceType(service api.Service) string {
+	if serviceType := service.Labels[ServiceTypeLabel]; serviceType != "" {
+		return serviceType
+	}
+	return ClusterIPType
+}
+
+// SummaryUpdate counts the services of each type at Timestamp. The four types above are always
+// counted; services of other types are counted under their ServiceTypeLabel.
+type SummaryUpdate struct {
+	Counts    map[string]int
+	Timestamp time.Time
+}
+
+// CountSummaryAggregator follows a channel of ServiceUpdates and sends a SummaryUpdate of the
+// services they lead to every interval, for consumers that only need the totals.
+type CountSummaryAggregator struct {
+	updates   <-chan ServiceUpdate
+	summaries chan<- SummaryUpdate
+	interval  time.Duration
+	clock     Clock
+	// types maps the ServiceKey of each service to its type.
+	types map[string]string
+}
+
+// NewCountSummaryAggregator creates a CountSummaryAggregator and starts following updates and
+// sending summaries, until updates is closed.
+func NewCountSummaryAggregator(updates <-chan ServiceUpdate, interval time.Duration, summaries chan<- SummaryUpdate) *CountSummaryAggregator {
+	return newCountSummaryAggregator(updates, interval, summaries, realClock{})
+}
+
+func newCountSummaryAggregator(updates <-chan ServiceUpdate, interval time.Duration, summaries chan<- SummaryUpdate, clock Clock) *CountSummaryAggregator {
+	aggregator := &CountSummaryAggregator{
+		updates:   updates,
+		summaries: summaries,
+		interval:  interval,
+		clock:     clock,
+		types:     make(map[string]string),
+	}
+	go func() {
+		defer util.HandleCrash()
+		aggregator.run()
+	}()
+	return aggregator
+}
+
+func (a *CountSummaryAggregator) run() {
+	tick := a.clock.After(a.interval)
+	for {
+		select {
+		case update, ok := <-a.updates:
+			if !ok {
+				return
+			}
+			a.apply(update)
+		case now := <-tick:
+			a.summaries <- a.summary(now)
+			tick = a.clock.After(a.interval)
+		}
+	}
+}
+
+// apply updates the types of the services with update.
+func (a *CountSummaryAggregator) apply(update ServiceUpdate) {
+	switch update.Op {
+	case SET:
+		a.types = make(map[string]string)
+		fallthrough
+	case ADD:
+		for _, service := range update.Services {
+			a.types[ServiceKey(service)] = ServiceType(service)
+		}
+	case REMOVE:
+		for _, service := range update.Services {
+			delete(a.types, ServiceKey(service))
+		}
+	}
+}
+
+func (a *CountSummaryAggregator) summary(now time.Time) SummaryUpdate {
+	counts := map[string]int{
+		ClusterIPType:    0,
+		NodePortType:     0,
+		LoadBalancerType: 0,
+		ExternalNameType: 0,
+	}
+	for _, serviceType := range a.types {
+		counts[serviceType]++
+	}
+	return SummaryUpdate{Counts: counts, Timestamp: now}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func typedService(id, serviceType string) api.Service {
+	return api.Service{JSONBase: api.JSONBase{ID: id}, Labels: map[string]string{ServiceTypeLabel: serviceType}}
+}
+
+func TestCountSummaryAggregator(t *testing.T) {
+	updates := make(chan ServiceUpdate)
+	summaries := make(chan SummaryUpdate)
+	clock := newFakeClock()
+	newCountSummaryAggregator(updates, time.Minute, summaries, clock)
+	defer close(updates)
+
+	plain := api.Service{JSONBase: api.JSONBase{ID: "plain"}}
+	cluster := typedService("cluster", ClusterIPType)
+	node := typedService("node", NodePortType)
+	balancer := typedService("balancer", LoadBalancerType)
+	external := typedService("external", ExternalNameType)
+	steps := []struct {
+		updates  []ServiceUpdate
+		expected map[string]int
+	}{
+		{
+			[]ServiceUpdate{
+				{Op: SET, Services: []api.Service{plain, cluster, node, balancer, external}},
+				{Op: ADD, Services: []api.Service{typedService("node2", NodePortType)}},
+				{Op: REMOVE, Services: []api.Service{balancer}},
+			},
+			map[string]int{ClusterIPType: 2, NodePortType: 2, LoadBalancerType: 0, ExternalNameType: 1},
+		},
+		{
+			[]ServiceUpdate{
+				// a service that changes type is only counted once
+				{Op: ADD, Services: []api.Service{typedService("plain", LoadBalancerType)}},
+				{Op: ADD, Services: []api.Service{typedService("other", "Headless")}},
+			},
+			map[string]int{ClusterIPType: 1, NodePortType: 2, LoadBalancerType: 1, ExternalNameType: 1, "Headless": 1},
+		},
+		{
+			[]ServiceUpdate{{Op: SET}},
+			map[string]int{ClusterIPType: 0, NodePortType: 0, LoadBalancerType: 0, ExternalNameType: 0},
+		},
+	}
+	for i, step := range steps {
+		for _, update := range step.updates {
+			updates <- update
+		}
+		clock.BlockUntil(t, 1)
+		clock.Step(time.Minute)
+		summary := <-summaries
+		if !reflect.DeepEqual(step.expected, summary.Counts) {
+			t.Errorf("step %d: expected %#v, got %#v", i, step.expected, summary.Counts)
+		}
+		if !summary.Timestamp.Equal(clock.Now()) {
+			t.Errorf("step %d: expected timestamp %v, got %v", i, clock.Now(), summary.Timestamp)
+		}
+	}
+}
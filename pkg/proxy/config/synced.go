@@ -0,0 +1,65 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"sync"
+)
+
+// syncSignal is closed once the first SET of a resource has been delivered.
+type syncSignal struct {
+	init  sync.Once
+	close sync.Once
+	ch    chan struct{}
+}
+
+// done returns a channel that is closed once the signal is marked.
+func (s *syncSignal) done() <-chan struct{} {
+	return s.channel()
+}
+
+// mark closes the channel of the signal, if it has not been already.
+func (s *syncSignal) mark() {
+	ch := s.channel()
+	s.close.Do(func() { close(ch) })
+}
+
+func (s *syncSignal) channel() chan struct{} {
+	s.init.Do(func() { s.ch = make(chan struct{}) })
+	return s.ch
+}
+
+// WaitForSync blocks until the first SET of both services and endpoints has been delivered,
+// so that a consumer has the full state of the apiserver, and returns nil. It returns the
+// error of ctx if it is done first.
+func (s *SourceAPI) WaitForSync(ctx context.Context) error {
+	for _, signal := range []*syncSignal{&s.servicesSynced, &s.endpointsSynced} {
+		// A source that has synced reports so even if ctx is done.
+		select {
+		case <-signal.done():
+			continue
+		default:
+		}
+		select {
+		case <-signal.done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestWaitForSync(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	source := SourceAPI{services: services, endpoints: endpoints}
+	synced := make(chan error)
+	go func() {
+		synced <- source.WaitForSync(context.Background())
+	}()
+
+	// an ADD is not the full state
+	go source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}}})
+	<-services
+	go source.sendServices(ServiceUpdate{Op: SET})
+	<-services
+	select {
+	case err := <-synced:
+		t.Fatalf("expected to wait for endpoints, got %v", err)
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := source.WaitForSync(ctx); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	go source.sendEndpoints(EndpointsUpdate{Op: SET})
+	<-endpoints
+	if err := <-synced; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// later calls return right away
+	if err := source.WaitForSync(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// ClusterIPLabel is the service label holding the IP address the service is reachable on.
+const ClusterIPLabel = "wormhole.io/cluster-ip"
+
+// IgnoreLabel is the conventional label for SourceAPIOptions.IgnoreLabel, with which a service
+// opts out of being proxied.
+const IgnoreLabel = "proxy.kubernetes.io/ignore"
+
+// ServiceTransformer rewrites a service before it is sent. A service for which it returns an
+// error is dropped from the update, and removed if the update is an ADD, as it may have been
+// sent before.
+type ServiceTransformer func(service *api.Service) error
+
+// ClusterIPNormalizer is a ServiceTransformer that rewrites the ClusterIPLabel of a service in
+// the canonical form of its address, e.g. "::ffff:1.2.3.4" as "1.2.3.4" and "2001:DB8::0:1"
+// as "2001:db8::1". Services without the label are left as they are.
+func ClusterIPNormalizer(service *api.Service) error {
+	value, ok := service.Labels[ClusterIPLabel]
+	if !ok {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return fmt.Errorf("invalid cluster IP %q", value)
+	}
+	if ip.String() == value {
+		return nil
+	}
+	// The labels may be shared with other copies of the service.
+	labels := make(map[string]string, len(service.Labels))
+	for k, v := range service.Labels {
+		labels[k] = v
+	}
+	labels[ClusterIPLabel] = ip.String()
+	service.Labels = labels
+	return nil
+}
+
+// InvalidUpdate is a service or endpoints object that failed validation, or a watch that could
+// not be decoded, as sent to SourceAPIOptions.DeadLetter.
+type InvalidUpdate struct {
+	// Event holds an update of the object as it was received, before any transformer ran. For
+	// a watch that could not be decoded it only holds the Resource.
+	Event Event
+	// Err is the error of the validation or transformer that rejected it, or the DecodeError
+	// of the watch.
+	Err error
+}
+
+// deadLetterService sends service, rejected from an update with op, to DeadLetter.
+func (s *SourceAPI) deadLetterService(op Operation, service api.Service, err error) {
+	invalid := ServiceUpdate{Op: op, Services: []api.Service{service}}
+	s.DeadLetter <- InvalidUpdate{Event: Event{Resource: ServicesResource, Services: &invalid}, Err: err}
+}
+
+// deadLetterEndpoints sends endpoints, rejected from an update with op, to DeadLetter.
+func (s *SourceAPI) deadLetterEndpoints(op Operation, endpoints api.Endpoints, err error) {
+	invalid := EndpointsUpdate{Op: op, Endpoints: []api.Endpoints{endpoints}}
+	s.DeadLetter <- InvalidUpdate{Event: Event{Resource: EndpointsResource, Endpoints: &invalid}, Err: err}
+}
+
+// deadLetterWatch sends the error of a watch of resource to DeadLetter, if there is one and
+// the watch could not be decoded.
+func (s *SourceAPI) deadLetterWatch(resource ResourceType, err error) {
+	var decodeErr *DecodeError
+	if s.DeadLetter != nil && errors.As(err, &decodeErr) {
+		s.DeadLetter <- InvalidUpdate{Event: Event{Resource: resource}, Err: err}
+	}
+}
+
+// transformServices applies transformers to the services of update, dropping the services
+// that any of them fail on, and passing each of those to reject, if it is not nil, as it was
+// before it was transformed. It returns the update and the services it dropped. The services
+// of a REMOVE are only identified by their ID, so they are left as they are.
+func transformServices(update ServiceUpdate, transformers []ServiceTransformer, reject func(api.Service, error)) (ServiceUpdate, []api.Service) {
+	if update.Op == REMOVE || len(update.Services) == 0 {
+		return update, nil
+	}
+	services := make([]api.Service, 0, len(update.Services))
+	var dropped []api.Service
+next:
+	for _, service := range update.Services {
+		original := service
+		for _, transform := range transformers {
+			if err := transform(&service); err != nil {
+				glog.Errorf("Dropping service %s: %v", service.ID, err)
+				if reject != nil {
+					reject(original, err)
+				}
+				dropped = append(dropped, original)
+				continue next
+			}
+		}
+		services = append(services, service)
+	}
+	update.Services = services
+	return update, dropped
+}
+
+// splitServices separates the services for which drop returns true from the others.
+func splitServices(services []api.Service, drop func(api.Service) bool) (kept, dropped []api.Service) {
+	kept = make([]api.Service, 0, len(services))
+	for _, service := range services {
+		if drop(service) {
+			dropped = append(dropped, service)
+			continue
+		}
+		kept = append(kept, service)
+	}
+	return kept, dropped
+}
+
+// dropServices removes the services for which drop returns true from a SET or ADD. Those of
+// an ADD are sent as a REMOVE instead, in case they were sent before. It returns false if the
+// update is left with nothing to send.
+func (s *SourceAPI) dropServices(update *ServiceUpdate, drop func(api.Service) bool) bool {
+	if update.Op != SET && update.Op != ADD {
+		return true
+	}
+	kept, dropped := splitServices(update.Services, drop)
+	update.Services = kept
+	if update.Op == ADD && len(dropped) > 0 {
+		s.sendServices(ServiceUpdate{Op: REMOVE, Services: dropped})
+		return len(kept) > 0
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestClusterIPNormalizer(t *testing.T) {
+	table := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{value: "10.0.0.1", expected: "10.0.0.1"},
+		{value: "010.000.000.001", err: true},
+		{value: "::ffff:10.0.0.1", expected: "10.0.0.1"},
+		{value: "::FFFF:a00:1", expected: "10.0.0.1"},
+		{value: "2001:DB8::0:1", expected: "2001:db8::1"},
+		{value: "2001:0db8:0000:0000:0000:0000:0000:0001", expected: "2001:db8::1"},
+		{value: "::1", expected: "::1"},
+		{value: "", err: true},
+		{value: "10.0.0.256", err: true},
+		{value: "10.0.0.1:80", err: true},
+		{value: "foo", err: true},
+	}
+	for _, item := range table {
+		labels := map[string]string{ClusterIPLabel: item.value, "name": "foo"}
+		service := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Labels: labels}
+		err := ClusterIPNormalizer(&service)
+		if item.err {
+			if err == nil {
+				t.Errorf("expected an error for %q", item.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", item.value, err)
+			continue
+		}
+		expected := map[string]string{ClusterIPLabel: item.expected, "name": "foo"}
+		if !reflect.DeepEqual(expected, service.Labels) {
+			t.Errorf("expected %#v, got %#v", expected, service.Labels)
+		}
+		if labels[ClusterIPLabel] != item.value {
+			t.Errorf("expected the original labels to be left as they are, got %#v", labels)
+		}
+	}
+
+	service := api.Service{JSONBase: api.JSONBase{ID: "foo"}}
+	if err := ClusterIPNormalizer(&service); err != nil || service.Labels != nil {
+		t.Errorf("expected a service without a cluster IP to be left as it is, got %#v, %v", service, err)
+	}
+}
+
+func TestServicesTransformed(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.ServiceTransformers = []ServiceTransformer{ClusterIPNormalizer}
+
+	valid := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Labels: map[string]string{ClusterIPLabel: "::ffff:10.0.0.1"}}
+	invalid := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Labels: map[string]string{ClusterIPLabel: "bar"}}
+	go func() {
+		source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{valid, invalid}})
+		// a service modified to fail a transformer is removed instead of added
+		source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{invalid}})
+		source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}}}})
+	}()
+
+	normalized := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Labels: map[string]string{ClusterIPLabel: "10.0.0.1"}}
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{normalized}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{invalid}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "bar"}}}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestServicesDeadLettered(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	deadLetter := make(chan InvalidUpdate, 1)
+	source := SourceAPI{services: services}
+	source.ServiceTransformers = []ServiceTransformer{ClusterIPNormalizer}
+	source.DeadLetter = deadLetter
+
+	valid := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{ClusterIPLabel: "10.0.0.1"}}
+	invalid := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81, Labels: map[string]string{ClusterIPLabel: "bar"}}
+	go source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{valid, invalid}})
+
+	expected := ServiceUpdate{Op: REMOVE, Services: []api.Service{invalid}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	expected = ServiceUpdate{Op: ADD, Services: []api.Service{valid}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	dead := <-deadLetter
+	expectedEvent := Event{Resource: ServicesResource, Services: &ServiceUpdate{Op: ADD, Services: []api.Service{invalid}}}
+	if !reflect.DeepEqual(expectedEvent, dead.Event) {
+		t.Errorf("expected %#v, got %#v", expectedEvent, dead.Event)
+	}
+	if dead.Err == nil {
+		t.Errorf("expected the error of the transformer")
+	}
+}
+
+func TestInvalidDeadLettered(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	endpoints := make(chan EndpointsUpdate)
+	deadLetter := make(chan InvalidUpdate, 1)
+	source := SourceAPI{services: services, endpoints: endpoints}
+	source.DeadLetter = deadLetter
+
+	// the services that fail Validate are dropped, and the rest are sent
+	valid := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	invalid := api.Service{JSONBase: api.JSONBase{ID: "bar"}}
+	go source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{valid, invalid}})
+	dead := <-deadLetter
+	expectedEvent := Event{Resource: ServicesResource, Services: &ServiceUpdate{Op: SET, Services: []api.Service{invalid}}}
+	if !reflect.DeepEqual(expectedEvent, dead.Event) || dead.Err == nil {
+		t.Errorf("expected %#v with an error, got %#v", expectedEvent, dead)
+	}
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{valid}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	// an ADD of nothing but invalid endpoints is not sent at all
+	invalidEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:0"}}
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{invalidEndpoints}})
+	dead = <-deadLetter
+	expectedEvent = Event{Resource: EndpointsResource, Endpoints: &EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{invalidEndpoints}}}
+	if !reflect.DeepEqual(expectedEvent, dead.Event) || dead.Err == nil {
+		t.Errorf("expected %#v with an error, got %#v", expectedEvent, dead)
+	}
+}
+
+func TestServicesIgnored(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.IgnoreLabel = IgnoreLabel
+
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81, Labels: map[string]string{IgnoreLabel: "true"}}
+	notIgnored := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 82, Labels: map[string]string{IgnoreLabel: "false"}}
+	go func() {
+		source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo, bar, notIgnored}})
+		source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	}()
+
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{foo, notIgnored}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	// a service that opts out is removed instead of added
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestServicesExternalNameSkipped(t *testing.T) {
+	services := make(chan ServiceUpdate)
+	source := SourceAPI{services: services}
+	source.SkipExternalNameServices = true
+
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: 81, Labels: map[string]string{ServiceTypeLabel: ExternalNameType}}
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: 82, Labels: map[string]string{ServiceTypeLabel: NodePortType}}
+	go func() {
+		source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{foo, bar, baz}})
+		source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{bar}})
+	}()
+
+	expected := ServiceUpdate{Op: SET, Services: []api.Service{foo, baz}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	// a service that became an ExternalName is removed instead of added
+	expected = ServiceUpdate{Op: REMOVE, Services: []api.Service{bar}}
+	if actual := <-services; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
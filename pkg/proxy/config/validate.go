@@ -0,0 +1,149 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// validPort returns whether port is a port number other than 0.
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+// validOp returns an error if op is not a known Operation.
+func validOp(op Operation) error {
+	if op < 0 || int(op) >= len(operationNames) {
+		return fmt.Errorf("unknown operation %v", op)
+	}
+	return nil
+}
+
+// Validate returns an error describing the first problem of u: an unknown Op, a service
+// without an ID, a service twice in a SET, or, except in a REMOVE, which only identifies its
+// services, a port outside 1-65535.
+func (u ServiceUpdate) Validate() error {
+	var first error
+	_, err := u.dropInvalid(func(service api.Service, err error) {
+		if first == nil {
+			first = err
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return first
+}
+
+// dropInvalid returns u without the services Validate finds a problem with, passing each of
+// those to reject with its problem. Of the services twice in a SET, the first is kept. An
+// update with an unknown Op is invalid as a whole, and returns an error instead.
+func (u ServiceUpdate) dropInvalid(reject func(api.Service, error)) (ServiceUpdate, error) {
+	if err := validOp(u.Op); err != nil {
+		return u, err
+	}
+	valid := make([]api.Service, 0, len(u.Services))
+	seen := make(map[string]bool, len(u.Services))
+	for i, service := range u.Services {
+		key := ServiceKey(service)
+		var err error
+		switch {
+		case service.ID == "":
+			err = fmt.Errorf("service %d of %v has no ID", i, u.Op)
+		case u.Op == SET && seen[key]:
+			err = fmt.Errorf("service %s is in SET more than once", key)
+		case u.Op != REMOVE && !validPort(service.Port):
+			err = fmt.Errorf("service %s has invalid port %d", key, service.Port)
+		}
+		if err != nil {
+			reject(service, err)
+			continue
+		}
+		seen[key] = true
+		valid = append(valid, service)
+	}
+	if u.Services != nil {
+		u.Services = valid
+	}
+	return u, nil
+}
+
+// Validate returns an error describing the first problem of u: an unknown Op, endpoints
+// without an ID, the endpoints of a service twice in a SET, or an address whose port is
+// outside 1-65535.
+func (u EndpointsUpdate) Validate() error {
+	var first error
+	_, err := u.dropInvalid(func(endpoints api.Endpoints, err error) {
+		if first == nil {
+			first = err
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return first
+}
+
+// dropInvalid returns u without the endpoints Validate finds a problem with, as
+// ServiceUpdate.dropInvalid does.
+func (u EndpointsUpdate) dropInvalid(reject func(api.Endpoints, error)) (EndpointsUpdate, error) {
+	if err := validOp(u.Op); err != nil {
+		return u, err
+	}
+	valid := make([]api.Endpoints, 0, len(u.Endpoints))
+	seen := make(map[string]bool, len(u.Endpoints))
+	for i, e := range u.Endpoints {
+		var err error
+		switch {
+		case e.ID == "":
+			err = fmt.Errorf("endpoints %d of %v have no ID", i, u.Op)
+		case u.Op == SET && seen[e.ID]:
+			err = fmt.Errorf("endpoints %s are in SET more than once", e.ID)
+		default:
+			err = invalidAddress(e)
+		}
+		if err != nil {
+			reject(e, err)
+			continue
+		}
+		seen[e.ID] = true
+		valid = append(valid, e)
+	}
+	if u.Endpoints != nil {
+		u.Endpoints = valid
+	}
+	return u, nil
+}
+
+// invalidAddress returns an error if an address of e has a port outside 1-65535.
+func invalidAddress(e api.Endpoints) error {
+	for _, endpoint := range e.Endpoints {
+		_, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			// An address without a port.
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || !validPort(n) {
+			return fmt.Errorf("endpoints %s have invalid address %q", e.ID, endpoint)
+		}
+	}
+	return nil
+}
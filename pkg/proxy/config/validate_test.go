@@ -0,0 +1,111 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestServiceUpdateValidate(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	fooInB := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{NamespaceLabel: "b"}}
+	table := []struct {
+		update ServiceUpdate
+		valid  bool
+	}{
+		{ServiceUpdate{Op: SET, Services: []api.Service{foo, fooInB}}, true},
+		{ServiceUpdate{Op: SET, Services: []api.Service{}}, true},
+		{ServiceUpdate{Op: SET, Services: []api.Service{foo, foo}}, false},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{{Port: 80}}}, false},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}}}, false},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 65536}}}, false},
+		{ServiceUpdate{Op: ADD, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}, Port: 65535}}}, true},
+		// a REMOVE only needs the ID
+		{ServiceUpdate{Op: REMOVE, Services: []api.Service{{JSONBase: api.JSONBase{ID: "foo"}}}}, true},
+		{ServiceUpdate{Op: REMOVE, Services: []api.Service{{}}}, false},
+		{ServiceUpdate{Op: SNAPSHOT_START}, true},
+		{ServiceUpdate{Op: DRAIN + 1}, false},
+	}
+	for i, item := range table {
+		err := item.update.Validate()
+		if item.valid && err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+		if !item.valid && err == nil {
+			t.Errorf("%d: expected an error for %#v", i, item.update)
+		}
+	}
+}
+
+func TestEndpointsUpdateValidate(t *testing.T) {
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "[2001:db8::1]:80", "endpoint1"}}
+	table := []struct {
+		update EndpointsUpdate
+		valid  bool
+	}{
+		{EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo}}, true},
+		{EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo, foo}}, false},
+		{EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{{Endpoints: []string{"10.0.0.1:80"}}}}, false},
+		{EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:0"}}}}, false},
+		{EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:http"}}}}, false},
+		{EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}}}}, true},
+		{EndpointsUpdate{Op: Operation(-1)}, false},
+	}
+	for i, item := range table {
+		err := item.update.Validate()
+		if item.valid && err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+		if !item.valid && err == nil {
+			t.Errorf("%d: expected an error for %#v", i, item.update)
+		}
+	}
+}
+
+func TestDropInvalid(t *testing.T) {
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: 80}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}}
+	var rejected []string
+	update, err := ServiceUpdate{Op: SET, Services: []api.Service{foo, bar, foo}}.dropInvalid(func(service api.Service, err error) {
+		rejected = append(rejected, service.ID)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the first of the services twice in the SET is kept
+	if !reflect.DeepEqual(ServiceUpdate{Op: SET, Services: []api.Service{foo}}, update) {
+		t.Errorf("unexpected update %#v", update)
+	}
+	if !reflect.DeepEqual([]string{"bar", "foo"}, rejected) {
+		t.Errorf("unexpected rejected services %v", rejected)
+	}
+
+	fooEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}}
+	barEndpoints := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.0.1:0"}}
+	endpoints, err := EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{barEndpoints, fooEndpoints}}.dropInvalid(func(api.Endpoints, error) {})
+	if err != nil || !reflect.DeepEqual(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{fooEndpoints}}, endpoints) {
+		t.Errorf("unexpected update %#v, %v", endpoints, err)
+	}
+
+	// an unknown operation rejects the update as a whole
+	if _, err := (ServiceUpdate{Op: DRAIN + 1, Services: []api.Service{foo}}).dropInvalid(func(api.Service, error) {}); err == nil {
+		t.Errorf("expected an error for an unknown operation")
+	}
+}
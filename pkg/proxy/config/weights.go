@@ -0,0 +1,163 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+)
+
+// WeightLabel is the service label holding the weights of its endpoints, as comma separated
+// endpoint=weight pairs, e.g. "10.0.0.1:80=95,10.0.0.2:80=5". Endpoints that are not listed
+// have a weight of 1. The API has no annotations, and endpoints no labels, so the weights are
+// kept with the service.
+const WeightLabel = "wormhole.io/weight"
+
+// ParseWeights parses the value of the WeightLabel of service, skipping malformed pairs.
+func ParseWeights(service, value string) map[string]int {
+	weights := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			glog.Errorf("Ignoring malformed weight %q of %s", pair, service)
+			continue
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 0 {
+			glog.Errorf("Ignoring malformed weight %q of %s", pair, service)
+			continue
+		}
+		weights[strings.TrimSpace(parts[0])] = weight
+	}
+	return weights
+}
+
+// Weight returns the weight of endpoint of the endpoints with the given ID, 1 if it has none.
+func (u EndpointsUpdate) Weight(id, endpoint string) int {
+	if weight, ok := u.Weights[id][endpoint]; ok {
+		return weight
+	}
+	return 1
+}
+
+// recordWeights remembers the weights of the endpoints of the services in update, and returns
+// the IDs of the services whose weights changed.
+func (s *SourceAPI) recordWeights(update ServiceUpdate) []string {
+	s.protocolLock.Lock()
+	defer s.protocolLock.Unlock()
+	last := s.weights
+	if update.Op == SET || s.weights == nil {
+		s.weights = make(map[string]map[string]int)
+	}
+	for _, service := range update.Services {
+		value, ok := service.Labels[WeightLabel]
+		if update.Op == REMOVE || !ok {
+			delete(s.weights, ServiceKey(service))
+			continue
+		}
+		s.weights[ServiceKey(service)] = ParseWeights(service.ID, value)
+	}
+	if update.Op == REMOVE {
+		// The endpoints of the services are removed along with them.
+		return nil
+	}
+	var changed []string
+	for _, service := range update.Services {
+		key := ServiceKey(service)
+		if !reflect.DeepEqual(last[key], s.weights[key]) {
+			changed = append(changed, key)
+		}
+	}
+	if update.Op == SET {
+		for key := range last {
+			if _, found := s.weights[key]; !found {
+				changed = append(changed, key)
+			}
+		}
+	}
+	return changed
+}
+
+// recordSentEndpoints remembers the endpoints of update as those last sent.
+func (s *SourceAPI) recordSentEndpoints(update EndpointsUpdate) {
+	s.protocolLock.Lock()
+	defer s.protocolLock.Unlock()
+	if update.Op == SET || s.sentEndpoints == nil {
+		s.sentEndpoints = make(map[string]compactEntry)
+	}
+	for _, e := range update.Endpoints {
+		switch update.Op {
+		case SET, ADD:
+			s.sentEndpoints[e.ID] = compactEntry{e, update.Ports[e.ID]}
+		case REMOVE:
+			delete(s.sentEndpoints, e.ID)
+		}
+	}
+}
+
+// resendEndpoints sends the endpoints last sent of the services with the given IDs again, as
+// an ADD, so that they are sent with the protocols and weights the services now give them.
+func (s *SourceAPI) resendEndpoints(ids []string) {
+	s.protocolLock.Lock()
+	update := EndpointsUpdate{Op: ADD}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		entry, ok := s.sentEndpoints[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		update.Endpoints = append(update.Endpoints, entry.endpoints)
+		if entry.ports != nil {
+			if update.Ports == nil {
+				update.Ports = make(map[string]map[string][]string)
+			}
+			update.Ports[id] = entry.ports
+		}
+	}
+	s.protocolLock.Unlock()
+	if len(update.Endpoints) == 0 {
+		return
+	}
+	sort.Sort(endpointsByID(update.Endpoints))
+	s.sendEndpointsUpdate(update)
+}
+
+// endpointsWeights returns the weights of endpoints given by the services owning them, or nil
+// if none has any.
+func (s *SourceAPI) endpointsWeights(endpoints []api.Endpoints) map[string]map[string]int {
+	s.protocolLock.Lock()
+	defer s.protocolLock.Unlock()
+	var weights map[string]map[string]int
+	for _, e := range endpoints {
+		if w, ok := s.weights[e.ID]; ok {
+			if weights == nil {
+				weights = make(map[string]map[string]int)
+			}
+			weights[e.ID] = w
+		}
+	}
+	return weights
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestEndpointsWeights(t *testing.T) {
+	services := make(chan ServiceUpdate, 10)
+	endpoints := make(chan EndpointsUpdate, 10)
+	source := SourceAPI{services: services, endpoints: endpoints}
+	weighted := api.Service{
+		JSONBase: api.JSONBase{ID: "foo"},
+		Labels:   map[string]string{WeightLabel: "10.0.0.1:80=95, 10.0.0.2:80=5, 10.0.0.3:80=bad"},
+	}
+	plain := api.Service{JSONBase: api.JSONBase{ID: "bar"}}
+	source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{weighted, plain}})
+	<-services
+
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"}}
+	bar := api.Endpoints{JSONBase: api.JSONBase{ID: "bar"}, Endpoints: []string{"10.0.1.1:80"}}
+	source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo, bar}})
+	update := <-endpoints
+	expected := map[string]map[string]int{"foo": {"10.0.0.1:80": 95, "10.0.0.2:80": 5}}
+	if !reflect.DeepEqual(expected, update.Weights) {
+		t.Errorf("expected %#v, got %#v", expected, update.Weights)
+	}
+	for _, item := range []struct {
+		id, endpoint string
+		weight       int
+	}{
+		{"foo", "10.0.0.1:80", 95},
+		{"foo", "10.0.0.2:80", 5},
+		// malformed and missing weights default to 1
+		{"foo", "10.0.0.3:80", 1},
+		{"foo", "10.0.0.4:80", 1},
+		{"bar", "10.0.1.1:80", 1},
+	} {
+		if weight := update.Weight(item.id, item.endpoint); weight != item.weight {
+			t.Errorf("expected a weight of %d for %s of %s, got %d", item.weight, item.endpoint, item.id, weight)
+		}
+	}
+
+	// without weights nothing changes
+	source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{weighted}})
+	<-services
+	source.sendEndpoints(EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{foo}})
+	if update := <-endpoints; update.Weights != nil {
+		t.Errorf("expected no weights, got %#v", update.Weights)
+	}
+}
+
+func TestEndpointsWeightsResent(t *testing.T) {
+	services := make(chan ServiceUpdate, 10)
+	endpoints := make(chan EndpointsUpdate, 10)
+	source := SourceAPI{services: services, endpoints: endpoints}
+
+	// the endpoints arrive before their service
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"}}
+	source.sendEndpoints(EndpointsUpdate{Op: SET, Endpoints: []api.Endpoints{foo}})
+	if update := <-endpoints; update.Weights != nil {
+		t.Errorf("expected no weights, got %#v", update.Weights)
+	}
+	weighted := api.Service{
+		JSONBase: api.JSONBase{ID: "foo"},
+		Labels:   map[string]string{WeightLabel: "10.0.0.1:80=95,10.0.0.2:80=5"},
+	}
+	source.sendServices(ServiceUpdate{Op: ADD, Services: []api.Service{weighted}})
+	<-services
+	expected := EndpointsUpdate{
+		Op:        ADD,
+		Endpoints: []api.Endpoints{foo},
+		Weights:   map[string]map[string]int{"foo": {"10.0.0.1:80": 95, "10.0.0.2:80": 5}},
+	}
+	if update := <-endpoints; !reflect.DeepEqual(expected, update) {
+		t.Errorf("expected %#v, got %#v", expected, update)
+	}
+
+	// the same weights, or the service being removed, send nothing more
+	source.sendServices(ServiceUpdate{Op: SET, Services: []api.Service{weighted}})
+	<-services
+	source.sendServices(ServiceUpdate{Op: REMOVE, Services: []api.Service{weighted}})
+	<-services
+	if len(endpoints) != 0 {
+		t.Errorf("expected no endpoints to be sent again, got %#v", <-endpoints)
+	}
+}
+
+// weightedHandlerMock is a WeightedEndpointsConfigHandler that sends the weights it is given.
+type weightedHandlerMock chan map[string]map[string]int
+
+func (h weightedHandlerMock) OnUpdate(endpoints []api.Endpoints) {
+	panic("expected OnWeightedUpdate to be called")
+}
+
+func (h weightedHandlerMock) OnWeightedUpdate(endpoints []api.Endpoints, weights map[string]map[string]int) {
+	h <- weights
+}
+
+func TestEndpointsConfigWeights(t *testing.T) {
+	config := NewEndpointsConfig()
+	channel := config.Channel("one")
+	handler := make(weightedHandlerMock)
+	config.RegisterHandler(handler)
+
+	foo := api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"}}
+	weights := map[string]map[string]int{"foo": {"10.0.0.1:80": 95}}
+	channel <- EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{foo}, Weights: weights}
+	if actual := <-handler; !reflect.DeepEqual(weights, actual) {
+		t.Errorf("expected %#v, got %#v", weights, actual)
+	}
+	// a change of weights alone is passed on
+	weights = map[string]map[string]int{"foo": {"10.0.0.1:80": 5}}
+	channel <- EndpointsUpdate{Op: ADD, Endpoints: []api.Endpoints{foo}, Weights: weights}
+	if actual := <-handler; !reflect.DeepEqual(weights, actual) {
+		t.Errorf("expected %#v, got %#v", weights, actual)
+	}
+	channel <- EndpointsUpdate{Op: REMOVE, Endpoints: []api.Endpoints{foo}}
+	if actual := <-handler; len(actual) != 0 {
+		t.Errorf("expected no weights, got %#v", actual)
+	}
+}
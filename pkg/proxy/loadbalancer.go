@@ -24,6 +24,8 @@ import (
 // LoadBalancer is an interface for distributing incoming requests to service endpoints.
 type LoadBalancer interface {
 	// NextEndpoint returns the namespace and endpoint to handle a request for the given
-	// service and source address.
-	NextEndpoint(service string, srcAddr net.Addr) (netns.NsHandle, string, error)
+	// service, port name and source address. The port name is empty for the unnamed port of
+	// the service, and otherwise names one of the ports of the EndpointsUpdate.Ports of the
+	// service.
+	NextEndpoint(service, port string, srcAddr net.Addr) (netns.NsHandle, string, error)
 }
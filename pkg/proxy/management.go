@@ -0,0 +1,189 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+
+	pb "github.com/vishvananda/wormhole/pkg/proxy/management"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoClientCAs is returned by NewManagementServer for a TLS config that cannot verify
+// clients.
+var ErrNoClientCAs = errors.New("management API requires ClientCAs to authenticate clients")
+
+// ErrNotManaged is the message of the error returned by a Management method whose subsystem
+// is not set.
+var ErrNotManaged = errors.New("subsystem is not managed by this proxy")
+
+// Management reconfigures a running proxy. It implements the WormholeManagement gRPC service
+// of management.proto, served by NewManagementServer and called with a ManagementClient.
+type Management struct {
+	pb.UnimplementedWormholeManagementServer
+
+	// LoadBalancer takes the weights set by UpdateEndpointWeight and DrainEndpoint.
+	LoadBalancer *WeightedLB
+	// CircuitBreaker takes the threshold set by SetCircuitBreakerThreshold.
+	CircuitBreaker *CircuitBreaker
+	// Proxier is reported by GetProxyStats.
+	Proxier *Proxier
+}
+
+// UpdateEndpointWeight sets the weight of an endpoint, as WeightedLB.SetWeight.
+func (m *Management) UpdateEndpointWeight(ctx context.Context, req *pb.UpdateEndpointWeightRequest) (*pb.UpdateEndpointWeightResponse, error) {
+	if m.LoadBalancer == nil {
+		return nil, status.Error(codes.Unimplemented, ErrNotManaged.Error())
+	}
+	m.LoadBalancer.SetWeight(req.Service, req.Endpoint, int(req.Weight))
+	return &pb.UpdateEndpointWeightResponse{}, nil
+}
+
+// SetCircuitBreakerThreshold sets the number of consecutive failures that trips an endpoint.
+func (m *Management) SetCircuitBreakerThreshold(ctx context.Context, req *pb.SetCircuitBreakerThresholdRequest) (*pb.SetCircuitBreakerThresholdResponse, error) {
+	if m.CircuitBreaker == nil {
+		return nil, status.Error(codes.Unimplemented, ErrNotManaged.Error())
+	}
+	if req.FailureThreshold < 1 {
+		return nil, status.Error(codes.InvalidArgument, "failure threshold must be positive")
+	}
+	m.CircuitBreaker.SetFailureThreshold(int(req.FailureThreshold))
+	return &pb.SetCircuitBreakerThresholdResponse{}, nil
+}
+
+// GetProxyStats returns the services being proxied and the endpoints that are tripped.
+func (m *Management) GetProxyStats(ctx context.Context, req *pb.GetProxyStatsRequest) (*pb.ProxyStats, error) {
+	stats := &pb.ProxyStats{}
+	if m.Proxier != nil {
+		for _, service := range m.Proxier.Stats() {
+			stats.Services = append(stats.Services, &pb.ServiceStats{
+				Id:       service.ID,
+				Protocol: service.Protocol,
+				Port:     int32(service.Port),
+				Active:   service.Active,
+			})
+		}
+	}
+	if m.CircuitBreaker != nil {
+		stats.Tripped = make(map[string]*pb.Endpoints)
+		for service, endpoints := range m.CircuitBreaker.Tripped() {
+			stats.Tripped[service] = &pb.Endpoints{Endpoints: endpoints}
+		}
+	}
+	return stats, nil
+}
+
+// DrainEndpoint stops sending new connections to an endpoint by giving it a weight of 0.
+// Connections that are open are left alone.
+func (m *Management) DrainEndpoint(ctx context.Context, req *pb.DrainEndpointRequest) (*pb.DrainEndpointResponse, error) {
+	if m.LoadBalancer == nil {
+		return nil, status.Error(codes.Unimplemented, ErrNotManaged.Error())
+	}
+	m.LoadBalancer.SetWeight(req.Service, req.Endpoint, 0)
+	return &pb.DrainEndpointResponse{}, nil
+}
+
+// NewManagementServer returns a gRPC server of m using mutual TLS: clients must present a
+// certificate signed by one of config.ClientCAs.
+func NewManagementServer(m *Management, config *tls.Config) (*grpc.Server, error) {
+	if config.ClientCAs == nil {
+		return nil, ErrNoClientCAs
+	}
+	config = config.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	pb.RegisterWormholeManagementServer(server, m)
+	return server, nil
+}
+
+// ProxyStats is the state of a proxy as returned by GetProxyStats.
+type ProxyStats struct {
+	Services []ServiceStats
+	// Tripped holds the endpoints of each service taken out of rotation by the circuit breaker.
+	Tripped map[string][]string
+}
+
+// ManagementClient calls the Management of a remote proxy.
+type ManagementClient struct {
+	conn      *grpc.ClientConn
+	RPCClient pb.WormholeManagementClient
+}
+
+// DialManagement connects to the Management of the proxy at address. config must hold the
+// client certificate.
+func DialManagement(address string, config *tls.Config) (*ManagementClient, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		return nil, err
+	}
+	return &ManagementClient{conn, pb.NewWormholeManagementClient(conn)}, nil
+}
+
+// Close closes the connection to the proxy.
+func (c *ManagementClient) Close() error {
+	return c.conn.Close()
+}
+
+// UpdateEndpointWeight sets the weight of endpoint of service, as Management.UpdateEndpointWeight.
+func (c *ManagementClient) UpdateEndpointWeight(service, endpoint string, weight int) error {
+	req := &pb.UpdateEndpointWeightRequest{Service: service, Endpoint: endpoint, Weight: int32(weight)}
+	_, err := c.RPCClient.UpdateEndpointWeight(context.Background(), req)
+	return err
+}
+
+// SetCircuitBreakerThreshold sets the number of consecutive failures that trips an endpoint.
+func (c *ManagementClient) SetCircuitBreakerThreshold(failureThreshold int) error {
+	req := &pb.SetCircuitBreakerThresholdRequest{FailureThreshold: int32(failureThreshold)}
+	_, err := c.RPCClient.SetCircuitBreakerThreshold(context.Background(), req)
+	return err
+}
+
+// GetProxyStats returns the services being proxied and the endpoints that are tripped.
+func (c *ManagementClient) GetProxyStats() (ProxyStats, error) {
+	reply, err := c.RPCClient.GetProxyStats(context.Background(), &pb.GetProxyStatsRequest{})
+	if err != nil {
+		return ProxyStats{}, err
+	}
+	stats := ProxyStats{}
+	for _, service := range reply.Services {
+		stats.Services = append(stats.Services, ServiceStats{
+			ID:       service.Id,
+			Protocol: service.Protocol,
+			Port:     int(service.Port),
+			Active:   service.Active,
+		})
+	}
+	if reply.Tripped != nil {
+		stats.Tripped = make(map[string][]string)
+		for service, endpoints := range reply.Tripped {
+			stats.Tripped[service] = endpoints.Endpoints
+		}
+	}
+	return stats, nil
+}
+
+// DrainEndpoint stops the proxy from sending new connections to endpoint of service.
+func (c *ManagementClient) DrainEndpoint(service, endpoint string) error {
+	req := &pb.DrainEndpointRequest{Service: service, Endpoint: endpoint}
+	_, err := c.RPCClient.DrainEndpoint(context.Background(), req)
+	return err
+}
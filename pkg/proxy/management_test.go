@@ -0,0 +1,129 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/certtest"
+	"google.golang.org/grpc"
+)
+
+// serveManagement serves management on a local address, which it returns, with a certificate
+// issued by ca, accepting the clients of ca.
+func serveManagement(t *testing.T, management *Management, ca *certtest.CA) (*grpc.Server, string) {
+	server, err := NewManagementServer(management, &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, certtest.Leaf("proxy", x509.ExtKeyUsageServerAuth))},
+		ClientCAs:    ca.Pool(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go server.Serve(listener)
+	return server, listener.Addr().String()
+}
+
+func TestManagement(t *testing.T) {
+	ca := certtest.NewCA(t)
+
+	lb := NewWeightedLB()
+	lb.OnUpdate([]api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"}}})
+	breaker := NewCircuitBreaker(lb, 3, time.Minute, time.Second)
+	management := &Management{LoadBalancer: lb, CircuitBreaker: breaker, Proxier: NewProxier(breaker, "127.0.0.1")}
+
+	if _, err := NewManagementServer(management, &tls.Config{}); err != ErrNoClientCAs {
+		t.Errorf("expected %v, got %v", ErrNoClientCAs, err)
+	}
+	server, address := serveManagement(t, management, ca)
+	defer server.Stop()
+
+	client, err := DialManagement(address, &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, certtest.Leaf("operator", x509.ExtKeyUsageClientAuth))},
+		RootCAs:      ca.Pool(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	// draining one endpoint sends every connection to the other
+	if err := client.DrainEndpoint("foo", "10.0.0.1:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if counts := countEndpoints(t, lb, "foo", 3); counts["10.0.0.2:80"] != 3 {
+		t.Errorf("expected only 10.0.0.2:80, got %v", counts)
+	}
+	if err := client.UpdateEndpointWeight("foo", "10.0.0.2:80", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.UpdateEndpointWeight("foo", "10.0.0.1:80", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if counts := countEndpoints(t, lb, "foo", 3); counts["10.0.0.1:80"] != 3 {
+		t.Errorf("expected only 10.0.0.1:80, got %v", counts)
+	}
+
+	if err := client.SetCircuitBreakerThreshold(0); err == nil {
+		t.Errorf("expected an error for a threshold of 0")
+	}
+	if err := client.SetCircuitBreakerThreshold(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	breaker.ReportFailure("foo", "10.0.0.2:80")
+	port, err := management.Proxier.AddService("foo", "TCP", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer management.Proxier.StopProxy("foo")
+	stats, err := client.GetProxyStats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := ProxyStats{Services: []ServiceStats{{ID: "foo", Protocol: "TCP", Port: port, Active: true}}, Tripped: map[string][]string{"foo": {"10.0.0.2:80"}}}
+	if !reflect.DeepEqual(expected, stats) {
+		t.Errorf("expected %#v, got %#v", expected, stats)
+	}
+}
+
+func TestManagementRequiresClientCertificate(t *testing.T) {
+	ca := certtest.NewCA(t)
+	server, address := serveManagement(t, &Management{LoadBalancer: NewWeightedLB()}, ca)
+	defer server.Stop()
+
+	// a client signed by another CA is refused as well as one without a certificate
+	other := certtest.NewCA(t)
+	for _, certificates := range [][]tls.Certificate{nil, {other.Issue(t, certtest.Leaf("operator", x509.ExtKeyUsageClientAuth))}} {
+		client, err := DialManagement(address, &tls.Config{Certificates: certificates, RootCAs: ca.Pool()})
+		if err != nil {
+			continue
+		}
+		if err := client.DrainEndpoint("foo", "10.0.0.1:80"); err == nil {
+			t.Errorf("expected the call to be refused")
+		}
+		client.Close()
+	}
+}
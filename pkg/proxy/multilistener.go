@@ -0,0 +1,148 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+// PortRange is a range of ports, First to Last, that a MultiListener serves the services of a
+// namespace on. An empty Namespace matches the services of any namespace.
+type PortRange struct {
+	Address   string
+	Namespace string
+	First     int
+	Last      int
+}
+
+// contains returns whether service belongs in the range.
+func (r PortRange) contains(service api.Service) bool {
+	if r.Namespace != "" && service.Labels[config.NamespaceLabel] != r.Namespace {
+		return false
+	}
+	return service.Port >= r.First && service.Port <= r.Last
+}
+
+// ListenAddress is an address and port a MultiListener listens on.
+type ListenAddress struct {
+	Address string
+	Port    int
+}
+
+// MultiListener proxies services on several port ranges from a single process, each on its own
+// address and for its own namespace. A service is served in the first range that contains it
+// and ignored if there is none. Each range has a Proxier of its own, so a service that leaves
+// a range stops being accepted there while its established connections carry on.
+type MultiListener struct {
+	ranges   []PortRange
+	proxiers []*Proxier
+	// assigned holds the services of each range as of the last update.
+	assigned [][]api.Service
+
+	mu      sync.Mutex // protects targets
+	targets map[ListenAddress]string
+}
+
+// NewMultiListener creates a MultiListener serving ranges with the endpoints of loadBalancer.
+func NewMultiListener(loadBalancer LoadBalancer, ranges []PortRange) *MultiListener {
+	proxiers := make([]*Proxier, len(ranges))
+	for i, r := range ranges {
+		proxiers[i] = NewProxier(loadBalancer, r.Address)
+	}
+	return &MultiListener{
+		ranges:   ranges,
+		proxiers: proxiers,
+		targets:  make(map[ListenAddress]string),
+	}
+}
+
+// OnUpdate assigns services to their ranges, starting and stopping listeners as needed. The
+// listeners of services that left a range, or moved to another port, are released before any
+// are started, so that a service moving between ranges on the same address and port can bind
+// it. A service on the same address and port as an earlier one is not proxied.
+func (m *MultiListener) OnUpdate(services []api.Service) {
+	assigned := make([][]api.Service, len(m.ranges))
+	targets := make(map[ListenAddress]string)
+	for _, service := range services {
+		key := config.ServiceKey(service)
+		i := m.rangeOf(service)
+		if i < 0 {
+			glog.V(2).Infof("Not proxying %s on port %d, which is in no range", key, service.Port)
+			continue
+		}
+		address := ListenAddress{m.ranges[i].Address, service.Port}
+		if other, found := targets[address]; found {
+			glog.Errorf("Not proxying %s on %s port %d, which is taken by %s", key, address.Address, address.Port, other)
+			continue
+		}
+		assigned[i] = append(assigned[i], service)
+		targets[address] = key
+	}
+	for i, proxier := range m.proxiers {
+		if i < len(m.assigned) {
+			proxier.OnUpdate(unchanged(m.assigned[i], assigned[i]))
+		}
+	}
+	for i, proxier := range m.proxiers {
+		proxier.OnUpdate(assigned[i])
+	}
+	m.assigned = assigned
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets = targets
+}
+
+// unchanged returns the services of last that are in next on the same port.
+func unchanged(last, next []api.Service) []api.Service {
+	ports := make(map[string]int, len(next))
+	for _, service := range next {
+		ports[config.ServiceKey(service)] = service.Port
+	}
+	var kept []api.Service
+	for _, service := range last {
+		if port, found := ports[config.ServiceKey(service)]; found && port == service.Port {
+			kept = append(kept, service)
+		}
+	}
+	return kept
+}
+
+// rangeOf returns the index of the first range containing service, or -1.
+func (m *MultiListener) rangeOf(service api.Service) int {
+	for i, r := range m.ranges {
+		if r.contains(service) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Targets returns the key of the service proxied on each address and port, as of the last
+// update.
+func (m *MultiListener) Targets() map[ListenAddress]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	targets := make(map[ListenAddress]string, len(m.targets))
+	for address, service := range m.targets {
+		targets[address] = service
+	}
+	return targets
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+// freePort returns a port that nothing listens on at the moment.
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestMultiListener(t *testing.T) {
+	first, second := freePort(t), freePort(t)
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "a/foo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+		{
+			JSONBase:  api.JSONBase{ID: "b/bar"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	m := NewMultiListener(lb, []PortRange{
+		{Address: "127.0.0.1", Namespace: "a", First: first, Last: first},
+		{Address: "127.0.0.1", Namespace: "b", First: second, Last: second},
+	})
+
+	foo := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: first, Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "a"}}
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: second, Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "b"}}
+	// the port is in the range of another namespace
+	baz := api.Service{JSONBase: api.JSONBase{ID: "baz"}, Port: second, Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "a"}}
+	m.OnUpdate([]api.Service{foo, bar, baz})
+
+	expected := map[ListenAddress]string{
+		{"127.0.0.1", first}:  "a/foo",
+		{"127.0.0.1", second}: "b/bar",
+	}
+	if actual := m.Targets(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(first))
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(second))
+
+	m.OnUpdate([]api.Service{bar})
+	expected = map[ListenAddress]string{{"127.0.0.1", second}: "b/bar"}
+	if actual := m.Targets(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+	if err := waitForClosedPortTCP(m.proxiers[0], strconv.Itoa(first)); err != nil {
+		t.Fatal(err)
+	}
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(second))
+	m.OnUpdate(nil)
+}
+
+func TestMultiListenerMove(t *testing.T) {
+	port := freePort(t)
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "b/foo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	m := NewMultiListener(lb, []PortRange{
+		{Address: "127.0.0.1", Namespace: "a", First: port, Last: port},
+		{Address: "127.0.0.1", Namespace: "b", First: port, Last: port},
+	})
+	defer m.OnUpdate(nil)
+
+	inA := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: port, Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "a"}}
+	inB := api.Service{JSONBase: api.JSONBase{ID: "foo"}, Port: port, Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "b"}}
+	m.OnUpdate([]api.Service{inA})
+
+	// the listener of the range left is released before the new range binds the port
+	start := time.Now()
+	m.OnUpdate([]api.Service{inB})
+	if elapsed := time.Since(start); elapsed >= listenTimeout {
+		t.Errorf("expected the port to be free for the new range, waited %v", elapsed)
+	}
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(port))
+
+	// a second service on the same address and port is not proxied
+	bar := api.Service{JSONBase: api.JSONBase{ID: "bar"}, Port: port, Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "b"}}
+	m.OnUpdate([]api.Service{inB, bar})
+	expected := map[ListenAddress]string{{"127.0.0.1", port}: "b/foo"}
+	if actual := m.Targets(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
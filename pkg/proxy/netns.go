@@ -0,0 +1,67 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/vishvananda/netns"
+)
+
+// withNamespace calls f from within ns, if it is open. The calling goroutine is locked to its
+// thread until the thread is back in its original namespace.
+func withNamespace(ns netns.NsHandle, f func() error) error {
+	if !ns.IsOpen() {
+		return f()
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origns, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origns.Close()
+	defer netns.Set(origns)
+	if err := netns.Set(ns); err != nil {
+		return err
+	}
+	return f()
+}
+
+// dialEndpoint connects to endpoint from within ns, if it is open, retrying as retryDial does.
+func dialEndpoint(ns netns.NsHandle, network, endpoint string) (net.Conn, error) {
+	var conn net.Conn
+	err := withNamespace(ns, func() error {
+		var err error
+		conn, err = retryDial(network, endpoint, endpointDialTimeout)
+		return err
+	})
+	return conn, err
+}
+
+// dialTimeout makes a single connection attempt to endpoint from within ns, if it is open.
+func dialTimeout(ns netns.NsHandle, network, endpoint string, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	err := withNamespace(ns, func() error {
+		var err error
+		conn, err = net.DialTimeout(network, endpoint, timeout)
+		return err
+	})
+	return conn, err
+}
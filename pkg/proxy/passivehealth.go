@@ -0,0 +1,246 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netns"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+var ErrAllEndpointsUnhealthy = errors.New("all endpoints are unhealthy")
+
+// defaultMinConnections is the MinConnections of a new PassiveHealthChecker.
+const defaultMinConnections = 5
+
+// outcome is the result of a single connection to an endpoint.
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// endpointHealth is the recent history of a single endpoint of a service.
+type endpointHealth struct {
+	outcomes  []outcome
+	ejected   bool
+	ejectedAt time.Time
+}
+
+// PassiveHealthChecker wraps a LoadBalancer and takes endpoints out of rotation based on the
+// outcome of the connections the proxy makes to them, rather than by probing. An endpoint
+// whose connections fail, whether refused, reset or timed out, at ErrorRate or more over
+// Window is skipped for Cooldown and then reintroduced with a clean history.
+type PassiveHealthChecker struct {
+	loadBalancer LoadBalancer
+	// ErrorRate is the fraction of failed connections, between 0 and 1, that ejects an endpoint.
+	ErrorRate float64
+	// Window is how far back connections are counted.
+	Window time.Duration
+	// Cooldown is how long an ejected endpoint receives no traffic.
+	Cooldown time.Duration
+	// MinConnections is the number of connections in Window below which the error rate of an
+	// endpoint is not judged, so that a single early failure does not eject it. Defaults to 5.
+	MinConnections int
+	// Updates, if set, receives a REMOVE when an endpoint is ejected and an ADD when it is
+	// reintroduced, each holding a single api.Endpoints with the service ID and the affected
+	// endpoint. An update is dropped, rather than holding up connections, if Updates is full.
+	Updates chan<- config.EndpointsUpdate
+	// Clock is the source of time. Defaults to the real clock.
+	Clock config.Clock
+
+	lock   sync.Mutex
+	health map[string]map[string]*endpointHealth // service -> endpoint -> history
+}
+
+// NewPassiveHealthChecker creates a PassiveHealthChecker around loadBalancer.
+func NewPassiveHealthChecker(loadBalancer LoadBalancer, errorRate float64, window, cooldown time.Duration) *PassiveHealthChecker {
+	return &PassiveHealthChecker{
+		loadBalancer:   loadBalancer,
+		ErrorRate:      errorRate,
+		Window:         window,
+		Cooldown:       cooldown,
+		MinConnections: defaultMinConnections,
+		health:         make(map[string]map[string]*endpointHealth),
+	}
+}
+
+func (hc *PassiveHealthChecker) now() time.Time {
+	if hc.Clock == nil {
+		return time.Now()
+	}
+	return hc.Clock.Now()
+}
+
+// NextEndpoint returns the next endpoint of the wrapped load balancer that is not ejected.
+func (hc *PassiveHealthChecker) NextEndpoint(service, port string, srcAddr net.Addr) (netns.NsHandle, string, error) {
+	hc.lock.Lock()
+	attempts := 1
+	for _, h := range hc.health[service] {
+		if h.ejected {
+			attempts++
+		}
+	}
+	hc.lock.Unlock()
+
+	// As with CircuitBreaker, skipping every ejected endpoint once reaches a healthy one if
+	// there is any.
+	for i := 0; i < attempts; i++ {
+		ns, endpoint, err := hc.loadBalancer.NextEndpoint(service, port, srcAddr)
+		if err != nil {
+			return ns, endpoint, err
+		}
+		if hc.allow(service, endpoint) {
+			return ns, endpoint, nil
+		}
+	}
+	return netns.None(), "", ErrAllEndpointsUnhealthy
+}
+
+// allow reports whether endpoint may receive a connection, reintroducing it if it was ejected
+// more than Cooldown ago.
+func (hc *PassiveHealthChecker) allow(service, endpoint string) bool {
+	hc.lock.Lock()
+	h, found := hc.health[service][endpoint]
+	if !found || !h.ejected {
+		hc.lock.Unlock()
+		return true
+	}
+	if hc.now().Before(h.ejectedAt.Add(hc.Cooldown)) {
+		hc.lock.Unlock()
+		return false
+	}
+	h.ejected = false
+	h.outcomes = nil
+	hc.lock.Unlock()
+
+	glog.Infof("Reintroducing endpoint %s of %s after %v", endpoint, service, hc.Cooldown)
+	hc.notify(config.ADD, service, endpoint)
+	return true
+}
+
+// ReportSuccess counts a successful connection to endpoint.
+func (hc *PassiveHealthChecker) ReportSuccess(service, endpoint string) {
+	hc.record(service, endpoint, false)
+}
+
+// ReportFailure counts a failed connection to endpoint, ejecting it if its error rate over
+// Window reaches ErrorRate.
+func (hc *PassiveHealthChecker) ReportFailure(service, endpoint string) {
+	hc.record(service, endpoint, true)
+}
+
+func (hc *PassiveHealthChecker) record(service, endpoint string, failed bool) {
+	hc.lock.Lock()
+	if hc.health[service] == nil {
+		hc.health[service] = make(map[string]*endpointHealth)
+	}
+	h, found := hc.health[service][endpoint]
+	if !found {
+		h = &endpointHealth{}
+		hc.health[service][endpoint] = h
+	}
+	if h.ejected {
+		// Connections made before the endpoint was ejected say nothing new.
+		hc.lock.Unlock()
+		return
+	}
+	now := hc.now()
+	h.outcomes = append(h.outcomes, outcome{at: now, failed: failed})
+	start := now.Add(-hc.Window)
+	for len(h.outcomes) > 0 && h.outcomes[0].at.Before(start) {
+		h.outcomes = h.outcomes[1:]
+	}
+	failures := 0
+	for _, o := range h.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	total := len(h.outcomes)
+	ejected := total >= hc.MinConnections && float64(failures) >= hc.ErrorRate*float64(total)
+	if ejected {
+		h.ejected = true
+		h.ejectedAt = now
+		h.outcomes = nil
+	}
+	hc.lock.Unlock()
+
+	if ejected {
+		glog.Infof("Endpoint %s of %s failed %d of %d connections in %v, removing it from rotation", endpoint, service, failures, total, hc.Window)
+		hc.notify(config.REMOVE, service, endpoint)
+	}
+}
+
+// OnUpdate forgets the history of endpoints that no longer exist.
+func (hc *PassiveHealthChecker) OnUpdate(endpoints []api.Endpoints) {
+	active := make(map[string]map[string]bool)
+	for _, e := range endpoints {
+		active[e.ID] = make(map[string]bool)
+		for _, endpoint := range e.Endpoints {
+			active[e.ID][endpoint] = true
+		}
+	}
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	for service, health := range hc.health {
+		for endpoint := range health {
+			if !active[service][endpoint] {
+				delete(health, endpoint)
+			}
+		}
+		if len(health) == 0 {
+			delete(hc.health, service)
+		}
+	}
+}
+
+func (hc *PassiveHealthChecker) notify(op config.Operation, service, endpoint string) {
+	if hc.Updates == nil {
+		return
+	}
+	update := config.EndpointsUpdate{Op: op, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: service}, Endpoints: []string{endpoint}},
+	}}
+	select {
+	case hc.Updates <- update:
+	default:
+		glog.Warningf("Dropping update for endpoint %s of %s: updates are not being drained", endpoint, service)
+	}
+}
+
+// Ejected returns the endpoints of each service that are out of rotation, sorted.
+func (hc *PassiveHealthChecker) Ejected() map[string][]string {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	ejected := make(map[string][]string)
+	for service, health := range hc.health {
+		for endpoint, h := range health {
+			if h.ejected {
+				ejected[service] = append(ejected[service], endpoint)
+			}
+		}
+		sort.Strings(ejected[service])
+	}
+	return ejected
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+func TestPassiveHealthChecker(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "foo"},
+			Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+	})
+	updates := make(chan config.EndpointsUpdate, 10)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	hc := NewPassiveHealthChecker(lb, 0.5, 10*time.Second, time.Minute)
+	hc.MinConnections = 4
+	hc.Updates = updates
+	hc.Clock = clock
+
+	// failures that have left the window do not count
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	clock.now = clock.now.Add(11 * time.Second)
+	hc.ReportSuccess("foo", "10.0.0.1:80")
+	hc.ReportSuccess("foo", "10.0.0.1:80")
+	hc.ReportSuccess("foo", "10.0.0.1:80")
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	if ejected := hc.Ejected(); len(ejected) != 0 {
+		t.Errorf("expected no ejected endpoints, got %v", ejected)
+	}
+
+	// 3 of 6 connections in the window failed
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	expectedUpdate := config.EndpointsUpdate{Op: config.REMOVE, Endpoints: []api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.1:80"}},
+	}}
+	if actual := <-updates; !reflect.DeepEqual(expectedUpdate, actual) {
+		t.Errorf("expected %#v, got %#v", expectedUpdate, actual)
+	}
+	expectedEjected := map[string][]string{"foo": {"10.0.0.1:80"}}
+	if actual := hc.Ejected(); !reflect.DeepEqual(expectedEjected, actual) {
+		t.Errorf("expected %v, got %v", expectedEjected, actual)
+	}
+	for i := 0; i < 3; i++ {
+		if _, endpoint, err := hc.NextEndpoint("foo", "", nil); err != nil || endpoint != "10.0.0.2:80" {
+			t.Errorf("expected 10.0.0.2:80, got %q, %v", endpoint, err)
+		}
+	}
+
+	// after the cooldown the endpoint is back with a clean history
+	clock.now = clock.now.Add(time.Minute)
+	expected := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	actual := []string{}
+	for i := 0; i < 2; i++ {
+		_, endpoint, err := hc.NextEndpoint("foo", "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		actual = append(actual, endpoint)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	expectedUpdate.Op = config.ADD
+	if actual := <-updates; !reflect.DeepEqual(expectedUpdate, actual) {
+		t.Errorf("expected %#v, got %#v", expectedUpdate, actual)
+	}
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	if ejected := hc.Ejected(); len(ejected) != 0 {
+		t.Errorf("expected no ejected endpoints, got %v", ejected)
+	}
+
+	// with every endpoint ejected there is nothing to pick
+	hc.MinConnections = 1
+	hc.ReportFailure("foo", "10.0.0.1:80")
+	hc.ReportFailure("foo", "10.0.0.2:80")
+	if _, _, err := hc.NextEndpoint("foo", "", nil); err != ErrAllEndpointsUnhealthy {
+		t.Errorf("expected %v, got %v", ErrAllEndpointsUnhealthy, err)
+	}
+
+	// removed endpoints are forgotten
+	hc.OnUpdate([]api.Endpoints{{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"10.0.0.2:80"}}})
+	expectedEjected = map[string][]string{"foo": {"10.0.0.2:80"}}
+	if actual := hc.Ejected(); !reflect.DeepEqual(expectedEjected, actual) {
+		t.Errorf("expected %v, got %v", expectedEjected, actual)
+	}
+
+	// updates that find the channel full are dropped rather than blocking
+	hc.Updates = make(chan config.EndpointsUpdate)
+	clock.now = clock.now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, _, err := hc.NextEndpoint("foo", "", nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if ejected := hc.Ejected(); len(ejected) != 0 {
+		t.Errorf("expected no ejected endpoints, got %v", ejected)
+	}
+}
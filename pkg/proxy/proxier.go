@@ -17,10 +17,11 @@ limitations under the License.
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +31,7 @@ import (
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
 	"github.com/golang/glog"
 	"github.com/vishvananda/netns"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
 )
 
 type serviceInfo struct {
@@ -69,8 +71,10 @@ type proxySocket interface {
 	// Close stops the proxySocket from accepting incoming connections.  Each implementation should comment
 	// on the impact of calling Close while sessions are active.
 	Close() error
-	// ProxyLoop proxies incoming connections for the specified service to the service endpoints.
-	ProxyLoop(service string, proxier *Proxier)
+	// ProxyLoop proxies incoming connections for the specified port of the specified service to
+	// the addresses of that port of the service endpoints. The port name is empty for the
+	// unnamed port of the service.
+	ProxyLoop(service, port string, proxier *Proxier)
 }
 
 // tcpProxySocket implements proxySocket.  Close() is implemented by net.Listener.  When Close() is called,
@@ -97,10 +101,10 @@ func retryDial(network, address string, timeout time.Duration) (net.Conn, error)
 	}
 }
 
-func (tcp *tcpProxySocket) ProxyLoop(service string, proxier *Proxier) {
-	info, found := proxier.getServiceInfo(service)
+func (tcp *tcpProxySocket) ProxyLoop(service, port string, proxier *Proxier) {
+	info, found := proxier.getServiceInfo(config.ServicePortName(service, port))
 	if !found {
-		glog.Errorf("Failed to find service: %s", service)
+		glog.Errorf("Failed to find service: %s", config.ServicePortName(service, port))
 		return
 	}
 	for {
@@ -115,7 +119,17 @@ func (tcp *tcpProxySocket) ProxyLoop(service string, proxier *Proxier) {
 			continue
 		}
 		glog.Infof("Accepted TCP connection from %v to %v", inConn.RemoteAddr(), inConn.LocalAddr())
-		ns, endpoint, err := proxier.loadBalancer.NextEndpoint(service, inConn.RemoteAddr())
+		if proxier.RetryPolicy != nil {
+			outConn, endpoint, err := proxier.dialWithRetry(context.Background(), service, port, inConn.RemoteAddr(), proxier.RetryPolicy)
+			if err != nil {
+				glog.Errorf("Failed to connect to an endpoint of %s: %v", service, err)
+				inConn.Close()
+				continue
+			}
+			proxier.forward(inConn, outConn, endpoint)
+			continue
+		}
+		ns, endpoint, err := proxier.loadBalancer.NextEndpoint(service, port, inConn.RemoteAddr())
 		if err != nil {
 			glog.Errorf("Couldn't find an endpoint for %s %v", service, err)
 			inConn.Close()
@@ -126,34 +140,34 @@ func (tcp *tcpProxySocket) ProxyLoop(service string, proxier *Proxier) {
 		// and keep accepting inbound traffic.
 		if ns.IsOpen() {
 			glog.Infof("Using namespace %v for endpoint %s", ns, endpoint)
-			runtime.LockOSThread()
-			defer runtime.UnlockOSThread()
-			origns, err := netns.Get()
-			if err != nil {
-				glog.Errorf("Failed to get original ns: %v", err)
-				continue
-			}
-			err = netns.Set(ns)
-			if err != nil {
-				glog.Errorf("Failed to set ns: %v", err)
-				continue
-			}
-			defer netns.Set(origns)
 		}
-		outConn, err := retryDial("tcp", endpoint, endpointDialTimeout)
+		outConn, err := dialEndpoint(ns, "tcp", endpoint)
 		if err != nil {
 			// TODO: Try another endpoint?
 			glog.Errorf("Dial failed: %v", err)
+			proxier.reportFailure(service, endpoint)
 			inConn.Close()
 			continue
 		}
-		// Spin up an async copy loop.
-		proxyTCP(inConn.(*net.TCPConn), outConn.(*net.TCPConn))
+		proxier.reportSuccess(service, endpoint)
+		proxier.forward(inConn, outConn, endpoint)
+	}
+}
+
+// forward sends the PROXY header, if enabled, and starts copying between inConn and outConn.
+func (proxier *Proxier) forward(inConn, outConn net.Conn, endpoint string) {
+	if err := proxier.writeProxyHeader(outConn, inConn); err != nil {
+		glog.Errorf("Failed to send PROXY header to %s: %v", endpoint, err)
+		inConn.Close()
+		outConn.Close()
+		return
 	}
+	// Spin up an async copy loop.
+	proxyTCP(inConn, outConn)
 }
 
 // proxyTCP proxies data bi-directionally between in and out.
-func proxyTCP(in, out *net.TCPConn) {
+func proxyTCP(in, out net.Conn) {
 	glog.Infof("Creating proxy between %v <-> %v <-> %v <-> %v",
 		in.RemoteAddr(), in.LocalAddr(), out.LocalAddr(), out.RemoteAddr())
 	go copyBytes(in, out)
@@ -171,20 +185,124 @@ func (udp *udpProxySocket) Addr() net.Addr {
 	return udp.LocalAddr()
 }
 
+// udpClient is the connection to an endpoint kept for a client address of a UDP service.
+type udpClient struct {
+	addr     net.Addr
+	endpoint string
+	conn     net.Conn
+}
+
 // Holds all the known UDP clients that have not timed out.
 type clientCache struct {
 	mu      sync.Mutex
-	clients map[string]net.Conn // addr string -> connection
+	clients map[string]*udpClient // addr string -> client
 }
 
 func newClientCache() *clientCache {
-	return &clientCache{clients: map[string]net.Conn{}}
+	return &clientCache{clients: map[string]*udpClient{}}
 }
 
-func (udp *udpProxySocket) ProxyLoop(service string, proxier *Proxier) {
-	info, found := proxier.getServiceInfo(service)
+// get returns the client of cliAddr, connecting it to an endpoint of the port of service chosen
+// by loadBalancer if it has none. The endpoint is dialed without holding the lock, so that a slow
+// dial does not hold up the clients being removed. The replies to a new client are copied
+// back through out until it is idle for timeout.
+func (cache *clientCache) get(loadBalancer LoadBalancer, service, port string, cliAddr net.Addr, out net.PacketConn, timeout time.Duration) (*udpClient, error) {
+	key := cliAddr.String()
+	cache.mu.Lock()
+	client, found := cache.clients[key]
+	cache.mu.Unlock()
+	if found {
+		return client, nil
+	}
+	glog.Infof("New UDP connection from %s", cliAddr)
+	ns, endpoint, err := loadBalancer.NextEndpoint(service, port, cliAddr)
+	if err != nil {
+		glog.Errorf("Couldn't find an endpoint for %s %v", service, err)
+		return nil, err
+	}
+	glog.Infof("Mapped service %s to endpoint %s", service, endpoint)
+	conn, err := dialEndpoint(ns, "udp", endpoint)
+	if err != nil {
+		// TODO: Try another endpoint?
+		glog.Errorf("Dial failed: %v", err)
+		return nil, err
+	}
+	client = &udpClient{addr: cliAddr, endpoint: endpoint, conn: conn}
+	cache.mu.Lock()
+	if other, found := cache.clients[key]; found {
+		// Another datagram of the client got it connected first.
+		cache.mu.Unlock()
+		conn.Close()
+		return other, nil
+	}
+	cache.clients[key] = client
+	cache.mu.Unlock()
+	go func() {
+		defer util.HandleCrash()
+		cache.proxyClient(client, out, timeout)
+	}()
+	return client, nil
+}
+
+// proxyClient copies replies from the endpoint of client back to it through out, until the
+// client is idle for timeout or removed.
+func (cache *clientCache) proxyClient(client *udpClient, out net.PacketConn, timeout time.Duration) {
+	defer cache.remove(client)
+	var buffer [4096]byte
+	for {
+		n, err := client.conn.Read(buffer[0:])
+		if err != nil {
+			if !logTimeout(err) && cache.has(client) {
+				glog.Errorf("Read from %s failed: %v", client.endpoint, err)
+			}
+			break
+		}
+		if err := client.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			glog.Errorf("SetDeadline failed: %v", err)
+			break
+		}
+		if _, err := out.WriteTo(buffer[0:n], client.addr); err != nil {
+			if !logTimeout(err) {
+				glog.Errorf("WriteTo failed: %v", err)
+			}
+			break
+		}
+	}
+}
+
+// remove drops client, unless another client has replaced it, and closes its connection.
+func (cache *clientCache) remove(client *udpClient) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.clients[client.addr.String()] == client {
+		delete(cache.clients, client.addr.String())
+	}
+	client.conn.Close()
+}
+
+func (cache *clientCache) has(client *udpClient) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.clients[client.addr.String()] == client
+}
+
+// flush drops every client for which remove returns true.
+func (cache *clientCache) flush(remove func(*udpClient) bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for key, client := range cache.clients {
+		if remove(client) {
+			glog.Infof("Flushing UDP client %s of endpoint %s", client.addr, client.endpoint)
+			delete(cache.clients, key)
+			client.conn.Close()
+		}
+	}
+}
+
+func (udp *udpProxySocket) ProxyLoop(service, port string, proxier *Proxier) {
+	info, found := proxier.getServiceInfo(config.ServicePortName(service, port))
 	if !found {
-		glog.Errorf("Failed to find service: %s", service)
+		glog.Errorf("Failed to find service: %s", config.ServicePortName(service, port))
 		return
 	}
 	activeClients := newClientCache()
@@ -208,13 +326,13 @@ func (udp *udpProxySocket) ProxyLoop(service string, proxier *Proxier) {
 			break
 		}
 		// If this is a client we know already, reuse the connection and goroutine.
-		svrConn, err := udp.getBackendConn(activeClients, cliAddr, proxier, service, info.timeout)
+		client, err := activeClients.get(proxier.loadBalancer, service, port, cliAddr, udp, info.timeout)
 		if err != nil {
 			continue
 		}
 		// TODO: It would be nice to let the goroutine handle this write, but we don't
 		// really want to copy the buffer.  We could do a pool of buffers or something.
-		_, err = svrConn.Write(buffer[0:n])
+		_, err = client.conn.Write(buffer[0:n])
 		if err != nil {
 			if !logTimeout(err) {
 				glog.Errorf("Write failed: %v", err)
@@ -222,7 +340,7 @@ func (udp *udpProxySocket) ProxyLoop(service string, proxier *Proxier) {
 			}
 			continue
 		}
-		svrConn.SetDeadline(time.Now().Add(info.timeout))
+		err = client.conn.SetDeadline(time.Now().Add(info.timeout))
 		if err != nil {
 			glog.Errorf("SetDeadline failed: %v", err)
 			continue
@@ -230,72 +348,6 @@ func (udp *udpProxySocket) ProxyLoop(service string, proxier *Proxier) {
 	}
 }
 
-func (udp *udpProxySocket) getBackendConn(activeClients *clientCache, cliAddr net.Addr, proxier *Proxier, service string, timeout time.Duration) (net.Conn, error) {
-	activeClients.mu.Lock()
-	defer activeClients.mu.Unlock()
-
-	svrConn, found := activeClients.clients[cliAddr.String()]
-	if !found {
-		// TODO: This could spin up a new goroutine to make the outbound connection,
-		// and keep accepting inbound traffic.
-		glog.Infof("New UDP connection from %s", cliAddr)
-		ns, endpoint, err := proxier.loadBalancer.NextEndpoint(service, cliAddr)
-		if err != nil {
-			glog.Errorf("Couldn't find an endpoint for %s %v", service, err)
-			return nil, err
-		}
-		glog.Infof("Mapped service %s to endpoint %s", service, endpoint)
-		if ns.IsOpen() {
-			glog.Infof("Using namespace %v for endpoint %s", ns, endpoint)
-			runtime.LockOSThread()
-			netns.Set(ns)
-			defer runtime.UnlockOSThread()
-		}
-		svrConn, err = retryDial("udp", endpoint, endpointDialTimeout)
-		if err != nil {
-			// TODO: Try another endpoint?
-			glog.Errorf("Dial failed: %v", err)
-			return nil, err
-		}
-		activeClients.clients[cliAddr.String()] = svrConn
-		go func(cliAddr net.Addr, svrConn net.Conn, activeClients *clientCache, timeout time.Duration) {
-			defer util.HandleCrash()
-			udp.proxyClient(cliAddr, svrConn, activeClients, timeout)
-		}(cliAddr, svrConn, activeClients, timeout)
-	}
-	return svrConn, nil
-}
-
-// This function is expected to be called as a goroutine.
-func (udp *udpProxySocket) proxyClient(cliAddr net.Addr, svrConn net.Conn, activeClients *clientCache, timeout time.Duration) {
-	defer svrConn.Close()
-	var buffer [4096]byte
-	for {
-		n, err := svrConn.Read(buffer[0:])
-		if err != nil {
-			if !logTimeout(err) {
-				glog.Errorf("Read failed: %v", err)
-			}
-			break
-		}
-		svrConn.SetDeadline(time.Now().Add(timeout))
-		if err != nil {
-			glog.Errorf("SetDeadline failed: %v", err)
-			break
-		}
-		n, err = udp.WriteTo(buffer[0:n], cliAddr)
-		if err != nil {
-			if !logTimeout(err) {
-				glog.Errorf("WriteTo failed: %v", err)
-			}
-			break
-		}
-	}
-	activeClients.mu.Lock()
-	delete(activeClients.clients, cliAddr.String())
-	activeClients.mu.Unlock()
-}
-
 func logTimeout(err error) bool {
 	if e, ok := err.(net.Error); ok {
 		if e.Timeout() {
@@ -306,11 +358,14 @@ func logTimeout(err error) bool {
 	return false
 }
 
-func newProxySocket(protocol string, host string, port int) (proxySocket, error) {
+// ListenerFactory creates the listener of a TCP service, as net.Listen does.
+type ListenerFactory func(network, addr string) (net.Listener, error)
+
+func newProxySocket(protocol string, host string, port int, listen ListenerFactory) (proxySocket, error) {
 	endTime := time.Now().Add(listenTimeout)
 	for {
 		remaining := endTime.Sub(time.Now())
-		sock, err := innerProxySocket(protocol, host, port)
+		sock, err := innerProxySocket(protocol, host, port, listen)
 		if err != nil {
 			// TODO(vish): don't retry if the socket is in use
 			if endTime.After(time.Now()) {
@@ -326,10 +381,13 @@ func newProxySocket(protocol string, host string, port int) (proxySocket, error)
 
 }
 
-func innerProxySocket(protocol string, host string, port int) (proxySocket, error) {
+func innerProxySocket(protocol string, host string, port int, listen ListenerFactory) (proxySocket, error) {
 	switch strings.ToUpper(protocol) {
 	case "TCP":
-		listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if listen == nil {
+			listen = net.Listen
+		}
+		listener, err := listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 		if err != nil {
 			return nil, err
 		}
@@ -348,6 +406,18 @@ func innerProxySocket(protocol string, host string, port int) (proxySocket, erro
 	return nil, fmt.Errorf("Unknown protocol %q", protocol)
 }
 
+// socketPort returns the port sock is listening on. A socket that has no port, such as one on
+// a Unix socket from a ListenerFactory, is taken to be on the port that was asked for.
+func socketPort(sock proxySocket, port int) int {
+	switch addr := sock.Addr().(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	}
+	return port
+}
+
 // Proxier is a simple proxy for TCP connections between a localhost:lport
 // and services that provide the actual implementations.
 type Proxier struct {
@@ -357,6 +427,15 @@ type Proxier struct {
 	address      string
 	// NOTE(vish): this ns probably should be part of the Service struct
 	ns netns.NsHandle
+	// ProxyProtocol prepends a PROXY protocol v1 header to each new TCP backend connection.
+	ProxyProtocol bool
+	// ProxyProtocolV2 prepends a PROXY protocol v2 header instead. It takes precedence over ProxyProtocol.
+	ProxyProtocolV2 bool
+	// RetryPolicy, if set, makes a failed TCP backend connection be retried on other endpoints.
+	RetryPolicy *RetryPolicy
+	// ListenerFactory, if set, creates the listeners of TCP services instead of net.Listen, e.g.
+	// to serve on Unix sockets or on sockets handed over by systemd.
+	ListenerFactory ListenerFactory
 }
 
 // NOTE(vish): this ns probably should be part of the Service struct
@@ -376,14 +455,44 @@ func NewProxier(loadBalancer LoadBalancer, address string) *Proxier {
 	}
 }
 
-func copyBytes(in, out *net.TCPConn) {
+// reportSuccess tells the load balancer that a connection to endpoint succeeded, if it wants to know.
+func (proxier *Proxier) reportSuccess(service, endpoint string) {
+	if reporter, ok := proxier.loadBalancer.(EndpointReporter); ok {
+		reporter.ReportSuccess(service, endpoint)
+	}
+}
+
+// reportFailure tells the load balancer that a connection to endpoint failed, if it wants to know.
+func (proxier *Proxier) reportFailure(service, endpoint string) {
+	if reporter, ok := proxier.loadBalancer.(EndpointReporter); ok {
+		reporter.ReportFailure(service, endpoint)
+	}
+}
+
+// halfCloser is a connection that can be shut down one way at a time, such as a TCP or a Unix
+// socket connection.
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+func copyBytes(in, out net.Conn) {
 	glog.Infof("Copying from %v <-> %v <-> %v <-> %v",
 		in.RemoteAddr(), in.LocalAddr(), out.LocalAddr(), out.RemoteAddr())
 	if _, err := io.Copy(in, out); err != nil {
 		glog.Errorf("I/O error: %v", err)
 	}
-	in.CloseRead()
-	out.CloseWrite()
+	// Connections from a ListenerFactory may not be half-closable.
+	if conn, ok := in.(halfCloser); ok {
+		conn.CloseRead()
+	} else {
+		in.Close()
+	}
+	if conn, ok := out.(halfCloser); ok {
+		conn.CloseWrite()
+	} else {
+		out.Close()
+	}
 }
 
 // StopProxy stops the proxy for the named service.
@@ -418,6 +527,32 @@ func (proxier *Proxier) setServiceInfo(service string, info *serviceInfo) {
 	proxier.serviceMap[service] = info
 }
 
+// ServiceStats describes a service that is being proxied.
+type ServiceStats struct {
+	ID       string
+	Protocol string
+	Port     int
+	Active   bool
+}
+
+// Stats returns the services that are being proxied, ordered by ID.
+func (proxier *Proxier) Stats() []ServiceStats {
+	proxier.mu.Lock()
+	defer proxier.mu.Unlock()
+	stats := make([]ServiceStats, 0, len(proxier.serviceMap))
+	for service, info := range proxier.serviceMap {
+		stats = append(stats, ServiceStats{ID: service, Protocol: info.protocol, Port: info.port, Active: info.isActive()})
+	}
+	sort.Sort(serviceStatsByID(stats))
+	return stats
+}
+
+type serviceStatsByID []ServiceStats
+
+func (s serviceStatsByID) Len() int           { return len(s) }
+func (s serviceStatsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s serviceStatsByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
 // used to globally lock around unused ports. Only used in testing.
 var unusedPortLock sync.Mutex
 
@@ -427,18 +562,11 @@ var unusedPortLock sync.Mutex
 func (proxier *Proxier) addServiceOnUnusedPort(service, protocol string, timeout time.Duration) (string, error) {
 	unusedPortLock.Lock()
 	defer unusedPortLock.Unlock()
-	sock, err := newProxySocket(protocol, proxier.address, 0)
-	if err != nil {
-		return "", err
-	}
-	_, port, err := net.SplitHostPort(sock.Addr().String())
-	if err != nil {
-		return "", err
-	}
-	portNum, err := strconv.Atoi(port)
+	sock, err := newProxySocket(protocol, proxier.address, 0, proxier.ListenerFactory)
 	if err != nil {
 		return "", err
 	}
+	portNum := socketPort(sock, 0)
 	proxier.setServiceInfo(service, &serviceInfo{
 		port:     portNum,
 		protocol: protocol,
@@ -446,15 +574,15 @@ func (proxier *Proxier) addServiceOnUnusedPort(service, protocol string, timeout
 		socket:   sock,
 		timeout:  timeout,
 	})
-	proxier.startAccepting(service, sock)
-	return port, nil
+	proxier.startAccepting(service, "", sock)
+	return strconv.Itoa(portNum), nil
 }
 
-func (proxier *Proxier) startAccepting(service string, sock proxySocket) {
-	glog.Infof("Listening for %s on %s:%s", service, sock.Addr().Network(), sock.Addr().String())
+func (proxier *Proxier) startAccepting(service, port string, sock proxySocket) {
+	glog.Infof("Listening for %s on %s:%s", config.ServicePortName(service, port), sock.Addr().Network(), sock.Addr().String())
 	go func(service string, proxier *Proxier) {
 		defer util.HandleCrash()
-		sock.ProxyLoop(service, proxier)
+		sock.ProxyLoop(service, port, proxier)
 	}(service, proxier)
 }
 
@@ -465,30 +593,17 @@ func (proxier *Proxier) AddService(service, protocol string, port int) (int, err
 	glog.Infof("Adding proxy %s on %s:%d", service, proxier.address, port)
 	if proxier.ns.IsOpen() {
 		glog.Infof("Using namespace %v for proxy %s", proxier.ns, service)
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
-		origns, err := netns.Get()
-		if err != nil {
-			return 0, err
-		}
-		err = netns.Set(proxier.ns)
-		if err != nil {
-			return 0, err
-		}
-		defer netns.Set(origns)
-	}
-	sock, err := newProxySocket(protocol, proxier.address, port)
-	if err != nil {
-		return 0, err
 	}
-	_, portStr, err := net.SplitHostPort(sock.Addr().String())
-	if err != nil {
-		return 0, err
-	}
-	portNum, err := strconv.Atoi(portStr)
+	var sock proxySocket
+	err := withNamespace(proxier.ns, func() error {
+		var err error
+		sock, err = newProxySocket(protocol, proxier.address, port, proxier.ListenerFactory)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
+	portNum := socketPort(sock, port)
 	proxier.setServiceInfo(service, &serviceInfo{
 		port:     portNum,
 		protocol: protocol,
@@ -496,43 +611,24 @@ func (proxier *Proxier) AddService(service, protocol string, port int) (int, err
 		socket:   sock,
 		timeout:  udpIdleTimeout,
 	})
-	proxier.startAccepting(service, sock)
-	return portNum, err
+	proxier.startAccepting(service, "", sock)
+	return portNum, nil
 }
 
 // OnUpdate manages the active set of service proxies.
 // Active service proxies are reinitialized if found in the update set or
-// shutdown if missing from the update set.
+// shutdown if missing from the update set. Each port of a service, the unnamed one and those
+// of its config.PortsLabel, gets a proxy of its own, under the config.ServicePortName.
 func (proxier *Proxier) OnUpdate(services []api.Service) {
 	glog.Infof("Received update notice: %+v", services)
 	activeServices := util.StringSet{}
 	for _, service := range services {
-		activeServices.Insert(service.ID)
-		info, exists := proxier.getServiceInfo(service.ID)
-		// TODO: check health of the socket?  What if ProxyLoop exited?
-		if exists && info.isActive() && info.port == service.Port {
-			continue
-		}
-		if exists && info.port != service.Port {
-			err := proxier.stopProxyInternal(info)
-			if err != nil {
-				glog.Errorf("error stopping %s: %v", info.name, err)
-			}
+		// Services of the same name in different namespaces are proxied separately.
+		key := config.ServiceKey(service)
+		for port, portNum := range config.ServicePorts(service) {
+			activeServices.Insert(config.ServicePortName(key, port))
+			proxier.updatePort(key, port, service.Protocol, portNum)
 		}
-		glog.Infof("Adding a new service %s on %s port %d", service.ID, service.Protocol, service.Port)
-		sock, err := newProxySocket(service.Protocol, proxier.address, service.Port)
-		if err != nil {
-			glog.Errorf("Failed to get a socket for %s: %+v", service.ID, err)
-			continue
-		}
-		proxier.setServiceInfo(service.ID, &serviceInfo{
-			port:     service.Port,
-			protocol: service.Protocol,
-			active:   true,
-			socket:   sock,
-			timeout:  udpIdleTimeout,
-		})
-		proxier.startAccepting(service.ID, sock)
 	}
 	proxier.mu.Lock()
 	defer proxier.mu.Unlock()
@@ -545,3 +641,33 @@ func (proxier *Proxier) OnUpdate(services []api.Service) {
 		}
 	}
 }
+
+// updatePort makes sure the named port of service is proxied on portNum.
+func (proxier *Proxier) updatePort(service, port, protocol string, portNum int) {
+	name := config.ServicePortName(service, port)
+	info, exists := proxier.getServiceInfo(name)
+	// TODO: check health of the socket?  What if ProxyLoop exited?
+	if exists && info.isActive() && info.port == portNum {
+		return
+	}
+	if exists && info.port != portNum {
+		err := proxier.stopProxyInternal(info)
+		if err != nil {
+			glog.Errorf("error stopping %s: %v", info.name, err)
+		}
+	}
+	glog.Infof("Adding a new service %s on %s port %d", name, protocol, portNum)
+	sock, err := newProxySocket(protocol, proxier.address, portNum, proxier.ListenerFactory)
+	if err != nil {
+		glog.Errorf("Failed to get a socket for %s: %+v", name, err)
+		return
+	}
+	proxier.setServiceInfo(name, &serviceInfo{
+		port:     portNum,
+		protocol: protocol,
+		active:   true,
+		socket:   sock,
+		timeout:  udpIdleTimeout,
+	})
+	proxier.startAccepting(service, port, sock)
+}
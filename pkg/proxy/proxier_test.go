@@ -23,11 +23,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
 )
 
 func waitForClosedPortTCP(p *Proxier, proxyPort string) error {
@@ -153,6 +157,58 @@ func TestTCPProxy(t *testing.T) {
 	testEchoTCP(t, "127.0.0.1", proxyPort)
 }
 
+func TestTCPProxyListenerFactory(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	dir, err := ioutil.TempDir("", "proxier")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "echo.sock")
+
+	p := NewProxier(lb, "127.0.0.1")
+	var requested string
+	p.ListenerFactory = func(network, addr string) (net.Listener, error) {
+		requested = network + " " + addr
+		return net.Listen("unix", socket)
+	}
+	port, err := p.AddService("echo", "TCP", 8080)
+	if err != nil {
+		t.Fatalf("error adding new service: %#v", err)
+	}
+	if requested != "tcp 127.0.0.1:8080" {
+		t.Errorf("unexpected listener request %q", requested)
+	}
+	// a listener without a port keeps the one asked for
+	if port != 8080 {
+		t.Errorf("expected port 8080, got %d", port)
+	}
+
+	client := http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	res, err := client.Get("http://echo/aaaaa")
+	if err != nil {
+		t.Fatalf("error connecting to server: %v", err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("error reading data: %v", err)
+	}
+	if string(data) != "aaaaa" {
+		t.Errorf("expected aaaaa, got %s", string(data))
+	}
+}
+
 func TestUDPProxy(t *testing.T) {
 	lb := NewLoadBalancerRR()
 	lb.OnUpdate([]api.Endpoints{
@@ -390,6 +446,118 @@ func TestTCPProxyUpdatePort(t *testing.T) {
 	l.Close()
 }
 
+func TestTCPProxyAcrossNamespaces(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "a/echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+		{
+			JSONBase:  api.JSONBase{ID: "b/echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+
+	p := NewProxier(lb, "127.0.0.1")
+
+	// get two free ports
+	var ports []int
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+		l.Close()
+	}
+	for _, port := range ports {
+		if err := waitForClosedPortTCP(p, strconv.Itoa(port)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// services of the same name in different namespaces get a proxy each
+	p.OnUpdate([]api.Service{
+		{JSONBase: api.JSONBase{ID: "echo"}, Port: ports[0], Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "a"}},
+		{JSONBase: api.JSONBase{ID: "echo"}, Port: ports[1], Protocol: "TCP", Labels: map[string]string{config.NamespaceLabel: "b"}},
+	})
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(ports[0]))
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(ports[1]))
+	expected := []ServiceStats{
+		{ID: "a/echo", Protocol: "TCP", Port: ports[0], Active: true},
+		{ID: "b/echo", Protocol: "TCP", Port: ports[1], Active: true},
+	}
+	if stats := p.Stats(); !reflect.DeepEqual(expected, stats) {
+		t.Errorf("expected %#v, got %#v", expected, stats)
+	}
+	p.OnUpdate([]api.Service{})
+}
+
+func TestTCPProxyNamedPorts(t *testing.T) {
+	metrics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))
+	defer metrics.Close()
+	u, err := url.Parse(metrics.URL)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	lb := NewLoadBalancerRR()
+	lb.OnPortsUpdate(map[string]map[string][]string{"echo": {"metrics": {u.Host}}})
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+
+	p := NewProxier(lb, "127.0.0.1")
+
+	// get two free ports
+	var ports []int
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+		l.Close()
+	}
+
+	// each port of the service is routed to the addresses of that port
+	p.OnUpdate([]api.Service{
+		{JSONBase: api.JSONBase{ID: "echo"}, Port: ports[0], Protocol: "TCP", Labels: map[string]string{config.PortsLabel: "metrics=" + strconv.Itoa(ports[1])}},
+	})
+	testEchoTCP(t, "127.0.0.1", strconv.Itoa(ports[0]))
+	res, err := http.Get("http://127.0.0.1:" + strconv.Itoa(ports[1]) + "/")
+	if err != nil {
+		t.Fatalf("error connecting to server: %v", err)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(data) != "metrics" {
+		t.Errorf("expected metrics, got %q %v", data, err)
+	}
+	expected := []ServiceStats{
+		{ID: "echo", Protocol: "TCP", Port: ports[0], Active: true},
+		{ID: "echo:metrics", Protocol: "TCP", Port: ports[1], Active: true},
+	}
+	if stats := p.Stats(); !reflect.DeepEqual(expected, stats) {
+		t.Errorf("expected %#v, got %#v", expected, stats)
+	}
+
+	// a port dropped from the service is no longer proxied
+	p.OnUpdate([]api.Service{
+		{JSONBase: api.JSONBase{ID: "echo"}, Port: ports[0], Protocol: "TCP"},
+	})
+	if err := waitForClosedPortTCP(p, strconv.Itoa(ports[1])); err != nil {
+		t.Fatal(err)
+	}
+	p.OnUpdate([]api.Service{})
+}
+
 func TestUDPProxyUpdatePort(t *testing.T) {
 	lb := NewLoadBalancerRR()
 	lb.OnUpdate([]api.Endpoints{
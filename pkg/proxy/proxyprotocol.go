@@ -0,0 +1,97 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// writeProxyProtocolV1 writes a text PROXY protocol header describing a TCP
+// connection from src to dst. Connections that are not TCP are described as UNKNOWN.
+func writeProxyProtocolV1(w io.Writer, src, dst net.Addr) error {
+	srcTCP, srcOk := src.(*net.TCPAddr)
+	dstTCP, dstOk := dst.(*net.TCPAddr)
+	if !srcOk || !dstOk {
+		_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+		return err
+	}
+	family := "TCP6"
+	if srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil {
+		family = "TCP4"
+	}
+	_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", family, srcTCP.IP, dstTCP.IP, srcTCP.Port, dstTCP.Port)
+	return err
+}
+
+// writeProxyProtocolV2 writes a binary PROXY protocol header describing a TCP
+// connection from src to dst. Connections that are not TCP are sent as LOCAL.
+func writeProxyProtocolV2(w io.Writer, src, dst net.Addr) error {
+	var header bytes.Buffer
+	header.Write(proxyProtocolV2Signature)
+
+	srcTCP, srcOk := src.(*net.TCPAddr)
+	dstTCP, dstOk := dst.(*net.TCPAddr)
+	if !srcOk || !dstOk {
+		// version 2, LOCAL command, unspecified family and no addresses
+		header.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		_, err := w.Write(header.Bytes())
+		return err
+	}
+
+	var addresses []byte
+	// version 2 and PROXY command
+	header.WriteByte(0x21)
+	if srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4(); srcIP != nil && dstIP != nil {
+		// TCP over IPv4
+		header.WriteByte(0x11)
+		addresses = append(addresses, srcIP...)
+		addresses = append(addresses, dstIP...)
+	} else {
+		// TCP over IPv6
+		header.WriteByte(0x21)
+		addresses = append(addresses, srcTCP.IP.To16()...)
+		addresses = append(addresses, dstTCP.IP.To16()...)
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:], uint16(srcTCP.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dstTCP.Port))
+	addresses = append(addresses, ports...)
+
+	binary.Write(&header, binary.BigEndian, uint16(len(addresses)))
+	header.Write(addresses)
+	_, err := w.Write(header.Bytes())
+	return err
+}
+
+// writeProxyHeader sends the PROXY protocol header configured on the proxier, if any,
+// describing the client connection in to the backend connection out.
+func (proxier *Proxier) writeProxyHeader(out io.Writer, in net.Conn) error {
+	switch {
+	case proxier.ProxyProtocolV2:
+		return writeProxyProtocolV2(out, in.RemoteAddr(), in.LocalAddr())
+	case proxier.ProxyProtocol:
+		return writeProxyProtocolV1(out, in.RemoteAddr(), in.LocalAddr())
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestProxyProtocolV1(t *testing.T) {
+	testCases := []struct {
+		src, dst net.Addr
+		expected string
+	}{
+		{
+			src:      &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000},
+			dst:      &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80},
+			expected: "PROXY TCP4 10.0.0.1 10.0.0.2 5000 80\r\n",
+		},
+		{
+			src:      &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 5000},
+			dst:      &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 80},
+			expected: "PROXY TCP6 fd00::1 fd00::2 5000 80\r\n",
+		},
+		{
+			src:      &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000},
+			dst:      &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53},
+			expected: "PROXY UNKNOWN\r\n",
+		},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		if err := writeProxyProtocolV1(&buf, tc.src, tc.dst); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, buf.String())
+		}
+	}
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	var buf bytes.Buffer
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80}
+	if err := writeProxyProtocolV2(&buf, src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := append([]byte{}, proxyProtocolV2Signature...)
+	expected = append(expected,
+		0x21, 0x11, 0x00, 0x0c,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+		0x13, 0x88,
+		0x00, 0x50)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("expected %v, got %v", expected, buf.Bytes())
+	}
+
+	buf.Reset()
+	src = &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 5000}
+	dst = &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 80}
+	if err := writeProxyProtocolV2(&buf, src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header := buf.Bytes()[len(proxyProtocolV2Signature):]
+	if header[0] != 0x21 || header[1] != 0x21 || header[2] != 0x00 || header[3] != 36 || len(header) != 40 {
+		t.Errorf("unexpected IPv6 header %v", header)
+	}
+}
+
+func TestTCPProxyProtocol(t *testing.T) {
+	// The backend echoes back the first line it receives, which should be the PROXY header.
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(line))
+	}()
+
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{backend.Addr().String()},
+		},
+	})
+	p := NewProxier(lb, "127.0.0.1")
+	p.ProxyProtocol = true
+
+	proxyPort, err := p.addServiceOnUnusedPort("echo", "TCP", 0)
+	if err != nil {
+		t.Fatalf("error adding new service: %#v", err)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", proxyPort))
+	if err != nil {
+		t.Fatalf("error connecting to proxy: %v", err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("error reading from proxy: %v", err)
+	}
+	local := conn.LocalAddr().(*net.TCPAddr)
+	expected := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %s\r\n", local.Port, proxyPort)
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netns"
+)
+
+// ErrTimeout can be listed in RetryPolicy.RetryOn to retry connections that time out.
+var ErrTimeout = errors.New("timeout")
+
+// ErrNoEndpointsLeft is returned by a retried dial when the load balancer has no endpoint left
+// that was not tried yet.
+var ErrNoEndpointsLeft = errors.New("no untried endpoints left")
+
+// RetryPolicy controls how a failed backend connection is retried. Each attempt goes to an
+// endpoint that has not been tried yet for the same client connection, and all attempts
+// together are bounded by the deadline of the client connection, or by endpointDialTimeout if
+// it has none, the same as a single dial.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of endpoints tried.
+	MaxAttempts int
+	// PerAttemptTimeout bounds each dial. Zero means each dial may use the rest of the deadline.
+	PerAttemptTimeout time.Duration
+	// RetryOn lists the errors that are retried, such as syscall.ECONNREFUSED or ErrTimeout.
+	// If empty, every error is retried.
+	RetryOn []error
+}
+
+// retryable reports whether err is one of the errors listed in RetryOn.
+func (policy *RetryPolicy) retryable(err error) bool {
+	if len(policy.RetryOn) == 0 {
+		return true
+	}
+	timeout := false
+	if e, ok := err.(net.Error); ok {
+		timeout = e.Timeout()
+	}
+	cause := err
+	if e, ok := cause.(*net.OpError); ok {
+		cause = e.Err
+	}
+	if e, ok := cause.(*os.SyscallError); ok {
+		cause = e.Err
+	}
+	for _, retryOn := range policy.RetryOn {
+		if retryOn == err || retryOn == cause || (retryOn == ErrTimeout && timeout) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialWithRetry connects to an endpoint of the port of service, moving on to another endpoint each time
+// a connection fails with an error the policy retries. The attempts stop when ctx is done, and
+// at its deadline, or endpointDialTimeout from now if it has none.
+func (proxier *Proxier) dialWithRetry(ctx context.Context, service, port string, srcAddr net.Addr, policy *RetryPolicy) (net.Conn, string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(endpointDialTimeout)
+	}
+	tried := util.StringSet{}
+	var lastErr error
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		ns, endpoint, err := proxier.nextUntriedEndpoint(service, port, srcAddr, tried)
+		if err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+		tried.Insert(endpoint)
+
+		timeout := deadline.Sub(time.Now())
+		if err := ctx.Err(); err != nil || timeout <= 0 {
+			if err == nil {
+				err = context.DeadlineExceeded
+			}
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+		if policy.PerAttemptTimeout > 0 && policy.PerAttemptTimeout < timeout {
+			timeout = policy.PerAttemptTimeout
+		}
+		glog.Infof("Mapped service %s to endpoint %s, attempt %d", service, endpoint, attempt+1)
+		outConn, err := dialTimeout(ns, "tcp", endpoint, timeout)
+		if err == nil {
+			proxier.reportSuccess(service, endpoint)
+			return outConn, endpoint, nil
+		}
+		glog.Errorf("Dial to %s failed: %v", endpoint, err)
+		proxier.reportFailure(service, endpoint)
+		lastErr = err
+		if !policy.retryable(err) {
+			break
+		}
+	}
+	return nil, "", lastErr
+}
+
+// nextUntriedEndpoint asks the load balancer for endpoints until it returns one not in tried.
+func (proxier *Proxier) nextUntriedEndpoint(service, port string, srcAddr net.Addr, tried util.StringSet) (netns.NsHandle, string, error) {
+	// A load balancer that cycles through the endpoints returns a new one within len(tried)+1 calls.
+	for i := 0; i <= len(tried); i++ {
+		ns, endpoint, err := proxier.loadBalancer.NextEndpoint(service, port, srcAddr)
+		if err != nil {
+			return ns, "", err
+		}
+		if !tried.Has(endpoint) {
+			return ns, endpoint, nil
+		}
+	}
+	return netns.None(), "", ErrNoEndpointsLeft
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// closedPort returns an address nothing is listening on.
+func closedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+func TestTCPProxyRetry(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{closedPort(t), net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	p := NewProxier(lb, "127.0.0.1")
+	p.RetryPolicy = &RetryPolicy{
+		MaxAttempts:       2,
+		PerAttemptTimeout: time.Second,
+		RetryOn:           []error{syscall.ECONNREFUSED, ErrTimeout},
+	}
+
+	proxyPort, err := p.addServiceOnUnusedPort("echo", "TCP", 0)
+	if err != nil {
+		t.Fatalf("error adding new service: %#v", err)
+	}
+	// the first connection is refused by the closed endpoint and retried on the other
+	testEchoTCP(t, "127.0.0.1", proxyPort)
+}
+
+func TestTCPProxyRetryNotRetryable(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{closedPort(t), net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	p := NewProxier(lb, "127.0.0.1")
+	p.RetryPolicy = &RetryPolicy{MaxAttempts: 2, RetryOn: []error{ErrTimeout}}
+
+	proxyPort, err := p.addServiceOnUnusedPort("echo", "TCP", 0)
+	if err != nil {
+		t.Fatalf("error adding new service: %#v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if resp, err := client.Get("http://127.0.0.1:" + proxyPort + "/aaaaa"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected refused connection not to be retried")
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	dead := closedPort(t)
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{dead},
+		},
+	})
+	p := NewProxier(lb, "127.0.0.1")
+	// the only endpoint is tried once, however many attempts are allowed
+	_, _, err := p.dialWithRetry(context.Background(), "echo", "", nil, &RetryPolicy{MaxAttempts: 3})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !(&RetryPolicy{RetryOn: []error{syscall.ECONNREFUSED}}).retryable(err) {
+		t.Errorf("expected the refused dial to be returned, got %v", err)
+	}
+}
+
+func TestRetryPolicyDeadline(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	p := NewProxier(lb, "127.0.0.1")
+	// the deadline of the caller bounds the attempts, even with a live endpoint
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, _, err := p.dialWithRetry(ctx, "echo", "", nil, &RetryPolicy{MaxAttempts: 3}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	conn, _, err := p.dialWithRetry(context.Background(), "echo", "", nil, &RetryPolicy{MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+}
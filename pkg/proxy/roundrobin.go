@@ -26,6 +26,7 @@ import (
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	"github.com/golang/glog"
 	"github.com/vishvananda/netns"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
 )
 
 var (
@@ -38,6 +39,9 @@ type LoadBalancerRR struct {
 	lock         sync.RWMutex
 	endpointsMap map[string][]string
 	rrIndex      map[string]int
+	// ports are the named ports of the endpoints, as set by OnPortsUpdate. Their addresses are
+	// kept in endpointsMap under the config.ServicePortName.
+	ports map[string]map[string][]string
 }
 
 // NewLoadBalancerRR returns a new LoadBalancerRR.
@@ -50,8 +54,9 @@ func NewLoadBalancerRR() *LoadBalancerRR {
 
 // NextEndpoint returns a service endpoint.
 // The service endpoint is chosen using the round-robin algorithm.
-func (lb *LoadBalancerRR) NextEndpoint(service string, srcAddr net.Addr) (netns.NsHandle, string, error) {
+func (lb *LoadBalancerRR) NextEndpoint(service, port string, srcAddr net.Addr) (netns.NsHandle, string, error) {
 	ns := netns.None()
+	service = config.ServicePortName(service, port)
 	lb.lock.RLock()
 	endpoints, exists := lb.endpointsMap[service]
 	index := lb.rrIndex[service]
@@ -91,6 +96,13 @@ func filterValidEndpoints(endpoints []string) []string {
 	return result
 }
 
+// OnPortsUpdate sets the named ports of the endpoints of the next OnUpdate.
+func (lb *LoadBalancerRR) OnPortsUpdate(ports map[string]map[string][]string) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.ports = ports
+}
+
 // OnUpdate manages the registered service endpoints.
 // Registered endpoints are updated if found in the update set or
 // unregistered if missing from the update set.
@@ -98,6 +110,7 @@ func (lb *LoadBalancerRR) OnUpdate(endpoints []api.Endpoints) {
 	registeredEndpoints := make(map[string]bool)
 	lb.lock.Lock()
 	defer lb.lock.Unlock()
+	endpoints = config.WithNamedPorts(endpoints, lb.ports)
 	// Update endpoints for services.
 	for _, endpoint := range endpoints {
 		existingEndpoints, exists := lb.endpointsMap[endpoint.ID]
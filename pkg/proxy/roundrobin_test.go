@@ -59,7 +59,7 @@ func TestLoadBalanceFailsWithNoEndpoints(t *testing.T) {
 	loadBalancer := NewLoadBalancerRR()
 	var endpoints []api.Endpoints
 	loadBalancer.OnUpdate(endpoints)
-	_, endpoint, err := loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err := loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -69,7 +69,7 @@ func TestLoadBalanceFailsWithNoEndpoints(t *testing.T) {
 }
 
 func expectEndpoint(t *testing.T, loadBalancer *LoadBalancerRR, service string, expected string) {
-	_, endpoint, err := loadBalancer.NextEndpoint(service, nil)
+	_, endpoint, err := loadBalancer.NextEndpoint(service, "", nil)
 	if err != nil {
 		t.Errorf("Didn't find a service for %s, expected %s, failed with: %v", service, expected, err)
 	}
@@ -80,7 +80,7 @@ func expectEndpoint(t *testing.T, loadBalancer *LoadBalancerRR, service string,
 
 func TestLoadBalanceWorksWithSingleEndpoint(t *testing.T) {
 	loadBalancer := NewLoadBalancerRR()
-	_, endpoint, err := loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err := loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil || len(endpoint) != 0 {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -98,7 +98,7 @@ func TestLoadBalanceWorksWithSingleEndpoint(t *testing.T) {
 
 func TestLoadBalanceWorksWithMultipleEndpoints(t *testing.T) {
 	loadBalancer := NewLoadBalancerRR()
-	_, endpoint, err := loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err := loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil || len(endpoint) != 0 {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -116,7 +116,7 @@ func TestLoadBalanceWorksWithMultipleEndpoints(t *testing.T) {
 
 func TestLoadBalanceWorksWithMultipleEndpointsAndUpdates(t *testing.T) {
 	loadBalancer := NewLoadBalancerRR()
-	_, endpoint, err := loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err := loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil || len(endpoint) != 0 {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -145,7 +145,7 @@ func TestLoadBalanceWorksWithMultipleEndpointsAndUpdates(t *testing.T) {
 	endpoints[0] = api.Endpoints{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{}}
 	loadBalancer.OnUpdate(endpoints)
 
-	_, endpoint, err = loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err = loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil || len(endpoint) != 0 {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -153,7 +153,7 @@ func TestLoadBalanceWorksWithMultipleEndpointsAndUpdates(t *testing.T) {
 
 func TestLoadBalanceWorksWithServiceRemoval(t *testing.T) {
 	loadBalancer := NewLoadBalancerRR()
-	_, endpoint, err := loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err := loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil || len(endpoint) != 0 {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -181,7 +181,7 @@ func TestLoadBalanceWorksWithServiceRemoval(t *testing.T) {
 
 	// Then update the configuration by removing foo
 	loadBalancer.OnUpdate(endpoints[1:])
-	_, endpoint, err = loadBalancer.NextEndpoint("foo", nil)
+	_, endpoint, err = loadBalancer.NextEndpoint("foo", "", nil)
 	if err == nil || len(endpoint) != 0 {
 		t.Errorf("Didn't fail with non-existent service")
 	}
@@ -192,3 +192,22 @@ func TestLoadBalanceWorksWithServiceRemoval(t *testing.T) {
 	expectEndpoint(t, loadBalancer, "bar", "endpoint:5")
 	expectEndpoint(t, loadBalancer, "bar", "endpoint:4")
 }
+
+func TestLoadBalanceNamedPorts(t *testing.T) {
+	loadBalancer := NewLoadBalancerRR()
+	loadBalancer.OnPortsUpdate(map[string]map[string][]string{"foo": {"metrics": {"endpoint:9090"}}})
+	loadBalancer.OnUpdate([]api.Endpoints{
+		{JSONBase: api.JSONBase{ID: "foo"}, Endpoints: []string{"endpoint:80", "endpoint:9090"}},
+	})
+	for i := 0; i < 2; i++ {
+		if _, endpoint, _ := loadBalancer.NextEndpoint("foo", "metrics", nil); endpoint != "endpoint:9090" {
+			t.Errorf("expected endpoint:9090, got %s", endpoint)
+		}
+	}
+	if _, endpoint, _ := loadBalancer.NextEndpoint("foo", "", nil); endpoint != "endpoint:80" {
+		t.Errorf("expected endpoint:80, got %s", endpoint)
+	}
+	if _, _, err := loadBalancer.NextEndpoint("foo", "http", nil); err != ErrMissingServiceEntry {
+		t.Errorf("expected %v, got %v", ErrMissingServiceEntry, err)
+	}
+}
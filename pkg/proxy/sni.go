@@ -0,0 +1,302 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+const (
+	tlsRecordHeaderLen     = 5
+	tlsMaxRecordLen        = 16384 + 2048
+	tlsRecordTypeHandshake = 0x16
+	tlsClientHello         = 0x01
+	tlsExtensionServerName = 0x0000
+	tlsServerNameHostName  = 0x00
+)
+
+var ErrNotClientHello = errors.New("not a TLS ClientHello")
+
+// SNIRouter accepts TLS connections on a single listener and passes each one, still
+// encrypted, to an endpoint of the service named by the server_name in its ClientHello.
+// The service table is kept current by registering the router as a service config handler.
+type SNIRouter struct {
+	loadBalancer LoadBalancer
+
+	lock     sync.RWMutex
+	services util.StringSet
+	// defaultService receives connections whose server name matches no service.
+	// If empty, such connections are closed.
+	defaultService string
+	// port names the port of the services whose addresses connections are passed to.
+	// If empty, it is the unnamed port.
+	port string
+}
+
+// NewSNIRouter creates an SNIRouter which picks endpoints with loadBalancer.
+func NewSNIRouter(loadBalancer LoadBalancer, defaultService string) *SNIRouter {
+	return &SNIRouter{
+		loadBalancer:   loadBalancer,
+		services:       util.StringSet{},
+		defaultService: defaultService,
+	}
+}
+
+// OnUpdate replaces the set of services connections can be routed to.
+func (r *SNIRouter) OnUpdate(services []api.Service) {
+	active := util.StringSet{}
+	for _, service := range services {
+		active.Insert(config.ServiceKey(service))
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.services = active
+}
+
+// SetDefaultService replaces the service receiving connections whose server name matches no
+// service. If it is empty, such connections are closed.
+func (r *SNIRouter) SetDefaultService(service string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.defaultService = service
+}
+
+// SetPort replaces the name of the port of the services whose addresses connections are passed
+// to, such as "https". If it is empty, the unnamed port of the services is used.
+func (r *SNIRouter) SetPort(port string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.port = port
+}
+
+// servicePort returns the name of the port connections are passed to.
+func (r *SNIRouter) servicePort() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.port
+}
+
+// route returns the service for a server name. A service matches if its ID is the full
+// server name or its first label, so "mysql" serves both "mysql" and "mysql.example.com".
+// A service with a namespace matches its name and namespace as the first two labels, so
+// "mysql.prod.example.com" is served by "mysql" in namespace "prod".
+func (r *SNIRouter) route(serverName string) (string, bool) {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.services.Has(serverName) {
+		return serverName, true
+	}
+	labels := strings.SplitN(serverName, ".", 3)
+	if len(labels) >= 2 {
+		if key := config.NamespacedName(labels[1], labels[0]); r.services.Has(key) {
+			return key, true
+		}
+	}
+	if i := strings.Index(serverName, "."); i > 0 && r.services.Has(serverName[:i]) {
+		return serverName[:i], true
+	}
+	if r.defaultService != "" {
+		return r.defaultService, true
+	}
+	return "", false
+}
+
+// Serve accepts connections on listener until it is closed.
+func (r *SNIRouter) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				glog.Infof("Accept had a temporary failure: %v", err)
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer util.HandleCrash()
+			r.handle(conn)
+		}()
+	}
+}
+
+func (r *SNIRouter) handle(inConn net.Conn) {
+	reader := bufio.NewReaderSize(inConn, tlsRecordHeaderLen+tlsMaxRecordLen)
+	serverName, err := peekServerName(reader)
+	if err != nil {
+		glog.Errorf("Failed to read server name from %v: %v", inConn.RemoteAddr(), err)
+		inConn.Close()
+		return
+	}
+	service, found := r.route(serverName)
+	if !found {
+		glog.Errorf("No service for server name %q from %v", serverName, inConn.RemoteAddr())
+		inConn.Close()
+		return
+	}
+	ns, endpoint, err := r.loadBalancer.NextEndpoint(service, r.servicePort(), inConn.RemoteAddr())
+	if err != nil {
+		glog.Errorf("Couldn't find an endpoint for %s %v", service, err)
+		inConn.Close()
+		return
+	}
+	glog.Infof("Mapped server name %q to service %s endpoint %s", serverName, service, endpoint)
+	outConn, err := dialEndpoint(ns, "tcp", endpoint)
+	if err != nil {
+		glog.Errorf("Dial failed: %v", err)
+		inConn.Close()
+		return
+	}
+	// The peeked ClientHello is still buffered in reader, so it is forwarded along with the rest.
+	done := make(chan struct{})
+	go func() {
+		copyStream(outConn, reader, inConn)
+		close(done)
+	}()
+	copyStream(inConn, outConn, outConn)
+	<-done
+	inConn.Close()
+	outConn.Close()
+}
+
+// copyStream copies from in to out, then closes the write half of out and the read half of src.
+func copyStream(out net.Conn, in io.Reader, src net.Conn) {
+	if _, err := io.Copy(out, in); err != nil {
+		glog.Errorf("I/O error: %v", err)
+	}
+	if tcp, ok := src.(*net.TCPConn); ok {
+		tcp.CloseRead()
+	}
+	if tcp, ok := out.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		out.Close()
+	}
+}
+
+// peekServerName returns the server_name from the TLS ClientHello at the start of reader,
+// without consuming it. An empty name is returned if the client did not send one. The
+// ClientHello must fit in a single TLS record.
+func peekServerName(reader *bufio.Reader) (string, error) {
+	header, err := reader.Peek(tlsRecordHeaderLen)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != tlsRecordTypeHandshake {
+		return "", ErrNotClientHello
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if length > tlsMaxRecordLen {
+		return "", ErrNotClientHello
+	}
+	record, err := reader.Peek(tlsRecordHeaderLen + length)
+	if err != nil {
+		return "", err
+	}
+	return parseClientHelloServerName(record[tlsRecordHeaderLen:])
+}
+
+// parseClientHelloServerName extracts the server_name extension from a handshake message.
+func parseClientHelloServerName(data []byte) (string, error) {
+	if len(data) < 4 || data[0] != tlsClientHello {
+		return "", ErrNotClientHello
+	}
+	length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if len(data) < 4+length {
+		return "", ErrNotClientHello
+	}
+	hello := data[4 : 4+length]
+
+	// client_version (2) and random (32)
+	if len(hello) < 34 {
+		return "", ErrNotClientHello
+	}
+	hello = hello[34:]
+	// session_id, cipher_suites and compression_methods are skipped.
+	var ok bool
+	if hello, ok = skipVector(hello, 1); !ok {
+		return "", ErrNotClientHello
+	}
+	if hello, ok = skipVector(hello, 2); !ok {
+		return "", ErrNotClientHello
+	}
+	if hello, ok = skipVector(hello, 1); !ok {
+		return "", ErrNotClientHello
+	}
+	if len(hello) == 0 {
+		// no extensions
+		return "", nil
+	}
+	if len(hello) < 2 {
+		return "", ErrNotClientHello
+	}
+	extensions := hello[2:]
+	if int(binary.BigEndian.Uint16(hello)) > len(extensions) {
+		return "", ErrNotClientHello
+	}
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions)
+		extLen := int(binary.BigEndian.Uint16(extensions[2:]))
+		if len(extensions) < 4+extLen {
+			return "", ErrNotClientHello
+		}
+		ext := extensions[4 : 4+extLen]
+		extensions = extensions[4+extLen:]
+		if extType != tlsExtensionServerName || len(ext) < 2 {
+			continue
+		}
+		names := ext[2:]
+		for len(names) >= 3 {
+			nameType := names[0]
+			nameLen := int(binary.BigEndian.Uint16(names[1:]))
+			if len(names) < 3+nameLen {
+				return "", ErrNotClientHello
+			}
+			if nameType == tlsServerNameHostName {
+				return string(names[3 : 3+nameLen]), nil
+			}
+			names = names[3+nameLen:]
+		}
+	}
+	return "", nil
+}
+
+// skipVector skips a TLS vector whose length is encoded in lenBytes bytes.
+func skipVector(data []byte, lenBytes int) ([]byte, bool) {
+	if len(data) < lenBytes {
+		return nil, false
+	}
+	length := 0
+	for _, b := range data[:lenBytes] {
+		length = length<<8 | int(b)
+	}
+	if len(data) < lenBytes+length {
+		return nil, false
+	}
+	return data[lenBytes+length:], true
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+func TestPeekServerName(t *testing.T) {
+	for _, serverName := range []string{"foo.example.com", ""} {
+		client, server := net.Pipe()
+		go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+
+		reader := bufio.NewReaderSize(server, tlsRecordHeaderLen+tlsMaxRecordLen)
+		name, err := peekServerName(reader)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != serverName {
+			t.Errorf("expected %q, got %q", serverName, name)
+		}
+		// peeking should leave the record in the reader
+		if b, err := reader.ReadByte(); err != nil || b != tlsRecordTypeHandshake {
+			t.Errorf("expected handshake record to remain buffered, got %v %v", b, err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestPeekServerNameNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	go client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	defer client.Close()
+	if _, err := peekServerName(bufio.NewReader(server)); err != ErrNotClientHello {
+		t.Errorf("expected %v, got %v", ErrNotClientHello, err)
+	}
+}
+
+func TestSNIRouteNamespaces(t *testing.T) {
+	router := NewSNIRouter(NewLoadBalancerRR(), "")
+	router.OnUpdate([]api.Service{
+		{JSONBase: api.JSONBase{ID: "mysql"}, Labels: map[string]string{config.NamespaceLabel: "prod"}},
+		{JSONBase: api.JSONBase{ID: "mysql"}, Labels: map[string]string{config.NamespaceLabel: "dev"}},
+	})
+	for serverName, expected := range map[string]string{
+		"mysql.prod.example.com": "prod/mysql",
+		"mysql.dev":              "dev/mysql",
+		"mysql.example.com":      "",
+	} {
+		if service, _ := router.route(serverName); service != expected {
+			t.Errorf("expected %q for %s, got %q", expected, serverName, service)
+		}
+	}
+}
+
+func TestSNIRouter(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foo"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foo-secure"))
+	}))
+	defer secure.Close()
+	secureURL, err := url.Parse(secure.URL)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	lb := NewLoadBalancerRR()
+	lb.OnPortsUpdate(map[string]map[string][]string{"foo": {"secure": {secureURL.Host}}})
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "foo"},
+			Endpoints: []string{u.Host},
+		},
+	})
+	router := NewSNIRouter(lb, "")
+	router.OnUpdate([]api.Service{{JSONBase: api.JSONBase{ID: "foo"}}})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+	go router.Serve(listener)
+
+	get := func(serverName string) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	body, err := get("foo.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body != "foo" {
+		t.Errorf("expected foo, got %q", body)
+	}
+
+	// without a default service unknown names are rejected
+	if _, err := get("bar.example.com"); err == nil {
+		t.Errorf("expected unknown server name to be rejected")
+	}
+
+	router.SetDefaultService("foo")
+	body, err = get("bar.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body != "foo" {
+		t.Errorf("expected foo, got %q", body)
+	}
+
+	// connections go to the addresses of the port the router is set to
+	router.SetPort("secure")
+	body, err = get("foo.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body != "foo-secure" {
+		t.Errorf("expected foo-secure, got %q", body)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+const (
+	// from linux/netfilter_ipv4.h
+	soOriginalDst = 80
+	// from asm-generic/socket.h, missing from syscall
+	soReusePort = 0xf
+)
+
+// TProxyListener is a TCP listener for traffic redirected to the proxy by iptables without
+// rewriting its destination. Its socket is IP_TRANSPARENT, so with a TPROXY rule it accepts
+// connections to any address, and SO_REUSEPORT, so several proxies can share its port.
+type TProxyListener struct {
+	net.Listener
+}
+
+// ListenTProxy creates a TProxyListener on the IPv4 address. Setting IP_TRANSPARENT needs
+// CAP_NET_ADMIN.
+func ListenTProxy(address string) (*TProxyListener, error) {
+	addr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	file := os.NewFile(uintptr(fd), "tproxy:"+address)
+	defer file.Close()
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_TRANSPARENT, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt IP_TRANSPARENT", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt SO_REUSEADDR", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt SO_REUSEPORT", err)
+	}
+	sa := &syscall.SockaddrInet4{Port: addr.Port}
+	if ip := addr.IP.To4(); ip != nil {
+		copy(sa.Addr[:], ip)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	// FileListener dups the socket, so file is closed either way.
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, err
+	}
+	return &TProxyListener{listener}, nil
+}
+
+// OriginalDst returns the address a redirected connection was sent to. A connection NATed by
+// a REDIRECT rule has its destination in SO_ORIGINAL_DST. One captured by a TPROXY rule keeps
+// it as its local address, as does a connection that was not redirected at all, which
+// conntrack answers with ENOENT. Any other error getting SO_ORIGINAL_DST is returned.
+func OriginalDst(conn net.Conn) (*net.TCPAddr, error) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection: %v", conn.LocalAddr())
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var sa syscall.RawSockaddrInet4
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofSockaddrInet4)
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_IP, soOriginalDst,
+			uintptr(unsafe.Pointer(&sa)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			sockErr = errno
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr == syscall.ENOENT {
+		// conntrack has no NAT for the connection
+		if local, ok := tcp.LocalAddr().(*net.TCPAddr); ok {
+			return local, nil
+		}
+	}
+	if sockErr != nil {
+		return nil, os.NewSyscallError("getsockopt SO_ORIGINAL_DST", sockErr)
+	}
+	port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&sa.Port))[:])
+	return &net.TCPAddr{IP: net.IP(sa.Addr[:]).To16(), Port: int(port)}, nil
+}
+
+// TProxyRouter accepts connections on a TProxyListener and passes each one to an endpoint of
+// the service its original destination belongs to. A service matches on any of its
+// config.ServicePorts and, if it has one, the address in its config.ClusterIPLabel, and the
+// connection goes to the addresses of the port it matched on. The service table is kept
+// current by registering the router as a service config handler.
+type TProxyRouter struct {
+	loadBalancer LoadBalancer
+
+	lock     sync.RWMutex
+	services map[string]servicePort // "ip:port" or ":port" -> service port
+}
+
+// servicePort is a port of a service, by name.
+type servicePort struct {
+	service string
+	port    string
+}
+
+// NewTProxyRouter creates a TProxyRouter which picks endpoints with loadBalancer.
+func NewTProxyRouter(loadBalancer LoadBalancer) *TProxyRouter {
+	return &TProxyRouter{
+		loadBalancer: loadBalancer,
+		services:     map[string]servicePort{},
+	}
+}
+
+// OnUpdate replaces the set of services connections can be routed to.
+func (r *TProxyRouter) OnUpdate(services []api.Service) {
+	active := map[string]servicePort{}
+	for _, service := range services {
+		for port, portNum := range config.ServicePorts(service) {
+			active[net.JoinHostPort(service.Labels[config.ClusterIPLabel], strconv.Itoa(portNum))] = servicePort{config.ServiceKey(service), port}
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.services = active
+}
+
+// route returns the service and port name for an original destination, preferring a service
+// with its address over one that only has its port.
+func (r *TProxyRouter) route(dst *net.TCPAddr) (servicePort, bool) {
+	port := strconv.Itoa(dst.Port)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if service, ok := r.services[net.JoinHostPort(dst.IP.String(), port)]; ok {
+		return service, true
+	}
+	service, ok := r.services[net.JoinHostPort("", port)]
+	return service, ok
+}
+
+// Serve accepts connections on listener until it is closed.
+func (r *TProxyRouter) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				glog.Infof("Accept had a temporary failure: %v", err)
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer util.HandleCrash()
+			r.handle(conn)
+		}()
+	}
+}
+
+func (r *TProxyRouter) handle(inConn net.Conn) {
+	dst, err := OriginalDst(inConn)
+	if err != nil {
+		glog.Errorf("Failed to get original destination from %v: %v", inConn.RemoteAddr(), err)
+		inConn.Close()
+		return
+	}
+	route, found := r.route(dst)
+	if !found {
+		glog.Errorf("No service for destination %v from %v", dst, inConn.RemoteAddr())
+		inConn.Close()
+		return
+	}
+	service := config.ServicePortName(route.service, route.port)
+	ns, endpoint, err := r.loadBalancer.NextEndpoint(route.service, route.port, inConn.RemoteAddr())
+	if err != nil {
+		glog.Errorf("Couldn't find an endpoint for %s %v", service, err)
+		inConn.Close()
+		return
+	}
+	glog.Infof("Mapped destination %v to service %s endpoint %s", dst, service, endpoint)
+	outConn, err := dialEndpoint(ns, "tcp", endpoint)
+	if err != nil {
+		glog.Errorf("Dial failed: %v", err)
+		inConn.Close()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		copyStream(outConn, inConn, inConn)
+		close(done)
+	}()
+	copyStream(inConn, outConn, outConn)
+	<-done
+	inConn.Close()
+	outConn.Close()
+}
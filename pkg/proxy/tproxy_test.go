@@ -0,0 +1,110 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+func TestTProxyRoute(t *testing.T) {
+	router := NewTProxyRouter(NewLoadBalancerRR())
+	router.OnUpdate([]api.Service{
+		{JSONBase: api.JSONBase{ID: "foo"}, Port: 80, Labels: map[string]string{config.ClusterIPLabel: "10.0.0.1", config.PortsLabel: "metrics=9090"}},
+		{JSONBase: api.JSONBase{ID: "bar"}, Port: 80},
+	})
+	for _, c := range []struct {
+		dst     *net.TCPAddr
+		service servicePort
+		found   bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}, servicePort{"foo", ""}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9090}, servicePort{"foo", "metrics"}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80}, servicePort{"bar", ""}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 81}, servicePort{}, false},
+	} {
+		service, found := router.route(c.dst)
+		if service != c.service || found != c.found {
+			t.Errorf("expected %+v %v for %v, got %+v %v", c.service, c.found, c.dst, service, found)
+		}
+	}
+}
+
+func TestOriginalDstNotRedirected(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	defer conn.Close()
+
+	dst, err := OriginalDst(conn)
+	if errors.Is(err, syscall.ENOPROTOOPT) {
+		t.Skip("SO_ORIGINAL_DST needs conntrack")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.String() != listener.Addr().String() {
+		t.Errorf("expected %v, got %v", listener.Addr(), dst)
+	}
+}
+
+func TestTProxyRouter(t *testing.T) {
+	listener, err := ListenTProxy("127.0.0.1:0")
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("IP_TRANSPARENT needs CAP_NET_ADMIN")
+		}
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	portNum, _ := strconv.Atoi(port)
+
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", tcpServerPort)},
+		},
+	})
+	router := NewTProxyRouter(lb)
+	// Without a redirect the original destination is the listener itself.
+	router.OnUpdate([]api.Service{{JSONBase: api.JSONBase{ID: "echo"}, Port: portNum}})
+	go router.Serve(listener)
+
+	testEchoTCP(t, "127.0.0.1", port)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+)
+
+// UDPProxy forwards datagrams arriving on a UDP socket to the endpoints of a single service.
+// Each client address is bound to one endpoint, chosen by the load balancer on its first
+// datagram, and replies from that endpoint are sent back to the client. Register the proxy
+// as an endpoints config handler so that clients of removed endpoints are flushed.
+type UDPProxy struct {
+	conn         *net.UDPConn
+	service      string
+	loadBalancer LoadBalancer
+	// IdleTimeout is how long a client is kept bound to its endpoint without traffic.
+	IdleTimeout time.Duration
+
+	clients *clientCache
+}
+
+// NewUDPProxy creates a UDPProxy which serves service on conn.
+func NewUDPProxy(loadBalancer LoadBalancer, service string, conn *net.UDPConn) *UDPProxy {
+	return &UDPProxy{
+		conn:         conn,
+		service:      service,
+		loadBalancer: loadBalancer,
+		IdleTimeout:  udpIdleTimeout,
+		clients:      newClientCache(),
+	}
+}
+
+// Addr returns the address the proxy is receiving on.
+func (p *UDPProxy) Addr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+// Close stops the proxy and drops all clients.
+func (p *UDPProxy) Close() error {
+	err := p.conn.Close()
+	p.clients.flush(func(*udpClient) bool { return true })
+	return err
+}
+
+// Serve forwards datagrams until the proxy is closed.
+func (p *UDPProxy) Serve() error {
+	var buffer [4096]byte
+	for {
+		n, cliAddr, err := p.conn.ReadFrom(buffer[0:])
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				glog.Infof("ReadFrom had a temporary failure: %v", err)
+				continue
+			}
+			return err
+		}
+		client, err := p.clients.get(p.loadBalancer, p.service, "", cliAddr, p.conn, p.IdleTimeout)
+		if err != nil {
+			continue
+		}
+		if _, err := client.conn.Write(buffer[0:n]); err != nil {
+			if !logTimeout(err) {
+				glog.Errorf("Write to %s failed: %v", client.endpoint, err)
+			}
+			continue
+		}
+		if err := client.conn.SetReadDeadline(time.Now().Add(p.IdleTimeout)); err != nil {
+			glog.Errorf("SetReadDeadline failed: %v", err)
+		}
+	}
+}
+
+// OnUpdate drops the clients of any endpoint no longer listed for the service, so their
+// next datagram is balanced onto a live endpoint.
+func (p *UDPProxy) OnUpdate(endpoints []api.Endpoints) {
+	active := util.StringSet{}
+	for _, e := range endpoints {
+		if e.ID == p.service {
+			active.Insert(e.Endpoints...)
+		}
+	}
+	p.clients.flush(func(client *udpClient) bool {
+		return !active.Has(client.endpoint)
+	})
+}
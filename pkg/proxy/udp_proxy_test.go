@@ -0,0 +1,147 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func newTestUDPProxy(t *testing.T, lb LoadBalancer, idleTimeout time.Duration) *UDPProxy {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	p := NewUDPProxy(lb, "echo", conn)
+	p.IdleTimeout = idleTimeout
+	go p.Serve()
+	return p
+}
+
+func (p *UDPProxy) clientEndpoints() []string {
+	p.clients.mu.Lock()
+	defer p.clients.mu.Unlock()
+	endpoints := []string{}
+	for _, client := range p.clients.clients {
+		endpoints = append(endpoints, client.endpoint)
+	}
+	return endpoints
+}
+
+func waitForClients(p *UDPProxy, count int) []string {
+	for i := 0; i < 50; i++ {
+		if endpoints := p.clientEndpoints(); len(endpoints) == count {
+			return endpoints
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return p.clientEndpoints()
+}
+
+func TestUDPProxyEcho(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", udpServerPort)},
+		},
+	})
+	p := newTestUDPProxy(t, lb, time.Second)
+	defer p.Close()
+	_, port, _ := net.SplitHostPort(p.Addr().String())
+	testEchoUDP(t, "127.0.0.1", port)
+}
+
+func TestUDPProxyIdleTimeout(t *testing.T) {
+	lb := NewLoadBalancerRR()
+	lb.OnUpdate([]api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{net.JoinHostPort("127.0.0.1", udpServerPort)},
+		},
+	})
+	p := newTestUDPProxy(t, lb, 100*time.Millisecond)
+	defer p.Close()
+	_, port, _ := net.SplitHostPort(p.Addr().String())
+	testEchoUDP(t, "127.0.0.1", port)
+	if endpoints := waitForClients(p, 0); len(endpoints) != 0 {
+		t.Errorf("expected idle client to time out, got %v", endpoints)
+	}
+}
+
+func TestUDPProxyFlushRemovedEndpoint(t *testing.T) {
+	other, err := newUDPEchoServer()
+	if err != nil {
+		t.Fatalf("failed to make a UDP server: %v", err)
+	}
+	go other.Loop()
+	_, otherPort, _ := net.SplitHostPort(other.LocalAddr().String())
+	first := net.JoinHostPort("127.0.0.1", udpServerPort)
+	second := net.JoinHostPort("127.0.0.1", otherPort)
+
+	lb := NewLoadBalancerRR()
+	endpoints := []api.Endpoints{
+		{
+			JSONBase:  api.JSONBase{ID: "echo"},
+			Endpoints: []string{first},
+		},
+	}
+	lb.OnUpdate(endpoints)
+	p := newTestUDPProxy(t, lb, time.Second)
+	defer p.Close()
+	_, port, _ := net.SplitHostPort(p.Addr().String())
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("error connecting to proxy: %v", err)
+	}
+	defer conn.Close()
+	echo := func() {
+		buffer := make([]byte, 16)
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("error writing to proxy: %v", err)
+		}
+		if n, err := conn.Read(buffer); err != nil || string(buffer[:n]) != "hello" {
+			t.Fatalf("expected echo, got %q %v", buffer[:n], err)
+		}
+	}
+	echo()
+	if clients := p.clientEndpoints(); len(clients) != 1 || clients[0] != first {
+		t.Fatalf("expected client of %s, got %v", first, clients)
+	}
+
+	// unrelated services do not affect the client
+	p.OnUpdate(append(endpoints, api.Endpoints{JSONBase: api.JSONBase{ID: "other"}}))
+	if clients := p.clientEndpoints(); len(clients) != 1 {
+		t.Errorf("expected client to be kept, got %v", clients)
+	}
+
+	endpoints[0].Endpoints = []string{second}
+	lb.OnUpdate(endpoints)
+	p.OnUpdate(endpoints)
+	if clients := p.clientEndpoints(); len(clients) != 0 {
+		t.Errorf("expected client to be flushed, got %v", clients)
+	}
+	echo()
+	if clients := p.clientEndpoints(); len(clients) != 1 || clients[0] != second {
+		t.Errorf("expected client of %s, got %v", second, clients)
+	}
+}
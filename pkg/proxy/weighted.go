@@ -0,0 +1,185 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netns"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+// WeightLabel is the service label holding the weights of its endpoints, see config.WeightLabel.
+const WeightLabel = config.WeightLabel
+
+// WeightedLB is a LoadBalancer that sends each endpoint of a service a share of the
+// connections proportional to its weight, e.g. to send a small fraction of traffic to a
+// canary. Without weights it is a round-robin. Endpoints and their weights are set with
+// OnWeightedUpdate, which an EndpointsConfig calls with the weights sent by its sources.
+type WeightedLB struct {
+	lock         sync.Mutex
+	endpointsMap map[string][]string
+	weights      map[string]map[string]int // service -> endpoint -> weight
+	overrides    map[string]map[string]int // service -> endpoint -> weight set by SetWeight
+	current      map[string][]int          // service -> smooth round-robin state of each endpoint
+	// ports are the named ports of the endpoints, as set by OnPortsUpdate. Their addresses are
+	// kept in endpointsMap under the config.ServicePortName.
+	ports map[string]map[string][]string
+}
+
+// NewWeightedLB returns a new WeightedLB.
+func NewWeightedLB() *WeightedLB {
+	return &WeightedLB{
+		endpointsMap: make(map[string][]string),
+		weights:      make(map[string]map[string]int),
+		overrides:    make(map[string]map[string]int),
+		current:      make(map[string][]int),
+	}
+}
+
+// NextEndpoint returns a service endpoint, picking endpoints in proportion to their weights
+// and spreading the picks of each endpoint evenly.
+func (lb *WeightedLB) NextEndpoint(service, port string, srcAddr net.Addr) (netns.NsHandle, string, error) {
+	ns := netns.None()
+	service = config.ServicePortName(service, port)
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	endpoints, exists := lb.endpointsMap[service]
+	if !exists {
+		return ns, "", ErrMissingServiceEntry
+	}
+	if len(endpoints) == 0 {
+		return ns, "", ErrMissingEndpoints
+	}
+	weights := make([]int, len(endpoints))
+	total := 0
+	for i, endpoint := range endpoints {
+		weights[i] = lb.weight(service, endpoint)
+		total += weights[i]
+	}
+	// An endpoint with a weight of 0 gets no connections, unless all of them have 0.
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = len(weights)
+	}
+	current := lb.current[service]
+	best := 0
+	for i, weight := range weights {
+		current[i] += weight
+		if current[i] > current[best] {
+			best = i
+		}
+	}
+	current[best] -= total
+	return ns, endpoints[best], nil
+}
+
+// weight returns the weight of endpoint, as set by SetWeight or else by OnWeightedUpdate, 1
+// if neither gives one.
+func (lb *WeightedLB) weight(service, endpoint string) int {
+	if weight, ok := lb.overrides[service][endpoint]; ok {
+		return weight
+	}
+	if weight, ok := lb.weights[service][endpoint]; ok {
+		return weight
+	}
+	return 1
+}
+
+// OnUpdate manages the registered service endpoints.
+// Registered endpoints are updated if found in the update set or
+// unregistered if missing from the update set. Their weights are left as they are.
+func (lb *WeightedLB) OnUpdate(endpoints []api.Endpoints) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.setEndpoints(endpoints)
+}
+
+// OnWeightedUpdate is OnUpdate that also replaces the weights of the endpoints.
+func (lb *WeightedLB) OnWeightedUpdate(endpoints []api.Endpoints, weights map[string]map[string]int) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.setEndpoints(endpoints)
+	for service, current := range lb.current {
+		if !reflect.DeepEqual(lb.weights[service], weights[service]) {
+			glog.Infof("WeightedLB: Setting weights for %s to %v", service, weights[service])
+			for i := range current {
+				current[i] = 0
+			}
+		}
+	}
+	lb.weights = weights
+}
+
+// OnPortsUpdate sets the named ports of the endpoints of the next OnUpdate or
+// OnWeightedUpdate.
+func (lb *WeightedLB) OnPortsUpdate(ports map[string]map[string][]string) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	lb.ports = ports
+}
+
+// setEndpoints sets the endpoints of OnUpdate. lb.lock must be held.
+func (lb *WeightedLB) setEndpoints(endpoints []api.Endpoints) {
+	registeredEndpoints := make(map[string]bool)
+	endpoints = config.WithNamedPorts(endpoints, lb.ports)
+	for _, endpoint := range endpoints {
+		existingEndpoints, exists := lb.endpointsMap[endpoint.ID]
+		validEndpoints := filterValidEndpoints(endpoint.Endpoints)
+		if !exists || !reflect.DeepEqual(existingEndpoints, validEndpoints) {
+			glog.Infof("WeightedLB: Setting endpoints for %s to %+v", endpoint.ID, endpoint.Endpoints)
+			lb.endpointsMap[endpoint.ID] = validEndpoints
+			lb.current[endpoint.ID] = make([]int, len(validEndpoints))
+		}
+		registeredEndpoints[endpoint.ID] = true
+	}
+	for k, v := range lb.endpointsMap {
+		if !registeredEndpoints[k] {
+			glog.Infof("WeightedLB: Removing endpoints for %s -> %+v", k, v)
+			delete(lb.endpointsMap, k)
+			delete(lb.current, k)
+		}
+	}
+}
+
+// SetWeight sets the weight of an endpoint of service, taking precedence over the weights
+// given by OnWeightedUpdate until it is cleared by a negative weight.
+func (lb *WeightedLB) SetWeight(service, endpoint string, weight int) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	glog.Infof("WeightedLB: Setting weight of %s for %s to %d", endpoint, service, weight)
+	if weight < 0 {
+		delete(lb.overrides[service], endpoint)
+		if len(lb.overrides[service]) == 0 {
+			delete(lb.overrides, service)
+		}
+	} else {
+		if lb.overrides[service] == nil {
+			lb.overrides[service] = make(map[string]int)
+		}
+		lb.overrides[service][endpoint] = weight
+	}
+	for i := range lb.current[service] {
+		lb.current[service][i] = 0
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/vishvananda/wormhole/pkg/proxy/config"
+)
+
+// countEndpoints returns how often each endpoint of service is picked in n connections.
+func countEndpoints(t *testing.T, lb LoadBalancer, service string, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		_, endpoint, err := lb.NextEndpoint(service, "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		counts[endpoint]++
+	}
+	return counts
+}
+
+func TestWeightedLBWithoutWeights(t *testing.T) {
+	lb := NewWeightedLB()
+	if _, _, err := lb.NextEndpoint("foo", "", nil); err != ErrMissingServiceEntry {
+		t.Errorf("expected %v, got %v", ErrMissingServiceEntry, err)
+	}
+	lb.OnUpdate([]api.Endpoints{{
+		JSONBase:  api.JSONBase{ID: "foo"},
+		Endpoints: []string{"endpoint1:40", "endpoint2:40", "endpoint3:40"},
+	}})
+	for _, expected := range []string{"endpoint1:40", "endpoint2:40", "endpoint3:40", "endpoint1:40"} {
+		if _, endpoint, _ := lb.NextEndpoint("foo", "", nil); endpoint != expected {
+			t.Errorf("expected %s, got %s", expected, endpoint)
+		}
+	}
+}
+
+func TestWeightedLBCanary(t *testing.T) {
+	lb := NewWeightedLB()
+	endpoints := []api.Endpoints{{
+		JSONBase:  api.JSONBase{ID: "foo"},
+		Endpoints: []string{"stable1:40", "stable2:40", "canary:40"},
+	}}
+	lb.OnWeightedUpdate(endpoints, map[string]map[string]int{"foo": {"stable1:40": 50, "stable2:40": 45, "canary:40": 5}})
+	expected := map[string]int{"stable1:40": 100, "stable2:40": 90, "canary:40": 10}
+	if actual := countEndpoints(t, lb, "foo", 200); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// removing the weights goes back to equal shares
+	lb.OnWeightedUpdate(endpoints, nil)
+	expected = map[string]int{"stable1:40": 2, "stable2:40": 2, "canary:40": 2}
+	if actual := countEndpoints(t, lb, "foo", 6); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestWeightedLBZeroWeight(t *testing.T) {
+	lb := NewWeightedLB()
+	lb.OnWeightedUpdate([]api.Endpoints{{
+		JSONBase:  api.JSONBase{ID: "foo"},
+		Endpoints: []string{"endpoint1:40", "endpoint2:40"},
+	}}, map[string]map[string]int{"foo": config.ParseWeights("foo", "endpoint1:40=0,endpoint2:40=bad")})
+	// the malformed weight defaults to 1
+	expected := map[string]int{"endpoint2:40": 4}
+	if actual := countEndpoints(t, lb, "foo", 4); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
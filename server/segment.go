@@ -268,7 +268,7 @@ func (s *Segment) Trigger() error {
 }
 
 // NextEndpoint is an implementation of the loadbalancer interface for proxy.
-func (s *Segment) NextEndpoint(service string, srcAddr net.Addr) (netns.NsHandle, string, error) {
+func (s *Segment) NextEndpoint(service, port string, srcAddr net.Addr) (netns.NsHandle, string, error) {
 	err := s.Trigger()
 	if err != nil {
 		return netns.None(), "", err